@@ -0,0 +1,78 @@
+// Package attrset caches the attribute sets of measurements whose
+// attributes have bounded cardinality, such as the value of a die or the
+// route and status of a request.
+//
+// Recording a measurement with metric.WithAttributes sorts and
+// deduplicates the attributes, and allocates, on every call. A Cache
+// builds each combination's attribute.Set, and the option recording it,
+// once:
+//
+//	rolls := attrset.New(64, func(value int64) []attribute.KeyValue {
+//		return []attribute.KeyValue{attribute.Int64("value", value)}
+//	})
+//	counter.Add(ctx, 1, rolls.Option(roll))
+package attrset
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Cache caches attribute sets by key. Cache is safe for concurrent use.
+type Cache[K comparable] struct {
+	limit   int64
+	build   func(K) []attribute.KeyValue
+	entries sync.Map // K -> *entry
+	size    atomic.Int64
+}
+
+type entry struct {
+	set attribute.Set
+	opt metric.MeasurementOption
+}
+
+// New returns a Cache that builds the attributes for a key with build,
+// caching at most limit sets. Once the cache is full, sets for new keys
+// are built on every call, so a key whose cardinality is bounded only in
+// practice, such as a request method, cannot grow the cache unboundedly.
+func New[K comparable](limit int, build func(K) []attribute.KeyValue) *Cache[K] {
+	return &Cache[K]{limit: int64(limit), build: build}
+}
+
+// Set returns the attribute set for key.
+func (c *Cache[K]) Set(key K) attribute.Set {
+	return c.entry(key).set
+}
+
+// Option returns an option recording a measurement with the
+// attribute set for key.
+func (c *Cache[K]) Option(key K) metric.MeasurementOption {
+	return c.entry(key).opt
+}
+
+// Len returns the number of cached attribute sets.
+func (c *Cache[K]) Len() int {
+	return int(c.size.Load())
+}
+
+func (c *Cache[K]) entry(key K) *entry {
+	if e, ok := c.entries.Load(key); ok {
+		return e.(*entry)
+	}
+	set := attribute.NewSet(c.build(key)...)
+	e := &entry{set: set, opt: metric.WithAttributeSet(set)}
+	// Reserve a slot before storing, so concurrent
+	// callers cannot take the cache over its limit.
+	if c.size.Add(1) > c.limit {
+		c.size.Add(-1)
+		return e
+	}
+	if actual, loaded := c.entries.LoadOrStore(key, e); loaded {
+		c.size.Add(-1)
+		return actual.(*entry)
+	}
+	return e
+}
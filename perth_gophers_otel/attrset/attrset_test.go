@@ -0,0 +1,43 @@
+package attrset_test
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"oteldemo/attrset"
+)
+
+func TestCache(t *testing.T) {
+	var builds int
+	c := attrset.New(2, func(value int64) []attribute.KeyValue {
+		builds++
+		return []attribute.KeyValue{attribute.Int64("value", value)}
+	})
+	for range 3 {
+		for _, value := range []int64{1, 2, 3} {
+			set := c.Set(value)
+			if got, _ := set.Value("value"); got.AsInt64() != value {
+				t.Errorf("Set(%d) has value %d", value, got.AsInt64())
+			}
+		}
+	}
+	if got := c.Len(); got != 2 {
+		t.Errorf("got %d cached sets, want the limit of 2", got)
+	}
+	// Values 1 and 2 are cached; 3 is built every time.
+	if want := 2 + 3; builds != want {
+		t.Errorf("got %d builds, want %d", builds, want)
+	}
+}
+
+func TestCacheOptionAllocs(t *testing.T) {
+	c := attrset.New(10, func(route string) []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.String("http.route", route)}
+	})
+	c.Option("/roll/:dice")
+	allocs := testing.AllocsPerRun(100, func() { c.Option("/roll/:dice") })
+	if allocs != 0 {
+		t.Errorf("got %v allocs per cached Option, want 0", allocs)
+	}
+}
@@ -0,0 +1,226 @@
+// Package breaker provides a circuit breaker for calls to downstream
+// dependencies, instrumented with OpenTelemetry.
+//
+// State transitions are recorded as "circuit_breaker.state_change" events
+// on the span of the call that caused them, and the state of each breaker
+// is reported by the circuit_breaker.state gauge: 0 (closed), 1 (half-open)
+// or 2 (open).
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/clock"
+)
+
+// ErrOpen is returned by Breaker.Do when the circuit is open,
+// without calling the function.
+var ErrOpen = errors.New("circuit breaker open")
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed breakers allow calls through, counting consecutive failures.
+	Closed State = iota
+	// HalfOpen breakers allow a single trial call through, to decide
+	// whether to close again.
+	HalfOpen
+	// Open breakers reject calls until the cooldown period elapses.
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "unknown"
+}
+
+// Breaker is a circuit breaker. It opens after a number of consecutive
+// failures, rejecting calls until a cooldown period has elapsed, after
+// which a trial call decides whether to close again.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	clock     clock.Clock
+	attrs     attribute.Set
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trialing bool
+}
+
+// Option configures a Breaker.
+type Option func(*options)
+
+type options struct {
+	threshold     int
+	cooldown      time.Duration
+	clock         clock.Clock
+	meterProvider metric.MeterProvider
+}
+
+// WithFailureThreshold sets the number of consecutive failures after
+// which the breaker opens. The default is 5.
+func WithFailureThreshold(n int) Option {
+	return func(o *options) { o.threshold = n }
+}
+
+// WithCooldown sets how long the breaker stays open before allowing a
+// trial call. The default is 10 seconds.
+func WithCooldown(d time.Duration) Option {
+	return func(o *options) { o.cooldown = d }
+}
+
+// WithClock sets the clock used to time the cooldown.
+// If unspecified, clock.Real is used.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithMeterProvider sets the MeterProvider used to report the breaker's
+// state. If unspecified, the global MeterProvider is used.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(o *options) { o.meterProvider = mp }
+}
+
+// New returns a new closed Breaker, identified in telemetry by name.
+func New(name string, opts ...Option) (*Breaker, error) {
+	o := options{threshold: 5, cooldown: 10 * time.Second, clock: clock.Real}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.meterProvider == nil {
+		o.meterProvider = otel.GetMeterProvider()
+	}
+	b := &Breaker{
+		name:      name,
+		threshold: o.threshold,
+		cooldown:  o.cooldown,
+		clock:     o.clock,
+		attrs:     attribute.NewSet(attribute.String("circuit_breaker.name", name)),
+	}
+
+	// Breakers share the gauge, so register the callback separately;
+	// callbacks passed when creating an existing instrument are ignored.
+	meter := o.meterProvider.Meter("oteldemo/breaker")
+	gauge, err := meter.Int64ObservableGauge(
+		"circuit_breaker.state",
+		metric.WithDescription("Circuit breaker state: 0 (closed), 1 (half-open) or 2 (open)"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		o.ObserveInt64(gauge, int64(b.State()), metric.WithAttributeSet(b.attrs))
+		return nil
+	}, gauge)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// State returns the breaker's current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && clock.Since(b.clock, b.openedAt) >= b.cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Do calls fn if the breaker allows it, recording the result. If the
+// breaker is open, Do returns ErrOpen without calling fn. Errors caused
+// by cancellation of ctx are not counted as failures.
+func (b *Breaker) Do(ctx context.Context, fn func(context.Context) error) error {
+	if !b.allow(ctx) {
+		trace.SpanFromContext(ctx).AddEvent("circuit_breaker.rejected", trace.WithAttributes(b.attrs.ToSlice()...))
+		return ErrOpen
+	}
+	err := fn(ctx)
+	if err != nil && ctx.Err() != nil {
+		// The caller gave up; this says nothing about the dependency.
+		b.mu.Lock()
+		b.trialing = false
+		b.mu.Unlock()
+		return err
+	}
+	b.record(ctx, err == nil)
+	return err
+}
+
+func (b *Breaker) allow(ctx context.Context) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if clock.Since(b.clock, b.openedAt) < b.cooldown {
+			return false
+		}
+		b.transition(ctx, HalfOpen)
+		fallthrough
+	case HalfOpen:
+		if b.trialing {
+			return false
+		}
+		b.trialing = true
+	}
+	return true
+}
+
+func (b *Breaker) record(ctx context.Context, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialing = false
+	switch {
+	case ok:
+		b.failures = 0
+		if b.state != Closed {
+			b.transition(ctx, Closed)
+		}
+	case b.state == HalfOpen:
+		b.transition(ctx, Open)
+	default:
+		b.failures++
+		if b.state == Closed && b.failures >= b.threshold {
+			b.transition(ctx, Open)
+		}
+	}
+}
+
+// transition changes the breaker's state, recording a span event.
+// b.mu must be held.
+func (b *Breaker) transition(ctx context.Context, to State) {
+	from := b.state
+	b.state = to
+	if to == Open {
+		b.openedAt = b.clock.Now()
+	}
+	log.Printf("circuit breaker %q: %s -> %s", b.name, from, to)
+	trace.SpanFromContext(ctx).AddEvent("circuit_breaker.state_change", trace.WithAttributes(
+		attribute.String("circuit_breaker.name", b.name),
+		attribute.String("circuit_breaker.from", from.String()),
+		attribute.String("circuit_breaker.to", to.String()),
+		attribute.Int("circuit_breaker.failures", b.failures),
+	))
+}
@@ -0,0 +1,140 @@
+package breaker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/metric/noop"
+
+	"oteldemo/breaker"
+	"oteldemo/clock"
+)
+
+var errFailed = errors.New("failed")
+
+// newBreaker returns a breaker opening after three failures, and
+// cooling down for a minute on the returned fake clock.
+func newBreaker(t *testing.T) (*breaker.Breaker, *clock.Fake) {
+	t.Helper()
+	clk := clock.NewFake(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	b, err := breaker.New("test",
+		breaker.WithFailureThreshold(3),
+		breaker.WithCooldown(time.Minute),
+		breaker.WithClock(clk),
+		breaker.WithMeterProvider(noop.NewMeterProvider()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, clk
+}
+
+func succeed(context.Context) error { return nil }
+func fail(context.Context) error    { return errFailed }
+
+// open fails calls through b until it opens.
+func open(t *testing.T, b *breaker.Breaker) {
+	t.Helper()
+	for range 3 {
+		if err := b.Do(context.Background(), fail); err != errFailed {
+			t.Fatalf("got error %v, want %v", err, errFailed)
+		}
+	}
+	if got := b.State(); got != breaker.Open {
+		t.Fatalf("got state %s, want %s", got, breaker.Open)
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	b, _ := newBreaker(t)
+	for range 2 {
+		b.Do(context.Background(), fail)
+	}
+	if got := b.State(); got != breaker.Closed {
+		t.Fatalf("got state %s after 2 failures, want %s", got, breaker.Closed)
+	}
+	// Successes reset the count of consecutive failures.
+	b.Do(context.Background(), succeed)
+	for range 2 {
+		b.Do(context.Background(), fail)
+	}
+	if got := b.State(); got != breaker.Closed {
+		t.Fatalf("got state %s after a success and 2 failures, want %s", got, breaker.Closed)
+	}
+
+	b.Do(context.Background(), fail)
+	if got := b.State(); got != breaker.Open {
+		t.Fatalf("got state %s after 3 failures, want %s", got, breaker.Open)
+	}
+	called := false
+	err := b.Do(context.Background(), func(context.Context) error {
+		called = true
+		return nil
+	})
+	if err != breaker.ErrOpen || called {
+		t.Errorf("got error %v, called %t; want %v without calling", err, called, breaker.ErrOpen)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b, clk := newBreaker(t)
+	open(t, b)
+	clk.Advance(time.Minute - time.Second)
+	if got := b.State(); got != breaker.Open {
+		t.Fatalf("got state %s before the cooldown, want %s", got, breaker.Open)
+	}
+	clk.Advance(time.Second)
+	if got := b.State(); got != breaker.HalfOpen {
+		t.Fatalf("got state %s after the cooldown, want %s", got, breaker.HalfOpen)
+	}
+
+	// Only a single trial call is allowed while half-open.
+	trialing, finish := make(chan struct{}), make(chan struct{})
+	trialErr := make(chan error)
+	go func() {
+		trialErr <- b.Do(context.Background(), func(context.Context) error {
+			close(trialing)
+			<-finish
+			return nil
+		})
+	}()
+	<-trialing
+	if err := b.Do(context.Background(), succeed); err != breaker.ErrOpen {
+		t.Errorf("got error %v during the trial call, want %v", err, breaker.ErrOpen)
+	}
+	close(finish)
+	if err := <-trialErr; err != nil {
+		t.Fatal(err)
+	}
+	if got := b.State(); got != breaker.Closed {
+		t.Errorf("got state %s after a successful trial call, want %s", got, breaker.Closed)
+	}
+}
+
+func TestBreakerTrialFails(t *testing.T) {
+	b, clk := newBreaker(t)
+	open(t, b)
+	clk.Advance(time.Minute)
+	if err := b.Do(context.Background(), fail); err != errFailed {
+		t.Fatalf("got error %v, want %v", err, errFailed)
+	}
+	if got := b.State(); got != breaker.Open {
+		t.Errorf("got state %s after a failed trial call, want %s", got, breaker.Open)
+	}
+}
+
+func TestBreakerCancelled(t *testing.T) {
+	b, _ := newBreaker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for range 5 {
+		if err := b.Do(ctx, func(ctx context.Context) error { return ctx.Err() }); err != context.Canceled {
+			t.Fatalf("got error %v, want %v", err, context.Canceled)
+		}
+	}
+	if got := b.State(); got != breaker.Closed {
+		t.Errorf("got state %s after cancelled calls, want %s", got, breaker.Closed)
+	}
+}
@@ -0,0 +1,141 @@
+// Package clock abstracts the passage of time, so that code reading the
+// time can be tested deterministically with a Fake clock.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time, and waits for it to pass.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the current
+	// time once the duration d has passed.
+	After(d time.Duration) <-chan time.Time
+
+	// NewTimer returns a Timer that sends the current time
+	// on its channel once the duration d has passed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event, which can be stopped
+// to release its resources before it fires.
+type Timer interface {
+	// C returns the channel on which the time is sent.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing, returning
+	// false if it has already fired or been stopped.
+	Stop() bool
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// Since returns the time elapsed on c since t.
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Fake is a Clock whose time passes only when advanced.
+// Fake is safe for concurrent use.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	until time.Time
+	c     chan time.Time
+}
+
+// NewFake returns a Fake clock whose time is now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake clock's time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After returns a channel that receives the fake clock's time
+// once it has been advanced by at least d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.waiters = append(f.waiters, waiter{until: f.now.Add(d), c: c})
+	return c
+}
+
+// NewTimer returns a Timer that sends the fake clock's time
+// once it has been advanced by at least d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return &fakeTimer{f: f, c: f.After(d)}
+}
+
+type fakeTimer struct {
+	f *Fake
+	c <-chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	for i, w := range t.f.waiters {
+		if w.c == t.c {
+			t.f.waiters = append(t.f.waiters[:i], t.f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance advances the fake clock's time by d, firing
+// any channels returned by After and timers that are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	waiting := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.until.After(f.now) {
+			waiting = append(waiting, w)
+		} else {
+			w.c <- f.now
+		}
+	}
+	f.waiters = waiting
+}
+
+// Waiters returns the number of channels returned by After, and timers,
+// that have not yet fired or been stopped, so tests can wait for code
+// to start waiting before advancing the clock.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
@@ -0,0 +1,746 @@
+// Package config provides the dice server's configuration.
+//
+// Configuration is merged from, in increasing order of precedence:
+// built-in defaults, a YAML file (-config or DICE_CONFIG), environment
+// variables, and command line flags. Each flag has a corresponding
+// environment variable, named by upper-casing the flag name, replacing
+// "-" with "_", and adding the prefix "DICE_"; e.g. -otlp-endpoint may
+// also be set with DICE_OTLP_ENDPOINT.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables corresponding to flags.
+const EnvPrefix = "DICE_"
+
+// Config holds the dice server's configuration.
+type Config struct {
+	// ListenAddr is the address on which the server listens: a TCP
+	// host:port, "unix:" followed by a Unix domain socket path, or
+	// "systemd" to use a socket passed by systemd socket activation.
+	// See ParseListenAddr.
+	ListenAddr string `yaml:"listen_addr"`
+
+	// AdminAddr is the address on which operational endpoints, such
+	// as pprof, are served, in the same form as ListenAddr. If empty,
+	// operational endpoints are disabled.
+	AdminAddr string `yaml:"admin_addr"`
+
+	// RequestTimeout is the maximum time allowed to serve a request,
+	// after which its context is cancelled and 504 Gateway Timeout
+	// returned. Zero means no limit.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	// RouteTimeouts overrides RequestTimeout for specific
+	// routes, keyed by route path, e.g. "/roll/:dice".
+	RouteTimeouts map[string]time.Duration `yaml:"route_timeouts"`
+
+	// MaxConcurrentRequests is the maximum number of requests served
+	// concurrently. Further requests wait in a queue of up to
+	// MaxQueuedRequests for at most QueueTimeout, and are otherwise shed
+	// with 503 Service Unavailable. Zero means no limit.
+	MaxConcurrentRequests int           `yaml:"max_concurrent_requests"`
+	MaxQueuedRequests     int           `yaml:"max_queued_requests"`
+	QueueTimeout          time.Duration `yaml:"queue_timeout"`
+
+	// MaxRequestBodyBytes is the maximum size of a request body. Larger
+	// requests are rejected with 413 Content Too Large.
+	MaxRequestBodyBytes int64 `yaml:"max_request_body_bytes"`
+
+	// ShutdownTimeout is how long to wait for in-flight requests to
+	// complete, and then for telemetry to be flushed, when shutting down.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	TLS        TLS        `yaml:"tls"`
+	Telemetry  Telemetry  `yaml:"telemetry"`
+	Failures   Failures   `yaml:"failures"`
+	Features   Features   `yaml:"features"`
+	Downstream Downstream `yaml:"downstream"`
+	Storage    Storage    `yaml:"storage"`
+	Proxy      Proxy      `yaml:"proxy"`
+	Debug      Debug      `yaml:"debug"`
+}
+
+// TLS configures HTTPS. If neither a certificate nor autocert domains
+// are configured, the server serves plain HTTP.
+type TLS struct {
+	// CertFile and KeyFile are paths to a PEM-encoded
+	// certificate and private key with which to serve HTTPS.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// AutocertDomains enables automatic provisioning of certificates
+	// from Let's Encrypt for the given domains.
+	AutocertDomains []string `yaml:"autocert_domains"`
+
+	// AutocertCacheDir is a directory in which to cache
+	// automatically provisioned certificates.
+	AutocertCacheDir string `yaml:"autocert_cache_dir"`
+}
+
+// Enabled reports whether HTTPS is configured.
+func (cfg TLS) Enabled() bool {
+	return cfg.CertFile != "" || len(cfg.AutocertDomains) > 0
+}
+
+// Telemetry configures the telemetry pipeline.
+type Telemetry struct {
+	// ConfigFile is the path to an OpenTelemetry declarative
+	// configuration file. If set, it replaces the built-in
+	// pipeline and the other telemetry options are ignored.
+	ConfigFile string `yaml:"config_file"`
+
+	// Console controls whether telemetry is printed to stdout,
+	// in addition to being exported as OTLP.
+	Console bool `yaml:"console"`
+
+	// Phase selects how much of the telemetry pipeline is set up,
+	// following the progression of the talk; see the Phase constants.
+	// Phases before PhaseFull print telemetry to stdout only.
+	Phase int `yaml:"phase"`
+
+	// Dashboard renders live telemetry in the terminal, in place of
+	// the console exporters and logs.
+	Dashboard bool `yaml:"dashboard"`
+
+	// SamplerRatio is the ratio of root traces to sample.
+	// Traces with a parent follow the parent's sampling decision.
+	SamplerRatio float64 `yaml:"sampler_ratio"`
+
+	// SemconvStabilityOptIn selects the HTTP semantic conventions of
+	// span attributes, as OTEL_SEMCONV_STABILITY_OPT_IN does, from which
+	// it is defaulted: empty for the old conventions, "http" for the
+	// stable conventions, or "http/dup" for both, while migrating. HTTP
+	// metrics always follow the stable conventions; "http/dup" also
+	// records the old request duration metric.
+	SemconvStabilityOptIn string `yaml:"semconv_stability_opt_in"`
+
+	// Replay holds paths to OTLP-JSON files, as written by the
+	// Collector's file exporter, to re-export to the OTLP endpoint
+	// with their timestamps shifted to the present, instead of
+	// serving. This populates a backend if the live demo fails.
+	Replay []string `yaml:"replay"`
+
+	// Smoke runs a preflight check instead of serving: a roll against
+	// the server on an ephemeral port, verifying that telemetry was
+	// produced, so problems are caught before going on stage.
+	Smoke bool `yaml:"smoke"`
+
+	OTLP OTLP `yaml:"otlp"`
+}
+
+// Phases of the demo, for Telemetry.Phase.
+const (
+	// PhaseUninstrumented sets up no telemetry.
+	PhaseUninstrumented = 1
+	// PhaseTraces sets up tracing.
+	PhaseTraces = 2
+	// PhaseMetrics sets up tracing and metrics.
+	PhaseMetrics = 3
+	// PhaseFull sets up tracing and metrics, exported as OTLP.
+	PhaseFull = 4
+)
+
+// OTLP configures OTLP export.
+type OTLP struct {
+	// Endpoint is the primary OTLP endpoint URL. If empty, the
+	// standard OTEL_EXPORTER_OTLP_* environment variables are used.
+	Endpoint string `yaml:"endpoint"`
+
+	// SecondaryEndpoint is an OTLP endpoint URL to fail over to
+	// when the primary endpoint is unavailable.
+	SecondaryEndpoint string `yaml:"secondary_endpoint"`
+
+	// SpoolDir is a directory in which to spool OTLP requests while
+	// the collector is unreachable. If empty, requests are dropped.
+	SpoolDir string `yaml:"spool_dir"`
+
+	// SpoolMaxBytes is the maximum size of spooled OTLP requests
+	// per signal. The oldest requests are dropped first.
+	SpoolMaxBytes int64 `yaml:"spool_max_bytes"`
+
+	// StartupCheck probes the endpoints at startup, logging a diagnosis
+	// of any that are unreachable or reject the credentials, and
+	// reporting the server as unready until one is reachable.
+	StartupCheck bool `yaml:"startup_check"`
+}
+
+// Failures configures failure injection, for demonstrating how
+// failures show up in telemetry.
+type Failures struct {
+	// Tetraphobic makes rolls involving the number 4 fail.
+	Tetraphobic bool `yaml:"tetraphobic"`
+
+	// ErrorRate is the probability of a roll failing at random.
+	ErrorRate float64 `yaml:"error_rate"`
+
+	// Latency is added to every roll.
+	Latency time.Duration `yaml:"latency"`
+}
+
+// Features holds feature toggles.
+type Features struct {
+	// UniformRolls makes dice roll with a uniform distribution,
+	// rather than the default (loaded) Zipf distribution.
+	UniformRolls bool `yaml:"uniform_rolls"`
+
+	// MirrorFraction is the fraction of requests mirrored to shadow
+	// handlers, such as one using a new dice notation parser, to compare
+	// their responses with the primary handlers'.
+	MirrorFraction float64 `yaml:"mirror_fraction"`
+
+	// FlagsFile is the path to a YAML file defining OpenFeature flags,
+	// such as "loaded-dice" and "tetraphobic", overriding the equivalent
+	// configuration. It is re-read when the configuration is reloaded.
+	FlagsFile string `yaml:"flags_file"`
+}
+
+// Downstream configures the services the dice server calls.
+type Downstream struct {
+	// FortuneURL is the base URL of the fortune service, which is asked
+	// for a luck modifier for each roll, so that traces span multiple
+	// services. If empty, the fortune service is not called.
+	FortuneURL string `yaml:"fortune_url"`
+
+	// FortuneTimeout is the maximum time to wait for the fortune
+	// service, after which the roll proceeds without a modifier.
+	FortuneTimeout time.Duration `yaml:"fortune_timeout"`
+
+	// ModifierAddr is the host:port of the Modifier gRPC service, which
+	// modifies the sum of each roll. Rolls fail if the service does. If
+	// empty, the service is not called.
+	ModifierAddr string `yaml:"modifier_addr"`
+
+	// ModifierTimeout is the maximum time to wait for the Modifier
+	// service, after which the roll fails with 504 Gateway Timeout.
+	ModifierTimeout time.Duration `yaml:"modifier_timeout"`
+
+	// KafkaBrokers are the addresses of Kafka brokers to which each
+	// roll is published, with its trace context in the message headers,
+	// for consumers such as rollconsumer to continue the trace. If
+	// empty, rolls are not published.
+	KafkaBrokers []string `yaml:"kafka_brokers"`
+
+	// KafkaTopic is the topic to which rolls are published.
+	KafkaTopic string `yaml:"kafka_topic"`
+
+	// WebhookTimeout is the maximum time to wait for each attempt to
+	// deliver a roll to a session's webhook, after which it is retried.
+	WebhookTimeout time.Duration `yaml:"webhook_timeout"`
+}
+
+// Storage configures persistence.
+type Storage struct {
+	// SQLitePath is the path to a SQLite database in which the
+	// history of rolls made with a session, and provably fair rolls,
+	// are recorded. If empty, neither is available.
+	SQLitePath string `yaml:"sqlite_path"`
+
+	// RedisAddr is the host:port of a Redis server in which to cache
+	// sessions and probability tables. If empty, they are not cached.
+	RedisAddr string `yaml:"redis_addr"`
+
+	// CacheTTL is how long values are cached in Redis.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+
+	// MaintenanceInterval is how often maintenance jobs, compacting
+	// the history and recomputing the leaderboard, run in the
+	// background when history is recorded. If zero, they don't run.
+	MaintenanceInterval time.Duration `yaml:"maintenance_interval"`
+
+	// HistoryRetention is the number of most recent rolls of each
+	// session kept when the history is compacted.
+	HistoryRetention int `yaml:"history_retention"`
+}
+
+// Proxy configures running behind reverse proxies and API gateways.
+type Proxy struct {
+	// TrustedProxies are the IP addresses or CIDR ranges of reverse
+	// proxies whose X-Forwarded-For, X-Forwarded-Proto and
+	// X-Forwarded-Host headers are trusted, for identifying clients and
+	// the URLs they requested. If empty, the headers are ignored.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// GatewayPropagators are trace context formats injected by API
+	// gateways to accept, in addition to W3C trace context: "b3" (e.g.
+	// Envoy or Istio) or "xray" (AWS API Gateway and load balancers).
+	GatewayPropagators []string `yaml:"gateway_propagators"`
+}
+
+// GatewayPropagators are the valid Proxy.GatewayPropagators.
+var GatewayPropagators = []string{"b3", "xray"}
+
+// Debug configures debugging aids, which may be expensive
+// or expose internals, and so are disabled by default.
+type Debug struct {
+	// GoroutineDumps attaches a (truncated) dump of all goroutines
+	// to the span of a request whose handler panics.
+	GoroutineDumps bool `yaml:"goroutine_dumps"`
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		ListenAddr:      "localhost:8080",
+		AdminAddr:       "localhost:8081",
+		ShutdownTimeout: 10 * time.Second,
+
+		MaxRequestBodyBytes: 64 << 10,
+		QueueTimeout:        time.Second,
+		Telemetry: Telemetry{
+			Console:      true,
+			Phase:        PhaseFull,
+			SamplerRatio: 1,
+			OTLP: OTLP{
+				SpoolMaxBytes: 64 << 20,
+			},
+		},
+		Failures: Failures{
+			Tetraphobic: true,
+		},
+		Storage: Storage{
+			CacheTTL:            5 * time.Minute,
+			MaintenanceInterval: time.Minute,
+			HistoryRetention:    1000,
+		},
+		Downstream: Downstream{
+			FortuneTimeout:  500 * time.Millisecond,
+			ModifierTimeout: 500 * time.Millisecond,
+			KafkaTopic:      "rolls",
+			WebhookTimeout:  2 * time.Second,
+		},
+	}
+}
+
+// Load returns the configuration given command line arguments (excluding
+// the program name), and the environment.
+func Load(args []string) (*Config, error) {
+	// Parse the flags first to find the config file, and which flags
+	// were set. These are applied last, to take precedence over the
+	// config file and environment.
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "path to a YAML configuration file")
+	Default().registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	cfg.Telemetry.SemconvStabilityOptIn = os.Getenv("OTEL_SEMCONV_STABILITY_OPT_IN")
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+	cfgFlags := flag.NewFlagSet("", flag.ContinueOnError)
+	cfg.registerFlags(cfgFlags)
+	var errs []error
+	cfgFlags.VisitAll(func(f *flag.Flag) {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(name); ok {
+			if err := f.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for %s: %w", v, name, err))
+			}
+		}
+	})
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if err := cfgFlags.Set(f.Name, f.Value.String()); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for -%s: %w", f.Value, f.Name, err))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr,
+		"host:port, unix:<path>, or systemd[:<name>] on which to listen")
+	fs.StringVar(&cfg.AdminAddr, "admin-listen", cfg.AdminAddr,
+		"address on which to serve operational endpoints, in the same form as -listen, or empty to disable them")
+	fs.DurationVar(&cfg.RequestTimeout, "request-timeout", cfg.RequestTimeout,
+		"maximum time allowed to serve a request, or 0 for no limit")
+	fs.Var((*durationMapValue)(&cfg.RouteTimeouts), "route-timeouts",
+		"comma-separated per-route request timeouts, e.g. /roll/:dice=5s")
+	fs.IntVar(&cfg.MaxConcurrentRequests, "max-concurrent-requests", cfg.MaxConcurrentRequests,
+		"maximum number of requests served concurrently, or 0 for no limit")
+	fs.IntVar(&cfg.MaxQueuedRequests, "max-queued-requests", cfg.MaxQueuedRequests,
+		"maximum number of requests waiting to be served; further requests are shed")
+	fs.DurationVar(&cfg.QueueTimeout, "queue-timeout", cfg.QueueTimeout,
+		"maximum time a request waits to be served before being shed")
+	fs.Int64Var(&cfg.MaxRequestBodyBytes, "max-request-body-bytes", cfg.MaxRequestBodyBytes,
+		"maximum size of a request body; larger requests are rejected")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout,
+		"time to wait for in-flight requests, and then telemetry flushing, when shutting down")
+
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "path to a TLS certificate file")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "path to a TLS private key file")
+	fs.Var((*listValue)(&cfg.TLS.AutocertDomains), "autocert-domains",
+		"comma-separated domains for which to provision TLS certificates from Let's Encrypt")
+	fs.StringVar(&cfg.TLS.AutocertCacheDir, "autocert-cache-dir", cfg.TLS.AutocertCacheDir,
+		"directory in which to cache provisioned TLS certificates")
+
+	fs.StringVar(&cfg.Telemetry.ConfigFile, "otel-config", cfg.Telemetry.ConfigFile,
+		"path to an OpenTelemetry declarative configuration file, replacing the built-in telemetry pipeline")
+	fs.IntVar(&cfg.Telemetry.Phase, "phase", cfg.Telemetry.Phase,
+		"demo phase: 1 (uninstrumented), 2 (traces), 3 (traces and metrics), or 4 (full pipeline, with OTLP export)")
+	fs.BoolVar(&cfg.Telemetry.Console, "console-exporters", cfg.Telemetry.Console,
+		"print telemetry to stdout")
+	fs.BoolVar(&cfg.Telemetry.Dashboard, "dashboard", cfg.Telemetry.Dashboard,
+		"render live telemetry in the terminal, in place of the console exporters and logs")
+	fs.Float64Var(&cfg.Telemetry.SamplerRatio, "sampler-ratio", cfg.Telemetry.SamplerRatio,
+		"ratio of root traces to sample")
+	fs.StringVar(&cfg.Telemetry.SemconvStabilityOptIn, "semconv-stability-opt-in", cfg.Telemetry.SemconvStabilityOptIn,
+		`HTTP semantic conventions of span attributes: empty for the old, "http" for the stable, or "http/dup" for both; metrics are always stable`)
+	fs.Var((*listValue)(&cfg.Telemetry.Replay), "replay",
+		"comma-separated OTLP-JSON files to re-export with updated timestamps, instead of serving")
+	fs.BoolVar(&cfg.Telemetry.Smoke, "smoke", cfg.Telemetry.Smoke,
+		"roll once against the server on an ephemeral port, check telemetry was produced, and exit")
+	fs.StringVar(&cfg.Telemetry.OTLP.Endpoint, "otlp-endpoint", cfg.Telemetry.OTLP.Endpoint,
+		"primary OTLP endpoint URL (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.StringVar(&cfg.Telemetry.OTLP.SecondaryEndpoint, "otlp-secondary-endpoint", cfg.Telemetry.OTLP.SecondaryEndpoint,
+		"secondary OTLP endpoint URL, used when the primary is unavailable")
+	fs.StringVar(&cfg.Telemetry.OTLP.SpoolDir, "otlp-spool-dir", cfg.Telemetry.OTLP.SpoolDir,
+		"directory in which to spool OTLP requests while the collector is unreachable")
+	fs.Int64Var(&cfg.Telemetry.OTLP.SpoolMaxBytes, "otlp-spool-max-bytes", cfg.Telemetry.OTLP.SpoolMaxBytes,
+		"maximum size of spooled OTLP requests per signal; the oldest are dropped first")
+	fs.BoolVar(&cfg.Telemetry.OTLP.StartupCheck, "otlp-startup-check", cfg.Telemetry.OTLP.StartupCheck,
+		"check the OTLP endpoints are reachable at startup, reporting unready until one is")
+
+	fs.BoolVar(&cfg.Failures.Tetraphobic, "tetraphobic", cfg.Failures.Tetraphobic,
+		"fail rolls involving the number 4")
+	fs.Float64Var(&cfg.Failures.ErrorRate, "error-rate", cfg.Failures.ErrorRate,
+		"probability of a roll failing at random")
+	fs.DurationVar(&cfg.Failures.Latency, "latency", cfg.Failures.Latency,
+		"latency to add to every roll")
+
+	fs.BoolVar(&cfg.Features.UniformRolls, "uniform-rolls", cfg.Features.UniformRolls,
+		"roll dice with a uniform distribution, rather than a Zipf distribution")
+	fs.Float64Var(&cfg.Features.MirrorFraction, "mirror-fraction", cfg.Features.MirrorFraction,
+		"fraction of requests to mirror to shadow handlers, comparing their responses")
+	fs.StringVar(&cfg.Features.FlagsFile, "feature-flags", cfg.Features.FlagsFile,
+		"path to a YAML file defining OpenFeature flags")
+
+	fs.StringVar(&cfg.Downstream.FortuneURL, "fortune-url", cfg.Downstream.FortuneURL,
+		"base URL of the fortune service to call for each roll, or empty to roll without it")
+	fs.DurationVar(&cfg.Downstream.FortuneTimeout, "fortune-timeout", cfg.Downstream.FortuneTimeout,
+		"maximum time to wait for the fortune service")
+	fs.StringVar(&cfg.Downstream.ModifierAddr, "modifier-addr", cfg.Downstream.ModifierAddr,
+		"host:port of the Modifier gRPC service to call for each roll, or empty to roll without it")
+	fs.DurationVar(&cfg.Downstream.ModifierTimeout, "modifier-timeout", cfg.Downstream.ModifierTimeout,
+		"maximum time to wait for the Modifier service")
+	fs.Var((*listValue)(&cfg.Downstream.KafkaBrokers), "kafka-brokers",
+		"comma-separated Kafka broker addresses to publish rolls to, or empty to not publish them")
+	fs.StringVar(&cfg.Downstream.KafkaTopic, "kafka-topic", cfg.Downstream.KafkaTopic,
+		"Kafka topic to publish rolls to")
+	fs.DurationVar(&cfg.Downstream.WebhookTimeout, "webhook-timeout", cfg.Downstream.WebhookTimeout,
+		"maximum time to wait for each attempt to deliver a roll to a session's webhook")
+
+	fs.StringVar(&cfg.Storage.SQLitePath, "sqlite-path", cfg.Storage.SQLitePath,
+		"path to a SQLite database in which to record roll history and fair rolls, or empty to not record them")
+	fs.StringVar(&cfg.Storage.RedisAddr, "redis-addr", cfg.Storage.RedisAddr,
+		"host:port of a Redis server in which to cache sessions and probability tables, or empty to not cache them")
+	fs.DurationVar(&cfg.Storage.CacheTTL, "cache-ttl", cfg.Storage.CacheTTL,
+		"how long values are cached in Redis")
+	fs.DurationVar(&cfg.Storage.MaintenanceInterval, "maintenance-interval", cfg.Storage.MaintenanceInterval,
+		"how often to compact the roll history and recompute the leaderboard, or 0 to not do so")
+	fs.IntVar(&cfg.Storage.HistoryRetention, "history-retention", cfg.Storage.HistoryRetention,
+		"number of most recent rolls of each session to keep when compacting the roll history")
+
+	fs.Var((*listValue)(&cfg.Proxy.TrustedProxies), "trusted-proxies",
+		"comma-separated IP addresses or CIDR ranges of reverse proxies whose X-Forwarded-* headers are trusted")
+	fs.Var((*listValue)(&cfg.Proxy.GatewayPropagators), "gateway-propagators",
+		"comma-separated trace context formats to accept from API gateways, in addition to W3C: "+strings.Join(GatewayPropagators, ", "))
+
+	fs.BoolVar(&cfg.Debug.GoroutineDumps, "debug-goroutine-dumps", cfg.Debug.GoroutineDumps,
+		"attach a dump of all goroutines to the span of a request whose handler panics")
+}
+
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports whether the configuration is valid.
+func (cfg *Config) Validate() error {
+	var errs []error
+	if _, _, err := ParseListenAddr(cfg.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("invalid listen address: %w", err))
+	}
+	if cfg.AdminAddr != "" {
+		if _, _, err := ParseListenAddr(cfg.AdminAddr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid admin listen address: %w", err))
+		} else if cfg.AdminAddr == cfg.ListenAddr && !strings.HasSuffix(cfg.AdminAddr, ":0") {
+			// Ephemeral ports are chosen independently, so may be shared.
+			errs = append(errs, errors.New("admin listen address must differ from listen address"))
+		}
+	}
+	if cfg.RequestTimeout < 0 {
+		errs = append(errs, errors.New("request timeout must not be negative"))
+	}
+	for route, timeout := range cfg.RouteTimeouts {
+		if timeout < 0 {
+			errs = append(errs, fmt.Errorf("request timeout for %s must not be negative", route))
+		}
+	}
+	if cfg.MaxConcurrentRequests < 0 || cfg.MaxQueuedRequests < 0 {
+		errs = append(errs, errors.New("request concurrency and queue limits must not be negative"))
+	}
+	if cfg.MaxConcurrentRequests > 0 && cfg.QueueTimeout <= 0 {
+		errs = append(errs, errors.New("queue timeout must be positive"))
+	}
+	if cfg.MaxRequestBodyBytes <= 0 {
+		errs = append(errs, errors.New("maximum request body size must be positive"))
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown timeout must be positive"))
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("TLS certificate and key must be specified together"))
+	}
+	if cfg.TLS.CertFile != "" && len(cfg.TLS.AutocertDomains) > 0 {
+		errs = append(errs, errors.New("TLS certificate and autocert domains are mutually exclusive"))
+	}
+	if p := cfg.Telemetry.Phase; p < PhaseUninstrumented || p > PhaseFull {
+		errs = append(errs, fmt.Errorf("phase %d out of range [%d, %d]", p, PhaseUninstrumented, PhaseFull))
+	} else if p != PhaseFull && cfg.Telemetry.ConfigFile != "" {
+		errs = append(errs, errors.New("OpenTelemetry configuration file requires the full pipeline phase"))
+	}
+	if cfg.Telemetry.Dashboard && cfg.Telemetry.ConfigFile != "" {
+		errs = append(errs, errors.New("dashboard is not supported with an OpenTelemetry configuration file"))
+	}
+	if cfg.Telemetry.Smoke && len(cfg.Telemetry.Replay) > 0 {
+		errs = append(errs, errors.New("smoke test and replay are mutually exclusive"))
+	}
+	if r := cfg.Telemetry.SamplerRatio; r < 0 || r > 1 {
+		errs = append(errs, fmt.Errorf("sampler ratio %v out of range [0, 1]", r))
+	}
+	for _, endpoint := range []string{cfg.Telemetry.OTLP.Endpoint, cfg.Telemetry.OTLP.SecondaryEndpoint} {
+		if endpoint == "" {
+			continue
+		}
+		if u, err := url.Parse(endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("invalid OTLP endpoint: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("invalid OTLP endpoint %q: expected http or https URL", endpoint))
+		}
+	}
+	if cfg.Telemetry.OTLP.SpoolMaxBytes <= 0 {
+		errs = append(errs, errors.New("OTLP spool size must be positive"))
+	}
+	if r := cfg.Failures.ErrorRate; r < 0 || r > 1 {
+		errs = append(errs, fmt.Errorf("error rate %v out of range [0, 1]", r))
+	}
+	if f := cfg.Features.MirrorFraction; f < 0 || f > 1 {
+		errs = append(errs, fmt.Errorf("mirror fraction %v out of range [0, 1]", f))
+	}
+	if cfg.Failures.Latency < 0 {
+		errs = append(errs, errors.New("latency must not be negative"))
+	}
+	if fortuneURL := cfg.Downstream.FortuneURL; fortuneURL != "" {
+		if u, err := url.Parse(fortuneURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid fortune service URL: %w", err))
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("invalid fortune service URL %q: expected http or https URL", fortuneURL))
+		}
+	}
+	if cfg.Downstream.FortuneTimeout <= 0 {
+		errs = append(errs, errors.New("fortune service timeout must be positive"))
+	}
+	if addr := cfg.Downstream.ModifierAddr; addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid Modifier service address: %w", err))
+		}
+	}
+	if cfg.Downstream.ModifierTimeout <= 0 {
+		errs = append(errs, errors.New("Modifier service timeout must be positive"))
+	}
+	if cfg.Downstream.WebhookTimeout <= 0 {
+		errs = append(errs, errors.New("webhook timeout must be positive"))
+	}
+	if addr := cfg.Storage.RedisAddr; addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid Redis address: %w", err))
+		}
+	}
+	if cfg.Storage.CacheTTL <= 0 {
+		errs = append(errs, errors.New("cache TTL must be positive"))
+	}
+	if cfg.Storage.MaintenanceInterval < 0 {
+		errs = append(errs, errors.New("maintenance interval must not be negative"))
+	}
+	if cfg.Storage.HistoryRetention < 1 {
+		errs = append(errs, errors.New("history retention must be at least 1 roll"))
+	}
+	if len(cfg.Downstream.KafkaBrokers) > 0 && cfg.Downstream.KafkaTopic == "" {
+		errs = append(errs, errors.New("Kafka topic must be specified with Kafka brokers"))
+	}
+	for _, proxy := range cfg.Proxy.TrustedProxies {
+		if _, err := ParseIPRange(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("invalid trusted proxy: %w", err))
+		}
+	}
+	for _, name := range cfg.Proxy.GatewayPropagators {
+		if !slices.Contains(GatewayPropagators, name) {
+			errs = append(errs, fmt.Errorf("unknown gateway propagator %q, want one of %s",
+				name, strings.Join(GatewayPropagators, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RedactedValue replaces secrets in configuration reported by the server.
+const RedactedValue = "REDACTED"
+
+// Redacted returns a copy of cfg with secrets, such as credentials in
+// OTLP endpoint and downstream service URLs, replaced by RedactedValue,
+// so it may be reported by the server.
+func (cfg *Config) Redacted() *Config {
+	redacted := *cfg
+	redacted.Telemetry.OTLP.Endpoint = RedactURL(cfg.Telemetry.OTLP.Endpoint)
+	redacted.Telemetry.OTLP.SecondaryEndpoint = RedactURL(cfg.Telemetry.OTLP.SecondaryEndpoint)
+	redacted.Downstream.FortuneURL = RedactURL(cfg.Downstream.FortuneURL)
+	return &redacted
+}
+
+// RedactURL returns rawURL with any user information replaced by
+// RedactedValue. Values that can't be parsed as URLs are redacted
+// entirely, in case they hold secrets.
+func RedactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return RedactedValue
+	}
+	if u.User != nil {
+		u.User = url.User(RedactedValue)
+	}
+	return u.String()
+}
+
+// ParseIPRange parses an IP address or CIDR range,
+// returning an address as a range containing only it.
+func ParseIPRange(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	bits := 8 * len(ip)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// ParseListenAddr parses a listen address, returning its network and
+// network-specific address:
+//
+//   - "unix:<path>" returns ("unix", path), a Unix domain socket.
+//   - "systemd" returns ("systemd", ""), the first socket passed by systemd
+//     socket activation; "systemd:<name>" returns ("systemd", name), the
+//     socket with the given FileDescriptorName.
+//   - Otherwise the address must be a TCP host:port, and ("tcp", addr)
+//     is returned.
+func ParseListenAddr(addr string) (network, address string, err error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return "", "", errors.New("missing Unix socket path")
+		}
+		return "unix", path, nil
+	}
+	if addr == "systemd" {
+		return "systemd", "", nil
+	}
+	if name, ok := strings.CutPrefix(addr, "systemd:"); ok {
+		return "systemd", name, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", "", err
+	}
+	return "tcp", addr, nil
+}
+
+// listValue is a flag.Value for a comma-separated list of strings.
+type listValue []string
+
+func (v *listValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+
+// durationMapValue is a flag.Value for a comma-separated
+// list of key=duration pairs.
+type durationMapValue map[string]time.Duration
+
+func (v *durationMapValue) String() string {
+	pairs := make([]string, 0, len(*v))
+	for k, d := range *v {
+		pairs = append(pairs, k+"="+d.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v *durationMapValue) Set(s string) error {
+	m := make(map[string]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		k, dstr, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected key=duration, got %q", pair)
+		}
+		d, err := time.ParseDuration(dstr)
+		if err != nil {
+			return err
+		}
+		m[k] = d
+	}
+	*v = m
+	return nil
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets the environment variables read by Load for the
+// duration of the test, so the test's environment doesn't leak in.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, EnvPrefix) || name == "OTEL_SEMCONV_STABILITY_OPT_IN" {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// writeConfigFile writes a YAML config file, returning its path.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dice.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Default(); !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want the defaults %+v", cfg, want)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := writeConfigFile(t, `
+listen_addr: localhost:9090
+request_timeout: 1s
+telemetry:
+  sampler_ratio: 0.25
+downstream:
+  kafka_topic: from-file
+`)
+	t.Setenv("DICE_CONFIG", path)
+	t.Setenv("DICE_REQUEST_TIMEOUT", "2s")
+	t.Setenv("DICE_SAMPLER_RATIO", "0.5")
+	cfg, err := Load([]string{"-sampler-ratio=0.75"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name      string
+		got, want any
+	}{
+		// Set only in the file.
+		{"listen address", cfg.ListenAddr, "localhost:9090"},
+		{"Kafka topic", cfg.Downstream.KafkaTopic, "from-file"},
+		// The environment overrides the file.
+		{"request timeout", cfg.RequestTimeout, 2 * time.Second},
+		// Flags override the environment and the file.
+		{"sampler ratio", cfg.Telemetry.SamplerRatio, 0.75},
+		// Set nowhere.
+		{"shutdown timeout", cfg.ShutdownTimeout, Default().ShutdownTimeout},
+	} {
+		if test.got != test.want {
+			t.Errorf("got %s %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestLoadConfigFlagOverridesEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DICE_CONFIG", writeConfigFile(t, "listen_addr: localhost:9090\n"))
+	path := writeConfigFile(t, "listen_addr: localhost:9191\n")
+	cfg, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "localhost:9191"; cfg.ListenAddr != want {
+		t.Errorf("got listen address %q, want %q", cfg.ListenAddr, want)
+	}
+}
+
+func TestLoadEnvParsing(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("DICE_ROUTE_TIMEOUTS", "/roll/:dice=5s, /simulate/:dice=1m")
+	t.Setenv("DICE_KAFKA_BROKERS", "kafka-1:9092, ,kafka-2:9092")
+	t.Setenv("DICE_TETRAPHOBIC", "false")
+	t.Setenv("DICE_MAX_REQUEST_BODY_BYTES", "1024")
+	t.Setenv("OTEL_SEMCONV_STABILITY_OPT_IN", "http/dup")
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTimeouts := map[string]time.Duration{"/roll/:dice": 5 * time.Second, "/simulate/:dice": time.Minute}
+	if !reflect.DeepEqual(cfg.RouteTimeouts, wantTimeouts) {
+		t.Errorf("got route timeouts %v, want %v", cfg.RouteTimeouts, wantTimeouts)
+	}
+	wantBrokers := []string{"kafka-1:9092", "kafka-2:9092"}
+	if !reflect.DeepEqual(cfg.Downstream.KafkaBrokers, wantBrokers) {
+		t.Errorf("got Kafka brokers %q, want %q", cfg.Downstream.KafkaBrokers, wantBrokers)
+	}
+	if cfg.Failures.Tetraphobic {
+		t.Error("got tetraphobic, want it turned off by DICE_TETRAPHOBIC")
+	}
+	if cfg.MaxRequestBodyBytes != 1024 {
+		t.Errorf("got maximum request body size %d, want 1024", cfg.MaxRequestBodyBytes)
+	}
+	if cfg.Telemetry.SemconvStabilityOptIn != "http/dup" {
+		t.Errorf("got semconv stability opt-in %q, want %q", cfg.Telemetry.SemconvStabilityOptIn, "http/dup")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		env  map[string]string
+		file string
+		args []string
+		// want is a substring of the error.
+		want string
+	}{{
+		name: "env duration",
+		env:  map[string]string{"DICE_REQUEST_TIMEOUT": "soon"},
+		want: "DICE_REQUEST_TIMEOUT",
+	}, {
+		name: "env route timeouts",
+		env:  map[string]string{"DICE_ROUTE_TIMEOUTS": "/roll/:dice"},
+		want: "expected key=duration",
+	}, {
+		name: "flag",
+		args: []string{"-phase=full"},
+		want: "invalid value",
+	}, {
+		name: "unknown file field",
+		file: "listen_adr: localhost:9090\n",
+		want: "field listen_adr not found",
+	}, {
+		name: "missing file",
+		args: []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")},
+		want: "no such file",
+	}, {
+		name: "invalid after merging",
+		file: "telemetry:\n  sampler_ratio: 0.5\n",
+		args: []string{"-sampler-ratio=2"},
+		want: "sampler ratio 2 out of range",
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			if test.file != "" {
+				t.Setenv("DICE_CONFIG", writeConfigFile(t, test.file))
+			}
+			_, err := Load(test.args)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults are invalid: %v", err)
+	}
+	for _, test := range []struct {
+		name   string
+		modify func(*Config)
+		// want is a substring of the error.
+		want string
+	}{
+		{"listen address", func(c *Config) { c.ListenAddr = "localhost" }, "invalid listen address"},
+		{"shared admin address", func(c *Config) { c.AdminAddr = c.ListenAddr }, "must differ"},
+		{"negative route timeout", func(c *Config) {
+			c.RouteTimeouts = map[string]time.Duration{"/roll/:dice": -time.Second}
+		}, "/roll/:dice must not be negative"},
+		{"queue timeout", func(c *Config) { c.MaxConcurrentRequests, c.QueueTimeout = 1, 0 }, "queue timeout"},
+		{"TLS key without certificate", func(c *Config) { c.TLS.KeyFile = "key.pem" }, "specified together"},
+		{"phase too low", func(c *Config) { c.Telemetry.Phase = 0 }, "phase 0 out of range"},
+		{"phase too high", func(c *Config) { c.Telemetry.Phase = PhaseFull + 1 }, "out of range"},
+		{"config file without full phase", func(c *Config) {
+			c.Telemetry.Phase, c.Telemetry.ConfigFile = PhaseTraces, "otel.yaml"
+		}, "requires the full pipeline phase"},
+		{"sampler ratio", func(c *Config) { c.Telemetry.SamplerRatio = -0.1 }, "sampler ratio"},
+		{"OTLP endpoint", func(c *Config) { c.Telemetry.OTLP.Endpoint = "grpc://localhost:4317" }, "expected http or https URL"},
+		{"fortune URL", func(c *Config) { c.Downstream.FortuneURL = "localhost:8082" }, "fortune service URL"},
+		{"Redis address", func(c *Config) { c.Storage.RedisAddr = "localhost" }, "invalid Redis address"},
+		{"Kafka topic", func(c *Config) {
+			c.Downstream.KafkaBrokers, c.Downstream.KafkaTopic = []string{"localhost:9092"}, ""
+		}, "Kafka topic"},
+		{"trusted proxy", func(c *Config) { c.Proxy.TrustedProxies = []string{"proxy"} }, "invalid trusted proxy"},
+		{"gateway propagator", func(c *Config) { c.Proxy.GatewayPropagators = []string{"jaeger"} }, `unknown gateway propagator "jaeger"`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Default()
+			test.modify(cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/clock"
+	"oteldemo/config"
+	"oteldemo/dice"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// seededIDGenerator generates trace and span IDs from a
+// fixed seed, so they are the same in every test run.
+type seededIDGenerator struct {
+	rng *rand.Rand
+}
+
+func (g seededIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	var traceID trace.TraceID
+	g.rng.Read(traceID[:])
+	return traceID, g.NewSpanID(ctx, traceID)
+}
+
+func (g seededIDGenerator) NewSpanID(context.Context, trace.TraceID) trace.SpanID {
+	var spanID trace.SpanID
+	g.rng.Read(spanID[:])
+	return spanID
+}
+
+// TestConsoleGolden compares the console exporters' output, which the
+// audience sees on screen, for a fixed request against golden files in
+// testdata. Run with -update to accept intentional changes.
+//
+// The output is made deterministic by seeding the ID generator, and
+// using a fake clock for the server, which seeds its rolls and measures
+// request durations, and zeroing the timestamps recorded by the SDK.
+func TestConsoleGolden(t *testing.T) {
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("dice-server"))
+
+	var spansOut bytes.Buffer
+	spanExporter, err := stdouttrace.New(
+		stdouttrace.WithPrettyPrint(),
+		stdouttrace.WithWriter(&spansOut),
+		stdouttrace.WithoutTimestamps(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithIDGenerator(seededIDGenerator{rand.New(rand.NewSource(1))}),
+		sdktrace.WithSyncer(spanExporter),
+	)
+	defer tp.Shutdown(context.Background())
+
+	var metricsOut bytes.Buffer
+	metricExporter, err := stdoutmetric.New(
+		stdoutmetric.WithPrettyPrint(),
+		stdoutmetric.WithWriter(&metricsOut),
+		stdoutmetric.WithoutTimestamps(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(reader),
+	)
+	defer mp.Shutdown(context.Background())
+
+	srv, err := dice.New(
+		dice.WithConfig(config.Default()),
+		dice.WithTracerProvider(tp),
+		dice.WithMeterProvider(mp),
+		dice.WithPropagators(propagation.TraceContext{}),
+		dice.WithClock(clock.NewFake(time.Date(2024, 2, 20, 18, 0, 0, 0, time.UTC))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/roll/3d6", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	// Export metrics as the periodic reader does, but with scopes and
+	// data points in a fixed order; the SDK collects them in map order.
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(rm.ScopeMetrics, func(i, j int) bool {
+		return rm.ScopeMetrics[i].Scope.Name < rm.ScopeMetrics[j].Scope.Name
+	})
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sortDataPoints(m.Data)
+		}
+	}
+	if err := metricExporter.Export(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, "console_spans.golden", spansOut.Bytes())
+	assertGolden(t, "console_metrics.golden", metricsOut.Bytes())
+}
+
+// sortDataPoints sorts the data points of a metric by their attributes.
+func sortDataPoints(data metricdata.Aggregation) {
+	switch data := data.(type) {
+	case metricdata.Sum[int64]:
+		sortByAttributes(data.DataPoints, func(dp metricdata.DataPoint[int64]) attribute.Set { return dp.Attributes })
+	case metricdata.Gauge[int64]:
+		sortByAttributes(data.DataPoints, func(dp metricdata.DataPoint[int64]) attribute.Set { return dp.Attributes })
+	case metricdata.Histogram[float64]:
+		sortByAttributes(data.DataPoints, func(dp metricdata.HistogramDataPoint[float64]) attribute.Set { return dp.Attributes })
+	}
+}
+
+func sortByAttributes[T any](points []T, attrs func(T) attribute.Set) {
+	enc := attribute.DefaultEncoder()
+	sort.Slice(points, func(i, j int) bool {
+		a, b := attrs(points[i]), attrs(points[j])
+		return a.Encoded(enc) < b.Encoded(enc)
+	})
+}
+
+// assertGolden compares got with the named golden file in
+// testdata, or updates the file if the -update flag is set.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("console output differs from %s (run with -update to accept):\n got:\n%s\nwant:\n%s", path, got, want)
+	}
+}
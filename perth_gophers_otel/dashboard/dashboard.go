@@ -0,0 +1,302 @@
+// Package dashboard renders live telemetry in the terminal: recent spans,
+// the request rate, and a histogram of rolled values. It is fed by an
+// in-memory span exporter and metric reader, so the demo is self-contained
+// when no backend is available.
+//
+// The dashboard's buffers have a fixed memory budget, so a long-running
+// demo doesn't slowly grow. Telemetry evicted to stay within budget is
+// counted by the dashboard_evictions metric, by buffer.
+//
+// The budget covers only the dashboard, whose span buffer is the demo's
+// only in-memory trace buffer. The roll history is kept in the store,
+// not in memory, and is bounded by compaction instead; there are no
+// server-sent event backlogs.
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// maxSpans is the number of recent spans shown.
+	maxSpans = 15
+
+	// maxSpanBytes is the budget for recent spans, as estimated by
+	// spanSize; spans with many large attributes or events are evicted
+	// before maxSpans is reached.
+	maxSpanBytes = 256 << 10
+
+	// maxLogLines is the number of recent log lines shown.
+	maxLogLines = 5
+
+	// maxLogLineBytes is the length at which log lines are truncated,
+	// including lines still being written.
+	maxLogLineBytes = 1 << 10
+
+	// maxRequests is the most request end times kept for computing
+	// the request rate, bounding the buffer under heavy load.
+	maxRequests = 100_000
+
+	// rateWindow is the window over which the request rate is computed.
+	rateWindow = 10 * time.Second
+
+	// refreshInterval is how often the dashboard is redrawn.
+	refreshInterval = time.Second
+
+	// rollsMetric is the metric whose values are shown as a histogram.
+	rollsMetric = "dice_rolls"
+)
+
+// Dashboard collects telemetry for rendering in the terminal.
+// It implements sdktrace.SpanExporter and io.Writer (for logs).
+type Dashboard struct {
+	reader    *sdkmetric.ManualReader
+	evictions metric.Int64Counter
+
+	mu        sync.Mutex
+	spans     []sdktrace.ReadOnlySpan // most recent last
+	spanBytes int                     // estimated size of spans
+	requests  []time.Time             // end times of server spans within rateWindow
+	logs      []string
+	partial   []byte // incomplete log line
+}
+
+// New returns a new Dashboard, counting evictions
+// with the global MeterProvider.
+func New() *Dashboard {
+	d := &Dashboard{reader: sdkmetric.NewManualReader()}
+	// The global Meter delegates to the MeterProvider once it is set,
+	// which may be after the Dashboard is created, to be its reader.
+	evictions, err := otel.Meter("oteldemo/dashboard").Int64Counter(
+		"dashboard_evictions",
+		metric.WithDescription("Telemetry evicted from the dashboard's buffers to stay within budget"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	d.evictions = evictions
+	return d
+}
+
+// evicted counts n evictions from the named buffer.
+func (d *Dashboard) evicted(buffer string, n int) {
+	if d.evictions != nil && n > 0 {
+		d.evictions.Add(context.Background(), int64(n), metric.WithAttributes(
+			attribute.String("buffer", buffer),
+		))
+	}
+}
+
+// Reader returns the metric reader to register with the MeterProvider.
+func (d *Dashboard) Reader() sdkmetric.Reader {
+	return d.reader
+}
+
+// ExportSpans records spans for display.
+func (d *Dashboard) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, span := range spans {
+		if span.SpanKind() == trace.SpanKindServer {
+			d.requests = append(d.requests, span.EndTime())
+		}
+		d.spanBytes += spanSize(span)
+	}
+	if n := len(d.requests) - maxRequests; n > 0 {
+		d.requests = append(d.requests[:0], d.requests[n:]...)
+		d.evicted("requests", n)
+	}
+	d.spans = append(d.spans, spans...)
+	var n int
+	for ; len(d.spans)-n > maxSpans || (d.spanBytes > maxSpanBytes && n < len(d.spans)); n++ {
+		d.spanBytes -= spanSize(d.spans[n])
+	}
+	if n > 0 {
+		// Clear the evicted spans, so they can be garbage collected.
+		clear(d.spans[:n])
+		d.spans = append(d.spans[:0], d.spans[n:]...)
+		d.evicted("spans", n)
+	}
+	return nil
+}
+
+// spanSize estimates the memory retained by a span, from the size
+// of its attributes and events, which dominate for large spans.
+func spanSize(span sdktrace.ReadOnlySpan) int {
+	const overhead = 512 // fixed fields, IDs, and the like
+	size := overhead + len(span.Name()) + attributesSize(span.Attributes())
+	for _, event := range span.Events() {
+		size += len(event.Name) + attributesSize(event.Attributes)
+	}
+	for _, link := range span.Links() {
+		size += attributesSize(link.Attributes)
+	}
+	return size
+}
+
+func attributesSize(attrs []attribute.KeyValue) int {
+	var size int
+	for _, kv := range attrs {
+		// Strings and slices are estimated by their
+		// emitted form; other values by a KeyValue.
+		size += len(kv.Key) + 32
+		switch kv.Value.Type() {
+		case attribute.STRING, attribute.BOOLSLICE, attribute.INT64SLICE,
+			attribute.FLOAT64SLICE, attribute.STRINGSLICE:
+			size += len(kv.Value.Emit())
+		}
+	}
+	return size
+}
+
+// Shutdown implements sdktrace.SpanExporter.
+func (d *Dashboard) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Write records log output for display, e.g. with log.SetOutput,
+// so logs don't disrupt the dashboard.
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	written := len(p)
+	for len(p) > 0 {
+		line, rest, complete := bytes.Cut(p, []byte{'\n'})
+		p = rest
+		if room := maxLogLineBytes - len(d.partial); len(line) > room {
+			// Drop the rest of a long line, counting the bytes dropped.
+			d.evicted("log_bytes", len(line)-max(room, 0))
+			line = line[:max(room, 0)]
+		}
+		d.partial = append(d.partial, line...)
+		if complete {
+			d.logs = append(d.logs, string(d.partial))
+			d.partial = d.partial[:0]
+		}
+	}
+	if n := len(d.logs) - maxLogLines; n > 0 {
+		d.logs = append(d.logs[:0], d.logs[n:]...)
+		d.evicted("logs", n)
+	}
+	return written, nil
+}
+
+// Run redraws the dashboard to w every second, until ctx is cancelled.
+func (d *Dashboard) Run(ctx context.Context, w io.Writer) error {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		var buf bytes.Buffer
+		// Move the cursor home and clear the screen.
+		buf.WriteString("\x1b[H\x1b[2J")
+		d.render(ctx, &buf)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dashboard) render(ctx context.Context, w io.Writer) {
+	var rm metricdata.ResourceMetrics
+	collectErr := d.reader.Collect(ctx, &rm)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	i := sort.Search(len(d.requests), func(i int) bool {
+		return now.Sub(d.requests[i]) < rateWindow
+	})
+	d.requests = append(d.requests[:0], d.requests[i:]...)
+
+	fmt.Fprintf(w, "dice server  %s\n\n", now.Format(time.TimeOnly))
+	fmt.Fprintf(w, "Requests: %.1f/s (last %s)\n\n", float64(len(d.requests))/rateWindow.Seconds(), rateWindow)
+
+	fmt.Fprintln(w, "Rolled values:")
+	if collectErr != nil {
+		fmt.Fprintf(w, "  error collecting metrics: %v\n", collectErr)
+	} else {
+		renderRolls(w, rollCounts(rm))
+	}
+
+	fmt.Fprintln(w, "\nRecent spans:")
+	for i := len(d.spans) - 1; i >= 0; i-- {
+		span := d.spans[i]
+		status := "ok"
+		if span.Status().Code == codes.Error {
+			status = "ERROR"
+		}
+		fmt.Fprintf(w, "  %s  %-32.32s %10s  %-5s  %s\n",
+			span.EndTime().Format(time.TimeOnly), span.Name(),
+			span.EndTime().Sub(span.StartTime()).Round(time.Microsecond),
+			status, span.SpanContext().TraceID(),
+		)
+	}
+
+	fmt.Fprintln(w, "\nLogs:")
+	for _, line := range d.logs {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+}
+
+// rollCounts returns the total count of each rolled value.
+func rollCounts(rm metricdata.ResourceMetrics) map[int64]int64 {
+	counts := make(map[int64]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if m.Name != rollsMetric || !ok {
+				continue
+			}
+			for _, dp := range sum.DataPoints {
+				if v, ok := dp.Attributes.Value("value"); ok {
+					counts[v.AsInt64()] += dp.Value
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// renderRolls renders counts as a horizontal bar chart.
+func renderRolls(w io.Writer, counts map[int64]int64) {
+	const width = 50
+	if len(counts) == 0 {
+		fmt.Fprintln(w, "  (none yet)")
+		return
+	}
+	values := make([]int64, 0, len(counts))
+	var most int64
+	for v, n := range counts {
+		values = append(values, v)
+		if n > most {
+			most = n
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	for _, v := range values {
+		n := counts[v]
+		bar := strings.Repeat("#", int(n*width/most))
+		fmt.Fprintf(w, "  %4d | %-*s %d\n", v, width, bar, n)
+	}
+}
@@ -0,0 +1,25 @@
+package dice
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newAdminEcho returns an echo.Echo serving operational endpoints. These
+// are served on a separate listener, bound to localhost by default, to
+// keep them off the public API surface as one would in production.
+func newAdminEcho() *echo.Echo {
+	r := echo.New()
+	r.HideBanner = true
+
+	r.GET("/debug/pprof/*", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+	r.GET("/debug/pprof/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+	r.GET("/debug/pprof/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+	r.Any("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	r.GET("/debug/pprof/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+	r.GET("/debug/vars", echo.WrapHandler(expvar.Handler()))
+	return r
+}
@@ -0,0 +1,40 @@
+package dice
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/baggage"
+)
+
+// rollSizeKey is the baggage member classifying the size of a roll,
+// which downstream services record on their own spans.
+const rollSizeKey = "roll.size"
+
+// rollSize classifies a roll of n dice as small, medium or large.
+func rollSize(n int64) string {
+	switch {
+	case n <= 2:
+		return "small"
+	case n <= 10:
+		return "medium"
+	default:
+		return "large"
+	}
+}
+
+// withRollBaggage returns ctx with its baggage enriched with the size of
+// a roll of n dice, so it is propagated to the downstream services
+// called for the roll along with the trace context. Unlike span
+// attributes, baggage crosses process boundaries, letting downstream
+// services classify their work by the request that caused it.
+func withRollBaggage(ctx context.Context, n int64) context.Context {
+	member, err := baggage.NewMember(rollSizeKey, rollSize(n))
+	if err != nil {
+		return ctx
+	}
+	b, err := baggage.FromContext(ctx).SetMember(member)
+	if err != nil {
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, b)
+}
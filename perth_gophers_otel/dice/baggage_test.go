@@ -0,0 +1,45 @@
+package dice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+
+	"oteldemo/config"
+)
+
+func TestRollBaggage(t *testing.T) {
+	for dice, want := range map[string]string{
+		"1d6":   "small",
+		"2d20":  "small",
+		"3d6":   "medium",
+		"10d6":  "medium",
+		"11d6":  "large",
+		"100d6": "large",
+	} {
+		t.Run(dice, func(t *testing.T) {
+			var got baggage.Baggage
+			fortunes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = baggage.Parse(r.Header.Get("baggage"))
+				w.Write([]byte(`{"fortune": "Fortune favours the bold.", "luck": 0}`))
+			}))
+			defer fortunes.Close()
+
+			cfg := config.Default()
+			cfg.Downstream.FortuneURL = fortunes.URL
+			s := newTestServer(t, cfg, WithPropagators(propagation.NewCompositeTextMapPropagator(
+				propagation.TraceContext{},
+				propagation.Baggage{},
+			)))
+			if rec := s.get("/roll/" + dice); rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if size := got.Member(rollSizeKey).Value(); size != want {
+				t.Errorf("got %s=%q in the fortune request's baggage, want %q", rollSizeKey, size, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package dice
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// BenchmarkRoll measures the cost of serving a roll, with
+// increasing amounts of the telemetry pipeline in place:
+//
+//   - noop: no-op providers, as when instrumentation is not set up;
+//   - unsampled: an SDK TracerProvider that samples nothing, and a
+//     no-op MeterProvider, taking the handler's fast path;
+//   - sdk: SDK providers, with nothing reading the telemetry;
+//   - exporters: SDK providers exporting to stdout, discarded.
+func BenchmarkRoll(b *testing.B) {
+	b.Run("noop", func(b *testing.B) {
+		benchmarkRoll(b,
+			WithTracerProvider(tracenoop.NewTracerProvider()),
+			WithMeterProvider(metricnoop.NewMeterProvider()),
+		)
+	})
+	b.Run("unsampled", func(b *testing.B) {
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample()))
+		defer shutdown(b, tp.Shutdown)
+		benchmarkRoll(b,
+			WithTracerProvider(tp),
+			WithMeterProvider(metricnoop.NewMeterProvider()),
+		)
+	})
+	b.Run("sdk", func(b *testing.B) {
+		tp := sdktrace.NewTracerProvider()
+		mp := sdkmetric.NewMeterProvider()
+		defer shutdown(b, tp.Shutdown, mp.Shutdown)
+		benchmarkRoll(b, WithTracerProvider(tp), WithMeterProvider(mp))
+	})
+	b.Run("exporters", func(b *testing.B) {
+		spanExporter, err := stdouttrace.New(stdouttrace.WithWriter(io.Discard))
+		if err != nil {
+			b.Fatal(err)
+		}
+		metricExporter, err := stdoutmetric.New(stdoutmetric.WithWriter(io.Discard))
+		if err != nil {
+			b.Fatal(err)
+		}
+		tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter))
+		mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+		defer shutdown(b, tp.Shutdown, mp.Shutdown)
+		benchmarkRoll(b, WithTracerProvider(tp), WithMeterProvider(mp))
+	})
+}
+
+// BenchmarkRollSampling measures the cost of serving a roll whose span
+// is sampled, and so enriched with events, and one whose span is not,
+// with die events enabled.
+func BenchmarkRollSampling(b *testing.B) {
+	enabled := dieEvents.Enabled()
+	dieEvents.Set(true)
+	b.Cleanup(func() { dieEvents.Set(enabled) })
+
+	for _, bc := range []struct {
+		name    string
+		sampler sdktrace.Sampler
+	}{
+		{"sampled", sdktrace.AlwaysSample()},
+		{"unsampled", sdktrace.NeverSample()},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			tp := sdktrace.NewTracerProvider(
+				sdktrace.WithSampler(bc.sampler),
+				sdktrace.WithSpanProcessor(sdktrace.NewSimpleSpanProcessor(tracetest.NewNoopExporter())),
+			)
+			defer shutdown(b, tp.Shutdown)
+			benchmarkRoll(b,
+				WithTracerProvider(tp),
+				WithMeterProvider(metricnoop.NewMeterProvider()),
+			)
+		})
+	}
+}
+
+// BenchmarkRollingDiceEvent measures adding the "rolling dice" event
+// to sampled spans from concurrent requests, with its attributes passed
+// in a new slice, and in a pooled slice by addEvent.
+func BenchmarkRollingDiceEvent(b *testing.B) {
+	tp := sdktrace.NewTracerProvider()
+	defer shutdown(b, tp.Shutdown)
+	tracer := tp.Tracer("bench")
+	b.Run("variadic", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_, span := tracer.Start(context.Background(), "roll")
+				span.AddEvent("rolling dice", trace.WithAttributes(
+					attribute.Int64("n", 3),
+					attribute.Int64("sides", 6),
+				))
+				span.End()
+			}
+		})
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_, span := tracer.Start(context.Background(), "roll")
+				addEvent(span, "rolling dice", nKey.Int64(3), sidesKey.Int64(6))
+				span.End()
+			}
+		})
+	})
+}
+
+// BenchmarkDie measures rolling 3d6 from concurrent requests, with
+// each request seeding its own generator, as the handler once did, and
+// with a generator shared between requests, and with Zipf generators
+// cached by number of sides.
+func BenchmarkDie(b *testing.B) {
+	const n, sides = 3, 6
+	for _, loaded := range []bool{false, true} {
+		name := "fair"
+		if loaded {
+			name = "loaded"
+		}
+		b.Run(name+"/reseeded", func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+					die := newDie(rng, sides, loaded)
+					for range n {
+						die()
+					}
+				}
+			})
+		})
+		b.Run(name+"/shared", func(b *testing.B) {
+			rng := newSharedRand(time.Now().UnixNano())
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					die := newDie(rng, sides, loaded)
+					for range n {
+						die()
+					}
+				}
+			})
+		})
+		b.Run(name+"/cached", func(b *testing.B) {
+			zipfs, err := newZipfCache(metricnoop.NewMeterProvider().Meter(""), newSharedRand(time.Now().UnixNano()))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					die := zipfs.die(sides, loaded)
+					for range n {
+						die()
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkParseDice measures parsing valid notation, and rejecting
+// invalid notation, as under fuzzing or load from a broken client.
+func BenchmarkParseDice(b *testing.B) {
+	for _, input := range []string{"3d6", "128d6", "2x6"} {
+		b.Run(input, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				parseDice(input)
+			}
+		})
+	}
+}
+
+func benchmarkRoll(b *testing.B, opts ...Option) {
+	s, err := New(append(opts, WithPropagators(propagation.TraceContext{}))...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := s.Handler()
+	req := httptest.NewRequest(http.MethodGet, "/roll/3d6", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+		}
+	}
+}
+
+// shutdown calls each of the shutdown functions, outside the
+// benchmark timer, to flush exporters started by a benchmark.
+func shutdown(b *testing.B, fns ...func(context.Context) error) {
+	b.StopTimer()
+	for _, fn := range fns {
+		if err := fn(context.Background()); err != nil {
+			b.Error(err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package dice
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// limitBody is middleware that rejects requests with bodies larger
+// than the configured maximum with 413 Content Too Large, counting
+// rejections by s.bodyLimitRejections.
+//
+// Requests declaring a larger Content-Length are rejected up front;
+// otherwise the body is limited as it is read, and the handler's
+// resulting *http.MaxBytesError is turned into a 413 response.
+func (s *Server) limitBody(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit := s.cfg.MaxRequestBodyBytes
+		req := c.Request()
+		if req.ContentLength > limit {
+			return s.rejectBody(c, &http.MaxBytesError{Limit: limit})
+		}
+		req.Body = http.MaxBytesReader(c.Response(), req.Body, limit)
+		err := next(c)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) && !c.Response().Committed {
+			return s.rejectBody(c, err)
+		}
+		return err
+	}
+}
+
+func (s *Server) rejectBody(c echo.Context, err error) error {
+	s.bodyLimitRejections.Add(c.Request().Context(), 1, s.routeAttrs.Option(c.Path()))
+	return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "request body too large").SetInternal(err)
+}
@@ -0,0 +1,173 @@
+package dice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// chaosFaultKey identifies the kind of fault injected into a request.
+const chaosFaultKey = attribute.Key("chaos.fault")
+
+// chaosStatusCodes are the status codes of injected errors.
+var chaosStatusCodes = []int{
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// chaosSettings configures the faults injected by the chaos middleware.
+// The zero value injects no faults.
+type chaosSettings struct {
+	// ErrorRate is the probability of responding with a random 5xx error.
+	ErrorRate float64 `json:"error_rate"`
+
+	// LatencyRate is the probability of delaying a request by Latency.
+	LatencyRate float64      `json:"latency_rate"`
+	Latency     jsonDuration `json:"latency"`
+
+	// ResetRate is the probability of resetting the connection,
+	// without responding.
+	ResetRate float64 `json:"reset_rate"`
+
+	// Routes holds the routes to inject faults into, e.g. "/roll/:dice".
+	// If empty, faults are injected into all routes except health checks.
+	Routes []string `json:"routes,omitempty"`
+}
+
+func (cs *chaosSettings) validate() error {
+	for name, p := range map[string]float64{
+		"error_rate":   cs.ErrorRate,
+		"latency_rate": cs.LatencyRate,
+		"reset_rate":   cs.ResetRate,
+	} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("%s %v out of range [0,1]", name, p)
+		}
+	}
+	if cs.Latency < 0 {
+		return errors.New("latency must be non-negative")
+	}
+	return nil
+}
+
+// targets reports whether faults should be injected into the route.
+func (cs *chaosSettings) targets(route string) bool {
+	if len(cs.Routes) == 0 {
+		return !healthRoutes[route]
+	}
+	return slices.Contains(cs.Routes, route)
+}
+
+// jsonDuration is a time.Duration represented in JSON
+// as a string, like "250ms".
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(v)
+	return nil
+}
+
+// addChaosRoutes adds routes to r for getting (GET /chaos)
+// and replacing (PUT /chaos) the chaos settings.
+func (s *Server) addChaosRoutes(r *echo.Echo) {
+	r.GET("/chaos", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, s.chaosSettings.Load())
+	})
+	r.PUT("/chaos", func(c echo.Context) error {
+		var settings chaosSettings
+		if err := json.NewDecoder(c.Request().Body).Decode(&settings); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := settings.validate(); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		s.chaosSettings.Store(&settings)
+		log.Printf("chaos settings: %+v", settings)
+		return c.JSON(http.StatusOK, &settings)
+	})
+}
+
+// chaos is middleware that injects faults into requests, as configured
+// through the admin API: random 5xx errors, latency, and connection
+// resets. Each fault is recorded as a span event, so it can be found
+// while diagnosing the symptoms from telemetry.
+func (s *Server) chaos(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cs := s.chaosSettings.Load()
+		if !cs.targets(c.Path()) {
+			return next(c)
+		}
+		ctx := c.Request().Context()
+		span := trace.SpanFromContext(ctx)
+		if cs.Latency > 0 && rand.Float64() < cs.LatencyRate {
+			span.AddEvent("chaos", trace.WithAttributes(
+				chaosFaultKey.String("latency"),
+				attribute.String("chaos.latency", time.Duration(cs.Latency).String()),
+			))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.clock.After(time.Duration(cs.Latency)):
+			}
+		}
+		if rand.Float64() < cs.ResetRate {
+			span.AddEvent("chaos", trace.WithAttributes(chaosFaultKey.String("connection_reset")))
+			return resetConnection(c)
+		}
+		if rand.Float64() < cs.ErrorRate {
+			code := chaosStatusCodes[rand.Intn(len(chaosStatusCodes))]
+			span.AddEvent("chaos", trace.WithAttributes(chaosFaultKey.String("error")))
+			return echo.NewHTTPError(code)
+		}
+		return next(c)
+	}
+}
+
+// errConnectionReset is returned by resetConnection, so that the
+// request is reported as failed by instrumentation.
+var errConnectionReset = errors.New("chaos: connection reset")
+
+// resetConnection resets the request's connection without responding.
+// HTTP/1 connections are hijacked and closed with a TCP RST; requests on
+// connections that cannot be hijacked, such as HTTP/2 streams, are
+// aborted with http.ErrAbortHandler, which resets the stream.
+func resetConnection(c echo.Context) error {
+	conn, _, err := http.NewResponseController(c.Response()).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		// Discard unsent data and send RST on close.
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+	// Nothing can be written to a hijacked connection,
+	// so stop the error handler from trying.
+	c.Response().Committed = true
+	c.Response().Status = 0
+	return errConnectionReset
+}
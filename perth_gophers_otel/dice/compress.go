@@ -0,0 +1,228 @@
+package dice
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// contentEncodingKey is the span and metric attribute
+// recording the response content encoding.
+const contentEncodingKey = attribute.Key("http.response.content_encoding")
+
+// minCompressBytes is the size of response body below which responses
+// aren't compressed, as compressing them saves few bytes, if any.
+const minCompressBytes = 1 << 10
+
+// compress is middleware that compresses responses with gzip or deflate,
+// as accepted by the client's Accept-Encoding header, once their bodies
+// exceed minCompressBytes or are flushed. The encoding used is
+// recorded on the span, and the response body sizes before and after
+// compression are recorded by s.uncompressedBytes and s.compressedBytes.
+//
+// Errors returned by handlers are rendered by the error handler after
+// compress returns, so problem responses are not compressed.
+func (s *Server) compress(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if skipTelemetry(c) {
+			return next(c)
+		}
+		res := c.Response()
+		res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+		encoding := negotiateEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding))
+		if c.Request().Method == http.MethodHead {
+			encoding = "identity"
+		}
+
+		cw := &compressWriter{ResponseWriter: res.Writer, encoding: encoding}
+		res.Writer = cw
+		defer func() {
+			res.Writer = cw.ResponseWriter
+			if err := cw.Close(); err != nil {
+				c.Logger().Error(err)
+			}
+			if !cw.wroteHeader {
+				// Nothing written; the error handler will respond.
+				return
+			}
+			ctx := c.Request().Context()
+			if !cw.compressing {
+				encoding = "identity"
+			} else {
+				trace.SpanFromContext(ctx).SetAttributes(contentEncodingKey.String(encoding))
+			}
+			attrs := s.encodingAttrs.Option(encoding)
+			s.uncompressedBytes.Add(ctx, cw.uncompressed, attrs)
+			s.compressedBytes.Add(ctx, cw.compressed, attrs)
+		}()
+		return next(c)
+	}
+}
+
+// negotiateEncoding returns the preferred content encoding accepted by
+// the given Accept-Encoding header value: "gzip", "deflate", or
+// "identity" if neither is accepted.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "identity", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "deflate" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		// Prefer gzip when equally weighted, as it is more widely supported.
+		if q > bestQ || (q == bestQ && q > 0 && coding == "gzip") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressWriter is an http.ResponseWriter that compresses the response
+// body, unless its encoding is "identity", the handler set its own
+// Content-Encoding, or the response has no body. The header and body are
+// buffered until the body exceeds minCompressBytes or is flushed, and
+// smaller bodies are written uncompressed when the writer is closed. It
+// counts the bytes written before and after compression.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	w           io.WriteCloser
+	compressing bool
+	wroteHeader bool
+
+	// buffering is set while the header and body are held in buf,
+	// until deciding whether to compress. code is the held status.
+	buffering bool
+	code      int
+	buf       []byte
+
+	uncompressed int64
+	compressed   int64
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.encoding != "identity" && w.Header().Get(echo.HeaderContentEncoding) == "" && bodyAllowed(code) {
+		w.buffering, w.code = true, code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// startCompressing writes the held header, with the Content-Encoding,
+// and compresses the buffered body.
+func (w *compressWriter) startCompressing() error {
+	w.buffering, w.compressing = false, true
+	h := w.Header()
+	h.Set(echo.HeaderContentEncoding, w.encoding)
+	h.Del(echo.HeaderContentLength)
+	dst := countingWriter{w.ResponseWriter, &w.compressed}
+	if w.encoding == "gzip" {
+		w.w = gzip.NewWriter(dst)
+	} else {
+		w.w, _ = flate.NewWriter(dst, flate.DefaultCompression)
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	_, err := w.w.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// writeBuffered writes the held header and buffered body uncompressed.
+func (w *compressWriter) writeBuffered() error {
+	w.buffering = false
+	w.ResponseWriter.WriteHeader(w.code)
+	n, err := w.ResponseWriter.Write(w.buf)
+	w.compressed += int64(n)
+	w.buf = nil
+	return err
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.uncompressed += int64(len(p))
+	if w.buffering {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) <= minCompressBytes {
+			return len(p), nil
+		}
+		if err := w.startCompressing(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if !w.compressing {
+		w.compressed += int64(len(p))
+		return w.ResponseWriter.Write(p)
+	}
+	return w.w.Write(p)
+}
+
+// Flush flushes any buffered compressed data to the client. Responses
+// flushed while buffering are compressed, as streams may be long-lived.
+func (w *compressWriter) Flush() {
+	if w.buffering {
+		w.startCompressing()
+	}
+	if w.compressing {
+		if f, ok := w.w.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close writes bodies too small to compress, or flushes the
+// remainder of the compressed body.
+func (w *compressWriter) Close() error {
+	if w.buffering {
+		return w.writeBuffered()
+	}
+	if !w.compressing {
+		return nil
+	}
+	return w.w.Close()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bodyAllowed reports whether a response with the given status may have a body.
+func bodyAllowed(code int) bool {
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// countingWriter is an io.Writer that counts bytes written to it.
+type countingWriter struct {
+	io.Writer
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	*w.n += int64(n)
+	return n, err
+}
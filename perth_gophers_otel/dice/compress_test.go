@@ -0,0 +1,55 @@
+package dice
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+var acceptGzip = map[string]string{echo.HeaderAcceptEncoding: "gzip"}
+
+func TestCompress(t *testing.T) {
+	s := newTestServer(t, nil)
+	rec := s.getWithHeaders("/", acceptGzip)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := rec.Header().Get(echo.HeaderContentEncoding); got != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) <= minCompressBytes {
+		t.Errorf("got %d byte body, want more than %d", len(body), minCompressBytes)
+	}
+	s.ExpectSpan("/").WithAttr(contentEncodingKey.String("gzip"))
+}
+
+func TestCompressSmallResponse(t *testing.T) {
+	s := newTestServer(t, nil)
+	rec := s.getWithHeaders("/roll/2d6", acceptGzip)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	// Small responses are sent uncompressed.
+	if got := rec.Header().Get(echo.HeaderContentEncoding); got != "" {
+		t.Errorf("got Content-Encoding %q, want none", got)
+	}
+	if sum, err := strconv.Atoi(strings.TrimSpace(rec.Body.String())); err != nil || sum < 2 || sum > 12 {
+		t.Errorf("got body %q, want a sum of 2d6", rec.Body)
+	}
+	s.ExpectMetric("http.server.response.uncompressed_size").
+		WithAttr(contentEncodingKey.String("identity")).
+		Sum(float64(rec.Body.Len()))
+}
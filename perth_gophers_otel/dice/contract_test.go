@@ -0,0 +1,223 @@
+package dice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openAPI is the subset of an OpenAPI document used by contract tests.
+type openAPI struct {
+	Paths      map[string]map[string]operation `yaml:"paths"`
+	Components struct {
+		Responses map[string]response `yaml:"responses"`
+	} `yaml:"components"`
+}
+
+type operation struct {
+	Responses map[string]response `yaml:"responses"`
+}
+
+type response struct {
+	Ref     string `yaml:"$ref"`
+	Content map[string]struct {
+		Schema schema `yaml:"schema"`
+	} `yaml:"content"`
+}
+
+func loadOpenAPI(t *testing.T) *openAPI {
+	t.Helper()
+	data, err := os.ReadFile("openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc openAPI
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return &doc
+}
+
+// response returns the documented response for the operation and
+// status code, resolving references to shared responses.
+func (doc *openAPI) response(path, method string, code int) (response, bool) {
+	op, ok := doc.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return response{}, false
+	}
+	resp, ok := op.Responses[strconv.Itoa(code)]
+	if name, found := strings.CutPrefix(resp.Ref, "#/components/responses/"); found {
+		resp, ok = doc.Components.Responses[name]
+	}
+	return resp, ok
+}
+
+// openAPIPath converts an echo route path to an OpenAPI path template,
+// e.g. /roll/:dice to /roll/{dice}.
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if name, ok := strings.CutPrefix(part, ":"); ok {
+			parts[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func TestContractRoutesDocumented(t *testing.T) {
+	doc := loadOpenAPI(t)
+	s := newTestServer(t, nil)
+	served := make(map[string]bool)
+	for _, r := range s.echo.Routes() {
+		path := openAPIPath(r.Path)
+		served[r.Method+" "+path] = true
+		if _, ok := doc.Paths[path][strings.ToLower(r.Method)]; !ok {
+			t.Errorf("%s %s is not described in openapi.yaml", r.Method, path)
+		}
+	}
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if !served[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s is described in openapi.yaml, but not served", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestContractResponses(t *testing.T) {
+	doc := loadOpenAPI(t)
+	failing := newTestServer(t, nil, WithReadinessCheck("collector", func() error {
+		return errors.New("unreachable")
+	}))
+	history := newHistoryTestServer(t)
+	if rec := history.get("/roll/2d6?session=alice"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	history.runJob(context.Background(), leaderboardJob, history.recomputeLeaderboard)
+	rolled := commitFairRollForTest(t, history)
+	if rec := history.post("/rolls/"+rolled, `{"client_seed": "lucky"}`); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	committed := commitFairRollForTest(t, history)
+	jobs := newTestServer(t, nil)
+	startJobWorkersForTest(t, jobs)
+	job := submitJobForTest(t, jobs, "1000d6")
+	pollJobForTest(t, jobs, job.ID)
+	for _, test := range []struct {
+		target string
+		path   string
+		server *testServer
+		code   int
+		// body is the JSON body of a POST request;
+		// requests without one are GET requests.
+		body string
+		// accept is the Accept header of a GET request.
+		accept string
+	}{
+		{target: "/", path: "/", code: http.StatusOK},
+		{target: "/roll/2d6", path: "/roll/{dice}", code: http.StatusOK},
+		{target: "/roll/nonsense", path: "/roll/{dice}", code: http.StatusBadRequest},
+		{target: "/roll/0d6", path: "/roll/{dice}", code: http.StatusUnprocessableEntity},
+		{target: "/roll/4d4", path: "/roll/{dice}", code: http.StatusInternalServerError},
+		{target: "/simulate/1000d6", path: "/simulate/{dice}", code: http.StatusOK},
+		{target: "/simulate/1000d6", path: "/simulate/{dice}", accept: mimeNDJSON, code: http.StatusOK},
+		{target: "/simulate/100000000d6", path: "/simulate/{dice}", code: http.StatusBadRequest},
+		{target: "/simulate/0d6", path: "/simulate/{dice}", code: http.StatusUnprocessableEntity},
+		{target: "/jobs/simulate", path: "/jobs/simulate", body: `{"dice": "1000d6"}`, code: http.StatusAccepted},
+		{target: "/jobs/simulate", path: "/jobs/simulate", body: `{"dice": "100000000d6"}`, code: http.StatusBadRequest},
+		{target: "/jobs/simulate", path: "/jobs/simulate", body: `{"dice": "0d6"}`, code: http.StatusUnprocessableEntity},
+		{target: "/jobs/" + job.ID, path: "/jobs/{id}", server: jobs, code: http.StatusOK},
+		{target: "/jobs/missing", path: "/jobs/{id}", code: http.StatusNotFound},
+		{target: "/roll/2d6?session=a+b", path: "/roll/{dice}", code: http.StatusBadRequest},
+		{target: "/sessions/alice/history", path: "/sessions/{id}/history", server: history, code: http.StatusOK},
+		{target: "/sessions/alice/history?limit=0", path: "/sessions/{id}/history", server: history, code: http.StatusBadRequest},
+		{target: "/sessions/bob/history", path: "/sessions/{id}/history", server: history, code: http.StatusNotFound},
+		{target: "/sessions/alice/history", path: "/sessions/{id}/history", code: http.StatusNotFound},
+		{target: "/sessions/alice/webhook", path: "/sessions/{id}/webhook", server: history, body: `{"url": "http://example.com/rolls"}`, code: http.StatusOK},
+		{target: "/sessions/alice/webhook", path: "/sessions/{id}/webhook", server: history, body: `{"url": "example.com"}`, code: http.StatusBadRequest},
+		{target: "/sessions/alice/webhook", path: "/sessions/{id}/webhook", server: history, body: `{"url": "http://127.0.0.1/rolls"}`, code: http.StatusBadRequest},
+		{target: "/sessions/a+b/webhook", path: "/sessions/{id}/webhook", server: history, body: `{"url": "http://example.com/rolls"}`, code: http.StatusBadRequest},
+		{target: "/sessions/alice/webhook", path: "/sessions/{id}/webhook", body: `{"url": "http://example.com/rolls"}`, code: http.StatusNotFound},
+		{target: "/odds/2d6", path: "/odds/{dice}", code: http.StatusOK},
+		{target: "/odds/nonsense", path: "/odds/{dice}", code: http.StatusBadRequest},
+		{target: "/odds/0d6", path: "/odds/{dice}", code: http.StatusUnprocessableEntity},
+		{target: "/leaderboard", path: "/leaderboard", server: history, code: http.StatusOK},
+		{target: "/leaderboard", path: "/leaderboard", code: http.StatusNotFound},
+		{target: "/rolls", path: "/rolls", server: history, body: `{"dice": "2d6"}`, code: http.StatusCreated},
+		{target: "/rolls", path: "/rolls", server: history, body: `{"dice": "nonsense"}`, code: http.StatusBadRequest},
+		{target: "/rolls", path: "/rolls", body: `{"dice": "2d6"}`, code: http.StatusNotFound},
+		{target: "/rolls/" + committed, path: "/rolls/{id}", server: history, body: `{"client_seed": "lucky"}`, code: http.StatusOK},
+		{target: "/rolls/" + rolled, path: "/rolls/{id}", server: history, body: `{"client_seed": "lucky"}`, code: http.StatusConflict},
+		{target: "/rolls/" + rolled, path: "/rolls/{id}", server: history, body: `{}`, code: http.StatusBadRequest},
+		{target: "/rolls/" + committed, path: "/rolls/{id}", server: history, body: `{"client_seed": ""}`, code: http.StatusBadRequest},
+		{target: "/rolls", path: "/rolls", server: history, body: `{"dice": 2}`, code: http.StatusBadRequest},
+		{target: "/rolls/missing", path: "/rolls/{id}", server: history, body: `{"client_seed": "lucky"}`, code: http.StatusNotFound},
+		{target: "/rolls/" + rolled + "/proof", path: "/rolls/{id}/proof", server: history, code: http.StatusOK},
+		{target: "/rolls/missing/proof", path: "/rolls/{id}/proof", server: history, code: http.StatusNotFound},
+		{target: "/healthz", path: "/healthz", code: http.StatusOK},
+		{target: "/readyz", path: "/readyz", code: http.StatusOK},
+		{target: "/readyz", path: "/readyz", server: failing, code: http.StatusServiceUnavailable},
+	} {
+		t.Run(fmt.Sprintf("%s %d", test.target, test.code), func(t *testing.T) {
+			s := test.server
+			if s == nil {
+				s = newTestServer(t, nil)
+			}
+			var rec *httptest.ResponseRecorder
+			method := http.MethodGet
+			if test.body != "" {
+				method = http.MethodPost
+				rec = s.post(test.target, test.body)
+			} else {
+				rec = s.getWithHeaders(test.target, map[string]string{"Accept": test.accept})
+			}
+			if rec.Code != test.code {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, test.code, rec.Body)
+			}
+			resp, ok := doc.response(test.path, method, rec.Code)
+			if !ok {
+				t.Fatalf("status %d is not described in openapi.yaml", rec.Code)
+			}
+			mediaType, _, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, ok := resp.Content[mediaType]
+			if !ok {
+				t.Fatalf("content type %s is not described in openapi.yaml", mediaType)
+			}
+			if mediaType == mimeNDJSON {
+				// Each line is validated against the schema.
+				for _, line := range strings.SplitAfter(strings.TrimSuffix(rec.Body.String(), "\n"), "\n") {
+					var v any
+					if err := json.Unmarshal([]byte(line), &v); err != nil {
+						t.Fatal(err)
+					}
+					if errs := content.Schema.validate("#", v); errs != nil {
+						t.Errorf("response line %q does not match schema: %v", line, errs)
+					}
+				}
+				return
+			}
+			var body any = rec.Body.String()
+			if strings.HasSuffix(mediaType, "json") {
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if errs := content.Schema.validate("#", body); errs != nil {
+				t.Errorf("response does not match schema: %v", errs)
+			}
+		})
+	}
+}
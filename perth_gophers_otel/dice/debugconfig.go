@@ -0,0 +1,60 @@
+package dice
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+
+	"oteldemo/config"
+	"oteldemo/toggle"
+)
+
+// WithTelemetryDescription sets a function describing the telemetry
+// pipeline, such as its sampler and exporters, which is set up outside
+// the server. Its result is reported by GET /debug/config on the admin
+// listener, encoded as YAML, and so must not hold secrets.
+func WithTelemetryDescription(describe func() any) Option {
+	return func(s *Server) { s.describeTelemetry = describe }
+}
+
+// effectiveConfig is the response to GET /debug/config.
+type effectiveConfig struct {
+	// Generation is the configuration's generation,
+	// incremented by each reload.
+	Generation int64 `yaml:"generation"`
+
+	// Config is the live configuration, with secrets redacted.
+	Config *config.Config `yaml:"config"`
+
+	// Telemetry describes the telemetry pipeline,
+	// if given with WithTelemetryDescription.
+	Telemetry any `yaml:"telemetry,omitempty"`
+
+	// Toggles holds whether each instrumentation toggle is on, by name.
+	Toggles map[string]bool `yaml:"toggles"`
+}
+
+// handleDebugConfig handles GET /debug/config on the admin listener,
+// reporting the configuration the server is running with, after merging
+// defaults, the config file, environment variables, flags, and reloads,
+// along with the telemetry pipeline and instrumentation toggles. The
+// configuration is encoded as YAML, in the format of the config file.
+func (s *Server) handleDebugConfig(c echo.Context) error {
+	resp := effectiveConfig{
+		Generation: s.generation.Load(),
+		Config:     s.config().Redacted(),
+		Toggles:    make(map[string]bool),
+	}
+	if s.describeTelemetry != nil {
+		resp.Telemetry = s.describeTelemetry()
+	}
+	for _, t := range toggle.All() {
+		resp.Toggles[t.Name()] = t.Enabled()
+	}
+	body, err := yaml.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/yaml", body)
+}
@@ -0,0 +1,128 @@
+package dice
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/middleware"
+)
+
+// Errors returned for invalid dice notation. Invalid notation is
+// expected from clients, and under fuzzing and load, so the errors are
+// sentinels rather than constructed for each request.
+var (
+	// errInvalidNotation is returned for dice notation
+	// that cannot be parsed, responding 400 Bad Request.
+	errInvalidNotation = errors.New("expected dice notation like 2d20")
+
+	// errNotationRange is returned for dice notation with more dice or
+	// sides than can be rolled. It wraps errInvalidNotation.
+	errNotationRange = fmt.Errorf("%w, with at most %d dice and sides", errInvalidNotation, math.MaxInt8)
+
+	// errNoDice is returned for well-formed dice notation that rolls
+	// nothing, responding 422 Unprocessable Entity.
+	errNoDice = errors.New("must roll at least one die, with at least one side")
+)
+
+// errInvalidSession is returned for invalid session
+// IDs, responding 400 Bad Request.
+var errInvalidSession = fmt.Errorf(
+	"session IDs must be up to %d letters, digits, hyphens and underscores",
+	maxSessionIDLength,
+)
+
+// problem is an RFC 9457 problem details object.
+type problem struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Status  int    `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+	TraceID string `json:"trace_id,omitempty"`
+
+	// Errors are the fields of an invalid request body.
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// handleError is an echo.HTTPErrorHandler that responds with an
+// application/problem+json body, and classifies the error on the span.
+// The problem's messages are in the locale preferred by the request's
+// Accept-Language header, which is recorded on the span.
+// The error itself is recorded by middleware.Errors.
+//
+// otelecho calls the error handler while the span is active, and then
+// echo calls it again once the response has been committed; the error
+// is classified and written only on the first call.
+func handleError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	p := problem{Type: "about:blank"}
+	var httpErr *echo.HTTPError
+	var validationErr *validationError
+	switch {
+	case errors.Is(err, errInvalidNotation):
+		p.Status = http.StatusBadRequest
+		p.Title = "Invalid dice notation"
+		p.Detail = err.Error()
+	case errors.Is(err, errInvalidSession):
+		p.Status = http.StatusBadRequest
+		p.Title = "Invalid session"
+		p.Detail = err.Error()
+	case errors.Is(err, errNoDice):
+		p.Status = http.StatusUnprocessableEntity
+		p.Title = "Invalid request"
+		p.Detail = err.Error()
+	case errors.As(err, &validationErr):
+		p.Status = http.StatusBadRequest
+		p.Title = "Invalid request body"
+		p.Detail = "the request body does not match the schema in the API description"
+		p.Errors = validationErr.fields
+	case errors.As(err, &httpErr):
+		p.Status = httpErr.Code
+		p.Title = http.StatusText(httpErr.Code)
+		if msg, ok := httpErr.Message.(string); ok && msg != p.Title {
+			p.Detail = msg
+		}
+	default:
+		// Don't leak the details of internal errors to clients;
+		// they can be found in the trace.
+		p.Status = http.StatusInternalServerError
+		p.Title = http.StatusText(http.StatusInternalServerError)
+	}
+
+	locale := negotiateLocale(c.Request().Header.Get("Accept-Language"))
+	localizeProblem(locale, &p)
+
+	span := trace.SpanFromContext(c.Request().Context())
+	if span.SpanContext().IsValid() {
+		p.TraceID = span.SpanContext().TraceID().String()
+	}
+	span.SetAttributes(localeKey.String(locale))
+	// Distinguish panics from errors returned by handlers,
+	// which are classified by their status code.
+	if errors.As(err, new(*middleware.PanicError)) {
+		span.SetAttributes(semconv.ErrorTypeKey.String("panic"))
+	} else if p.Status >= 500 {
+		span.SetAttributes(semconv.ErrorTypeKey.String(strconv.Itoa(p.Status)))
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/problem+json")
+	c.Response().Header().Set("Content-Language", locale)
+	c.Response().Header().Add(echo.HeaderVary, "Accept-Language")
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		err = c.JSON(p.Status, p)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
@@ -0,0 +1,101 @@
+package dice
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/clock"
+	"oteldemo/rollevents"
+)
+
+// messageWriter writes Kafka messages. It is implemented by
+// *kafka.Writer, and faked in tests.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// rollPublisher publishes rolls to Kafka, injecting the trace context
+// of a producer span into each message's headers, so that consumers
+// can continue the trace.
+type rollPublisher struct {
+	topic       string
+	writer      messageWriter
+	tracer      trace.Tracer
+	propagators propagation.TextMapPropagator
+	clock       clock.Clock
+	spanOpts    []trace.SpanStartOption
+}
+
+func newRollPublisher(
+	topic string, w messageWriter, tracer trace.Tracer,
+	propagators propagation.TextMapPropagator, clk clock.Clock,
+) *rollPublisher {
+	return &rollPublisher{
+		topic:       topic,
+		writer:      w,
+		tracer:      tracer,
+		propagators: propagators,
+		clock:       clk,
+		spanOpts: []trace.SpanStartOption{
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(
+				semconv.MessagingSystemKafka,
+				semconv.MessagingOperationPublish,
+				semconv.MessagingDestinationName(topic),
+			),
+		},
+	}
+}
+
+// newKafkaWriter returns a writer publishing to topic on the given
+// brokers. Messages are written asynchronously, so rolls don't wait on
+// the brokers; publish spans therefore measure only enqueuing, and
+// delivery failures are logged.
+func newKafkaWriter(brokers []string, topic string) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Topic:                  topic,
+		Balancer:               &kafka.LeastBytes{},
+		Async:                  true,
+		AllowAutoTopicCreation: true,
+		Completion: func(msgs []kafka.Message, err error) {
+			if err != nil {
+				log.Printf("error publishing %d roll events: %v", len(msgs), err)
+			}
+		},
+	}
+}
+
+// publish publishes a roll, in a producer span.
+func (p *rollPublisher) publish(ctx context.Context, n, sides, sum int64) {
+	ctx, span := p.tracer.Start(ctx, p.topic+" publish", p.spanOpts...)
+	defer span.End()
+	value, err := json.Marshal(rollevents.Event{
+		N: n, Sides: sides, Sum: sum,
+		Time: p.clock.Now(),
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	msg := kafka.Message{Value: value}
+	p.propagators.Inject(ctx, rollevents.NewHeaderCarrier(&msg))
+	if err := p.writer.WriteMessages(ctx, msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// close flushes any pending messages, and closes the writer.
+func (p *rollPublisher) close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,74 @@
+package dice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/rollevents"
+)
+
+// fakeWriter is a messageWriter recording the messages written.
+type fakeWriter struct {
+	mu   sync.Mutex
+	msgs []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error { return nil }
+
+func TestRollPublishesEvent(t *testing.T) {
+	s := newTestServer(t, nil)
+	w := &fakeWriter{}
+	s.events = newRollPublisher("rolls", w, s.tracer, s.propagators, s.clock)
+	rec := s.get("/roll/3d6")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	server := s.ExpectSpan(rollSpan).Span()
+	producer := s.ExpectSpan("rolls publish").
+		WithAttr(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName("rolls"),
+		).
+		Span()
+	if producer.SpanKind() != trace.SpanKindProducer {
+		t.Errorf("got span kind %v, want producer", producer.SpanKind())
+	}
+	if producer.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("publish span is not a child of the roll span")
+	}
+
+	if len(w.msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(w.msgs))
+	}
+	msg := w.msgs[0]
+	var ev rollevents.Event
+	if err := json.Unmarshal(msg.Value, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.TrimSpace(rec.Body.String()); ev.N != 3 || ev.Sides != 6 || strconv.FormatInt(ev.Sum, 10) != want {
+		t.Errorf("got event %+v, want 3d6 summing to %s", ev, want)
+	}
+	// Consumers continue the trace from the producer span.
+	ctx := propagation.TraceContext{}.Extract(context.Background(), rollevents.NewHeaderCarrier(&msg))
+	if got := trace.SpanContextFromContext(ctx); got.SpanID() != producer.SpanContext().SpanID() {
+		t.Errorf("message headers carry span %s, want the publish span %s", got.SpanID(), producer.SpanContext().SpanID())
+	}
+}
@@ -0,0 +1,100 @@
+package dice_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"oteldemo/clock"
+	"oteldemo/dice"
+)
+
+// A fake clock makes the rolls, which are seeded from the clock, and
+// request durations deterministic.
+var exampleClock = clock.NewFake(time.Date(2024, 2, 20, 18, 0, 0, 0, time.UTC))
+
+// Requests are traced with a server span named after the route, and the
+// roll is recorded as an event on the span.
+func ExampleServer_Handler_tracing() {
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	defer tp.Shutdown(context.Background())
+
+	srv, err := dice.New(dice.WithTracerProvider(tp), dice.WithClock(exampleClock))
+	if err != nil {
+		panic(err)
+	}
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/roll/3d6", nil))
+	fmt.Printf("rolled %s", rec.Body)
+
+	for _, span := range spans.GetSpans() {
+		fmt.Printf("span %q (%s)\n", span.Name, span.SpanKind)
+		for _, kv := range span.Attributes {
+			if kv.Key == "http.route" || kv.Key == "http.status_code" {
+				fmt.Printf("  %s=%s\n", kv.Key, kv.Value.Emit())
+			}
+		}
+		for _, event := range span.Events {
+			// Skip the feature flag evaluation events, which
+			// depend on the configured OpenFeature provider.
+			if event.Name == "feature_flag" {
+				continue
+			}
+			fmt.Printf("  event %q\n", event.Name)
+			for _, kv := range event.Attributes {
+				fmt.Printf("    %s=%s\n", kv.Key, kv.Value.Emit())
+			}
+		}
+	}
+	// Output:
+	// rolled 4
+	// span "/roll/:dice" (server)
+	//   http.route=/roll/:dice
+	//   http.status_code=200
+	//   event "rolling dice"
+	//     n=3
+	//     sides=6
+}
+
+// Each die rolled is counted by the dice_rolls counter,
+// with the value rolled as an attribute.
+func Example_metrics() {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(context.Background())
+
+	srv, err := dice.New(dice.WithMeterProvider(mp), dice.WithClock(exampleClock))
+	if err != nil {
+		panic(err)
+	}
+	for _, notation := range []string{"3d6", "2d20"} {
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/roll/"+notation, nil))
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		panic(err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "dice_rolls" {
+				continue
+			}
+			var total int64
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				total += dp.Value
+			}
+			fmt.Printf("%s: %d dice rolled\n", m.Name, total)
+		}
+	}
+	// Output:
+	// dice_rolls: 5 dice rolled
+}
@@ -0,0 +1,152 @@
+package dice
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/fair"
+	"oteldemo/store"
+)
+
+// fairRollIDKey is the span attribute identifying a fair roll.
+const fairRollIDKey = attribute.Key("fair.roll.id")
+
+// fairCommitment is the response to POST /rolls.
+type fairCommitment struct {
+	ID         string `json:"id"`
+	Dice       string `json:"dice"`
+	Commitment string `json:"commitment"`
+}
+
+// fairResult is the response to POST /rolls/:id.
+type fairResult struct {
+	ID    string  `json:"id"`
+	Dice  string  `json:"dice"`
+	Rolls []int64 `json:"rolls"`
+	Sum   int64   `json:"sum"`
+}
+
+// commitFairRoll handles POST /rolls, with a body like {"dice": "2d6"},
+// starting a provably fair roll: the server's seed for the roll is
+// chosen and committed to, and the commitment returned, before the
+// client chooses its seed and rolls the dice with POST /rolls/:id.
+func (s *Server) commitFairRoll(c echo.Context) error {
+	if s.store == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "fair rolls are not enabled")
+	}
+	var body struct {
+		Dice string `json:"dice"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	n, sides, _, err := s.parseCache.parse(ctx, body.Dice)
+	if err != nil {
+		return err
+	}
+	var idBytes [16]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(idBytes[:])
+	seed, err := fair.NewSeed()
+	if err != nil {
+		return err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(fairRollIDKey.String(id))
+	roll := store.FairRoll{
+		ID: id, N: n, Sides: sides,
+		Seed:       seed,
+		Commitment: fair.Commit(seed, id),
+		Committed:  s.clock.Now(),
+	}
+	if err := s.store.CommitFairRoll(ctx, roll); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, fairCommitment{ID: id, Dice: notation(n, sides), Commitment: roll.Commitment})
+}
+
+// rollFair handles POST /rolls/:id, with a body like {"client_seed":
+// "lucky"}, rolling the dice of a committed fair roll from the server's
+// and client's seeds. Each fair roll can be rolled only once.
+func (s *Server) rollFair(c echo.Context) error {
+	roll, err := s.fairRoll(c)
+	if err != nil {
+		return err
+	}
+	var body struct {
+		ClientSeed string `json:"client_seed"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return err
+	}
+	rolls := fair.Roll(roll.Seed, body.ClientSeed, roll.N, roll.Sides)
+	err = s.store.CompleteFairRoll(c.Request().Context(), roll.ID, body.ClientSeed, rolls, s.clock.Now())
+	if errors.Is(err, store.ErrFairRollRolled) {
+		return echo.NewHTTPError(http.StatusConflict, "the dice have already been rolled")
+	} else if err != nil {
+		return err
+	}
+	var sum int64
+	for _, r := range rolls {
+		sum += r
+	}
+	return c.JSON(http.StatusOK, fairResult{ID: roll.ID, Dice: notation(roll.N, roll.Sides), Rolls: rolls, Sum: sum})
+}
+
+// fairProof handles GET /rolls/:id/proof, revealing the server's seed
+// for a fair roll once its dice are rolled, with which the roll can be
+// verified with fair.Proof.Verify.
+func (s *Server) fairProof(c echo.Context) error {
+	roll, err := s.fairRoll(c)
+	if err != nil {
+		return err
+	}
+	if roll.Rolled.IsZero() {
+		// Revealing the seed before the client has chosen
+		// theirs would let the client choose the outcome.
+		return echo.NewHTTPError(http.StatusConflict, "the dice have not been rolled yet")
+	}
+	p := fair.Proof{
+		ID:         roll.ID,
+		N:          roll.N,
+		Sides:      roll.Sides,
+		Commitment: roll.Commitment,
+		Seed:       hex.EncodeToString(roll.Seed),
+		ClientSeed: roll.ClientSeed,
+		Rolls:      roll.Rolls,
+	}
+	for _, r := range roll.Rolls {
+		p.Sum += r
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// fairRoll returns the fair roll identified by the request's
+// id parameter, recording the ID on the request's span.
+func (s *Server) fairRoll(c echo.Context) (store.FairRoll, error) {
+	if s.store == nil {
+		return store.FairRoll{}, echo.NewHTTPError(http.StatusNotFound, "fair rolls are not enabled")
+	}
+	ctx := c.Request().Context()
+	id := c.Param("id")
+	trace.SpanFromContext(ctx).SetAttributes(fairRollIDKey.String(id))
+	roll, err := s.store.FairRoll(ctx, id)
+	if errors.Is(err, store.ErrFairRollNotFound) {
+		return roll, echo.NewHTTPError(http.StatusNotFound, "fair roll not found")
+	}
+	return roll, err
+}
+
+// notation returns the dice notation for n dice with the given sides.
+func notation(n, sides int64) string {
+	return strconv.FormatInt(n, 10) + "d" + strconv.FormatInt(sides, 10)
+}
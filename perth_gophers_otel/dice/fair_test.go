@@ -0,0 +1,95 @@
+package dice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oteldemo/diceclient"
+	"oteldemo/fair"
+)
+
+// commitFairRollForTest commits to a fair roll of 3d6, returning its ID.
+func commitFairRollForTest(t *testing.T, s *testServer) string {
+	t.Helper()
+	rec := s.post("/rolls", `{"dice": "3d6"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	var resp fairCommitment
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.ID
+}
+
+func TestFairRoll(t *testing.T) {
+	s := newHistoryTestServer(t)
+	rec := s.post("/rolls", `{"dice": "3d6"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	var commitment fairCommitment
+	if err := json.Unmarshal(rec.Body.Bytes(), &commitment); err != nil {
+		t.Fatal(err)
+	}
+	s.ExpectSpan("/rolls").WithAttr(fairRollIDKey.String(commitment.ID))
+
+	// The seed isn't revealed until the dice are rolled.
+	proofPath := "/rolls/" + commitment.ID + "/proof"
+	if rec := s.get(proofPath); rec.Code != http.StatusConflict {
+		t.Fatalf("got status %d before rolling, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+
+	rec = s.post("/rolls/"+commitment.ID, `{"client_seed": "lucky"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var result fairResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Rolls) != 3 || result.Sum < 3 || result.Sum > 18 {
+		t.Errorf("got %+v, want three d6 rolls", result)
+	}
+
+	rec = s.get(proofPath)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var proof fair.Proof
+	if err := json.Unmarshal(rec.Body.Bytes(), &proof); err != nil {
+		t.Fatal(err)
+	}
+	if proof.Commitment != commitment.Commitment || proof.Sum != result.Sum || proof.ClientSeed != "lucky" {
+		t.Errorf("got proof %+v, want it to match the commitment %+v and result %+v", proof, commitment, result)
+	}
+	if err := proof.Verify(); err != nil {
+		t.Errorf("proof does not verify: %v", err)
+	}
+
+	// The dice can't be rerolled with another client seed.
+	if rec := s.post("/rolls/"+commitment.ID, `{"client_seed": "luckier"}`); rec.Code != http.StatusConflict {
+		t.Errorf("got status %d rerolling, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+}
+
+func TestFairRollClient(t *testing.T) {
+	s := newHistoryTestServer(t)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	c, err := diceclient.New(srv.URL, diceclient.WithTracerProvider(s.TracerProvider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := c.RollFair(context.Background(), "2d20", "lucky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.Rolls) != 2 || proof.Sides != 20 {
+		t.Errorf("got proof %+v, want two d20 rolls", proof)
+	}
+	s.ExpectSpan("fair roll").WithAttr(fairRollIDKey.String(proof.ID))
+}
@@ -0,0 +1,114 @@
+package dice
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// chiSquared returns Pearson's chi-squared statistic for the observed
+// counts of each die value, against the expected probabilities.
+func chiSquared(observed []int, expected []float64, n int) float64 {
+	var x2 float64
+	for i, o := range observed {
+		e := expected[i] * float64(n)
+		x2 += (float64(o) - e) * (float64(o) - e) / e
+	}
+	return x2
+}
+
+// chiSquaredCritical returns the critical value of the chi-squared
+// distribution with df degrees of freedom at a significance level of
+// 0.001, using the Wilson-Hilferty approximation.
+func chiSquaredCritical(df int) float64 {
+	const z = 3.090 // standard normal quantile for 0.999
+	k := float64(df)
+	return k * math.Pow(1-2/(9*k)+z*math.Sqrt(2/(9*k)), 3)
+}
+
+// rollCounts rolls a die n times, returning the
+// number of times each value, 1 to sides, was rolled.
+func rollCounts(seed, sides int64, loaded bool, n int) []int {
+	die := newDie(rand.New(rand.NewSource(seed)), sides, loaded)
+	counts := make([]int, sides)
+	for range n {
+		v := die()
+		if v < 1 || v > sides {
+			panic("die rolled out of range")
+		}
+		counts[v-1]++
+	}
+	return counts
+}
+
+// uniform returns the probabilities of each value of a fair die.
+func uniform(sides int64) []float64 {
+	p := make([]float64, sides)
+	for i := range p {
+		p[i] = 1 / float64(sides)
+	}
+	return p
+}
+
+// zipf returns the probabilities of each value of a loaded die,
+// proportional to 1/k² for value k.
+func zipf(sides int64) []float64 {
+	p := make([]float64, sides)
+	var total float64
+	for i := range p {
+		p[i] = 1 / float64((i+1)*(i+1))
+		total += p[i]
+	}
+	for i := range p {
+		p[i] /= total
+	}
+	return p
+}
+
+func TestDieFairness(t *testing.T) {
+	const n = 100000
+	for _, test := range []struct {
+		name     string
+		loaded   bool
+		expected func(sides int64) []float64
+	}{
+		{"uniform", false, uniform},
+		{"zipf", true, zipf},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, sides := range []int64{2, 6, 20, 100} {
+				// Seeded, so the test is deterministic; a fixed seed
+				// still fails if the distribution is wrong.
+				observed := rollCounts(sides, sides, test.loaded, n)
+				expected := test.expected(sides)
+				df := int(sides) - 1
+				// Merge sparse tail values, whose expected counts
+				// are too small for the chi-squared approximation.
+				for len(expected) > 2 && expected[len(expected)-1]*n < 5 {
+					last := len(expected) - 1
+					expected[last-1] += expected[last]
+					observed[last-1] += observed[last]
+					expected, observed = expected[:last], observed[:last]
+					df--
+				}
+				x2 := chiSquared(observed, expected, n)
+				if critical := chiSquaredCritical(df); x2 > critical {
+					t.Errorf("d%d: chi-squared %.1f exceeds critical value %.1f (df=%d)", sides, x2, critical, df)
+				}
+			}
+		})
+	}
+}
+
+func TestDieFairnessDetectsBias(t *testing.T) {
+	// Guard against a test that cannot fail: loaded
+	// dice are not uniform, and fair dice are not Zipf.
+	const n, sides = 100000, 6
+	df := int(sides) - 1
+	if x2 := chiSquared(rollCounts(1, sides, true, n), uniform(sides), n); x2 <= chiSquaredCritical(df) {
+		t.Errorf("loaded dice passed as uniform: chi-squared %.1f", x2)
+	}
+	if x2 := chiSquared(rollCounts(1, sides, false, n), zipf(sides), n); x2 <= chiSquaredCritical(df) {
+		t.Errorf("fair dice passed as Zipf: chi-squared %.1f", x2)
+	}
+}
@@ -0,0 +1,68 @@
+package dice
+
+import (
+	"context"
+
+	"github.com/open-feature/go-sdk/openfeature"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Feature flags evaluated with OpenFeature. Their defaults,
+// used when the provider does not define them, come from
+// the configuration.
+const (
+	// loadedDiceFlag selects the (loaded) Zipf distribution for
+	// rolls, rather than a uniform distribution.
+	loadedDiceFlag = "loaded-dice"
+
+	// tetraphobicFlag makes rolls involving the number 4 fail.
+	tetraphobicFlag = "tetraphobic"
+)
+
+// flagEvaluationHook is an openfeature.Hook that records flag
+// evaluations as "feature_flag" events on the current span,
+// following the OpenTelemetry semantic conventions.
+type flagEvaluationHook struct {
+	openfeature.UnimplementedHook
+}
+
+func (flagEvaluationHook) After(
+	ctx context.Context,
+	hookCtx openfeature.HookContext,
+	details openfeature.InterfaceEvaluationDetails,
+	_ openfeature.HookHints,
+) error {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return nil
+	}
+	attrs := []attribute.KeyValue{
+		semconv.FeatureFlagKey(hookCtx.FlagKey()),
+		semconv.FeatureFlagProviderName(hookCtx.ProviderMetadata().Name),
+		attribute.String("feature_flag.reason", string(details.Reason)),
+	}
+	if details.Variant != "" {
+		attrs = append(attrs, semconv.FeatureFlagVariant(details.Variant))
+	}
+	span.AddEvent("feature_flag", trace.WithAttributes(attrs...))
+	return nil
+}
+
+func (flagEvaluationHook) Error(
+	ctx context.Context,
+	hookCtx openfeature.HookContext,
+	err error,
+	_ openfeature.HookHints,
+) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.AddEvent("feature_flag", trace.WithAttributes(
+		semconv.FeatureFlagKey(hookCtx.FlagKey()),
+		semconv.FeatureFlagProviderName(hookCtx.ProviderMetadata().Name),
+		attribute.String("error.message", err.Error()),
+	))
+}
@@ -0,0 +1,84 @@
+package dice
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"oteldemo/clock"
+)
+
+// forceFlusher is implemented by SDK providers, such as
+// *sdktrace.TracerProvider and *sdkmetric.MeterProvider.
+type forceFlusher interface {
+	ForceFlush(ctx context.Context) error
+}
+
+// Statuses of providers in the response to POST /flush.
+const (
+	flushFlushed     = "flushed"
+	flushFailed      = "failed"
+	flushUnsupported = "unsupported"
+)
+
+// flushResult is the result of flushing a provider,
+// in the response to POST /flush.
+type flushResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// forceFlush exports the telemetry buffered by the server's tracer and
+// meter providers, in that order, so spans ending with metrics recorded
+// are flushed before the metrics. It returns the result for each
+// provider, by name. Providers that don't support flushing, such as
+// no-op providers, are reported as unsupported. The server has no
+// logger provider, as it logs with the log package, so there are no
+// logs to flush.
+func (s *Server) forceFlush(ctx context.Context) map[string]flushResult {
+	providers := []struct {
+		name     string
+		provider any
+	}{
+		{"tracer", s.tracerProvider},
+		{"meter", s.meterProvider},
+	}
+	results := make(map[string]flushResult, len(providers))
+	for _, p := range providers {
+		flusher, ok := p.provider.(forceFlusher)
+		if !ok {
+			results[p.name] = flushResult{Status: flushUnsupported}
+			continue
+		}
+		start := s.clock.Now()
+		err := flusher.ForceFlush(ctx)
+		result := flushResult{Status: flushFlushed, Duration: clock.Since(s.clock, start).String()}
+		if err != nil {
+			result.Status = flushFailed
+			result.Error = err.Error()
+		}
+		results[p.name] = result
+	}
+	return results
+}
+
+// handleFlush handles POST /flush on the admin listener, flushing
+// telemetry so it can be seen in the backend immediately, e.g. before
+// switching to the dashboard during the talk. Flushing is bounded by the
+// shutdown timeout, as when shutting down. The response reports the
+// result for the tracer and meter providers, with 500 Internal Server
+// Error if either failed; there is no logger provider to flush.
+func (s *Server) handleFlush(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	results := s.forceFlush(ctx)
+	code := http.StatusOK
+	for _, result := range results {
+		if result.Status == flushFailed {
+			code = http.StatusInternalServerError
+		}
+	}
+	return c.JSON(code, results)
+}
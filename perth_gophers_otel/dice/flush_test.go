@@ -0,0 +1,34 @@
+package dice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFlush(t *testing.T) {
+	s := newTestServer(t, nil)
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/flush", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var results map[string]flushResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"tracer": flushFlushed,
+		"meter":  flushFlushed,
+	}
+	for name, want := range want {
+		if got := results[name].Status; got != want {
+			t.Errorf("got %s status %q, want %q", name, got, want)
+		}
+	}
+	// There is no logger provider to report.
+	if len(results) != len(want) {
+		t.Errorf("got results %+v, want only the tracer and meter providers", results)
+	}
+}
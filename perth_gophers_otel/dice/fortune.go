@@ -0,0 +1,108 @@
+package dice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/breaker"
+	"oteldemo/clock"
+)
+
+// fortuneLuckKey is the span attribute recording the luck modifier
+// applied to a roll.
+const fortuneLuckKey = attribute.Key("fortune.luck")
+
+// maxFortuneBytes bounds the size of fortune service responses.
+const maxFortuneBytes = 4 << 10
+
+// fortune is a response from the fortune service.
+type fortune struct {
+	Fortune string `json:"fortune"`
+	Luck    int64  `json:"luck"`
+}
+
+// fortuneClient calls the fortune service, with an otelhttp-instrumented
+// client so its spans and the service's join the roll's trace. Calls go
+// through a circuit breaker, so rolls aren't held up waiting on the
+// service while it is down.
+type fortuneClient struct {
+	url     string
+	timeout time.Duration
+	client  *http.Client
+	breaker *breaker.Breaker
+}
+
+func newFortuneClient(
+	baseURL string, timeout time.Duration, clk clock.Clock,
+	tp trace.TracerProvider, mp metric.MeterProvider, propagators propagation.TextMapPropagator,
+) (*fortuneClient, error) {
+	b, err := breaker.New("fortune", breaker.WithClock(clk), breaker.WithMeterProvider(mp))
+	if err != nil {
+		return nil, err
+	}
+	transport := otelhttp.NewTransport(http.DefaultTransport,
+		otelhttp.WithTracerProvider(tp),
+		otelhttp.WithMeterProvider(mp),
+		otelhttp.WithPropagators(propagators),
+	)
+	return &fortuneClient{
+		url:     baseURL + "/fortune",
+		timeout: timeout,
+		client:  &http.Client{Transport: transport},
+		breaker: b,
+	}, nil
+}
+
+// get asks the fortune service for a fortune.
+func (f *fortuneClient) get(ctx context.Context) (fortune, error) {
+	var result fortune
+	err := f.breaker.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := f.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("fortune service responded with %s", resp.Status)
+		}
+		return json.NewDecoder(io.LimitReader(resp.Body, maxFortuneBytes)).Decode(&result)
+	})
+	return result, err
+}
+
+// applyLuck adjusts sum by the luck modifier from the fortune service,
+// if configured, keeping it within [lo, hi]. If the service fails, the
+// roll proceeds unmodified, and the failure is recorded on span.
+func (s *Server) applyLuck(ctx context.Context, span trace.Span, sum, lo, hi int64) int64 {
+	if s.fortune == nil {
+		return sum
+	}
+	f, err := s.fortune.get(ctx)
+	if err != nil {
+		if span.IsRecording() {
+			addEvent(span, "fortune unavailable", semconv.ExceptionMessage(err.Error()))
+		}
+		return sum
+	}
+	if span.IsRecording() {
+		span.SetAttributes(fortuneLuckKey.Int64(f.Luck))
+	}
+	return max(lo, min(hi, sum+f.Luck))
+}
@@ -0,0 +1,65 @@
+package dice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/config"
+)
+
+func TestRollFortune(t *testing.T) {
+	var traceparent string
+	fortunes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"fortune": "Today is not your day.", "luck": -100}`))
+	}))
+	defer fortunes.Close()
+
+	cfg := config.Default()
+	cfg.Downstream.FortuneURL = fortunes.URL
+	s := newTestServer(t, cfg)
+	rec := s.get("/roll/3d6")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	// Luck can't make a roll less than the number of dice.
+	if sum, _ := strconv.Atoi(strings.TrimSpace(rec.Body.String())); sum != 3 {
+		t.Errorf("got sum %d, want 3", sum)
+	}
+
+	server := s.ExpectSpan(rollSpan).WithAttr(fortuneLuckKey.Int64(-100)).Span()
+	client := s.ExpectSpan("HTTP GET").Span()
+	if client.SpanKind() != trace.SpanKindClient {
+		t.Errorf("got span kind %v, want client", client.SpanKind())
+	}
+	if client.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("fortune client span is not a child of the roll span")
+	}
+	if !strings.Contains(traceparent, client.SpanContext().SpanID().String()) {
+		t.Errorf("got traceparent %q, want it to reference the client span", traceparent)
+	}
+}
+
+func TestRollFortuneUnavailable(t *testing.T) {
+	fortunes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no fortunes today", http.StatusServiceUnavailable)
+	}))
+	defer fortunes.Close()
+
+	cfg := config.Default()
+	cfg.Downstream.FortuneURL = fortunes.URL
+	s := newTestServer(t, cfg)
+	if rec := s.get("/roll/2d6"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	s.ExpectSpan(rollSpan).
+		WithEvent("fortune unavailable",
+			attribute.String("exception.message", "fortune service responded with 503 Service Unavailable"),
+		)
+}
@@ -0,0 +1,58 @@
+package dice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+
+	"oteldemo/config"
+	"oteldemo/teletest"
+)
+
+// testServer is a Server whose telemetry is recorded in memory,
+// for assertions in tests.
+type testServer struct {
+	*Server
+	*teletest.Recorder
+}
+
+// newTestServer returns a testServer with the given configuration,
+// or the default configuration if cfg is nil.
+func newTestServer(t *testing.T, cfg *config.Config, opts ...Option) *testServer {
+	t.Helper()
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	rec := teletest.New(t)
+	opts = append([]Option{
+		WithConfig(cfg),
+		WithTracerProvider(rec.TracerProvider),
+		WithMeterProvider(rec.MeterProvider),
+		WithPropagators(propagation.TraceContext{}),
+	}, opts...)
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testServer{Server: s, Recorder: rec}
+}
+
+// get serves a GET request for target, returning the recorded response.
+func (s *testServer) get(target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// post serves a POST request for target with a JSON body,
+// returning the recorded response.
+func (s *testServer) post(target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,42 @@
+package dice
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// healthRoutes are excluded from telemetry, so probes
+// don't drown out the interesting requests in the demo.
+var healthRoutes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// skipTelemetry reports whether telemetry should be skipped
+// for the request, for use as a middleware.Skipper.
+func skipTelemetry(c echo.Context) bool {
+	return healthRoutes[c.Path()]
+}
+
+// addHealthRoutes adds the /healthz (liveness) and /readyz (readiness) routes.
+func (s *Server) addHealthRoutes(r *echo.Echo) {
+	r.GET("/healthz", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+	r.GET("/readyz", func(c echo.Context) error {
+		failed := make(map[string]string)
+		for name, check := range s.readiness {
+			if err := check(); err != nil {
+				failed[name] = err.Error()
+			}
+		}
+		if len(failed) > 0 {
+			return c.JSON(http.StatusServiceUnavailable, map[string]any{
+				"status": "unavailable",
+				"checks": failed,
+			})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
@@ -0,0 +1,107 @@
+package dice
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"oteldemo/store"
+)
+
+// maxSessionIDLength bounds session IDs, which are chosen by clients.
+const maxSessionIDLength = 64
+
+// Limits on the number of rolls returned by GET /sessions/:id/history.
+const (
+	defaultHistoryLimit = 10
+	maxHistoryLimit     = 100
+)
+
+// historyResponse is the response to GET /sessions/:id/history.
+type historyResponse struct {
+	Session store.Session `json:"session"`
+	Rolls   []store.Roll  `json:"rolls"`
+}
+
+// history handles GET /sessions/:id/history, responding with a session
+// and up to limit (a query parameter) of its most recent rolls.
+func (s *Server) history(c echo.Context) error {
+	if s.store == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "roll history is not enabled")
+	}
+	id := c.Param("id")
+	if !validSessionID(id) {
+		return errInvalidSession
+	}
+	limit := defaultHistoryLimit
+	if v := c.QueryParam("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxHistoryLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be an integer in [1, %d]", maxHistoryLimit))
+		}
+	}
+	ctx := c.Request().Context()
+	sess, err := s.store.Session(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "session not found")
+	} else if err != nil {
+		return err
+	}
+	rolls, err := s.store.History(ctx, id, limit)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, historyResponse{Session: sess, Rolls: rolls})
+}
+
+// sessionParam returns the session query parameter of a roll request,
+// which is empty if the roll is not part of a session.
+func sessionParam(c echo.Context) (string, error) {
+	if c.Request().URL.RawQuery == "" {
+		// Most rolls have no query, so don't allocate one.
+		return "", nil
+	}
+	session := c.QueryParam("session")
+	if session != "" && !validSessionID(session) {
+		return "", errInvalidSession
+	}
+	return session, nil
+}
+
+// recordRoll records a roll in the history of a session, if history
+// is enabled and the roll is part of a session, and delivers it to the
+// session's webhook.
+func (s *Server) recordRoll(c echo.Context, session string, n, sides, sum int64) error {
+	if s.store == nil || session == "" {
+		return nil
+	}
+	ctx := c.Request().Context()
+	roll := store.Roll{
+		N: n, Sides: sides, Sum: sum,
+		Time: s.clock.Now(),
+	}
+	if err := s.store.RecordRoll(ctx, session, roll); err != nil {
+		return err
+	}
+	s.notifyWebhook(ctx, session, roll)
+	return nil
+}
+
+// validSessionID reports whether id is a valid session ID: up to
+// maxSessionIDLength letters, digits, hyphens and underscores.
+func validSessionID(id string) bool {
+	if id == "" || len(id) > maxSessionIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch b := id[i]; {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9', b == '-', b == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
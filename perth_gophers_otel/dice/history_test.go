@@ -0,0 +1,58 @@
+package dice
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/config"
+)
+
+// newHistoryTestServer returns a testServer recording
+// roll history in a temporary SQLite database.
+func newHistoryTestServer(t *testing.T) *testServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Storage.SQLitePath = filepath.Join(t.TempDir(), "dice.db")
+	s := newTestServer(t, cfg)
+	t.Cleanup(func() { s.store.Close() })
+	return s
+}
+
+func TestRollHistory(t *testing.T) {
+	s := newHistoryTestServer(t)
+	for _, target := range []string{"/roll/2d6?session=alice", "/roll/1d20?session=alice", "/roll/3d6"} {
+		if rec := s.get(target); rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d: %s", target, rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+	rec := s.get("/sessions/alice/history")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var resp historyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Session.Rolls != 2 || len(resp.Rolls) != 2 || resp.Rolls[0].Sides != 20 || resp.Rolls[1].Sides != 6 {
+		t.Errorf("got %+v, want alice's two rolls, newest first", resp)
+	}
+
+	// Queries are traced beneath the requests making them.
+	s.ExpectSpan("sql.conn.exec").WithKind(trace.SpanKindClient).WithAttr(semconv.DBSystemSqlite)
+	roll := s.ExpectSpan(rollSpan).Span()
+	var queries int
+	for _, span := range s.Spans() {
+		if span.SpanContext().TraceID() == roll.SpanContext().TraceID() && span.Name() == "sql.conn.exec" {
+			queries++
+		}
+	}
+	if queries != 2 {
+		t.Errorf("got %d queries in the roll's trace, want 2", queries)
+	}
+	s.ExpectSpan("sql.conn.query")
+}
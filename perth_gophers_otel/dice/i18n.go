@@ -0,0 +1,138 @@
+package dice
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// localeKey is the span attribute recording the locale
+// in which an error response's messages were written.
+const localeKey = attribute.Key("i18n.locale")
+
+// defaultLocale is the locale of messages written in the code,
+// used when the client accepts none of the translated locales.
+const defaultLocale = "en"
+
+// translations maps the user-facing messages of error responses, in
+// English, to their translations, by locale. Messages formatted with
+// request-specific values, such as some request body field errors, are
+// not translated, and remain in English.
+var translations = map[string]map[string]string{
+	"fr": {
+		// Problem titles.
+		"Invalid dice notation": "Notation de dés invalide",
+		"Invalid session":       "Session invalide",
+		"Invalid request":       "Requête invalide",
+		"Invalid request body":  "Corps de requête invalide",
+
+		http.StatusText(http.StatusBadRequest):            "Requête incorrecte",
+		http.StatusText(http.StatusNotFound):              "Introuvable",
+		http.StatusText(http.StatusConflict):              "Conflit",
+		http.StatusText(http.StatusRequestEntityTooLarge): "Requête trop volumineuse",
+		http.StatusText(http.StatusUnprocessableEntity):   "Entité non traitable",
+		http.StatusText(http.StatusInternalServerError):   "Erreur interne du serveur",
+		http.StatusText(http.StatusServiceUnavailable):    "Service indisponible",
+		http.StatusText(http.StatusGatewayTimeout):        "Délai de la passerelle dépassé",
+
+		// Problem details.
+		errInvalidNotation.Error(): "notation de dés attendue, comme 2d20",
+		errNotationRange.Error(): fmt.Sprintf(
+			"notation de dés attendue, comme 2d20, avec au plus %d dés et faces", math.MaxInt8),
+		errSimulationRange.Error(): fmt.Sprintf(
+			"notation de dés attendue, comme 2d20, avec au plus %d dés et %d faces", maxSimulatedDice, math.MaxInt8),
+		errNoDice.Error(): "il faut lancer au moins un dé, avec au moins une face",
+		errInvalidSession.Error(): fmt.Sprintf(
+			"les identifiants de session comportent au plus %d lettres, chiffres, traits d'union et tirets bas",
+			maxSessionIDLength),
+		fmt.Sprintf("limit must be an integer in [1, %d]", maxHistoryLimit): fmt.Sprintf(
+			"limit doit être un entier dans [1, %d]", maxHistoryLimit),
+
+		"request body too large":                    "corps de requête trop volumineux",
+		"request timed out":                         "délai de la requête dépassé",
+		"server saturated, try again later":         "serveur saturé, réessayez plus tard",
+		"roll history is not enabled":               "l'historique des lancers n'est pas activé",
+		"session not found":                         "session introuvable",
+		"the leaderboard is not available":          "le classement n'est pas disponible",
+		"fair rolls are not enabled":                "les lancers équitables ne sont pas activés",
+		"fair roll not found":                       "lancer équitable introuvable",
+		"the dice have already been rolled":         "les dés ont déjà été lancés",
+		"the dice have not been rolled yet":         "les dés n'ont pas encore été lancés",
+		"url must be an absolute http or https URL": "url doit être une URL http ou https absolue",
+		"url must not refer to a private address":   "url ne doit pas désigner une adresse privée",
+		"job queue full, try again later":           "file d'attente des tâches pleine, réessayez plus tard",
+		"job not found":                             "tâche introuvable",
+
+		"the request body does not match the schema in the API description": "le corps de la requête ne correspond pas au schéma de la description de l'API",
+
+		// Request body field errors.
+		"is required":        "est obligatoire",
+		"must be JSON":       "doit être du JSON",
+		"must be an object":  "doit être un objet",
+		"must be an array":   "doit être un tableau",
+		"must be a string":   "doit être une chaîne",
+		"must be a number":   "doit être un nombre",
+		"must be an integer": "doit être un entier",
+		"must be a boolean":  "doit être un booléen",
+	},
+}
+
+// negotiateLocale returns the locale preferred by the given
+// Accept-Language header value, of defaultLocale and those with
+// translations. Language ranges match locales by their primary subtag,
+// so "fr-CA" matches "fr". Equally weighted ranges are preferred in the
+// order listed; if none match, defaultLocale is returned.
+func negotiateLocale(acceptLanguage string) string {
+	best, bestQ := defaultLocale, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		lang, params, _ := strings.Cut(part, ";")
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		lang, _, _ = strings.Cut(lang, "-")
+		if lang == "*" {
+			lang = defaultLocale
+		}
+		if _, ok := translations[lang]; !ok && lang != defaultLocale {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// localize returns msg translated to locale,
+// or msg itself if there is no translation.
+func localize(locale, msg string) string {
+	if translated, ok := translations[locale][msg]; ok {
+		return translated
+	}
+	return msg
+}
+
+// localizeProblem translates the messages of p to locale.
+func localizeProblem(locale string, p *problem) {
+	if locale == defaultLocale {
+		return
+	}
+	p.Title = localize(locale, p.Title)
+	p.Detail = localize(locale, p.Detail)
+	if p.Errors != nil {
+		fields := make([]fieldError, len(p.Errors))
+		for i, f := range p.Errors {
+			fields[i] = fieldError{Pointer: f.Pointer, Detail: localize(locale, f.Detail)}
+		}
+		p.Errors = fields
+	}
+}
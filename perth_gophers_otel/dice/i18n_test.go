@@ -0,0 +1,57 @@
+package dice
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestNegotiateLocale(t *testing.T) {
+	for acceptLanguage, want := range map[string]string{
+		"":                        "en",
+		"fr":                      "fr",
+		"fr-CA":                   "fr",
+		"FR-ca, en;q=0.5":         "fr",
+		"en, fr":                  "en",
+		"en;q=0.5, fr;q=0.8":      "fr",
+		"de, fr;q=0.5":            "fr",
+		"de":                      "en",
+		"*":                       "en",
+		"fr;q=0, en;q=0.1":        "en",
+		"fr;q=nonsense, en;q=0.1": "en",
+	} {
+		if got := negotiateLocale(acceptLanguage); got != want {
+			t.Errorf("negotiateLocale(%q) = %q, want %q", acceptLanguage, got, want)
+		}
+	}
+}
+
+func TestLocalizedProblem(t *testing.T) {
+	s := newTestServer(t, nil)
+	rec := s.getWithHeaders("/roll/nonsense", map[string]string{"Accept-Language": "fr-CA, en;q=0.8"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Language"); got != "fr" {
+		t.Errorf("got Content-Language %q, want fr", got)
+	}
+	var p problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "Notation de dés invalide" || p.Detail != "notation de dés attendue, comme 2d20" {
+		t.Errorf("got title %q and detail %q, want them in French", p.Title, p.Detail)
+	}
+	s.ExpectSpan(rollSpan).WithAttr(localeKey.String("fr"))
+}
+
+func TestTranslationsComplete(t *testing.T) {
+	// Sentinel errors are translated into every locale.
+	for locale, messages := range translations {
+		for _, err := range []error{errInvalidNotation, errNotationRange, errSimulationRange, errNoDice, errInvalidSession} {
+			if _, ok := messages[err.Error()]; !ok {
+				t.Errorf("%q is not translated to %s", err, locale)
+			}
+		}
+	}
+}
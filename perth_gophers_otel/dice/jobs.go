@@ -0,0 +1,321 @@
+package dice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/attrset"
+)
+
+const (
+	// jobWorkers is the number of jobs run concurrently.
+	jobWorkers = 2
+
+	// maxQueuedJobs is the maximum number of jobs waiting for a
+	// worker; further jobs are rejected until the queue drains.
+	maxQueuedJobs = 16
+
+	// maxRetainedJobs is the maximum number of jobs whose status is
+	// kept, after which the oldest finished jobs are forgotten.
+	maxRetainedJobs = 256
+)
+
+// simulateJob is the name of jobs submitted by POST /jobs/simulate.
+const simulateJob = "simulate"
+
+// Attribute keys of job spans and metrics, along with jobNameKey.
+const (
+	jobIDKey     = attribute.Key("job.id")
+	jobStatusKey = attribute.Key("job.status")
+)
+
+// Statuses of jobs.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// jobStatus is the response to POST /jobs/simulate and GET /jobs/:id.
+type jobStatus struct {
+	ID        string     `json:"id"`
+	Status    string     `json:"status"`
+	Dice      string     `json:"dice"`
+	Sum       *int64     `json:"sum,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+}
+
+// job is a simulation submitted to run in the background.
+type job struct {
+	n, sides int64
+	loaded   bool
+
+	// link links the span running the job to the span that enqueued it.
+	link trace.Link
+
+	// status is guarded by jobQueue.mu.
+	status jobStatus
+}
+
+// jobQueue runs jobs in the background with a pool of workers, so
+// clients needn't hold a request open for long-running simulations.
+// Jobs are produced by requests and consumed by the workers, so each is
+// enqueued in a producer span beneath the request's span, and run in a
+// consumer span in its own trace, linked to the producer span.
+type jobQueue struct {
+	queue   chan *job
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	// ids holds the IDs of jobs in the order they were submitted,
+	// for forgetting the oldest.
+	ids []string
+
+	queueDuration metric.Float64Histogram
+	completed     metric.Int64Counter
+	names         metric.MeasurementOption
+	statuses      *attrset.Cache[string]
+}
+
+func newJobQueue(meter metric.Meter) (*jobQueue, error) {
+	queueDuration, err := meter.Float64Histogram(
+		"job.queue.duration",
+		metric.WithDescription("Time jobs waited in the queue before running, by job"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	completed, err := meter.Int64Counter(
+		"jobs.completed",
+		metric.WithDescription("Jobs run to completion, by job and status"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &jobQueue{
+		queue:         make(chan *job, maxQueuedJobs),
+		jobs:          make(map[string]*job),
+		queueDuration: queueDuration,
+		completed:     completed,
+		names:         metric.WithAttributes(jobNameKey.String(simulateJob)),
+		// Jobs succeed or fail.
+		statuses: attrset.New(2, func(status string) []attribute.KeyValue {
+			return []attribute.KeyValue{jobNameKey.String(simulateJob), jobStatusKey.String(status)}
+		}),
+	}, nil
+}
+
+// start starts workers running jobs from the queue with run, until
+// the queue is closed. The context given to run is cancelled by close.
+func (q *jobQueue) start(workers int, run func(context.Context, *job)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	for range workers {
+		q.workers.Add(1)
+		go func() {
+			defer q.workers.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-q.queue:
+					run(ctx, j)
+				}
+			}
+		}()
+	}
+}
+
+// enqueue adds j to the queue, returning false if the queue is full,
+// or if no finished job can be forgotten to make room for it.
+func (q *jobQueue) enqueue(j *job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// Jobs are only added to the queue with q.mu held, so if there is
+	// room now, the send below won't block. Check before forgetting a
+	// job, so jobs aren't forgotten to make room for one rejected.
+	if len(q.queue) == cap(q.queue) {
+		return false
+	}
+	if len(q.ids) >= maxRetainedJobs && !q.forgetLocked() {
+		return false
+	}
+	q.queue <- j
+	q.jobs[j.status.ID] = j
+	q.ids = append(q.ids, j.status.ID)
+	return true
+}
+
+// forgetLocked forgets the oldest finished job,
+// returning false if no job has finished.
+func (q *jobQueue) forgetLocked() bool {
+	for i, id := range q.ids {
+		if status := q.jobs[id].status.Status; status == jobSucceeded || status == jobFailed {
+			delete(q.jobs, id)
+			q.ids = append(q.ids[:i], q.ids[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// update calls f to update the status of j.
+func (q *jobQueue) update(j *job, f func(*jobStatus)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f(&j.status)
+}
+
+// status returns the status of the job with the given ID.
+func (q *jobQueue) status(id string) (jobStatus, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return jobStatus{}, false
+	}
+	return j.status, true
+}
+
+// close stops the workers, cancelling the jobs they are running, and
+// waits until they have finished or ctx is done. Queued jobs are not run.
+func (q *jobQueue) close(ctx context.Context) error {
+	q.cancel()
+	finished := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for jobs: %w", ctx.Err())
+	}
+}
+
+// submitSimulation handles POST /jobs/simulate, with a body like
+// {"dice": "1000000d6"}, enqueuing a simulation as for GET
+// /simulate/:dice to run in the background. It responds 202 Accepted
+// with the job's status, whose ID may be used to poll for the sum with
+// GET /jobs/:id, as given by the Location header.
+func (s *Server) submitSimulation(c echo.Context) error {
+	var body struct {
+		Dice string `json:"dice"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return err
+	}
+	n, sides, err := parseDiceLimit(body.Dice, maxSimulatedDice)
+	if errors.Is(err, errNotationRange) {
+		return errSimulationRange
+	} else if err != nil {
+		return err
+	}
+	var idBytes [16]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(idBytes[:])
+	trace.SpanFromContext(c.Request().Context()).SetAttributes(jobIDKey.String(id))
+
+	ctx, span := s.tracer.Start(c.Request().Context(), "job enqueue",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(jobNameKey.String(simulateJob), jobIDKey.String(id)),
+	)
+	defer span.End()
+	j := &job{
+		n: n, sides: sides,
+		loaded: s.loadedDice(c),
+		link:   trace.LinkFromContext(ctx),
+		status: jobStatus{
+			ID:        id,
+			Status:    jobQueued,
+			Dice:      body.Dice,
+			Submitted: s.clock.Now(),
+		},
+	}
+	// Once enqueued, the status is updated by the worker.
+	status := j.status
+	if !s.jobs.enqueue(j) {
+		span.SetStatus(codes.Error, "job queue full")
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "job queue full, try again later")
+	}
+	c.Response().Header().Set(echo.HeaderLocation, "/jobs/"+id)
+	return c.JSON(http.StatusAccepted, status)
+}
+
+// pollJob handles GET /jobs/:id, responding with the job's status,
+// and its sum once it has succeeded.
+func (s *Server) pollJob(c echo.Context) error {
+	status, ok := s.jobs.status(c.Param("id"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "job not found")
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// runSimulationJob runs a simulation job in a new root span, linked to
+// the span that enqueued it, recording how long it was queued and
+// whether it succeeded. The dice are rolled by partition, as for
+// GET /simulate/:dice, in child spans.
+func (s *Server) runSimulationJob(ctx context.Context, j *job) {
+	q := s.jobs
+	started := s.clock.Now()
+	var submitted time.Time
+	q.update(j, func(status *jobStatus) {
+		status.Status = jobRunning
+		status.Started = &started
+		submitted = status.Submitted
+	})
+	ctx, span := s.tracer.Start(ctx, "job run",
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(j.link),
+		trace.WithAttributes(jobNameKey.String(simulateJob), jobIDKey.String(j.status.ID)),
+	)
+	q.queueDuration.Record(ctx, started.Sub(submitted).Seconds(), q.names)
+
+	_, wait := s.startSimulation(ctx, j.n, j.sides, j.loaded, false)
+	sum, err := wait()
+	finished := s.clock.Now()
+	outcome := jobSucceeded
+	if err != nil {
+		outcome = jobFailed
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(jobStatusKey.String(outcome))
+	q.completed.Add(ctx, 1, q.statuses.Option(outcome))
+	// End the span before reporting the job finished, so
+	// its trace is complete by the time the client sees it.
+	span.End()
+	q.update(j, func(status *jobStatus) {
+		status.Status = outcome
+		status.Finished = &finished
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Sum = &sum
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package dice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// submitJobForTest submits a simulation job, returning its status.
+func submitJobForTest(t *testing.T, s *testServer, dice string) jobStatus {
+	t.Helper()
+	rec := s.post("/jobs/simulate", `{"dice": "`+dice+`"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	var status jobStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/jobs/" + status.ID; rec.Header().Get("Location") != want {
+		t.Errorf("got Location %q, want %q", rec.Header().Get("Location"), want)
+	}
+	return status
+}
+
+// startJobWorkersForTest starts the server's job workers, as Serve
+// does, stopping them when the test completes.
+func startJobWorkersForTest(t *testing.T, s *testServer) {
+	t.Helper()
+	s.jobs.start(jobWorkers, s.runSimulationJob)
+	t.Cleanup(func() {
+		if err := s.jobs.close(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// pollJobForTest polls the job until it has finished, returning its status.
+func pollJobForTest(t *testing.T, s *testServer, id string) jobStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		rec := s.get("/jobs/" + id)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		var status jobStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		if status.Status == jobSucceeded || status.Status == jobFailed {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s after 10s", status.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJob(t *testing.T) {
+	s := newTestServer(t, nil)
+	startJobWorkersForTest(t, s)
+	submitted := submitJobForTest(t, s, "200000d6")
+	if submitted.Status != jobQueued || submitted.Dice != "200000d6" {
+		t.Errorf("got %+v, want a queued job rolling 200000d6", submitted)
+	}
+	status := pollJobForTest(t, s, submitted.ID)
+	if status.Status != jobSucceeded || status.Sum == nil {
+		t.Fatalf("got %+v, want a succeeded job with a sum", status)
+	}
+	if sum := *status.Sum; sum < 200000 || sum > 6*200000 {
+		t.Errorf("got sum %d, want a sum of 200000d6", sum)
+	}
+	if status.Started == nil || status.Finished == nil {
+		t.Errorf("got %+v, want start and finish times", status)
+	}
+
+	// The job is enqueued beneath the request, and run in its own
+	// trace, linked to the span that enqueued it.
+	enqueued := s.ExpectSpan("job enqueue").
+		WithKind(trace.SpanKindProducer).
+		WithAttr(jobIDKey.String(submitted.ID)).
+		Span()
+	run := s.ExpectSpan("job run").
+		WithKind(trace.SpanKindConsumer).
+		WithAttr(jobIDKey.String(submitted.ID), jobStatusKey.String(jobSucceeded)).
+		Span()
+	if run.Parent().IsValid() {
+		t.Errorf("got job run span with parent %s, want a root span", run.Parent().SpanID())
+	}
+	if links := run.Links(); len(links) != 1 || links[0].SpanContext.SpanID() != enqueued.SpanContext().SpanID() {
+		t.Errorf("got links %+v, want a link to the job enqueue span", links)
+	}
+	var partitions int
+	for _, span := range s.Spans() {
+		if span.Name() == "roll partition" && span.Parent().SpanID() == run.SpanContext().SpanID() {
+			partitions++
+		}
+	}
+	if partitions == 0 {
+		t.Error("got no roll partition spans beneath the job run span")
+	}
+	s.ExpectMetric("jobs.completed").WithAttr(jobStatusKey.String(jobSucceeded)).Sum(1)
+	s.ExpectMetric("job.queue.duration").WithAttr(jobNameKey.String(simulateJob)).Count(1)
+}
+
+func TestJobQueueFull(t *testing.T) {
+	// The workers aren't started until the server is
+	// served, so the jobs stay queued.
+	s := newTestServer(t, nil)
+	for range maxQueuedJobs {
+		submitJobForTest(t, s, "2d6")
+	}
+	rec := s.post("/jobs/simulate", `{"dice": "2d6"}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusServiceUnavailable, rec.Body)
+	}
+	if rec := s.get("/jobs/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d: %s", rec.Code, http.StatusNotFound, rec.Body)
+	}
+}
+
+func TestJobQueueFullKeepsFinishedJobs(t *testing.T) {
+	q, err := newJobQueue(metricnoop.NewMeterProvider().Meter(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range maxRetainedJobs {
+		id := strconv.Itoa(i)
+		q.jobs[id] = &job{status: jobStatus{ID: id, Status: jobSucceeded}}
+		q.ids = append(q.ids, id)
+	}
+	// Each job enqueued forgets a finished job, until the queue is full.
+	for i := range maxQueuedJobs {
+		if !q.enqueue(&job{status: jobStatus{ID: "queued" + strconv.Itoa(i)}}) {
+			t.Fatalf("job %d not enqueued, want room for %d", i, maxQueuedJobs)
+		}
+	}
+	if q.enqueue(&job{status: jobStatus{ID: "rejected"}}) {
+		t.Fatal("job enqueued, want the full queue to reject it")
+	}
+	if want := maxRetainedJobs - maxQueuedJobs; len(q.ids)-maxQueuedJobs != want {
+		t.Errorf("got %d finished jobs, want %d kept after rejecting a job", len(q.ids)-maxQueuedJobs, want)
+	}
+}
@@ -0,0 +1,92 @@
+package dice
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"oteldemo/config"
+)
+
+// listen returns a listener for addr, as described by config.ParseListenAddr.
+func listen(addr string) (net.Listener, error) {
+	network, address, err := config.ParseListenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch network {
+	case "systemd":
+		return systemdListener(address)
+	case "unix":
+		// Remove a socket left behind by an unclean shutdown;
+		// otherwise listening fails with "address already in use".
+		if fi, err := os.Stat(address); err == nil && fi.Mode().Type() == fs.ModeSocket {
+			if err := os.Remove(address); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// systemdListeners returns the listeners passed by systemd socket
+// activation, keyed by name, in the order they were passed.
+//
+// See sd_listen_fds(3). The file descriptors can only be
+// inherited once, so the result is cached.
+var systemdListeners = sync.OnceValues(func() ([]namedListener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_PID unset or mismatched)")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %w", err)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	const listenFDsStart = 3
+	listeners := make([]namedListener, n)
+	for i := range listeners {
+		fd := listenFDsStart + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inheriting systemd socket %q: %w", name, err)
+		}
+		listeners[i] = namedListener{name, l}
+	}
+	return listeners, nil
+})
+
+type namedListener struct {
+	name string
+	net.Listener
+}
+
+// systemdListener returns the listener passed by systemd socket
+// activation with the given name, or the first if name is empty.
+func systemdListener(name string) (net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range listeners {
+		if name == "" || l.name == name {
+			return l.Listener, nil
+		}
+	}
+	if name == "" {
+		return nil, errors.New("no sockets passed by systemd")
+	}
+	return nil, fmt.Errorf("no socket named %q passed by systemd", name)
+}
@@ -0,0 +1,209 @@
+package dice
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/attrset"
+	"oteldemo/clock"
+	"oteldemo/store"
+)
+
+// jobNameKey is the attribute identifying a maintenance job.
+const jobNameKey = attribute.Key("job.name")
+
+// Names of the maintenance jobs.
+const (
+	compactHistoryJob = "compact_history"
+	leaderboardJob    = "leaderboard"
+)
+
+const (
+	// compactionBatch bounds the rolls deleted at once when compacting
+	// the history, and so the links of the span deleting them, which
+	// the SDK limits to 128 by default.
+	compactionBatch = 100
+
+	// leaderboardSize is the number of sessions on the leaderboard.
+	leaderboardSize = 10
+)
+
+// maintenance runs jobs maintaining the history in the background.
+// The jobs aren't part of any request, so each run is traced in its own
+// root span. Their work is linked to the spans of the requests that
+// recorded the rolls they process, so the traces can be navigated from
+// a job to the requests it affected.
+type maintenance struct {
+	duration  metric.Float64Histogram
+	failures  metric.Int64Counter
+	evictions metric.Int64Counter
+	jobs      *attrset.Cache[string]
+
+	leaderboard atomic.Pointer[leaderboardResponse]
+}
+
+func newMaintenance(meter metric.Meter) (*maintenance, error) {
+	duration, err := meter.Float64Histogram(
+		"maintenance.job.duration",
+		metric.WithDescription("Duration of background maintenance jobs, by job"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	failures, err := meter.Int64Counter(
+		"maintenance.job.failures",
+		metric.WithDescription("Background maintenance jobs that failed, by job"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	evictions, err := meter.Int64Counter(
+		"history.evictions",
+		metric.WithDescription("Rolls deleted from the history when compacting it to the retained rolls"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &maintenance{
+		duration:  duration,
+		failures:  failures,
+		evictions: evictions,
+		jobs: attrset.New(2, func(job string) []attribute.KeyValue {
+			return []attribute.KeyValue{jobNameKey.String(job)}
+		}),
+	}, nil
+}
+
+// maintain runs the maintenance jobs immediately, and then
+// periodically at the configured interval, until ctx is done.
+func (s *Server) maintain(ctx context.Context) {
+	interval := s.cfg.Storage.MaintenanceInterval
+	for {
+		s.runJob(ctx, compactHistoryJob, s.compactHistory)
+		s.runJob(ctx, leaderboardJob, s.recomputeLeaderboard)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(interval):
+		}
+	}
+}
+
+// runJob runs a maintenance job in a new root span,
+// recording its duration and whether it failed.
+func (s *Server) runJob(ctx context.Context, name string, job func(context.Context) error) {
+	start := s.clock.Now()
+	ctx, span := s.tracer.Start(ctx, "maintenance "+name,
+		trace.WithNewRoot(),
+		trace.WithAttributes(jobNameKey.String(name)),
+	)
+	defer span.End()
+	err := job(ctx)
+	attrs := s.maintenance.jobs.Option(name)
+	s.maintenance.duration.Record(ctx, clock.Since(s.clock, start).Seconds(), attrs)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.maintenance.failures.Add(ctx, 1, attrs)
+		log.Printf("maintenance job %s failed: %v", name, err)
+	}
+}
+
+// compactHistory deletes all but the most recent rolls of each session,
+// in batches, bounding the history of each session. The rolls deleted
+// are counted by the history.evictions metric.
+func (s *Server) compactHistory(ctx context.Context) error {
+	for {
+		rolls, err := s.store.OldRolls(ctx, s.cfg.Storage.HistoryRetention, compactionBatch)
+		if err != nil {
+			return err
+		}
+		if len(rolls) > 0 {
+			if err := s.deleteRolls(ctx, rolls); err != nil {
+				return err
+			}
+		}
+		if len(rolls) < compactionBatch {
+			return nil
+		}
+	}
+}
+
+// deleteRolls deletes a batch of rolls, in a span linked
+// to the spans of the requests that recorded them.
+func (s *Server) deleteRolls(ctx context.Context, rolls []store.Roll) error {
+	ids := make([]int64, len(rolls))
+	links := make([]trace.Link, 0, len(rolls))
+	for i, roll := range rolls {
+		ids[i] = roll.ID
+		if roll.SpanContext.IsValid() {
+			links = append(links, trace.Link{SpanContext: roll.SpanContext})
+		}
+	}
+	ctx, span := s.tracer.Start(ctx, "delete rolls",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("rolls", len(rolls))),
+	)
+	defer span.End()
+	if err := s.store.DeleteRolls(ctx, ids); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	s.maintenance.evictions.Add(ctx, int64(len(ids)))
+	return nil
+}
+
+// leaderboardResponse is the response to GET /leaderboard.
+type leaderboardResponse struct {
+	Sessions []store.Session `json:"sessions"`
+	Computed time.Time       `json:"computed"`
+}
+
+// recomputeLeaderboard ranks the sessions with the most rolls, updating
+// the leaderboard in a span linked to the spans of the requests that
+// recorded each session's last roll.
+func (s *Server) recomputeLeaderboard(ctx context.Context) error {
+	leaders, err := s.store.Leaderboard(ctx, leaderboardSize)
+	if err != nil {
+		return err
+	}
+	resp := &leaderboardResponse{Sessions: make([]store.Session, len(leaders)), Computed: s.clock.Now()}
+	links := make([]trace.Link, 0, len(leaders))
+	for i, l := range leaders {
+		resp.Sessions[i] = l.Session
+		if l.SpanContext.IsValid() {
+			links = append(links, trace.Link{SpanContext: l.SpanContext})
+		}
+	}
+	_, span := s.tracer.Start(ctx, "update leaderboard",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("sessions", len(leaders))),
+	)
+	s.maintenance.leaderboard.Store(resp)
+	span.End()
+	return nil
+}
+
+// leaderboard handles GET /leaderboard, responding with the sessions with
+// the most rolls, as last computed by the maintenance jobs.
+func (s *Server) leaderboard(c echo.Context) error {
+	var resp *leaderboardResponse
+	if s.maintenance != nil {
+		resp = s.maintenance.leaderboard.Load()
+	}
+	if resp == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "the leaderboard is not available")
+	}
+	return c.JSON(http.StatusOK, resp)
+}
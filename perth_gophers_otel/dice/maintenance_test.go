@@ -0,0 +1,119 @@
+package dice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// rollSpans returns the spans of the rolls made so far, in order.
+func (s *testServer) rollSpans() []trace.ReadOnlySpan {
+	var spans []trace.ReadOnlySpan
+	for _, span := range s.Spans() {
+		if span.Name() == rollSpan {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// linkedSpanIDs returns the IDs of the spans linked from span.
+func linkedSpanIDs(span trace.ReadOnlySpan) map[string]bool {
+	ids := make(map[string]bool)
+	for _, link := range span.Links() {
+		ids[link.SpanContext.SpanID().String()] = true
+	}
+	return ids
+}
+
+func TestCompactHistory(t *testing.T) {
+	s := newHistoryTestServer(t)
+	s.cfg.Storage.HistoryRetention = 2
+	for range 4 {
+		if rec := s.get("/roll/2d6?session=alice"); rec.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+	s.runJob(context.Background(), compactHistoryJob, s.compactHistory)
+
+	rolls, err := s.store.History(context.Background(), "alice", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rolls) != 2 {
+		t.Errorf("got %d rolls after compaction, want 2", len(rolls))
+	}
+
+	job := s.ExpectSpan("maintenance compact_history").
+		WithAttr(jobNameKey.String(compactHistoryJob)).
+		Span()
+	if job.Parent().IsValid() {
+		t.Errorf("job span has parent %s, want a root span", job.Parent().SpanID())
+	}
+	del := s.ExpectSpan("delete rolls").WithAttr(attribute.Int("rolls", 2)).Span()
+	if del.Parent().SpanID() != job.SpanContext().SpanID() {
+		t.Errorf("delete span is not a child of the job span")
+	}
+	// The two oldest rolls were deleted.
+	linked := linkedSpanIDs(del)
+	for i, span := range s.rollSpans() {
+		if want := i < 2; linked[span.SpanContext().SpanID().String()] != want {
+			t.Errorf("roll %d: got linked %v, want %v", i, !want, want)
+		}
+	}
+	s.ExpectMetric("maintenance.job.duration").WithAttr(jobNameKey.String(compactHistoryJob)).Count(1)
+	s.ExpectMetric("history.evictions").Sum(2)
+	s.ExpectNoMetric("maintenance.job.failures")
+}
+
+func TestLeaderboard(t *testing.T) {
+	s := newHistoryTestServer(t)
+	if rec := s.get("/leaderboard"); rec.Code != http.StatusNotFound {
+		t.Fatalf("got status %d before computing the leaderboard, want %d", rec.Code, http.StatusNotFound)
+	}
+	for _, target := range []string{"/roll/2d6?session=bob", "/roll/2d6?session=alice", "/roll/2d6?session=alice"} {
+		if rec := s.get(target); rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d: %s", target, rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+	s.runJob(context.Background(), leaderboardJob, s.recomputeLeaderboard)
+
+	rec := s.get("/leaderboard")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var resp leaderboardResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Sessions) != 2 || resp.Sessions[0].ID != "alice" || resp.Sessions[1].ID != "bob" {
+		t.Errorf("got leaderboard %+v, want alice then bob", resp.Sessions)
+	}
+
+	// The update is linked to each session's last roll.
+	rolls := s.rollSpans()
+	update := s.ExpectSpan("update leaderboard").WithAttr(attribute.Int("sessions", 2)).Span()
+	linked := linkedSpanIDs(update)
+	for i, want := range []bool{true, false, true} {
+		if linked[rolls[i].SpanContext().SpanID().String()] != want {
+			t.Errorf("roll %d: got linked %v, want %v", i, !want, want)
+		}
+	}
+}
+
+func TestMaintenanceJobFailure(t *testing.T) {
+	s := newHistoryTestServer(t)
+	s.store.Close()
+	s.runJob(context.Background(), leaderboardJob, s.recomputeLeaderboard)
+
+	s.ExpectSpan("maintenance leaderboard").WithStatus(codes.Error)
+	s.ExpectMetric("maintenance.job.failures").WithAttr(jobNameKey.String(leaderboardJob)).Sum(1)
+	if rec := s.get("/leaderboard"); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
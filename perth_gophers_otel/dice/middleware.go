@@ -0,0 +1,43 @@
+package dice
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordProtocol is middleware that records the HTTP protocol
+// and TLS version of requests as span attributes.
+func recordProtocol(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		span := trace.SpanFromContext(req.Context())
+		span.SetAttributes(
+			semconv.NetworkProtocolName("http"),
+			semconv.NetworkProtocolVersion(httpVersion(req)),
+		)
+		if req.TLS != nil {
+			span.SetAttributes(
+				semconv.TLSProtocolNameTLS,
+				semconv.TLSProtocolVersion(strings.TrimPrefix(tls.VersionName(req.TLS.Version), "TLS ")),
+				semconv.TLSCipher(tls.CipherSuiteName(req.TLS.CipherSuite)),
+			)
+		}
+		return next(c)
+	}
+}
+
+// httpVersion returns the HTTP version of req as specified by the
+// semantic conventions, e.g. "1.1" or "2".
+func httpVersion(req *http.Request) string {
+	if req.ProtoMajor >= 2 {
+		return strconv.Itoa(req.ProtoMajor)
+	}
+	return fmt.Sprintf("%d.%d", req.ProtoMajor, req.ProtoMinor)
+}
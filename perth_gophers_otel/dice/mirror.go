@@ -0,0 +1,156 @@
+package dice
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxMirrored is the maximum number of mirrored requests
+// in flight; further requests are not mirrored.
+const maxMirrored = 16
+
+// mirrorOutcomeKey is the metric and span attribute
+// recording the outcome of a mirrored request.
+const mirrorOutcomeKey = attribute.Key("mirror.outcome")
+
+// shadowFunc is a shadow of a route's handler, which compares the results
+// of the primary implementation of some part of the handler with those
+// of a candidate replacement, for a mirrored request. It returns whether
+// the results match, and attributes describing them.
+//
+// Shadows must be free of side effects, such as recording metrics or
+// rolls, as they run in addition to the handler.
+type shadowFunc func(c echo.Context) (match bool, attrs []attribute.KeyValue)
+
+// mirror is middleware that mirrors the configured fraction of requests
+// to the shadow registered for the route in s.shadows, if any. Shadows
+// run asynchronously after the request has been served, in their own
+// trace linked to the request's, so they don't affect the response. The
+// outcome of the comparison is counted by s.mirrored.
+//
+// Request bodies are not mirrored.
+func (s *Server) mirror(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		shadow, ok := s.shadows[c.Path()]
+		fraction := s.config().Features.MirrorFraction
+		if !ok || fraction <= 0 || rand.Float64() >= fraction {
+			return next(c)
+		}
+		err := next(c)
+
+		// The context is pooled, so copy what's needed before returning.
+		ctx := context.WithoutCancel(c.Request().Context())
+		route := c.Path()
+		req := c.Request().Clone(ctx)
+		req.Body = http.NoBody
+		names, values := slices.Clone(c.ParamNames()), slices.Clone(c.ParamValues())
+		select {
+		case s.mirrorSlots <- struct{}{}:
+		default:
+			s.mirrored.Add(ctx, 1, s.mirrorAttrs.Option(mirrorAttrs{route, "dropped"}))
+			return err
+		}
+		go func() {
+			defer func() { <-s.mirrorSlots }()
+			s.runShadow(req, route, names, values, shadow)
+		}()
+		return err
+	}
+}
+
+// runShadow runs a shadow for a mirrored request,
+// recording the outcome of its comparison.
+func (s *Server) runShadow(req *http.Request, route string, names, values []string, shadow shadowFunc) {
+	ctx, span := s.tracer.Start(req.Context(), "mirror "+route,
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(req.Context())),
+		trace.WithAttributes(semconv.HTTPRoute(route)),
+	)
+	defer span.End()
+
+	// Shadows don't respond, so anything written is discarded.
+	c := s.echo.NewContext(req.WithContext(ctx), httptest.NewRecorder())
+	c.SetPath(route)
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	match, attrs := shadow(c)
+
+	outcome := "match"
+	if !match {
+		outcome = "diverged"
+	}
+	span.SetAttributes(attrs...)
+	span.SetAttributes(mirrorOutcomeKey.String(outcome))
+	s.mirrored.Add(ctx, 1, s.mirrorAttrs.Option(mirrorAttrs{route, outcome}))
+}
+
+// mirrorAttrs are the attributes of the mirror.requests counter.
+type mirrorAttrs struct {
+	route   string
+	outcome string
+}
+
+func (a mirrorAttrs) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{semconv.HTTPRoute(a.route), mirrorOutcomeKey.String(a.outcome)}
+}
+
+// newDiceNotation matches dice notation accepted by the new parser,
+// which allows the number of dice to be omitted, as in d20.
+var newDiceNotation = regexp.MustCompile(`^([0-9]*)d([0-9]+)$`)
+
+// parseDiceNew is a candidate replacement for parseDice, exercised by
+// mirroring requests to shadowRoll.
+func parseDiceNew(diceString string) (n, sides int64, err error) {
+	m := newDiceNotation.FindStringSubmatch(diceString)
+	if m == nil {
+		return 0, 0, errInvalidNotation
+	}
+	n = 1
+	if m[1] != "" {
+		// The pattern only matches digits, so the only possible
+		// error is that the number is out of range.
+		if n, err = strconv.ParseInt(m[1], 10, 8); err != nil {
+			return 0, 0, errNotationRange
+		}
+	}
+	if sides, err = strconv.ParseInt(m[2], 10, 8); err != nil {
+		return 0, 0, errNotationRange
+	}
+	if n < 1 || sides < 1 {
+		return 0, 0, errNoDice
+	}
+	return n, sides, nil
+}
+
+// shadowRoll is the shadow of GET /roll/:dice, comparing the results of
+// parsing the dice with parseDice and with the candidate parseDiceNew.
+// It only parses, so as not to record rolls twice.
+func shadowRoll(c echo.Context) (match bool, attrs []attribute.KeyValue) {
+	input := c.Param("dice")
+	n, sides, err := parseDice(input)
+	newN, newSides, newErr := parseDiceNew(input)
+	match = n == newN && sides == newSides && err == newErr
+	return match, []attribute.KeyValue{
+		attribute.String("mirror.primary_result", parseResult(n, sides, err)),
+		attribute.String("mirror.shadow_result", parseResult(newN, newSides, newErr)),
+	}
+}
+
+// parseResult describes the result of parsing dice notation,
+// as canonical notation or the error.
+func parseResult(n, sides int64, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return notation(n, sides)
+}
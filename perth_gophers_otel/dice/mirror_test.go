@@ -0,0 +1,44 @@
+package dice
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+
+	"oteldemo/config"
+)
+
+func TestMirrorComparesParseResults(t *testing.T) {
+	cfg := config.Default()
+	cfg.Features.MirrorFraction = 1
+	s := newTestServer(t, cfg)
+
+	for _, target := range []string{"/roll/2d6", "/roll/d20", "/roll/0d6"} {
+		s.get(target)
+		// Wait for the shadow to finish.
+		for len(s.mirrorSlots) > 0 {
+			runtime.Gosched()
+		}
+	}
+
+	// Only the new parser accepts d20, rolling 1d20; both
+	// parsers reject 0d6, which is not a divergence.
+	if code := s.get("/roll/d20").Code; code != http.StatusBadRequest {
+		t.Errorf("got status %d for d20, want %d from the primary parser", code, http.StatusBadRequest)
+	}
+	s.ExpectSpan("mirror /roll/:dice").WithAttr(
+		mirrorOutcomeKey.String("diverged"),
+		attribute.String("mirror.primary_result", errInvalidNotation.Error()),
+		attribute.String("mirror.shadow_result", "1d20"),
+	)
+	s.ExpectSpan("mirror /roll/:dice").WithAttr(
+		mirrorOutcomeKey.String("match"),
+		attribute.String("mirror.primary_result", errNoDice.Error()),
+	)
+	route := semconv.HTTPRoute("/roll/:dice")
+	s.ExpectMetric("mirror.requests").WithAttr(route, mirrorOutcomeKey.String("match")).Sum(2)
+	s.ExpectMetric("mirror.requests").WithAttr(route, mirrorOutcomeKey.String("diverged")).Sum(1)
+}
@@ -0,0 +1,106 @@
+package dice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"oteldemo/breaker"
+	"oteldemo/clock"
+	"oteldemo/modifier"
+)
+
+// modifierClient calls the Modifier gRPC service, instrumented with
+// otelgrpc so the calls appear as client spans in the roll's trace,
+// with the trace context propagated in the request metadata. Calls go
+// through a circuit breaker, so rolls fail fast while it is down.
+type modifierClient struct {
+	conn    *grpc.ClientConn
+	client  *modifier.Client
+	timeout time.Duration
+	breaker *breaker.Breaker
+}
+
+// dialModifier returns a client for the Modifier service at target.
+// Connections are established lazily, on the first call.
+func dialModifier(
+	target string, timeout time.Duration, clk clock.Clock,
+	tp trace.TracerProvider, mp metric.MeterProvider, propagators propagation.TextMapPropagator,
+	opts ...grpc.DialOption,
+) (*modifierClient, error) {
+	b, err := breaker.New("modifier", breaker.WithClock(clk), breaker.WithMeterProvider(mp))
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(
+			otelgrpc.WithTracerProvider(tp),
+			otelgrpc.WithMeterProvider(mp),
+			otelgrpc.WithPropagators(propagators),
+		)),
+	}, opts...)
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &modifierClient{conn: conn, client: modifier.NewClient(conn), timeout: timeout, breaker: b}, nil
+}
+
+// modify calls the service to modify sum. Failures are mapped from
+// their gRPC status to the response status of the roll; while the
+// circuit breaker is open, the service is reported unavailable.
+func (m *modifierClient) modify(ctx context.Context, sum int64) (int64, error) {
+	var modified int64
+	err := m.breaker.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+		var err error
+		modified, err = m.client.Modify(ctx, sum)
+		return err
+	})
+	if err != nil {
+		code := status.Code(err)
+		if errors.Is(err, breaker.ErrOpen) {
+			code = codes.Unavailable
+		}
+		return 0, echo.NewHTTPError(
+			httpStatusFromGRPC(code),
+			fmt.Sprintf("modifier service failed: %s", code),
+		).SetInternal(err)
+	}
+	return modified, nil
+}
+
+// close closes the connection to the service.
+func (m *modifierClient) close() error {
+	return m.conn.Close()
+}
+
+// httpStatusFromGRPC returns the status with which to respond to a
+// request for which a call to a downstream service failed with code.
+// The failure is the downstream service's rather than the client's, so
+// it is reported as a gateway error, or as unavailability if the
+// downstream service is unavailable or overloaded.
+func httpStatusFromGRPC(code codes.Code) int {
+	switch code {
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Unavailable, codes.ResourceExhausted:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
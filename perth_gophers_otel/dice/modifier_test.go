@@ -0,0 +1,145 @@
+package dice
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"oteldemo/config"
+	"oteldemo/modifier"
+)
+
+// modifierFunc is a modifier.Modifier function.
+type modifierFunc func(ctx context.Context, sum int64) (int64, error)
+
+func (f modifierFunc) Modify(ctx context.Context, sum int64) (int64, error) {
+	return f(ctx, sum)
+}
+
+// withModifier serves m in memory, instrumented with the test server's
+// TracerProvider, and has s call it for each roll.
+func (s *testServer) withModifier(t *testing.T, m modifier.Modifier) {
+	t.Helper()
+	l := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler(
+		otelgrpc.WithTracerProvider(s.TracerProvider),
+		otelgrpc.WithPropagators(propagation.TraceContext{}),
+	)))
+	modifier.Register(srv, m)
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+
+	client, err := dialModifier("passthrough:///bufconn", s.cfg.Downstream.ModifierTimeout, s.clock,
+		s.tracerProvider, s.meterProvider, s.propagators,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.close() })
+	s.modifier = client
+}
+
+func TestRollModifier(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.withModifier(t, modifierFunc(func(ctx context.Context, sum int64) (int64, error) {
+		return 1000 + sum, nil
+	}))
+	rec := s.get("/roll/2d6")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if sum, _ := strconv.Atoi(strings.TrimSpace(rec.Body.String())); sum < 1002 || sum > 1012 {
+		t.Errorf("got sum %d, want it modified to within [1002, 1012]", sum)
+	}
+
+	roll := s.ExpectSpan(rollSpan).Span()
+	client := s.ExpectSpan("modifier.Modifier/Modify").WithKind(trace.SpanKindClient).Span()
+	server := s.ExpectSpan("modifier.Modifier/Modify").
+		WithKind(trace.SpanKindServer).
+		WithAttr(attribute.Int64("rpc.grpc.status_code", int64(codes.OK))).
+		Span()
+	if client.Parent().SpanID() != roll.SpanContext().SpanID() {
+		t.Errorf("modifier client span is not a child of the roll span")
+	}
+	if server.Parent().SpanID() != client.SpanContext().SpanID() {
+		t.Errorf("modifier server span is not a child of the client span")
+	}
+}
+
+func TestRollModifierErrors(t *testing.T) {
+	for name, test := range map[string]struct {
+		err  error
+		want int
+	}{
+		"unavailable":      {status.Error(codes.Unavailable, "down"), http.StatusServiceUnavailable},
+		"invalid argument": {status.Error(codes.InvalidArgument, "bad sum"), http.StatusBadGateway},
+		"deadline exceeded": {
+			status.Error(codes.DeadlineExceeded, "too slow"),
+			http.StatusGatewayTimeout,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			s.withModifier(t, modifierFunc(func(ctx context.Context, sum int64) (int64, error) {
+				return 0, test.err
+			}))
+			if rec := s.get("/roll/2d6"); rec.Code != test.want {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, test.want, rec.Body)
+			}
+			s.ExpectSpan("modifier.Modifier/Modify").
+				WithKind(trace.SpanKindClient).
+				WithAttr(attribute.Int64("rpc.grpc.status_code", int64(status.Code(test.err))))
+		})
+	}
+}
+
+func TestRollModifierTimeout(t *testing.T) {
+	cfg := config.Default()
+	cfg.Downstream.ModifierTimeout = time.Millisecond
+	s := newTestServer(t, cfg)
+	s.withModifier(t, modifierFunc(func(ctx context.Context, sum int64) (int64, error) {
+		<-ctx.Done()
+		return 0, status.FromContextError(ctx.Err()).Err()
+	}))
+	if rec := s.get("/roll/2d6"); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusGatewayTimeout, rec.Body)
+	}
+}
+
+func TestRollModifierBreaker(t *testing.T) {
+	s := newTestServer(t, nil)
+	var calls atomic.Int64
+	s.withModifier(t, modifierFunc(func(ctx context.Context, sum int64) (int64, error) {
+		calls.Add(1)
+		return 0, status.Error(codes.Internal, "oops")
+	}))
+	for range 5 {
+		if rec := s.get("/roll/2d6"); rec.Code != http.StatusBadGateway {
+			t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadGateway, rec.Body)
+		}
+	}
+	// The breaker has opened, so the service is no longer called.
+	if rec := s.get("/roll/2d6"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusServiceUnavailable, rec.Body)
+	}
+	if n := calls.Load(); n != 5 {
+		t.Errorf("got %d calls to the modifier service, want 5", n)
+	}
+}
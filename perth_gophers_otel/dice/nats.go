@@ -0,0 +1,99 @@
+package dice
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/natsroll"
+)
+
+// natsQueue is the queue group in which servers subscribe to roll
+// requests, so each request is served by one of them.
+const natsQueue = "dice"
+
+// ServeNATS serves roll requests received over NATS request-reply, as
+// described by package natsroll, until ctx is done. Requests are served
+// by the same handlers and middleware as HTTP requests, in a consumer
+// span continuing the trace from the request's headers, so they are
+// instrumented the same way.
+func (s *Server) ServeNATS(ctx context.Context, nc *nats.Conn) error {
+	sub, err := nc.QueueSubscribe(natsroll.Subject, natsQueue, func(msg *nats.Msg) {
+		s.serveNATS(ctx, msg)
+	})
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	// Finish serving requests already received.
+	return sub.Drain()
+}
+
+// natsSpanOptions are the options of the spans of NATS requests.
+var natsSpanOptions = []trace.SpanStartOption{
+	trace.WithSpanKind(trace.SpanKindConsumer),
+	trace.WithAttributes(
+		semconv.MessagingSystemKey.String("nats"),
+		semconv.MessagingOperationDeliver,
+		semconv.MessagingDestinationName(natsroll.Subject),
+	),
+}
+
+// serveNATS serves a roll request received over NATS, by serving the
+// equivalent HTTP request and replying with the response.
+func (s *Server) serveNATS(ctx context.Context, msg *nats.Msg) {
+	ctx = s.propagators.Extract(ctx, natsroll.HeaderCarrier(msg.Header))
+	ctx, span := s.tracer.Start(ctx, natsroll.Subject+" deliver", natsSpanOptions...)
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/roll/"+url.PathEscape(string(msg.Data)), nil)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+	// The server span is started from the request headers,
+	// so it becomes a child of this span.
+	s.propagators.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	w := &natsResponseWriter{header: make(http.Header)}
+	s.echo.ServeHTTP(w, req)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	span.SetAttributes(attribute.Int("http.status_code", w.status))
+
+	reply := nats.NewMsg(msg.Reply)
+	reply.Header.Set(natsroll.StatusHeader, strconv.Itoa(w.status))
+	reply.Data = w.body.Bytes()
+	if err := msg.RespondMsg(reply); err != nil {
+		span.RecordError(err)
+	}
+}
+
+// natsResponseWriter is an http.ResponseWriter
+// recording a response to reply with over NATS.
+type natsResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *natsResponseWriter) Header() http.Header { return w.header }
+
+func (w *natsResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *natsResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
@@ -0,0 +1,108 @@
+package dice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/diceclient"
+)
+
+// serveNATS serves s over an embedded NATS server until the test ends,
+// returning a connection to the server on which to make requests.
+func (s *testServer) serveNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+
+	serverConn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(serverConn.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ServeNATS(ctx, serverConn) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	// Make sure the subscription is in place before making requests.
+	if err := serverConn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return nc
+}
+
+// natsClient returns a diceclient.Client requesting rolls over nc,
+// recording its spans with s's TracerProvider.
+func (s *testServer) natsClient(t *testing.T, nc *nats.Conn) *diceclient.Client {
+	t.Helper()
+	c, err := diceclient.New("http://dice.invalid",
+		diceclient.WithNATS(nc),
+		diceclient.WithTracerProvider(s.TracerProvider),
+		diceclient.WithPropagators(propagation.TraceContext{}),
+		diceclient.WithRetries(0, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRollNATS(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := s.natsClient(t, s.serveNATS(t))
+	sum, err := c.Roll(context.Background(), "2d6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum < 2 || sum > 12 {
+		t.Errorf("got sum %d, want it within [2, 12]", sum)
+	}
+
+	publish := s.ExpectSpan("dice.roll publish").WithKind(trace.SpanKindProducer).Span()
+	deliver := s.ExpectSpan("dice.roll deliver").WithKind(trace.SpanKindConsumer).Span()
+	roll := s.ExpectSpan(rollSpan).Span()
+	if deliver.Parent().SpanID() != publish.SpanContext().SpanID() {
+		t.Errorf("deliver span is not a child of the publish span")
+	}
+	if roll.Parent().SpanID() != deliver.SpanContext().SpanID() {
+		t.Errorf("roll span is not a child of the deliver span")
+	}
+}
+
+func TestRollNATSError(t *testing.T) {
+	s := newTestServer(t, nil)
+	c := s.natsClient(t, s.serveNATS(t))
+	_, err := c.Roll(context.Background(), "2x6")
+	var e *diceclient.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got error %v, want a *diceclient.Error", err)
+	}
+	if e.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", e.StatusCode, http.StatusBadRequest)
+	}
+}
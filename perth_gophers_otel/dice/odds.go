@@ -0,0 +1,69 @@
+package dice
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// oddsResponse is the response to GET /odds/:dice.
+type oddsResponse struct {
+	Dice string    `json:"dice"`
+	Sums []sumOdds `json:"sums"`
+}
+
+// sumOdds is the probability of rolling a sum.
+type sumOdds struct {
+	Sum         int64   `json:"sum"`
+	Probability float64 `json:"probability"`
+}
+
+// odds handles GET /odds/:dice, responding with the probability table
+// of the sums of fair dice. Tables are cached in Redis, if configured.
+func (s *Server) odds(c echo.Context) error {
+	ctx := c.Request().Context()
+	n, sides, _, err := s.parseCache.parse(ctx, c.Param("dice"))
+	if err != nil {
+		return err
+	}
+	// Key by the parsed notation, so equivalent notation
+	// (e.g. 02d6 and 2d6) shares a table.
+	resp := oddsResponse{Dice: notation(n, sides)}
+	if s.cache != nil && s.cache.get(ctx, oddsCache, resp.Dice, &resp) {
+		return c.JSON(http.StatusOK, resp)
+	}
+	resp.Sums = sumProbabilities(n, sides)
+	if s.cache != nil {
+		s.cache.set(ctx, oddsCache, resp.Dice, resp)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// sumProbabilities returns the probability of each sum of n fair dice
+// with the given number of sides, from n to n*sides.
+func sumProbabilities(n, sides int64) []sumOdds {
+	// p[k] is the probability of the dice rolled so far summing
+	// to k. Each die's distribution is convolved in using a running
+	// sum over the window of sides values, so the cost is O(n²·sides)
+	// rather than O(n²·sides²).
+	p := []float64{1}
+	for i := int64(1); i <= n; i++ {
+		next := make([]float64, i*sides+1)
+		var window float64
+		for k := range next {
+			if k-1 >= 0 && k-1 < len(p) {
+				window += p[k-1]
+			}
+			if j := k - 1 - int(sides); j >= 0 && j < len(p) {
+				window -= p[j]
+			}
+			next[k] = window / float64(sides)
+		}
+		p = next
+	}
+	odds := make([]sumOdds, 0, n*(sides-1)+1)
+	for sum := n; sum <= n*sides; sum++ {
+		odds = append(odds, sumOdds{Sum: sum, Probability: p[sum]})
+	}
+	return odds
+}
@@ -0,0 +1,108 @@
+package dice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+)
+
+// FuzzParseDice checks that parseDice does not panic, that everything
+// it accepts round-trips through canonical notation, and that the
+// candidate parseDiceNew accepts everything parseDice does, with the
+// same result, so mirroring only diverges on the new d20 form.
+//
+// There are no notation modifiers (like 2d6+1 or 4d6kh3) yet; when
+// they are added, seed them here.
+func FuzzParseDice(f *testing.F) {
+	for _, seed := range []string{
+		"2d6", "1d20", "d20", "127d127", "128d6", "0d6", "2d0", "-1d6",
+		"+2d6", "02d06", "2D6", "2d", "d", "", "2d6d6", " 2d6", "2d6+1", "4d6kh3",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		n, sides, err := parseDice(input)
+		if err != nil {
+			if !errors.Is(err, errInvalidNotation) && !errors.Is(err, errNoDice) {
+				t.Fatalf("parseDice(%q): unexpected error type %T: %v", input, err, err)
+			}
+			return
+		}
+		if n < 1 || sides < 1 {
+			t.Fatalf("parseDice(%q) = %d, %d; want positive values", input, n, sides)
+		}
+		for _, c := range input {
+			if (c < '0' || c > '9') && c != 'd' {
+				t.Fatalf("parseDice(%q) accepted non-notation character %q", input, c)
+			}
+		}
+
+		canonical := fmt.Sprintf("%dd%d", n, sides)
+		n2, sides2, err := parseDice(canonical)
+		if err != nil || n2 != n || sides2 != sides {
+			t.Fatalf("parseDice(%q) = %d, %d, %v; want %d, %d (from %q)",
+				canonical, n2, sides2, err, n, sides, input)
+		}
+
+		n3, sides3, err := parseDiceNew(input)
+		if err != nil || n3 != n || sides3 != sides {
+			t.Fatalf("parseDiceNew(%q) = %d, %d, %v; want %d, %d", input, n3, sides3, err, n, sides)
+		}
+	})
+}
+
+func TestParseDiceErrors(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  error
+	}{
+		{"2x6", errInvalidNotation},
+		{"-1d6", errInvalidNotation},
+		{"2d6+1", errInvalidNotation},
+		{"128d6", errNotationRange},
+		{"2d1000", errNotationRange},
+		{"0d6", errNoDice},
+		{"2d0", errNoDice},
+	} {
+		if _, _, err := parseDice(tc.input); !errors.Is(err, tc.want) {
+			t.Errorf("parseDice(%q): got error %v, want %v", tc.input, err, tc.want)
+		}
+	}
+	// Out of range notation is also invalid notation.
+	if !errors.Is(errNotationRange, errInvalidNotation) {
+		t.Error("errNotationRange does not wrap errInvalidNotation")
+	}
+}
+
+func TestParseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	pc, err := newParseCache(metricnoop.NewMeterProvider().Meter(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	parse := func(notation string) (hit bool) {
+		t.Helper()
+		_, _, hit, err := pc.parse(ctx, notation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hit
+	}
+	// Overfill the cache after 1d2, using 1d1 throughout
+	// so it stays recently used.
+	parse("1d2")
+	parse("1d1")
+	for i := range parseCacheSize - 1 {
+		parse(fmt.Sprintf("%dd%d", 2+i/100, 1+i%100))
+		parse("1d1")
+	}
+	if !parse("1d1") {
+		t.Error("recently used 1d1 was evicted")
+	}
+	if parse("1d2") {
+		t.Error("least recently used 1d2 was not evicted")
+	}
+}
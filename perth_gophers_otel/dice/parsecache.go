@@ -0,0 +1,107 @@
+package dice
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"oteldemo/attrset"
+)
+
+// parseCacheSize is the number of parsed expressions cached.
+const parseCacheSize = 1024
+
+// parseCacheResultKey is the metric attribute recording
+// whether an expression was found in the parse cache.
+const parseCacheResultKey = attribute.Key("dice.parse_cache.result")
+
+// parsedDice is dice notation, parsed.
+type parsedDice struct {
+	n, sides int64
+}
+
+// parseCache is a least-recently-used cache of parsed dice notation,
+// keyed by the notation as given, counting lookups by whether they hit.
+// Only valid notation is cached, so invalid notation cannot evict it.
+type parseCache struct {
+	lookups metric.Int64Counter
+	results *attrset.Cache[bool]
+
+	mu      sync.Mutex
+	order   *list.List // of *parseCacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type parseCacheEntry struct {
+	notation string
+	parsed   parsedDice
+}
+
+// newParseCache returns an empty parseCache,
+// registering its metrics with meter.
+func newParseCache(meter metric.Meter) (*parseCache, error) {
+	lookups, err := meter.Int64Counter(
+		"dice.parse_cache.lookups",
+		metric.WithDescription("Lookups of dice notation in the parse cache, by whether they hit"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &parseCache{
+		lookups: lookups,
+		results: attrset.New(2, func(hit bool) []attribute.KeyValue {
+			if hit {
+				return []attribute.KeyValue{parseCacheResultKey.String("hit")}
+			}
+			return []attribute.KeyValue{parseCacheResultKey.String("miss")}
+		}),
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}, nil
+}
+
+// parse parses dice notation as parseDice does, returning the cached
+// result if the notation was parsed recently, and whether it was.
+func (pc *parseCache) parse(ctx context.Context, notation string) (n, sides int64, hit bool, err error) {
+	parsed, hit := pc.get(notation)
+	defer pc.lookups.Add(ctx, 1, pc.results.Option(hit))
+	if hit {
+		return parsed.n, parsed.sides, true, nil
+	}
+	n, sides, err = parseDice(notation)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	pc.put(notation, parsedDice{n, sides})
+	return n, sides, false, nil
+}
+
+func (pc *parseCache) get(notation string) (parsedDice, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e, ok := pc.entries[notation]
+	if !ok {
+		return parsedDice{}, false
+	}
+	pc.order.MoveToFront(e)
+	return e.Value.(*parseCacheEntry).parsed, true
+}
+
+func (pc *parseCache) put(notation string, parsed parsedDice) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if e, ok := pc.entries[notation]; ok {
+		// Parsed concurrently by another request.
+		pc.order.MoveToFront(e)
+		return
+	}
+	if pc.order.Len() >= parseCacheSize {
+		oldest := pc.order.Back()
+		pc.order.Remove(oldest)
+		delete(pc.entries, oldest.Value.(*parseCacheEntry).notation)
+	}
+	pc.entries[notation] = pc.order.PushFront(&parseCacheEntry{notation, parsed})
+}
@@ -0,0 +1,143 @@
+package dice
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/config"
+)
+
+// Attributes of server spans recorded by otelecho, following an older
+// version of the semantic conventions, which are corrected for
+// requests forwarded by trusted proxies. They are renamed to the stable
+// conventions, if opted in to, by middleware.SemconvTracerProvider.
+const (
+	httpClientIPKey = attribute.Key("http.client_ip")
+	httpSchemeKey   = attribute.Key("http.scheme")
+	netHostNameKey  = attribute.Key("net.host.name")
+	netHostPortKey  = attribute.Key("net.host.port")
+)
+
+// proxies are the trusted reverse proxies.
+type proxies []*net.IPNet
+
+// newProxies returns the trusted proxies of cfg, which has been validated.
+func newProxies(cfg config.Proxy) proxies {
+	var p proxies
+	for _, s := range cfg.TrustedProxies {
+		ipNet, _ := config.ParseIPRange(s)
+		p = append(p, ipNet)
+	}
+	return p
+}
+
+// trusts reports whether the peer at addr ("host:port") is a trusted proxy.
+func (p proxies) trusts(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipExtractor returns the echo.IPExtractor identifying clients: the
+// peer, or the last address in X-Forwarded-For not of a trusted proxy.
+// Echo otherwise trusts X-Forwarded-For from any peer, letting clients
+// spoof their address, e.g. to target feature flags.
+func (p proxies) ipExtractor() echo.IPExtractor {
+	if len(p) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	opts := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, ipNet := range p {
+		opts = append(opts, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(opts...)
+}
+
+// recordForwarded is middleware that records the client, scheme and host
+// of requests as forwarded by trusted proxies on their server spans,
+// rather than those of the proxies' requests. The client recorded by
+// otelecho, taken from X-Forwarded-For whether trusted or not, is
+// replaced by the client's IP address as extracted by ipExtractor.
+func (s *Server) recordForwarded(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		span := trace.SpanFromContext(req.Context())
+		if !span.IsRecording() {
+			return next(c)
+		}
+		if req.Header.Get(echo.HeaderXForwardedFor) != "" {
+			span.SetAttributes(httpClientIPKey.String(c.RealIP()))
+		}
+		if !s.proxies.trusts(req.RemoteAddr) {
+			return next(c)
+		}
+		switch proto := firstForwarded(req.Header, echo.HeaderXForwardedProto); proto {
+		case "http", "https":
+			span.SetAttributes(httpSchemeKey.String(proto))
+		}
+		if host := firstForwarded(req.Header, "X-Forwarded-Host"); host != "" {
+			name, port, err := net.SplitHostPort(host)
+			if err != nil {
+				name, port = host, ""
+			}
+			span.SetAttributes(netHostNameKey.String(name))
+			if p, err := strconv.Atoi(port); err == nil {
+				span.SetAttributes(netHostPortKey.Int(p))
+			}
+		}
+		return next(c)
+	}
+}
+
+// firstForwarded returns the first value of a forwarding header, which
+// was set by the proxy nearest the client, and so describes the
+// client's request.
+func firstForwarded(h http.Header, key string) string {
+	v, _, _ := strings.Cut(h.Get(key), ",")
+	return strings.TrimSpace(v)
+}
+
+// extractPropagators returns the propagators with which to extract trace
+// context from requests: the server's, preceded by those accepting the
+// trace context formats of the configured API gateways. W3C trace
+// context thus takes precedence when a gateway propagates both.
+func (s *Server) extractPropagators() propagation.TextMapPropagator {
+	names := s.cfg.Proxy.GatewayPropagators
+	if len(names) == 0 {
+		return s.propagators
+	}
+	var propagators []propagation.TextMapPropagator
+	for _, name := range names {
+		switch name {
+		case "b3":
+			propagators = append(propagators, b3.New())
+		case "xray":
+			propagators = append(propagators, xray.Propagator{})
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(append(propagators, s.propagators)...)
+}
@@ -0,0 +1,108 @@
+package dice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"oteldemo/config"
+)
+
+// getWithHeaders serves a GET request for target with the given headers,
+// from httptest's default peer, 192.0.2.1.
+func (s *testServer) getWithHeaders(target string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+var forwardedHeaders = map[string]string{
+	"X-Forwarded-For":   "203.0.113.7, 192.0.2.1",
+	"X-Forwarded-Proto": "https",
+	"X-Forwarded-Host":  "dice.example.com:8443",
+}
+
+func TestForwardedUntrusted(t *testing.T) {
+	s := newTestServer(t, nil)
+	if rec := s.getWithHeaders("/roll/2d6", forwardedHeaders); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	// The headers are ignored, so the client is the peer.
+	s.ExpectSpan(rollSpan).
+		WithAttr(httpClientIPKey.String("192.0.2.1"), httpSchemeKey.String("http"))
+}
+
+func TestForwardedTrusted(t *testing.T) {
+	cfg := config.Default()
+	cfg.Proxy.TrustedProxies = []string{"192.0.2.0/24"}
+	s := newTestServer(t, cfg)
+	if rec := s.getWithHeaders("/roll/2d6", forwardedHeaders); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	s.ExpectSpan(rollSpan).WithAttr(
+		httpClientIPKey.String("203.0.113.7"),
+		httpSchemeKey.String("https"),
+		netHostNameKey.String("dice.example.com"),
+		netHostPortKey.Int(8443),
+	)
+}
+
+func TestGatewayPropagators(t *testing.T) {
+	const (
+		traceID     = "5759e988bd862e3fe1be46a994272793"
+		spanID      = "53995c3f42cd8ad8"
+		traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	)
+	for name, test := range map[string]struct {
+		propagator string
+		headers    map[string]string
+		traceID    string
+	}{
+		"b3": {
+			propagator: "b3",
+			headers:    map[string]string{"b3": traceID + "-" + spanID + "-1"},
+			traceID:    traceID,
+		},
+		"xray": {
+			propagator: "xray",
+			headers:    map[string]string{"X-Amzn-Trace-Id": "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=" + spanID + ";Sampled=1"},
+			traceID:    traceID,
+		},
+		"traceparent takes precedence": {
+			propagator: "b3",
+			headers: map[string]string{
+				"b3":          traceID + "-" + spanID + "-1",
+				"traceparent": traceparent,
+			},
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		"not accepted": {
+			headers: map[string]string{"b3": traceID + "-" + spanID + "-1"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.Default()
+			if test.propagator != "" {
+				cfg.Proxy.GatewayPropagators = []string{test.propagator}
+			}
+			s := newTestServer(t, cfg)
+			if rec := s.getWithHeaders("/roll/2d6", test.headers); rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			span := s.ExpectSpan(rollSpan).Span()
+			if test.traceID == "" {
+				if span.Parent().IsValid() {
+					t.Errorf("got parent %s, want a root span", span.Parent().SpanID())
+				}
+				return
+			}
+			if got := span.SpanContext().TraceID().String(); got != test.traceID {
+				t.Errorf("got trace ID %s, want %s", got, test.traceID)
+			}
+		})
+	}
+}
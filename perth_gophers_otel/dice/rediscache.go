@@ -0,0 +1,141 @@
+package dice
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/attrset"
+	"oteldemo/store"
+)
+
+// Attribute keys of the cache lookup metric.
+const (
+	cacheNameKey   = attribute.Key("cache.name")
+	cacheResultKey = attribute.Key("cache.result")
+)
+
+// Names of the caches kept in Redis.
+const (
+	sessionCache = "sessions"
+	oddsCache    = "odds"
+)
+
+// redisCache caches JSON values in Redis, counting lookups by cache and
+// whether they hit, from which the hit ratio can be derived. The client
+// is instrumented with redisotel, so commands appear as spans in the
+// traces of the requests making them.
+//
+// Redis errors are treated as misses, so an unavailable
+// cache slows requests down, but doesn't fail them.
+type redisCache struct {
+	client  *redis.Client
+	ttl     time.Duration
+	lookups metric.Int64Counter
+	results *attrset.Cache[cacheResult]
+}
+
+// cacheResult is the attributes of a cache lookup.
+type cacheResult struct {
+	cache string
+	hit   bool
+}
+
+func (r cacheResult) attributes() []attribute.KeyValue {
+	result := "miss"
+	if r.hit {
+		result = "hit"
+	}
+	return []attribute.KeyValue{cacheNameKey.String(r.cache), cacheResultKey.String(result)}
+}
+
+func newRedisCache(
+	addr string, ttl time.Duration,
+	tp trace.TracerProvider, mp metric.MeterProvider, meter metric.Meter,
+) (*redisCache, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := redisotel.InstrumentTracing(client, redisotel.WithTracerProvider(tp)); err != nil {
+		return nil, err
+	}
+	if err := redisotel.InstrumentMetrics(client, redisotel.WithMeterProvider(mp)); err != nil {
+		return nil, err
+	}
+	lookups, err := meter.Int64Counter(
+		"cache.lookups",
+		metric.WithDescription("Lookups in the Redis cache, by cache and whether they hit"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCache{
+		client:  client,
+		ttl:     ttl,
+		lookups: lookups,
+		// Two results for each of the caches.
+		results: attrset.New(4, cacheResult.attributes),
+	}, nil
+}
+
+// get decodes the value cached in cache at key into v,
+// reporting whether it was found.
+func (rc *redisCache) get(ctx context.Context, cache, key string, v any) bool {
+	data, err := rc.client.Get(ctx, cache+":"+key).Bytes()
+	hit := err == nil && json.Unmarshal(data, v) == nil
+	rc.lookups.Add(ctx, 1, rc.results.Option(cacheResult{cache, hit}))
+	return hit
+}
+
+// set caches v in cache at key.
+func (rc *redisCache) set(ctx context.Context, cache, key string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	rc.client.Set(ctx, cache+":"+key, data, rc.ttl)
+}
+
+// delete removes the value cached in cache at key.
+func (rc *redisCache) delete(ctx context.Context, cache, key string) {
+	rc.client.Del(ctx, cache+":"+key)
+}
+
+// close closes the connection to Redis.
+func (rc *redisCache) close() error {
+	return rc.client.Close()
+}
+
+// cachedStore is a store.Store caching sessions in Redis.
+type cachedStore struct {
+	store.Store
+	cache *redisCache
+}
+
+// RecordRoll records a roll, invalidating the cached session.
+func (s cachedStore) RecordRoll(ctx context.Context, session string, roll store.Roll) error {
+	if err := s.Store.RecordRoll(ctx, session, roll); err != nil {
+		return err
+	}
+	s.cache.delete(ctx, sessionCache, session)
+	return nil
+}
+
+// Session returns a session from the cache, or from the
+// underlying store if it is not cached, caching it.
+func (s cachedStore) Session(ctx context.Context, id string) (store.Session, error) {
+	var sess store.Session
+	if s.cache.get(ctx, sessionCache, id, &sess) {
+		return sess, nil
+	}
+	sess, err := s.Store.Session(ctx, id)
+	if err != nil {
+		return sess, err
+	}
+	s.cache.set(ctx, sessionCache, id, sess)
+	return sess, nil
+}
@@ -0,0 +1,108 @@
+package dice
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.opentelemetry.io/otel/attribute"
+
+	"oteldemo/config"
+)
+
+// newCacheTestServer returns a testServer recording roll history
+// in a temporary SQLite database, cached in an in-memory Redis.
+func newCacheTestServer(t *testing.T) *testServer {
+	t.Helper()
+	redis := miniredis.RunT(t)
+	cfg := config.Default()
+	cfg.Storage.SQLitePath = filepath.Join(t.TempDir(), "dice.db")
+	cfg.Storage.RedisAddr = redis.Addr()
+	s := newTestServer(t, cfg)
+	t.Cleanup(func() {
+		s.store.Close()
+		s.cache.close()
+	})
+	return s
+}
+
+func TestOdds(t *testing.T) {
+	s := newTestServer(t, nil)
+	rec := s.get("/odds/2d6")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var resp oddsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Sums) != 11 {
+		t.Fatalf("got %d sums, want 11", len(resp.Sums))
+	}
+	var total float64
+	for _, odds := range resp.Sums {
+		total += odds.Probability
+		ways := 6 - math.Abs(float64(odds.Sum-7))
+		if want := ways / 36; math.Abs(odds.Probability-want) > 1e-12 {
+			t.Errorf("P(%d) = %v, want %v", odds.Sum, odds.Probability, want)
+		}
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("probabilities sum to %v, want 1", total)
+	}
+}
+
+func TestOddsCached(t *testing.T) {
+	s := newCacheTestServer(t)
+	for range 2 {
+		if rec := s.get("/odds/3d6"); rec.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+	s.ExpectSpan("get").WithAttr(attribute.String("db.system", "redis"))
+	s.ExpectSpan("set").WithAttr(attribute.String("db.system", "redis"))
+	s.ExpectMetric("cache.lookups").
+		WithAttr(cacheNameKey.String(oddsCache), cacheResultKey.String("hit")).
+		Sum(1)
+	s.ExpectMetric("cache.lookups").
+		WithAttr(cacheNameKey.String(oddsCache), cacheResultKey.String("miss")).
+		Sum(1)
+}
+
+func TestSessionCached(t *testing.T) {
+	s := newCacheTestServer(t)
+	for _, target := range []string{
+		"/roll/2d6?session=alice",
+		"/sessions/alice/history",
+		"/sessions/alice/history",
+		// Rolling invalidates the cached session.
+		"/roll/2d6?session=alice",
+		"/sessions/alice/history",
+	} {
+		if rec := s.get(target); rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d: %s", target, rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+	s.ExpectSpan("del")
+	s.ExpectMetric("cache.lookups").
+		WithAttr(cacheNameKey.String(sessionCache), cacheResultKey.String("hit")).
+		Sum(1)
+	s.ExpectMetric("cache.lookups").
+		WithAttr(cacheNameKey.String(sessionCache), cacheResultKey.String("miss")).
+		Sum(2)
+}
+
+func TestCacheUnavailable(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.RedisAddr = "localhost:1"
+	s := newTestServer(t, cfg)
+	t.Cleanup(func() { s.cache.close() })
+	// Requests are served, uncached.
+	if rec := s.get("/odds/2d6"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	s.ExpectMetric("cache.lookups").WithAttr(cacheResultKey.String("miss")).Sum(1)
+}
@@ -0,0 +1,89 @@
+package dice
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"oteldemo/clock"
+	"oteldemo/config"
+)
+
+// newReloadTestServer returns a test server with cfg, which reloads a
+// copy of loaded, as it is when reloaded.
+func newReloadTestServer(t *testing.T, cfg, loaded *config.Config, opts ...Option) *testServer {
+	t.Helper()
+	opts = append(opts, WithConfigLoader(func() (*config.Config, error) {
+		next := *loaded
+		return &next, nil
+	}))
+	return newTestServer(t, cfg, opts...)
+}
+
+func TestReload(t *testing.T) {
+	cfg := config.Default()
+	loaded := config.Default()
+	loaded.Failures.ErrorRate = 1
+	loaded.ListenAddr = "localhost:9999"
+	var hooked *config.Config
+	s := newReloadTestServer(t, cfg, loaded, WithReloadHook(func(c *config.Config) { hooked = c }))
+
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if hooked == nil || hooked.Failures.ErrorRate != 1 {
+		t.Fatalf("got %+v passed to the reload hook, want the reloaded failures", hooked)
+	}
+	if got := s.config().ListenAddr; got != cfg.ListenAddr {
+		t.Errorf("got listen address %q, want %q until restarted", got, cfg.ListenAddr)
+	}
+	if code := s.get("/roll/2d6").Code; code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d with the reloaded error rate", code, http.StatusInternalServerError)
+	}
+	s.ExpectSpan("/roll/:dice").WithAttr(configGenerationKey.Int64(2))
+}
+
+func TestReloadRequestLimits(t *testing.T) {
+	cfg := config.Default()
+	cfg.Failures.Latency = time.Minute
+	loaded := *cfg
+	loaded.MaxConcurrentRequests = 1
+	loaded.MaxQueuedRequests = 0
+	clk := clock.NewFake(time.Now())
+	s := newReloadTestServer(t, cfg, &loaded, WithClock(clk))
+
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	// The first request holds the only slot, waiting on the injected
+	// latency, and the second is shed as there is no room to queue.
+	served := make(chan int)
+	go func() { served <- s.get("/roll/2d6").Code }()
+	for clk.Waiters() < 1 {
+		runtime.Gosched()
+	}
+	if code := s.get("/roll/2d6").Code; code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	// Lifting the limit serves requests while the slot is still held.
+	loaded.MaxConcurrentRequests = 0
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	unlimited := make(chan int)
+	go func() { unlimited <- s.get("/roll/2d6").Code }()
+	for clk.Waiters() < 2 {
+		runtime.Gosched()
+	}
+	clk.Advance(time.Minute)
+	for _, done := range []chan int{served, unlimited} {
+		if code := <-done; code != http.StatusOK {
+			t.Fatalf("got status %d, want %d", code, http.StatusOK)
+		}
+	}
+	s.ExpectMetric("http.server.requests_shed").WithAttr(attribute.String("reason", "queue_full")).Sum(1)
+}
@@ -0,0 +1,92 @@
+package dice
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// lockedSource is a rand.Source64 that is safe for concurrent use,
+// so a single *rand.Rand can be shared by all requests.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+// newSharedRand returns a *rand.Rand seeded with seed, which is safe for
+// concurrent use by the methods that draw from its source, such as
+// Int63n and Float64, and by Zipf generators. Read is not safe.
+func newSharedRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// zipfCache caches the Zipf generators of loaded dice by their number of
+// sides, as constructing one computes tables that depend only on the
+// number of sides. The cache is bounded by the sides parseDice accepts.
+type zipfCache struct {
+	rng   *rand.Rand
+	zipfs sync.Map // int64 -> *rand.Zipf
+	size  atomic.Int64
+}
+
+// newZipfCache returns a zipfCache drawing from rng, registering
+// a gauge of its size with meter.
+func newZipfCache(meter metric.Meter, rng *rand.Rand) (*zipfCache, error) {
+	c := &zipfCache{rng: rng}
+	_, err := meter.Int64ObservableGauge(
+		"dice.zipf_cache.size",
+		metric.WithDescription("Zipf generators cached for rolling loaded dice"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(c.size.Load())
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// get returns the Zipf generator for dice with the given number of sides.
+// Zipf generators only read their tables after construction, so one may
+// be shared by concurrent requests, given a concurrency-safe source.
+func (c *zipfCache) get(sides int64) *rand.Zipf {
+	if zipf, ok := c.zipfs.Load(sides); ok {
+		return zipf.(*rand.Zipf)
+	}
+	zipf, loaded := c.zipfs.LoadOrStore(sides, newZipf(c.rng, sides))
+	if !loaded {
+		c.size.Add(1)
+	}
+	return zipf.(*rand.Zipf)
+}
+
+// die returns a function that rolls a die with the given number
+// of sides, as newDie does, using a cached Zipf generator.
+func (c *zipfCache) die(sides int64, loaded bool) func() int64 {
+	if !loaded {
+		return newDie(c.rng, sides, false)
+	}
+	return loadedDie(c.get(sides))
+}
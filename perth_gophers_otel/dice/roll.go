@@ -0,0 +1,226 @@
+package dice
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/open-feature/go-sdk/openfeature"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/clock"
+	"oteldemo/config"
+)
+
+// roll handles GET /roll/:dice, rolling dice given in RPG dice
+// notation (e.g. 2d20) and responding with the sum.
+func (s *Server) roll(c echo.Context) error {
+	ctx := c.Request().Context()
+	n, sides, cached, err := s.parseCache.parse(ctx, c.Param("dice"))
+	if err != nil {
+		return err
+	}
+	session, err := sessionParam(c)
+	if err != nil {
+		return err
+	}
+	cfg := s.config()
+	// Target flags by client address, so flags
+	// can be rolled out to a subset of clients.
+	evalCtx := openfeature.NewTargetlessEvaluationContext(map[string]any{
+		"client.address": c.RealIP(),
+	})
+	tetraphobic, _ := s.flags.BooleanValue(ctx, tetraphobicFlag, cfg.Failures.Tetraphobic, evalCtx)
+	if tetraphobic && (n == 4 || sides == 4) {
+		return errTetraphobic
+	}
+	if err := injectFailures(ctx, s.clock, cfg.Failures); err != nil {
+		return err
+	}
+	loaded, _ := s.flags.BooleanValue(ctx, loadedDiceFlag, !cfg.Features.UniformRolls, evalCtx)
+	span := trace.SpanFromContext(ctx)
+	// Skip building events for spans that aren't sampled.
+	recording := span.IsRecording()
+	if recording {
+		span.SetAttributes(attribute.Bool("dice.parse_cache.hit", cached))
+		addEvent(span, "rolling dice", nKey.Int64(n), sidesKey.Int64(sides))
+	}
+
+	die := s.zipfs.die(sides, loaded)
+
+	var sum int64
+	if !recording && s.metricsDisabled {
+		// Nothing would observe the rolls, so skip instrumenting them.
+		for range n {
+			sum += die()
+		}
+	} else {
+		for range n {
+			roll := die()
+			if recording && dieEvents.Enabled() {
+				addEvent(span, "die rolled", valueKey.Int64(roll))
+			}
+			s.rollCounter.Add(ctx, 1, s.rollAttrs.Option(roll))
+			sum += roll
+		}
+	}
+	if s.fortune != nil || s.modifier != nil {
+		ctx = withRollBaggage(ctx, n)
+	}
+	sum = s.applyLuck(ctx, span, sum, n, n*sides)
+	if s.modifier != nil {
+		var err error
+		if sum, err = s.modifier.modify(ctx, sum); err != nil {
+			return err
+		}
+	}
+	if err := s.recordRoll(c, session, n, sides, sum); err != nil {
+		return err
+	}
+	if s.events != nil {
+		s.events.publish(ctx, n, sides, sum)
+	}
+	return writeSum(c, sum)
+}
+
+// Attribute keys of the roll events.
+const (
+	nKey     = attribute.Key("n")
+	sidesKey = attribute.Key("sides")
+	valueKey = attribute.Key("value")
+)
+
+// eventAttrs pools the attribute slices of span events. Options are
+// applied by appending their attributes to the event's own slice, so
+// the slice can be reused once AddEvent returns.
+var eventAttrs = sync.Pool{
+	New: func() any {
+		attrs := make([]attribute.KeyValue, 0, 2)
+		return &attrs
+	},
+}
+
+// addEvent adds an event with the given attributes to span,
+// passing them in a pooled slice.
+func addEvent(span trace.Span, name string, kv1 attribute.KeyValue, kvs ...attribute.KeyValue) {
+	attrs := eventAttrs.Get().(*[]attribute.KeyValue)
+	*attrs = append(append((*attrs)[:0], kv1), kvs...)
+	span.AddEvent(name, trace.WithAttributes(*attrs...))
+	clear(*attrs)
+	eventAttrs.Put(attrs)
+}
+
+// errTetraphobic fails rolls involving the number 4,
+// when the tetraphobic flag is enabled.
+var errTetraphobic = errors.New("tetraphobic")
+
+// sumBuffers pools the buffers in which sums are formatted.
+var sumBuffers = sync.Pool{
+	New: func() any {
+		// Large enough for any int64, and a newline.
+		b := make([]byte, 0, 24)
+		return &b
+	},
+}
+
+// writeSum responds with sum as plain text, formatted into a pooled
+// buffer. c.Blob writes the buffer before returning, so it can be reused.
+func writeSum(c echo.Context, sum int64) error {
+	bp := sumBuffers.Get().(*[]byte)
+	defer sumBuffers.Put(bp)
+	b := strconv.AppendInt((*bp)[:0], sum, 10)
+	b = append(b, '\n')
+	*bp = b
+	return c.Blob(http.StatusOK, echo.MIMETextPlainCharsetUTF8, b)
+}
+
+// newDie returns a function that rolls a die with the given number of
+// sides, returning a value in [1, sides]. Fair dice are uniform; loaded
+// dice follow a Zipf distribution, with the probability of rolling k
+// proportional to 1/k².
+func newDie(rng *rand.Rand, sides int64, loaded bool) func() int64 {
+	if !loaded {
+		return func() int64 { return 1 + rng.Int63n(sides) }
+	}
+	return loadedDie(newZipf(rng, sides))
+}
+
+// newZipf returns the Zipf generator for loaded dice with the given
+// number of sides, drawing values in [0, sides-1].
+func newZipf(rng *rand.Rand, sides int64) *rand.Zipf {
+	return rand.NewZipf(rng, 2, 1, uint64(sides)-1)
+}
+
+// loadedDie returns a function that rolls a loaded die using zipf.
+func loadedDie(zipf *rand.Zipf) func() int64 {
+	return func() int64 { return 1 + int64(zipf.Uint64()) }
+}
+
+// parseDice parses dice notation like 2d20, returning
+// the number of dice and the number of sides.
+func parseDice(diceString string) (n, sides int64, err error) {
+	return parseDiceLimit(diceString, math.MaxInt8)
+}
+
+// parseDiceLimit parses dice notation as parseDice does,
+// but allowing up to maxN dice.
+func parseDiceLimit(diceString string, maxN int64) (n, sides int64, err error) {
+	n, rest, err := parseCount(diceString, maxN)
+	if err != nil {
+		return 0, 0, err
+	}
+	if rest == "" || rest[0] != 'd' {
+		return 0, 0, errInvalidNotation
+	}
+	sides, rest, err = parseCount(rest[1:], math.MaxInt8)
+	if err != nil {
+		return 0, 0, err
+	}
+	if rest != "" {
+		return 0, 0, errInvalidNotation
+	}
+	if n < 1 || sides < 1 {
+		return 0, 0, errNoDice
+	}
+	return n, sides, nil
+}
+
+// parseCount parses the decimal digits at the start of s, up to
+// max, returning the value and the remainder of s. Unlike
+// strconv, it accepts no signs, which are not valid notation, and
+// does not allocate. It returns errInvalidNotation if s does not start
+// with a digit, and errNotationRange if the value is too large.
+func parseCount(s string, max int64) (v int64, rest string, err error) {
+	i := 0
+	for ; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+		v = v*10 + int64(s[i]-'0')
+		if v > max {
+			return 0, "", errNotationRange
+		}
+	}
+	if i == 0 {
+		return 0, "", errInvalidNotation
+	}
+	return v, s[i:], nil
+}
+
+// injectFailures adds latency and random errors to rolls, as configured.
+func injectFailures(ctx context.Context, clk clock.Clock, cfg config.Failures) error {
+	if cfg.Latency > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clk.After(cfg.Latency):
+		}
+	}
+	if cfg.ErrorRate > 0 && rand.Float64() < cfg.ErrorRate {
+		return errors.New("injected failure")
+	}
+	return nil
+}
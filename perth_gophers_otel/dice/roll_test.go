@@ -0,0 +1,159 @@
+package dice
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+
+	"oteldemo/clock"
+	"oteldemo/config"
+)
+
+// rollSpan is the name of the server span for roll requests,
+// which otelecho names after the route.
+const rollSpan = "/roll/:dice"
+
+// statusCode is the response status code attribute set by otelecho,
+// which follows an older version of the semantic conventions.
+func statusCode(code int) attribute.KeyValue {
+	return attribute.Int("http.status_code", code)
+}
+
+func TestRoll(t *testing.T) {
+	enabled := dieEvents.Enabled()
+	dieEvents.Set(true)
+	t.Cleanup(func() { dieEvents.Set(enabled) })
+
+	s := newTestServer(t, nil)
+	rec := s.get("/roll/3d6")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	s.ExpectSpan(rollSpan).
+		WithAttr(
+			attribute.String("http.route", "/roll/:dice"),
+			statusCode(http.StatusOK),
+			semconv.NetworkProtocolName("http"),
+			attribute.Int64("config.generation", 1),
+		).
+		WithStatus(codes.Unset).
+		WithEvent("rolling dice", attribute.Int64("n", 3), attribute.Int64("sides", 6)).
+		WithEvents("rolling dice", 1).
+		WithEvents("die rolled", 3)
+
+	s.ExpectMetric("dice_rolls").Sum(3)
+	s.ExpectMetric("http.server.request.duration").
+		WithAttr(
+			semconv.HTTPRoute("/roll/:dice"),
+			semconv.HTTPResponseStatusCode(http.StatusOK),
+		).
+		Count(1)
+}
+
+func TestRollInvalidNotation(t *testing.T) {
+	s := newTestServer(t, nil)
+	if rec := s.get("/roll/nonsense"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// Client errors are not server span errors, but are still recorded.
+	s.ExpectSpan(rollSpan).
+		WithAttr(statusCode(http.StatusBadRequest)).
+		WithStatus(codes.Unset).
+		WithEvents(semconv.ExceptionEventName, 1)
+	s.ExpectNoMetric("dice_rolls")
+}
+
+func TestRollInjectedFailure(t *testing.T) {
+	cfg := config.Default()
+	cfg.Failures.ErrorRate = 1
+	s := newTestServer(t, cfg)
+	if rec := s.get("/roll/2d6"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	s.ExpectSpan(rollSpan).
+		WithAttr(
+			statusCode(http.StatusInternalServerError),
+			semconv.ErrorTypeKey.String("500"),
+		).
+		WithStatus(codes.Error).
+		WithEvents(semconv.ExceptionEventName, 1)
+}
+
+func TestRollInjectedLatency(t *testing.T) {
+	cfg := config.Default()
+	cfg.Failures.Latency = time.Minute
+	clk := clock.NewFake(time.Now())
+	s := newTestServer(t, cfg, WithClock(clk))
+
+	done := make(chan int)
+	go func() { done <- s.get("/roll/2d6").Code }()
+	for clk.Waiters() == 0 {
+		runtime.Gosched()
+	}
+	clk.Advance(time.Minute)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	s.ExpectMetric("http.server.request.duration").Sum(60)
+}
+
+func TestRollChaosLatency(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	s := newTestServer(t, nil, WithClock(clk))
+	s.chaosSettings.Store(&chaosSettings{LatencyRate: 1, Latency: jsonDuration(time.Minute)})
+
+	done := make(chan int)
+	go func() { done <- s.get("/roll/2d6").Code }()
+	for clk.Waiters() == 0 {
+		runtime.Gosched()
+	}
+	clk.Advance(time.Minute)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	s.ExpectSpan("/roll/:dice").WithEvents("chaos", 1)
+}
+
+func TestHealthNotInstrumented(t *testing.T) {
+	s := newTestServer(t, nil)
+	for route := range healthRoutes {
+		if rec := s.get(route); rec.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", route, rec.Code, http.StatusOK)
+		}
+	}
+	s.ExpectNoSpans()
+	s.ExpectNoMetric("http.server.request.duration")
+}
+
+func TestRollCachesZipfGenerators(t *testing.T) {
+	cfg := config.Default()
+	cfg.Features.UniformRolls = false
+	s := newTestServer(t, cfg)
+	for _, target := range []string{"/roll/3d6", "/roll/2d6", "/roll/1d20"} {
+		if rec := s.get(target); rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d: %s", target, rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+	s.ExpectMetric("dice.zipf_cache.size").Sum(2)
+}
+
+func TestRollParseCache(t *testing.T) {
+	s := newTestServer(t, nil)
+	for range 2 {
+		if rec := s.get("/roll/2d6"); rec.Code != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+	s.ExpectSpan(rollSpan).WithAttr(attribute.Bool("dice.parse_cache.hit", false))
+	s.ExpectSpan(rollSpan).WithAttr(attribute.Bool("dice.parse_cache.hit", true))
+	s.ExpectMetric("dice.parse_cache.lookups").WithAttr(parseCacheResultKey.String("hit")).Sum(1)
+	s.ExpectMetric("dice.parse_cache.lookups").WithAttr(parseCacheResultKey.String("miss")).Sum(1)
+}
@@ -0,0 +1,600 @@
+// Package dice provides an HTTP server for rolling dice, instrumented
+// with OpenTelemetry.
+package dice
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	"github.com/open-feature/go-sdk/openfeature"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/acme/autocert"
+
+	"oteldemo/attrset"
+	"oteldemo/clock"
+	"oteldemo/config"
+	"oteldemo/middleware"
+	"oteldemo/store"
+)
+
+// instrumentationName identifies the dice package's instrumentation scope.
+const instrumentationName = "oteldemo/dice"
+
+// Server is a dice rolling HTTP server.
+type Server struct {
+	cfg               *config.Config
+	tracerProvider    trace.TracerProvider
+	meterProvider     metric.MeterProvider
+	propagators       propagation.TextMapPropagator
+	listener          net.Listener
+	adminListener     net.Listener
+	readiness         map[string]func() error
+	loadConfig        func() (*config.Config, error)
+	reloadHooks       []func(*config.Config)
+	describeTelemetry func() any
+	clock             clock.Clock
+
+	live       atomic.Pointer[config.Config]
+	generation atomic.Int64
+	reloadMu   sync.Mutex
+
+	tracer      trace.Tracer
+	meter       metric.Meter
+	rng         *rand.Rand
+	zipfs       *zipfCache
+	parseCache  *parseCache
+	rollCounter metric.Int64Counter
+	rollAttrs   *attrset.Cache[int64]
+	flags       *openfeature.Client
+	fortune     *fortuneClient
+	modifier    *modifierClient
+	events      *rollPublisher
+	store       store.Store
+	cache       *redisCache
+	proxies     proxies
+	maintenance *maintenance
+	webhooks    *webhooks
+	jobs        *jobQueue
+	draining    atomic.Int64
+
+	shadows     map[string]shadowFunc
+	mirrorSlots chan struct{}
+	mirrored    metric.Int64Counter
+	mirrorAttrs *attrset.Cache[mirrorAttrs]
+
+	chaosSettings atomic.Pointer[chaosSettings]
+
+	uncompressedBytes   metric.Int64Counter
+	compressedBytes     metric.Int64Counter
+	bodyLimitRejections metric.Int64Counter
+	encodingAttrs       *attrset.Cache[string]
+	routeAttrs          *attrset.Cache[string]
+	requestSchemas      requestSchemas
+	validationFailures  metric.Int64Counter
+	validationAttrs     *attrset.Cache[validationFailure]
+	limiter             *limiter
+
+	// metricsDisabled is set when meterProvider is a no-op,
+	// so measurements need not be prepared.
+	metricsDisabled bool
+
+	echo  *echo.Echo
+	admin *echo.Echo
+}
+
+// maxRoutes bounds the attribute sets cached for metrics by route.
+const maxRoutes = 64
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithConfig sets the server's configuration. If unspecified,
+// config.Default() is used.
+func WithConfig(cfg *config.Config) Option {
+	return func(s *Server) { s.cfg = cfg }
+}
+
+// WithTracerProvider sets the TracerProvider used by the server. If
+// unspecified, the global TracerProvider is used.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Server) { s.tracerProvider = tp }
+}
+
+// WithMeterProvider sets the MeterProvider used by the server. If
+// unspecified, the global MeterProvider is used. If mp is a no-op
+// MeterProvider, from go.opentelemetry.io/otel/metric/noop, the server
+// skips preparing measurements, as when metrics are not set up.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(s *Server) { s.meterProvider = mp }
+}
+
+// WithPropagators sets the propagators used to extract trace context from
+// requests. If unspecified, the global TextMapPropagator is used.
+func WithPropagators(p propagation.TextMapPropagator) Option {
+	return func(s *Server) { s.propagators = p }
+}
+
+// WithClock sets the clock used to seed rolls, inject latency, and
+// measure request durations. If unspecified, clock.Real is used.
+func WithClock(c clock.Clock) Option {
+	return func(s *Server) { s.clock = c }
+}
+
+// WithListener sets the listener on which Serve accepts connections,
+// overriding the configured listen address.
+func WithListener(l net.Listener) Option {
+	return func(s *Server) { s.listener = l }
+}
+
+// WithAdminListener sets the listener on which Serve accepts connections
+// for operational endpoints, overriding the configured admin address.
+func WithAdminListener(l net.Listener) Option {
+	return func(s *Server) { s.adminListener = l }
+}
+
+// WithReadinessCheck adds a check that must pass for the server to be
+// reported as ready by /readyz. The check should be cheap, as it is
+// called for every readiness probe.
+func WithReadinessCheck(name string, check func() error) Option {
+	return func(s *Server) { s.readiness[name] = check }
+}
+
+// New returns a new Server configured with the given options.
+func New(opts ...Option) (*Server, error) {
+	s := &Server{readiness: make(map[string]func() error)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.cfg == nil {
+		s.cfg = config.Default()
+	}
+	s.live.Store(s.cfg)
+	s.generation.Store(1)
+	if s.clock == nil {
+		s.clock = clock.Real
+	}
+	if s.tracerProvider == nil {
+		s.tracerProvider = otel.GetTracerProvider()
+	}
+	if s.meterProvider == nil {
+		s.meterProvider = otel.GetMeterProvider()
+	}
+	_, s.metricsDisabled = s.meterProvider.(metricnoop.MeterProvider)
+	if s.propagators == nil {
+		s.propagators = otel.GetTextMapPropagator()
+	}
+	// Seed from the clock, so rolls are deterministic with a fake clock.
+	s.rng = newSharedRand(s.clock.Now().UnixNano())
+	s.tracer = s.tracerProvider.Tracer(instrumentationName)
+	s.meter = s.meterProvider.Meter(instrumentationName)
+
+	s.flags = openfeature.NewClient(instrumentationName)
+	s.flags.AddHooks(flagEvaluationHook{})
+
+	var err error
+	s.rollCounter, err = s.meter.Int64Counter("dice_rolls")
+	if err != nil {
+		return nil, err
+	}
+	// Rolls are bounded by the sides parseDice accepts.
+	s.rollAttrs = attrset.New(math.MaxInt8, func(value int64) []attribute.KeyValue {
+		// include the value as a dimension
+		return []attribute.KeyValue{valueKey.Int64(value)}
+	})
+	// Responses are encoded with gzip, deflate or identity.
+	s.encodingAttrs = attrset.New(3, func(encoding string) []attribute.KeyValue {
+		return []attribute.KeyValue{contentEncodingKey.String(encoding)}
+	})
+	s.routeAttrs = attrset.New(maxRoutes, func(route string) []attribute.KeyValue {
+		return []attribute.KeyValue{semconv.HTTPRoute(route)}
+	})
+	s.mirrorAttrs = attrset.New(maxRoutes, mirrorAttrs.attributes)
+	s.uncompressedBytes, err = s.meter.Int64Counter(
+		"http.server.response.uncompressed_size",
+		metric.WithDescription("Size of response bodies before compression"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.compressedBytes, err = s.meter.Int64Counter(
+		"http.server.response.compressed_size",
+		metric.WithDescription("Size of response bodies after compression, as sent to clients"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.bodyLimitRejections, err = s.meter.Int64Counter(
+		"http.server.request.body_limit_rejections",
+		metric.WithDescription("Requests rejected for exceeding the maximum request body size"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.validationFailures, err = s.meter.Int64Counter(
+		"validation_failures",
+		metric.WithDescription("Request body fields that failed validation, by route and field"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// Fields are bounded by the schemas in openapi.yaml.
+	s.validationAttrs = attrset.New(maxRoutes, validationFailure.attributes)
+	if s.requestSchemas, err = loadRequestSchemas(); err != nil {
+		return nil, err
+	}
+	s.mirrored, err = s.meter.Int64Counter(
+		"mirror.requests",
+		metric.WithDescription("Requests mirrored to shadow handlers, by outcome"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.zipfs, err = newZipfCache(s.meter, s.rng)
+	if err != nil {
+		return nil, err
+	}
+	s.parseCache, err = newParseCache(s.meter)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.Downstream.FortuneURL != "" {
+		s.fortune, err = newFortuneClient(
+			s.cfg.Downstream.FortuneURL, s.cfg.Downstream.FortuneTimeout, s.clock,
+			s.tracerProvider, s.meterProvider, s.propagators,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if addr := s.cfg.Downstream.ModifierAddr; addr != "" {
+		s.modifier, err = dialModifier(addr, s.cfg.Downstream.ModifierTimeout, s.clock,
+			s.tracerProvider, s.meterProvider, s.propagators,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if path := s.cfg.Storage.SQLitePath; path != "" {
+		s.store, err = store.OpenSQLite(path,
+			store.WithTracerProvider(s.tracerProvider),
+			store.WithMeterProvider(s.meterProvider),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if addr := s.cfg.Storage.RedisAddr; addr != "" {
+		s.cache, err = newRedisCache(addr, s.cfg.Storage.CacheTTL,
+			s.tracerProvider, s.meterProvider, s.meter,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if s.store != nil {
+			s.store = cachedStore{Store: s.store, cache: s.cache}
+		}
+	}
+	if s.store != nil {
+		s.webhooks, err = newWebhooks(s.cfg.Downstream.WebhookTimeout, s.clock, s.meter,
+			s.tracerProvider, s.meterProvider, s.propagators,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.store != nil && s.cfg.Storage.MaintenanceInterval > 0 {
+		s.maintenance, err = newMaintenance(s.meter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if brokers := s.cfg.Downstream.KafkaBrokers; len(brokers) > 0 {
+		topic := s.cfg.Downstream.KafkaTopic
+		s.events = newRollPublisher(topic, newKafkaWriter(brokers, topic), s.tracer, s.propagators, s.clock)
+	}
+	s.jobs, err = newJobQueue(s.meter)
+	if err != nil {
+		return nil, err
+	}
+	s.proxies = newProxies(s.cfg.Proxy)
+	s.limiter, err = newLimiter(s.meter, s.clock,
+		s.cfg.MaxConcurrentRequests, s.cfg.MaxQueuedRequests, s.cfg.QueueTimeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.meter.Int64ObservableGauge(
+		"http.server.draining",
+		metric.WithDescription("Set to 1 while the server is draining in-flight requests"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(s.draining.Load())
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.meter.Int64ObservableGauge(
+		"config.generation",
+		metric.WithDescription("Generation of the configuration, incremented by each reload"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(s.generation.Load())
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.shadows = map[string]shadowFunc{"/roll/:dice": shadowRoll}
+	s.mirrorSlots = make(chan struct{}, maxMirrored)
+	s.chaosSettings.Store(&chaosSettings{})
+	s.echo, err = s.newEcho()
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.AdminAddr != "" || s.adminListener != nil {
+		s.admin = newAdminEcho()
+		addToggleRoutes(s.admin)
+		s.addChaosRoutes(s.admin)
+		s.admin.POST("/flush", s.handleFlush)
+		s.admin.GET("/debug/config", s.handleDebugConfig)
+		if s.loadConfig != nil {
+			s.admin.POST("/reload", s.handleReload)
+		}
+	}
+	return s, nil
+}
+
+// newEcho returns an instrumented echo.Echo serving the dice API.
+func (s *Server) newEcho() (*echo.Echo, error) {
+	stability := middleware.ParseSemconvStability(s.cfg.Telemetry.SemconvStabilityOptIn)
+	metrics, err := middleware.Metrics(middleware.MetricsConfig{
+		MeterProvider:    s.meterProvider,
+		Skipper:          skipTelemetry,
+		Clock:            s.clock,
+		SemconvStability: stability,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := echo.New()
+	r.HTTPErrorHandler = handleError
+	r.IPExtractor = s.proxies.ipExtractor()
+	r.Use(otelecho.Middleware("dice-server",
+		otelecho.WithTracerProvider(middleware.SemconvTracerProvider(s.tracerProvider, stability)),
+		otelecho.WithPropagators(s.extractPropagators()),
+		otelecho.WithSkipper(skipTelemetry),
+	))
+	r.Use(s.recordForwarded)
+	if !s.metricsDisabled {
+		r.Use(metrics)
+	}
+	r.Use(s.mirror)
+	r.Use(logAccess(s.clock))
+	// Recover from panics and record errors from everything below,
+	// so mirroring and access logs see panics as errors.
+	r.Use(middleware.Errors(middleware.RecoverConfig{
+		GoroutineDumps: func() bool { return s.cfg.Debug.GoroutineDumps },
+	}))
+	r.Use(recordProtocol)
+	r.Use(s.recordConfigGeneration)
+	r.Use(s.chaos)
+	r.Use(s.compress)
+	r.Use(s.limiter.middleware)
+	r.Use(s.limitBody)
+	r.Use(s.validateBody)
+	r.Use(timeout(s.cfg, s.clock))
+
+	s.addHealthRoutes(r)
+	r.GET("/", s.ui)
+	r.GET("/roll/:dice", s.roll)
+	r.GET("/simulate/:dice", s.simulate)
+	r.POST("/jobs/simulate", s.submitSimulation)
+	r.GET("/jobs/:id", s.pollJob)
+	r.GET("/sessions/:id/history", s.history)
+	r.POST("/sessions/:id/webhook", s.registerWebhook)
+	r.GET("/odds/:dice", s.odds)
+	r.GET("/leaderboard", s.leaderboard)
+	r.POST("/rolls", s.commitFairRoll)
+	r.POST("/rolls/:id", s.rollFair)
+	r.GET("/rolls/:id/proof", s.fairProof)
+	return r, nil
+}
+
+// Handler returns the http.Handler serving the dice API, e.g. for use
+// with net/http/httptest.
+func (s *Server) Handler() http.Handler {
+	return s.echo
+}
+
+// AdminHandler returns the http.Handler serving operational endpoints,
+// or nil if they are disabled.
+func (s *Server) AdminHandler() http.Handler {
+	if s.admin == nil {
+		return nil
+	}
+	return s.admin
+}
+
+// Listen binds the server's listeners, if they were not provided with
+// WithListener and WithAdminListener. Listen is called by Serve, but may
+// be called beforehand to learn the bound addresses with Addr and
+// AdminAddr, e.g. when listening on an ephemeral port ("localhost:0").
+func (s *Server) Listen() error {
+	if s.listener == nil {
+		l, err := listen(s.cfg.ListenAddr)
+		if err != nil {
+			return err
+		}
+		s.listener = l
+	}
+	if s.admin != nil && s.adminListener == nil {
+		l, err := listen(s.cfg.AdminAddr)
+		if err != nil {
+			s.listener.Close()
+			return err
+		}
+		s.adminListener = l
+	}
+	return nil
+}
+
+// Addr returns the address on which the server is listening,
+// or nil if Listen has not been called.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// AdminAddr returns the address on which operational endpoints are
+// served, or nil if they are disabled or Listen has not been called.
+func (s *Server) AdminAddr() net.Addr {
+	if s.adminListener == nil {
+		return nil
+	}
+	return s.adminListener.Addr()
+}
+
+// Serve serves HTTP requests, and operational endpoints if enabled, until
+// ctx is cancelled. The servers are then shut down gracefully: new
+// connections are refused, and in-flight requests are given until the
+// configured shutdown timeout to complete.
+func (s *Server) Serve(ctx context.Context) error {
+	if s.listener == nil || (s.admin != nil && s.adminListener == nil) {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+	servers := []*echo.Echo{s.echo}
+	errc := make(chan error, 2)
+	if err := s.start(s.echo, s.listener, s.cfg.TLS, errc); err != nil {
+		if s.adminListener != nil {
+			s.adminListener.Close()
+		}
+		return err
+	}
+	if s.admin != nil {
+		servers = append(servers, s.admin)
+		if err := s.start(s.admin, s.adminListener, config.TLS{}, errc); err != nil {
+			s.echo.Close()
+			return err
+		}
+	}
+	s.jobs.start(jobWorkers, s.runSimulationJob)
+	maintained := make(chan struct{})
+	maintainCtx, stopMaintenance := context.WithCancel(ctx)
+	defer stopMaintenance()
+	if s.maintenance != nil {
+		go func() {
+			defer close(maintained)
+			s.maintain(maintainCtx)
+		}()
+	} else {
+		close(maintained)
+	}
+	select {
+	case err := <-errc:
+		for _, server := range servers {
+			server.Close()
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down, waiting up to %s for in-flight requests", s.cfg.ShutdownTimeout)
+	s.draining.Store(1)
+	defer s.draining.Store(0)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	var errs []error
+	for _, server := range servers {
+		errs = append(errs, server.Shutdown(shutdownCtx))
+	}
+	for range servers {
+		if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+			errs = append(errs, err)
+		}
+	}
+	if s.events != nil {
+		// Flush rolls published by the requests just completed.
+		errs = append(errs, s.events.close())
+	}
+	if s.modifier != nil {
+		errs = append(errs, s.modifier.close())
+	}
+	if s.webhooks != nil {
+		// Deliver rolls made by the requests just completed.
+		errs = append(errs, s.webhooks.close(shutdownCtx))
+	}
+	// Cancel the jobs running, whose results would be lost anyway.
+	errs = append(errs, s.jobs.close(shutdownCtx))
+	// Finish any maintenance job using the store.
+	stopMaintenance()
+	<-maintained
+	if s.store != nil {
+		errs = append(errs, s.store.Close())
+	}
+	if s.cache != nil {
+		errs = append(errs, s.cache.close())
+	}
+	return errors.Join(errs...)
+}
+
+// start starts serving HTTP with r on l in the background, or HTTPS
+// if configured, sending the result to errc.
+func (s *Server) start(r *echo.Echo, l net.Listener, tlsCfg config.TLS, errc chan<- error) error {
+	server := r.Server
+	switch {
+	case len(tlsCfg.AutocertDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(tlsCfg.AutocertDomains...),
+		}
+		if tlsCfg.AutocertCacheDir != "" {
+			m.Cache = autocert.DirCache(tlsCfg.AutocertCacheDir)
+		}
+		server = r.TLSServer
+		server.TLSConfig = m.TLSConfig()
+	case tlsCfg.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			l.Close()
+			return fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		server = r.TLSServer
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}
+	}
+	if server.TLSConfig != nil {
+		r.TLSListener = tls.NewListener(l, server.TLSConfig)
+	} else {
+		r.Listener = l
+	}
+	go func() { errc <- r.StartServer(server) }()
+	return nil
+}
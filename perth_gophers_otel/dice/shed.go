@@ -0,0 +1,152 @@
+package dice
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/attrset"
+	"oteldemo/clock"
+)
+
+// maxShedReasons is the number of reasons for which requests are shed.
+const maxShedReasons = 3
+
+// limiter limits the number of requests served concurrently,
+// queueing and then shedding requests when saturated.
+type limiter struct {
+	limits atomic.Pointer[limits]
+	clock  clock.Clock
+
+	queued    atomic.Int64
+	inFlight  atomic.Int64
+	shed      metric.Int64Counter
+	shedAttrs *attrset.Cache[string]
+}
+
+// limits are the settings of a limiter, replaced as a whole by setLimits.
+type limits struct {
+	slots     chan struct{} // nil if requests are not limited
+	maxQueued int64
+	timeout   time.Duration
+}
+
+// newLimiter returns a limiter, registering its metrics with meter and
+// timing queued requests with clk. If maxConcurrent is zero, requests
+// are not limited but are still counted as in flight.
+func newLimiter(meter metric.Meter, clk clock.Clock, maxConcurrent, maxQueued int, timeout time.Duration) (*limiter, error) {
+	l := &limiter{clock: clk}
+	l.setLimits(maxConcurrent, maxQueued, timeout)
+	l.shedAttrs = attrset.New(maxShedReasons, func(reason string) []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.String("reason", reason)}
+	})
+	var err error
+	l.shed, err = meter.Int64Counter(
+		"http.server.requests_shed",
+		metric.WithDescription("Requests rejected because the server was saturated"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	queued, err := meter.Int64ObservableGauge(
+		"http.server.queue_depth",
+		metric.WithDescription("Requests waiting to be served"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	inFlight, err := meter.Int64ObservableGauge(
+		"http.server.in_flight",
+		metric.WithDescription("Requests being served"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		o.ObserveInt64(queued, l.queued.Load())
+		o.ObserveInt64(inFlight, l.inFlight.Load())
+		return nil
+	}, queued, inFlight)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// setLimits replaces the limiter's limits, as for newLimiter. Requests
+// already admitted or queued keep the limits they arrived under, so the
+// number served concurrently settles to the new limit as they complete.
+func (l *limiter) setLimits(maxConcurrent, maxQueued int, timeout time.Duration) {
+	next := &limits{maxQueued: int64(maxQueued), timeout: timeout}
+	if prev := l.limits.Load(); prev != nil && prev.slots != nil && cap(prev.slots) == maxConcurrent {
+		// Keep the slots, which are counting the requests being served.
+		next.slots = prev.slots
+	} else if maxConcurrent > 0 {
+		next.slots = make(chan struct{}, maxConcurrent)
+	}
+	l.limits.Store(next)
+}
+
+// middleware returns middleware that waits for a free slot before calling
+// the next handler. If the queue is full, or no slot frees up within the
+// timeout, the request is shed with 503 Service Unavailable.
+func (l *limiter) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if skipTelemetry(c) {
+			// Always serve health checks, so a saturated
+			// server isn't restarted by its supervisor.
+			return next(c)
+		}
+		if lim := l.limits.Load(); lim.slots != nil {
+			if reason := l.acquire(c, lim); reason != "" {
+				ctx := c.Request().Context()
+				trace.SpanFromContext(ctx).AddEvent("shed", trace.WithAttributes(
+					attribute.String("reason", reason),
+				))
+				l.shed.Add(ctx, 1, l.shedAttrs.Option(reason))
+				c.Response().Header().Set("Retry-After", "1")
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "server saturated, try again later")
+			}
+			defer func() { <-lim.slots }()
+		}
+		l.inFlight.Add(1)
+		defer l.inFlight.Add(-1)
+		return next(c)
+	}
+}
+
+// acquire acquires a slot under lim, returning the reason
+// for shedding the request if one could not be acquired.
+func (l *limiter) acquire(c echo.Context, lim *limits) (shedReason string) {
+	select {
+	case lim.slots <- struct{}{}:
+		return ""
+	default:
+	}
+	if l.queued.Add(1) > lim.maxQueued {
+		l.queued.Add(-1)
+		return "queue_full"
+	}
+	defer l.queued.Add(-1)
+
+	start := l.clock.Now()
+	timer := l.clock.NewTimer(lim.timeout)
+	defer timer.Stop()
+	select {
+	case lim.slots <- struct{}{}:
+		trace.SpanFromContext(c.Request().Context()).AddEvent("dequeued", trace.WithAttributes(
+			attribute.String("waited", clock.Since(l.clock, start).String()),
+		))
+		return ""
+	case <-timer.C():
+		return "queue_timeout"
+	case <-c.Request().Context().Done():
+		return "client_gone"
+	}
+}
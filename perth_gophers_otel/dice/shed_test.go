@@ -0,0 +1,45 @@
+package dice
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"oteldemo/clock"
+	"oteldemo/config"
+)
+
+func TestShedQueueTimeout(t *testing.T) {
+	cfg := config.Default()
+	cfg.Failures.Latency = time.Minute
+	cfg.MaxConcurrentRequests = 1
+	cfg.MaxQueuedRequests = 1
+	cfg.QueueTimeout = time.Second
+	clk := clock.NewFake(time.Now())
+	s := newTestServer(t, cfg, WithClock(clk))
+
+	// The first request holds the only slot, waiting on the
+	// injected latency, while the second waits in the queue.
+	served, shed := make(chan int), make(chan int)
+	go func() { served <- s.get("/roll/2d6").Code }()
+	for clk.Waiters() < 1 {
+		runtime.Gosched()
+	}
+	go func() { shed <- s.get("/roll/2d6").Code }()
+	for clk.Waiters() < 2 {
+		runtime.Gosched()
+	}
+
+	clk.Advance(time.Second)
+	if code := <-shed; code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	clk.Advance(time.Minute)
+	if code := <-served; code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	s.ExpectMetric("http.server.requests_shed").WithAttr(attribute.String("reason", "queue_timeout")).Sum(1)
+}
@@ -0,0 +1,191 @@
+package dice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/open-feature/go-sdk/openfeature"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// maxSimulatedDice is the most dice that can be rolled by
+	// GET /simulate/:dice, in a single request.
+	maxSimulatedDice = 10_000_000
+
+	// minPartition is the fewest dice rolled by each worker, below
+	// which the cost of a worker outweighs rolling in parallel.
+	minPartition = 100_000
+
+	// cancelCheckInterval is the number of dice rolled by
+	// a worker between checks for a cancelled request.
+	cancelCheckInterval = 1 << 16
+)
+
+// errSimulationRange is returned for simulations of more dice
+// or sides than can be rolled. It wraps errInvalidNotation.
+var errSimulationRange = fmt.Errorf(
+	"%w, with at most %d dice and %d sides", errInvalidNotation, maxSimulatedDice, math.MaxInt8,
+)
+
+// simulate handles GET /simulate/:dice, rolling up to maxSimulatedDice
+// dice given in RPG dice notation (e.g. 1000000d6) and responding with
+// the sum, as a simulation of many rolls.
+//
+// Large simulations are split between a bounded pool of workers, each
+// rolling a partition of the dice in a "roll partition" child span, so
+// that the concurrency is visible in traces. Rolls are counted by
+// dice_rolls, as for GET /roll/:dice, but once per value rolled by each
+// worker, rather than once per die.
+//
+// Clients accepting application/x-ndjson are streamed the progress of
+// the simulation as it runs, as described by streamSimulation, rather
+// than waiting for the sum.
+func (s *Server) simulate(c echo.Context) error {
+	n, sides, err := parseDiceLimit(c.Param("dice"), maxSimulatedDice)
+	if errors.Is(err, errNotationRange) {
+		return errSimulationRange
+	} else if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	streaming := acceptsNDJSON(c.Request())
+	progress, wait := s.startSimulation(ctx, n, sides, s.loadedDice(c), streaming)
+	if streaming {
+		return s.streamSimulation(c, n, progress, wait)
+	}
+	sum, err := wait()
+	if err != nil {
+		return err
+	}
+	return writeSum(c, sum)
+}
+
+// loadedDice reports whether the dice rolled for a request
+// are loaded, as determined by the loaded-dice feature flag.
+func (s *Server) loadedDice(c echo.Context) bool {
+	evalCtx := openfeature.NewTargetlessEvaluationContext(map[string]any{
+		"client.address": c.RealIP(),
+	})
+	loaded, _ := s.flags.BooleanValue(c.Request().Context(), loadedDiceFlag, !s.config().Features.UniformRolls, evalCtx)
+	return loaded
+}
+
+// startSimulation starts rolling n dice with the given number of sides,
+// split between workers, recording the simulation on the span in ctx.
+// The returned function waits for the workers to finish, returning the
+// sum. If streaming, the workers send their progress to the returned
+// channel, which is closed when they finish.
+func (s *Server) startSimulation(
+	ctx context.Context, n, sides int64, loaded, streaming bool,
+) (<-chan simulationProgress, func() (int64, error)) {
+	workers := min(int64(runtime.GOMAXPROCS(0)), max(1, n/minPartition))
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.Int64("dice.count", n),
+		attribute.Int64("dice.sides", sides),
+		attribute.Int64("simulate.workers", workers),
+	)
+
+	// Each worker rolls with its own generator, seeded from the shared
+	// one, so the workers don't contend for the shared generator's lock.
+	seeds := make([]int64, workers)
+	for i := range seeds {
+		seeds[i] = s.rng.Int63()
+	}
+	var progress chan simulationProgress
+	if streaming {
+		progress = make(chan simulationProgress, workers)
+	}
+	sums := make([]int64, workers)
+	counts := make([][]int64, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		// Spread the remainder over the first workers.
+		partition := n / workers
+		if i < n%workers {
+			partition++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seeds[i]))
+			sums[i], counts[i], errs[i] = s.rollPartition(ctx, int(i), rng, partition, sides, loaded, progress)
+		}()
+	}
+	if streaming {
+		go func() {
+			wg.Wait()
+			close(progress)
+		}()
+	}
+	return progress, func() (int64, error) {
+		wg.Wait()
+		return s.sumPartitions(ctx, sums, counts, errs)
+	}
+}
+
+// sumPartitions returns the sum of the partitions rolled by the workers,
+// counting the values rolled, or the errors of the workers that failed.
+func (s *Server) sumPartitions(ctx context.Context, sums []int64, counts [][]int64, errs []error) (int64, error) {
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+	var sum int64
+	for i := range sums {
+		sum += sums[i]
+		for value, count := range counts[i] {
+			if count > 0 {
+				s.rollCounter.Add(ctx, count, s.rollAttrs.Option(int64(value)))
+			}
+		}
+	}
+	return sum, nil
+}
+
+// rollPartition rolls n dice with the given number of sides in a child
+// span, returning their sum, and the number of times each value was
+// rolled, indexed by value. If progress is not nil, the dice rolled are
+// sent to it periodically.
+func (s *Server) rollPartition(
+	ctx context.Context, worker int, rng *rand.Rand, n, sides int64, loaded bool,
+	progress chan<- simulationProgress,
+) (sum int64, counts []int64, err error) {
+	ctx, span := s.tracer.Start(ctx, "roll partition", trace.WithAttributes(
+		attribute.Int("simulate.worker", worker),
+		attribute.Int64("dice.count", n),
+	))
+	defer span.End()
+
+	die := newDie(rng, sides, loaded)
+	counts = make([]int64, sides+1)
+	var reported simulationProgress
+	for i := range n {
+		if i%cancelCheckInterval == 0 {
+			if ctx.Err() != nil {
+				span.RecordError(ctx.Err())
+				return 0, nil, ctx.Err()
+			}
+			if progress != nil && i > 0 {
+				progress <- simulationProgress{Rolled: i - reported.Rolled, Sum: sum - reported.Sum}
+				reported = simulationProgress{Rolled: i, Sum: sum}
+			}
+		}
+		roll := die()
+		counts[roll]++
+		sum += roll
+	}
+	if progress != nil {
+		progress <- simulationProgress{Rolled: n - reported.Rolled, Sum: sum - reported.Sum}
+	}
+	span.SetAttributes(attribute.Int64("simulate.partial_sum", sum))
+	return sum, counts, nil
+}
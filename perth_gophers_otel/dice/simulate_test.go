@@ -0,0 +1,121 @@
+package dice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestSimulate(t *testing.T) {
+	const n = 4 * minPartition
+	procs := runtime.GOMAXPROCS(4)
+	t.Cleanup(func() { runtime.GOMAXPROCS(procs) })
+
+	s := newTestServer(t, nil)
+	rec := s.get("/simulate/" + strconv.Itoa(n) + "d6")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	sum, err := strconv.ParseInt(strings.TrimSpace(rec.Body.String()), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum < n || sum > 6*n {
+		t.Errorf("got sum %d, want within [%d, %d]", sum, n, 6*n)
+	}
+
+	request := s.ExpectSpan("/simulate/:dice").WithAttr(attribute.Int64("simulate.workers", 4)).Span()
+	s.ExpectSpan("roll partition").WithAttr(attribute.Int64("dice.count", minPartition))
+	var partitions, partialSums int64
+	for _, span := range s.Spans() {
+		if span.Name() != "roll partition" {
+			continue
+		}
+		partitions++
+		if span.Parent().SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("partition span %s is not a child of the request span", span.SpanContext().SpanID())
+		}
+		for _, kv := range span.Attributes() {
+			if kv.Key == "simulate.partial_sum" {
+				partialSums += kv.Value.AsInt64()
+			}
+		}
+	}
+	if partitions != 4 {
+		t.Errorf("got %d partition spans, want 4", partitions)
+	}
+	if partialSums != sum {
+		t.Errorf("got partial sums totalling %d, want %d", partialSums, sum)
+	}
+	s.ExpectMetric("dice_rolls").Sum(n)
+}
+
+func TestSimulateStream(t *testing.T) {
+	const n = 4 * minPartition
+	s := newTestServer(t, nil)
+	rec := s.getWithHeaders("/simulate/"+strconv.Itoa(n)+"d6", map[string]string{"Accept": mimeNDJSON})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != mimeNDJSON {
+		t.Errorf("got Content-Type %q, want %q", got, mimeNDJSON)
+	}
+	if !rec.Flushed {
+		t.Error("response was not flushed")
+	}
+
+	var lines []simulationProgress
+	dec := json.NewDecoder(rec.Body)
+	for dec.More() {
+		var p simulationProgress
+		if err := dec.Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, p)
+	}
+	if len(lines) < 2 {
+		t.Fatalf("got %d lines, want progress before the result", len(lines))
+	}
+	for i, p := range lines[:len(lines)-1] {
+		if p.Done || p.Rolled >= n || (i > 0 && p.Rolled <= lines[i-1].Rolled) {
+			t.Errorf("line %d: got %+v, want increasing progress", i, p)
+		}
+	}
+	result := lines[len(lines)-1]
+	if !result.Done || result.Rolled != n || result.Sum < n || result.Sum > 6*n {
+		t.Errorf("got result %+v, want %d dice rolled", result, n)
+	}
+
+	s.ExpectSpan("/simulate/:dice").
+		WithEvents("simulate progress", len(lines)-1).
+		WithEvent("simulate progress", attribute.Int64("simulate.rolled", lines[0].Rolled))
+	s.ExpectMetric("dice_rolls").Sum(n)
+}
+
+func TestSimulateStreamCancelled(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/simulate/"+strconv.Itoa(4*minPartition)+"d6", nil).WithContext(ctx)
+	req.Header.Set("Accept", mimeNDJSON)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var result simulationProgress
+	if err := json.Unmarshal(bytes.TrimSpace(rec.Body.Bytes()), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Done || result.Error == "" {
+		t.Errorf("got result %+v, want an error", result)
+	}
+}
@@ -0,0 +1,89 @@
+package dice
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mimeNDJSON is the media type of newline-delimited JSON.
+const mimeNDJSON = "application/x-ndjson"
+
+// progressUpdates is the number of progress updates streamed
+// for a simulation, in addition to its result.
+const progressUpdates = 10
+
+// simulationProgress is a line of a streamed simulation: the dice rolled
+// so far and their sum, or the dice rolled by a worker since it last
+// reported its progress.
+type simulationProgress struct {
+	Rolled int64  `json:"rolled"`
+	Sum    int64  `json:"sum"`
+	Done   bool   `json:"done,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// acceptsNDJSON reports whether req accepts newline-delimited JSON.
+func acceptsNDJSON(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get(echo.HeaderAccept), ",") {
+		if mediaType, _, err := mime.ParseMediaType(accept); err == nil && mediaType == mimeNDJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// streamSimulation streams the progress of a simulation of n dice as
+// newline-delimited JSON, from the progress reported by its workers
+// until the channel is closed, and then the simulation's result from
+// sum. Each line is flushed as it is written, so clients see the
+// progress of long simulations, and recorded as a "simulate progress"
+// event on the request's span.
+//
+// The response is committed before the simulation finishes, so failures
+// are reported as a final line with an error, rather than by status.
+func (s *Server) streamSimulation(
+	c echo.Context, n int64, progress <-chan simulationProgress, sum func() (int64, error),
+) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, mimeNDJSON)
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+	span := trace.SpanFromContext(c.Request().Context())
+	enc := json.NewEncoder(res)
+	write := func(p simulationProgress) {
+		// Write errors mean the client has gone; the
+		// workers are cancelled with the request.
+		_ = enc.Encode(p)
+		res.Flush()
+	}
+
+	step := max(1, n/progressUpdates)
+	next := step
+	var total simulationProgress
+	for p := range progress {
+		total.Rolled += p.Rolled
+		total.Sum += p.Sum
+		if total.Rolled >= next && total.Rolled < n {
+			write(total)
+			span.AddEvent("simulate progress", trace.WithAttributes(
+				attribute.Int64("simulate.rolled", total.Rolled),
+				attribute.Int64("simulate.partial_sum", total.Sum),
+			))
+			next = (total.Rolled/step + 1) * step
+		}
+	}
+
+	result, err := sum()
+	if err != nil {
+		write(simulationProgress{Rolled: total.Rolled, Sum: total.Sum, Error: err.Error()})
+		return err
+	}
+	write(simulationProgress{Rolled: n, Sum: result, Done: true})
+	return nil
+}
@@ -0,0 +1,52 @@
+package dice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/clock"
+	"oteldemo/config"
+)
+
+// timeout returns middleware that cancels the request context after the
+// timeout configured for the route. If the handler fails because of the
+// timeout, a "timeout" span event is recorded and 504 Gateway Timeout is
+// returned.
+//
+// Handlers must respect context cancellation for this to take effect.
+// The deadline is set on the request context, so it follows the wall
+// clock as context deadlines do; clk only measures the elapsed time
+// recorded with the span event.
+func timeout(cfg *config.Config, clk clock.Clock) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			timeout := cfg.RequestTimeout
+			if routeTimeout, ok := cfg.RouteTimeouts[c.Path()]; ok {
+				timeout = routeTimeout
+			}
+			if timeout <= 0 {
+				return next(c)
+			}
+
+			req := c.Request()
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+			c.SetRequest(req.WithContext(ctx))
+			start := clk.Now()
+			err := next(c)
+			if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) || c.Response().Committed {
+				return err
+			}
+			trace.SpanFromContext(ctx).AddEvent("timeout", trace.WithAttributes(
+				attribute.String("timeout", timeout.String()),
+				attribute.String("elapsed", clock.Since(clk, start).String()),
+			))
+			return echo.NewHTTPError(http.StatusGatewayTimeout, "request timed out").SetInternal(err)
+		}
+	}
+}
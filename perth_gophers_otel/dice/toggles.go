@@ -0,0 +1,82 @@
+package dice
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/clock"
+	"oteldemo/toggle"
+)
+
+var (
+	dieEvents = toggle.New("die-events", "record a span event for each die rolled", false)
+	accessLog = toggle.New("access-log", "log each request, with its trace ID", false)
+)
+
+// toggleState is the representation of a toggle in the admin API.
+type toggleState struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// addToggleRoutes adds routes to r for listing instrumentation toggles
+// (GET /toggles), and turning them on or off (PUT /toggles/:name with
+// a body like {"enabled": true}).
+func addToggleRoutes(r *echo.Echo) {
+	r.GET("/toggles", func(c echo.Context) error {
+		var states []toggleState
+		for _, t := range toggle.All() {
+			states = append(states, toggleState{t.Name(), t.Description(), t.Enabled()})
+		}
+		return c.JSON(http.StatusOK, states)
+	})
+	r.PUT("/toggles/:name", func(c echo.Context) error {
+		t, ok := toggle.Lookup(c.Param("name"))
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotFound, "no such toggle")
+		}
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return err
+		}
+		if body.Enabled == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, `expected {"enabled": true|false}`)
+		}
+		t.Set(*body.Enabled)
+		log.Printf("toggle %q enabled: %t", t.Name(), *body.Enabled)
+		return c.JSON(http.StatusOK, toggleState{t.Name(), t.Description(), t.Enabled()})
+	})
+}
+
+// logAccess returns middleware that logs requests, with their trace IDs
+// and durations measured with clk, when the access-log toggle is on.
+func logAccess(clk clock.Clock) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !accessLog.Enabled() || skipTelemetry(c) {
+				return next(c)
+			}
+			start := clk.Now()
+			err := next(c)
+			if err != nil {
+				// Handle the error now to log the final status,
+				// as echo's logger middleware does. The error
+				// handler ignores the later calls for err.
+				c.Error(err)
+			}
+			req := c.Request()
+			log.Printf("%s %s %s %d %s trace_id=%s",
+				c.RealIP(), req.Method, req.URL.RequestURI(),
+				c.Response().Status, clock.Since(clk, start),
+				trace.SpanContextFromContext(req.Context()).TraceID(),
+			)
+			return err
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package dice
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// uiHTML is the web UI, which rolls dice from the browser, starting the
+// trace of each roll there with a traceparent header, so that traces
+// begin with the user's click rather than at the server.
+//
+//go:embed ui/index.html
+var uiHTML []byte
+
+// ui handles GET /, serving the web UI.
+func (s *Server) ui(c echo.Context) error {
+	return c.HTMLBlob(http.StatusOK, uiHTML)
+}
@@ -0,0 +1,43 @@
+package dice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUI(t *testing.T) {
+	s := newTestServer(t, nil)
+	rec := s.get("/")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "traceparent") {
+		t.Errorf("UI does not send traceparent headers")
+	}
+}
+
+// TestRollFromBrowser checks that a roll continues the
+// trace started by the web UI's traceparent header.
+func TestRollFromBrowser(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	s := newTestServer(t, nil)
+	req := httptest.NewRequest(http.MethodGet, "/roll/2d6", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	span := s.ExpectSpan(rollSpan).Span()
+	if got := span.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("got trace ID %s, want the browser's %s", got, traceID)
+	}
+	if got := span.Parent().SpanID().String(); got != spanID {
+		t.Errorf("got parent span ID %s, want the browser's %s", got, spanID)
+	}
+}
@@ -0,0 +1,276 @@
+package dice
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v3"
+)
+
+// validationFieldKey is the metric attribute recording the JSON Pointer
+// of a request body field that failed validation.
+const validationFieldKey = attribute.Key("validation.field")
+
+// openAPIDoc is the server's OpenAPI document, whose request body
+// schemas are used to validate request bodies.
+//
+//go:embed openapi.yaml
+var openAPIDoc []byte
+
+// schema is the subset of JSON Schema used in openapi.yaml.
+type schema struct {
+	Type       string            `yaml:"type"`
+	Pattern    string            `yaml:"pattern"`
+	MinLength  *int              `yaml:"minLength"`
+	MaxLength  *int              `yaml:"maxLength"`
+	Required   []string          `yaml:"required"`
+	Properties map[string]schema `yaml:"properties"`
+	Items      *schema           `yaml:"items"`
+
+	// pattern is Pattern, compiled by compile.
+	pattern *regexp.Regexp
+}
+
+// compile compiles the patterns of s and its subschemas,
+// returning an error if any is invalid.
+func (s *schema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		s.pattern = re
+	}
+	for name, prop := range s.Properties {
+		if err := prop.compile(); err != nil {
+			return fmt.Errorf("property %s: %w", name, err)
+		}
+		s.Properties[name] = prop
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(); err != nil {
+			return fmt.Errorf("items: %w", err)
+		}
+	}
+	return nil
+}
+
+// fieldError is a field of a request body that failed validation,
+// identified by a JSON Pointer URI fragment like "#/dice", as in the
+// "errors" extension of RFC 9457's examples.
+type fieldError struct {
+	Detail  string `json:"detail"`
+	Pointer string `json:"pointer"`
+}
+
+func (e fieldError) Error() string {
+	return e.Pointer + ": " + e.Detail
+}
+
+// validationError is returned for request bodies that fail validation,
+// responding 400 Bad Request with the fields that failed.
+type validationError struct {
+	fields []fieldError
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		msgs[i] = f.Error()
+	}
+	return "invalid request body: " + strings.Join(msgs, "; ")
+}
+
+// validate checks that the value v, decoded from JSON, matches the
+// schema, returning the fields that do not, under the given pointer.
+func (s schema) validate(pointer string, v any) []fieldError {
+	invalid := func(format string, args ...any) []fieldError {
+		return []fieldError{{Pointer: pointer, Detail: fmt.Sprintf(format, args...)}}
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return invalid("must be an object")
+		}
+		var errs []fieldError
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, fieldError{Pointer: pointer + "/" + escapePointer(name), Detail: "is required"})
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		// Report fields in a stable order.
+		slices.Sort(names)
+		for _, name := range names {
+			if value, ok := obj[name]; ok {
+				errs = append(errs, s.Properties[name].validate(pointer+"/"+escapePointer(name), value)...)
+			}
+		}
+		return errs
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return invalid("must be an array")
+		}
+		if s.Items == nil {
+			return nil
+		}
+		var errs []fieldError
+		for i, item := range arr {
+			errs = append(errs, s.Items.validate(fmt.Sprintf("%s/%d", pointer, i), item)...)
+		}
+		return errs
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return invalid("must be a string")
+		}
+		if n := utf8.RuneCountInString(str); s.MinLength != nil && n < *s.MinLength {
+			return invalid("must be at least %d characters", *s.MinLength)
+		} else if s.MaxLength != nil && n > *s.MaxLength {
+			return invalid("must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			return invalid("must match pattern %q", s.Pattern)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return invalid("must be a number")
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			return invalid("must be an integer")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return invalid("must be a boolean")
+		}
+	case "":
+	default:
+		return invalid("has unsupported schema type %q", s.Type)
+	}
+	return nil
+}
+
+// escapePointer escapes a JSON Pointer reference token, per RFC 6901.
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// requestSchemas are the JSON request body schemas of the operations
+// in openapi.yaml, keyed by method and echo route path, like
+// "POST /rolls/:id".
+type requestSchemas map[string]schema
+
+// loadRequestSchemas loads the request body schemas from openapi.yaml,
+// returning an error if a schema's pattern is invalid.
+func loadRequestSchemas() (requestSchemas, error) {
+	return parseRequestSchemas(openAPIDoc)
+}
+
+// parseRequestSchemas parses the request body schemas of an OpenAPI document.
+func parseRequestSchemas(data []byte) (requestSchemas, error) {
+	var doc struct {
+		Paths map[string]map[string]struct {
+			RequestBody struct {
+				Content map[string]struct {
+					Schema schema `yaml:"schema"`
+				} `yaml:"content"`
+			} `yaml:"requestBody"`
+		} `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing openapi.yaml: %w", err)
+	}
+	schemas := make(requestSchemas)
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			content, ok := op.RequestBody.Content[echo.MIMEApplicationJSON]
+			if !ok {
+				continue
+			}
+			route := strings.ToUpper(method) + " " + echoPath(path)
+			if err := content.Schema.compile(); err != nil {
+				return nil, fmt.Errorf("request body schema of %s: %w", route, err)
+			}
+			schemas[route] = content.Schema
+		}
+	}
+	return schemas, nil
+}
+
+// echoPath converts an OpenAPI path template to an echo
+// route path, e.g. /roll/{dice} to /roll/:dice.
+func echoPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if name, ok := strings.CutPrefix(part, "{"); ok {
+			parts[i] = ":" + strings.TrimSuffix(name, "}")
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// validateBody is middleware that validates the JSON bodies of requests
+// against the route's request body schema in openapi.yaml, so handlers
+// bind only well-formed bodies. Invalid bodies are rejected with the
+// fields that failed in the problem response, and the failures counted
+// by s.validationFailures, by route and field.
+func (s *Server) validateBody(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		schema, ok := s.requestSchemas[c.Request().Method+" "+c.Path()]
+		if !ok {
+			return next(c)
+		}
+		req := c.Request()
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(data))
+
+		var body any
+		var fields []fieldError
+		if err := json.Unmarshal(data, &body); err != nil {
+			fields = []fieldError{{Pointer: "#", Detail: "must be JSON"}}
+		} else {
+			fields = schema.validate("#", body)
+		}
+		if len(fields) == 0 {
+			return next(c)
+		}
+		ctx := req.Context()
+		for _, f := range fields {
+			s.validationFailures.Add(ctx, 1, s.validationAttrs.Option(validationFailure{route: c.Path(), field: f.Pointer}))
+		}
+		trace.SpanFromContext(ctx).AddEvent("request body invalid", trace.WithAttributes(
+			attribute.Int("validation.errors", len(fields)),
+		))
+		return &validationError{fields: fields}
+	}
+}
+
+// validationFailure is the route and field of a validation failure,
+// by which the failures are counted.
+type validationFailure struct {
+	route, field string
+}
+
+func (f validationFailure) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{semconv.HTTPRoute(f.route), validationFieldKey.String(f.field)}
+}
@@ -0,0 +1,97 @@
+package dice
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestValidateBody(t *testing.T) {
+	s := newHistoryTestServer(t)
+	for name, test := range map[string]struct {
+		target string
+		body   string
+		fields []fieldError
+	}{
+		"not JSON": {
+			target: "/rolls",
+			body:   "2d6",
+			fields: []fieldError{{Pointer: "#", Detail: "must be JSON"}},
+		},
+		"not an object": {
+			target: "/rolls",
+			body:   `"2d6"`,
+			fields: []fieldError{{Pointer: "#", Detail: "must be an object"}},
+		},
+		"missing field": {
+			target: "/rolls",
+			body:   `{}`,
+			fields: []fieldError{{Pointer: "#/dice", Detail: "is required"}},
+		},
+		"wrong type": {
+			target: "/rolls",
+			body:   `{"dice": 2}`,
+			fields: []fieldError{{Pointer: "#/dice", Detail: "must be a string"}},
+		},
+		"too long": {
+			target: "/rolls/abc",
+			body:   `{"client_seed": "` + strings.Repeat("🎲", 257) + `"}`,
+			fields: []fieldError{{Pointer: "#/client_seed", Detail: "must be at most 256 characters"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := s.post(test.target, test.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+			var p problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.Errors, test.fields) {
+				t.Errorf("got errors %+v, want %+v", p.Errors, test.fields)
+			}
+		})
+	}
+
+	s.ExpectMetric("validation_failures").
+		WithAttr(attribute.String("http.route", "/rolls"), validationFieldKey.String("#/dice")).
+		Sum(2)
+	s.ExpectMetric("validation_failures").
+		WithAttr(attribute.String("http.route", "/rolls/:id"), validationFieldKey.String("#/client_seed")).
+		Sum(1)
+	s.ExpectSpan("/rolls").WithEvent("request body invalid")
+}
+
+func TestValidateBodyValid(t *testing.T) {
+	s := newHistoryTestServer(t)
+	// The body is restored for the handler to bind.
+	if rec := s.post("/rolls", `{"dice": "2d6"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	s.ExpectNoMetric("validation_failures")
+}
+
+func TestRequestSchemaInvalidPattern(t *testing.T) {
+	_, err := parseRequestSchemas([]byte(`
+paths:
+  /rolls:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                dice:
+                  type: string
+                  pattern: "^[0-9]+d([0-9]+$"
+`))
+	if want := "request body schema of POST /rolls: property dice: invalid pattern"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want one containing %q", err, want)
+	}
+}
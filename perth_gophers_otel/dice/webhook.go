@@ -0,0 +1,396 @@
+package dice
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/attrset"
+	"oteldemo/breaker"
+	"oteldemo/clock"
+	"oteldemo/store"
+)
+
+const (
+	// maxWebhookDeliveries is the maximum number of webhook
+	// deliveries in flight; further rolls are not delivered.
+	maxWebhookDeliveries = 16
+
+	// webhookAttempts is the number of attempts made to deliver a roll.
+	webhookAttempts = 3
+
+	// webhookBackoff is the delay before retrying a failed delivery,
+	// doubled for each further attempt.
+	webhookBackoff = time.Second
+)
+
+// webhookSignatureHeader is the header of webhook requests holding
+// "sha256=" and the hex-encoded HMAC-SHA256 of the body, keyed by the
+// secret returned when the webhook was registered.
+const webhookSignatureHeader = "X-Dice-Signature"
+
+// Attribute keys of webhook spans and metrics.
+const (
+	webhookSessionKey  = attribute.Key("webhook.session")
+	webhookAttemptsKey = attribute.Key("webhook.attempts")
+	webhookOutcomeKey  = attribute.Key("webhook.outcome")
+)
+
+// webhookRegistration is the response to POST /sessions/:id/webhook.
+type webhookRegistration struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// webhookRoll is the body of a webhook request, delivering a roll.
+type webhookRoll struct {
+	Session string    `json:"session"`
+	N       int64     `json:"n"`
+	Sides   int64     `json:"sides"`
+	Sum     int64     `json:"sum"`
+	Time    time.Time `json:"time"`
+}
+
+// errWebhookAddr is returned for webhook requests dialling an address
+// rejected by checkWebhookAddr.
+var errWebhookAddr = errors.New("webhook address is loopback, private, link-local or unspecified")
+
+// checkWebhookAddr returns errWebhookAddr if webhook requests must not
+// be made to addr: loopback, private, link-local and unspecified
+// addresses, which would let clients reach services on the server's
+// host or network.
+func checkWebhookAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return errWebhookAddr
+	}
+	return nil
+}
+
+// webhooks delivers rolls made with a session to the session's webhook,
+// with an otelhttp-instrumented client. Deliveries run in the background
+// after the roll has been served, each in its own trace linked to the
+// roll's, and failed attempts are retried with exponential backoff. The
+// outcomes are counted by deliveries.
+//
+// Deliveries share a circuit breaker, counting failures that would be
+// retried, so while webhooks are failing, as when the network is down,
+// attempts fail fast rather than holding delivery slots until they time
+// out.
+//
+// The client checks each address it dials with checkAddr, after DNS
+// has been resolved and for each redirect, so webhooks can't reach
+// addresses rejected by checkWebhookAddr by way of a hostname.
+type webhooks struct {
+	client    *http.Client
+	timeout   time.Duration
+	backoff   time.Duration
+	checkAddr func(netip.Addr) error
+	breaker   *breaker.Breaker
+
+	slots    chan struct{}
+	inFlight sync.WaitGroup
+	done     chan struct{}
+
+	deliveries metric.Int64Counter
+	outcomes   *attrset.Cache[string]
+}
+
+func newWebhooks(
+	timeout time.Duration, clk clock.Clock, meter metric.Meter,
+	tp trace.TracerProvider, mp metric.MeterProvider, propagators propagation.TextMapPropagator,
+) (*webhooks, error) {
+	deliveries, err := meter.Int64Counter(
+		"webhook.deliveries",
+		metric.WithDescription("Rolls delivered to webhooks, by outcome"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	b, err := breaker.New("webhook", breaker.WithClock(clk), breaker.WithMeterProvider(mp))
+	if err != nil {
+		return nil, err
+	}
+	w := &webhooks{
+		timeout:    timeout,
+		backoff:    webhookBackoff,
+		checkAddr:  checkWebhookAddr,
+		breaker:    b,
+		slots:      make(chan struct{}, maxWebhookDeliveries),
+		done:       make(chan struct{}),
+		deliveries: deliveries,
+		// Deliveries are delivered, failed or dropped.
+		outcomes: attrset.New(3, func(outcome string) []attribute.KeyValue {
+			return []attribute.KeyValue{webhookOutcomeKey.String(outcome)}
+		}),
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   w.controlDial,
+	}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	// Webhooks are dialled directly, so their addresses are checked
+	// rather than a proxy's.
+	base.Proxy = nil
+	base.DialContext = dialer.DialContext
+	w.client = &http.Client{Transport: otelhttp.NewTransport(base,
+		otelhttp.WithTracerProvider(tp),
+		otelhttp.WithMeterProvider(mp),
+		otelhttp.WithPropagators(propagators),
+	)}
+	return w, nil
+}
+
+// controlDial is the net.Dialer Control function of the webhook client,
+// failing connections to addresses rejected by checkAddr.
+func (w *webhooks) controlDial(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	return w.checkAddr(addrPort.Addr())
+}
+
+// registerWebhook handles POST /sessions/:id/webhook, with a body like
+// {"url": "https://example.com/rolls"}, registering the URL to which
+// the session's rolls are delivered. The response includes a new secret
+// with which deliveries are signed, replacing any registered before.
+func (s *Server) registerWebhook(c echo.Context) error {
+	if s.store == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "roll history is not enabled")
+	}
+	id := c.Param("id")
+	if !validSessionID(id) {
+		return errInvalidSession
+	}
+	var body struct {
+		URL string `json:"url"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return err
+	}
+	if u, err := url.Parse(body.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "url must be an absolute http or https URL")
+	} else if addr, err := netip.ParseAddr(u.Hostname()); err == nil && s.webhooks.checkAddr(addr) != nil {
+		// Hostnames are checked when dialled, but rejecting
+		// addresses now reports the mistake to the client.
+		return echo.NewHTTPError(http.StatusBadRequest, "url must not refer to a private address")
+	}
+	var secretBytes [32]byte
+	if _, err := rand.Read(secretBytes[:]); err != nil {
+		return err
+	}
+	secret := hex.EncodeToString(secretBytes[:])
+	err := s.store.SetWebhook(c.Request().Context(), store.Webhook{
+		Session:    id,
+		URL:        body.URL,
+		Secret:     []byte(secret),
+		Registered: s.clock.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, webhookRegistration{URL: body.URL, Secret: secret})
+}
+
+// notifyWebhook delivers a roll made with a session to its webhook, if
+// it has one, in the background. Failing to look up the webhook doesn't
+// fail the roll, but is recorded on its span.
+func (s *Server) notifyWebhook(ctx context.Context, session string, roll store.Roll) {
+	hook, err := s.store.Webhook(ctx, session)
+	if errors.Is(err, store.ErrWebhookNotFound) {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	if err != nil {
+		span.AddEvent("webhook lookup failed", trace.WithAttributes(semconv.ExceptionMessage(err.Error())))
+		return
+	}
+	body, err := json.Marshal(webhookRoll{
+		Session: session,
+		N:       roll.N, Sides: roll.Sides, Sum: roll.Sum,
+		Time: roll.Time,
+	})
+	if err != nil {
+		span.AddEvent("webhook encoding failed", trace.WithAttributes(semconv.ExceptionMessage(err.Error())))
+		return
+	}
+	w := s.webhooks
+	select {
+	case w.slots <- struct{}{}:
+	default:
+		w.deliveries.Add(ctx, 1, w.outcomes.Option("dropped"))
+		return
+	}
+	link := trace.LinkFromContext(ctx)
+	w.inFlight.Add(1)
+	go func() {
+		defer w.inFlight.Done()
+		defer func() { <-w.slots }()
+		s.deliverWebhook(link, hook, body)
+	}()
+}
+
+// deliverWebhook delivers a roll to a webhook in a new root span, linked
+// to the span of the roll, retrying failed attempts until webhookAttempts
+// have been made or the server shuts down.
+func (s *Server) deliverWebhook(link trace.Link, hook store.Webhook, body []byte) {
+	w := s.webhooks
+	ctx, span := s.tracer.Start(context.Background(), "webhook deliver",
+		trace.WithLinks(link),
+		trace.WithAttributes(webhookSessionKey.String(hook.Session)),
+	)
+	defer span.End()
+
+	var err error
+	var attempt int
+	for attempt = 1; ; attempt++ {
+		err = w.attempt(ctx, hook, body)
+		if err == nil || !retryable(err) || attempt == webhookAttempts {
+			break
+		}
+		addEvent(span, "webhook attempt failed",
+			webhookAttemptsKey.Int(attempt), semconv.ExceptionMessage(err.Error()),
+		)
+		if !w.sleep(s.clock, w.backoff<<(attempt-1)) {
+			break
+		}
+	}
+	outcome := "delivered"
+	if err != nil {
+		outcome = "failed"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(webhookAttemptsKey.Int(attempt), webhookOutcomeKey.String(outcome))
+	w.deliveries.Add(ctx, 1, w.outcomes.Option(outcome))
+}
+
+// attempt makes an attempt to deliver a webhook request through the
+// circuit breaker. Failures that aren't retried, such as the webhook
+// rejecting the request, say nothing of the network or webhooks in
+// general, so the breaker records them as successes.
+func (w *webhooks) attempt(ctx context.Context, hook store.Webhook, body []byte) error {
+	var err error
+	breakerErr := w.breaker.Do(ctx, func(ctx context.Context) error {
+		err = w.post(ctx, hook, body)
+		if err != nil && retryable(err) {
+			return err
+		}
+		return nil
+	})
+	if errors.Is(breakerErr, breaker.ErrOpen) {
+		return breakerErr
+	}
+	return err
+}
+
+// post makes an attempt to deliver a webhook request, signed with the
+// webhook's secret.
+func (w *webhooks) post(ctx context.Context, hook store.Webhook, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", echo.MIMEApplicationJSON)
+	req.Header.Set(webhookSignatureHeader, signWebhook(hook.Secret, body))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body, so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookStatusError{code: resp.StatusCode}
+	}
+	return nil
+}
+
+// sleep waits for d to pass on clk before retrying a delivery,
+// returning false if the server is shutting down.
+func (w *webhooks) sleep(clk clock.Clock, d time.Duration) bool {
+	select {
+	case <-w.done:
+		return false
+	case <-clk.After(d):
+		return true
+	}
+}
+
+// close stops retrying failed deliveries, and waits until those in
+// flight have finished or ctx is done.
+func (w *webhooks) close(ctx context.Context) error {
+	close(w.done)
+	finished := make(chan struct{})
+	go func() {
+		w.inFlight.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for webhook deliveries: %w", ctx.Err())
+	}
+}
+
+// webhookStatusError is returned for webhook requests
+// responding with a status other than 2xx.
+type webhookStatusError struct {
+	code int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook responded with %d %s", e.code, http.StatusText(e.code))
+}
+
+// retryable reports whether a failed webhook delivery should be
+// retried: those that failed to connect or time out, other than to
+// rejected addresses, or whose webhooks responded with 429 Too Many
+// Requests or a server error.
+func retryable(err error) bool {
+	if errors.Is(err, errWebhookAddr) {
+		return false
+	}
+	var statusErr *webhookStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	return statusErr.code == http.StatusTooManyRequests || statusErr.code >= 500
+}
+
+// signWebhook returns the value of the webhookSignatureHeader
+// for a webhook request's body.
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
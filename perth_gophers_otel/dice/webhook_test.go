@@ -0,0 +1,202 @@
+package dice
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// webhookRequest is a request received by a test webhook.
+type webhookRequest struct {
+	body      []byte
+	signature string
+}
+
+// newTestWebhook starts a webhook responding with the given statuses in
+// turn, and then 200 OK, sending the requests it receives to the
+// returned channel.
+func newTestWebhook(t *testing.T, statuses ...int) (string, <-chan webhookRequest) {
+	t.Helper()
+	requests := make(chan webhookRequest, webhookAttempts)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- webhookRequest{body: body, signature: r.Header.Get(webhookSignatureHeader)}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, requests
+}
+
+// registerTestWebhook registers url as alice's webhook, returning its
+// secret. Webhooks may then be delivered to any address, such as the
+// loopback addresses of test webhooks.
+func registerTestWebhook(t *testing.T, s *testServer, url string) string {
+	t.Helper()
+	s.webhooks.checkAddr = func(netip.Addr) error { return nil }
+	rec := s.post("/sessions/alice/webhook", `{"url": "`+url+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var reg webhookRegistration
+	if err := json.Unmarshal(rec.Body.Bytes(), &reg); err != nil {
+		t.Fatal(err)
+	}
+	return reg.Secret
+}
+
+func TestWebhook(t *testing.T) {
+	s := newHistoryTestServer(t)
+	s.webhooks.backoff = time.Millisecond
+	url, requests := newTestWebhook(t, http.StatusServiceUnavailable)
+	secret := registerTestWebhook(t, s, url)
+	if rec := s.get("/roll/2d6?session=alice"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	// Rolls without the session aren't delivered.
+	if rec := s.get("/roll/2d6?session=bob"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	// Closing stops retries, so wait for the retry before closing.
+	var req webhookRequest
+	for range 2 {
+		select {
+		case req = <-requests:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for webhook requests")
+		}
+	}
+	if err := s.webhooks.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := signWebhook([]byte(secret), req.body); req.signature != want {
+		t.Errorf("got signature %q, want %q", req.signature, want)
+	}
+	var roll webhookRoll
+	if err := json.Unmarshal(req.body, &roll); err != nil {
+		t.Fatal(err)
+	}
+	if roll.Session != "alice" || roll.N != 2 || roll.Sides != 6 || roll.Sum < 2 || roll.Sum > 12 {
+		t.Errorf("got %+v, want alice's roll of 2d6", roll)
+	}
+
+	// The delivery is traced separately, linked to the roll.
+	delivery := s.ExpectSpan("webhook deliver").
+		WithAttr(webhookAttemptsKey.Int(2), webhookOutcomeKey.String("delivered")).
+		WithEvents("webhook attempt failed", 1).
+		Span()
+	var rolled trace.SpanContext
+	for _, span := range s.Spans() {
+		if span.Name() == rollSpan {
+			rolled = span.SpanContext()
+			break
+		}
+	}
+	if links := delivery.Links(); len(links) != 1 || links[0].SpanContext.TraceID() != rolled.TraceID() {
+		t.Errorf("got links %+v, want a link to the roll", links)
+	}
+	var attempts int
+	for _, span := range s.Spans() {
+		if span.Parent().SpanID() == delivery.SpanContext().SpanID() && span.SpanKind() == trace.SpanKindClient {
+			attempts++
+		}
+	}
+	if attempts != 2 {
+		t.Errorf("got %d client spans beneath the delivery, want 2", attempts)
+	}
+	s.ExpectMetric("webhook.deliveries").WithAttr(webhookOutcomeKey.String("delivered")).Sum(1)
+}
+
+func TestWebhookRejected(t *testing.T) {
+	s := newHistoryTestServer(t)
+	url, requests := newTestWebhook(t, http.StatusBadRequest)
+	registerTestWebhook(t, s, url)
+	if rec := s.get("/roll/2d6?session=alice"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if err := s.webhooks.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Client errors aren't retried.
+	if n := len(requests); n != 1 {
+		t.Errorf("got %d webhook requests, want 1", n)
+	}
+	s.ExpectSpan("webhook deliver").
+		WithAttr(webhookAttemptsKey.Int(1), webhookOutcomeKey.String("failed")).
+		WithStatus(codes.Error)
+	s.ExpectMetric("webhook.deliveries").WithAttr(webhookOutcomeKey.String("failed")).Sum(1)
+}
+
+func TestWebhookPrivateAddress(t *testing.T) {
+	s := newHistoryTestServer(t)
+	s.webhooks.backoff = time.Millisecond
+	for _, url := range []string{
+		"http://127.0.0.1:8081/debug/config",
+		"http://[::1]/rolls",
+		"http://10.0.0.1/rolls",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/rolls",
+	} {
+		rec := s.post("/sessions/alice/webhook", `{"url": "`+url+`"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("registering %s: got status %d, want %d: %s", url, rec.Code, http.StatusBadRequest, rec.Body)
+		}
+	}
+
+	// Hostnames are checked when dialled, so a hostname
+	// resolving to a loopback address isn't delivered to.
+	url, requests := newTestWebhook(t)
+	url = strings.Replace(url, "127.0.0.1", "localhost", 1)
+	rec := s.post("/sessions/alice/webhook", `{"url": "`+url+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if rec := s.get("/roll/2d6?session=alice"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if err := s.webhooks.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(requests); n != 0 {
+		t.Errorf("got %d webhook requests, want none", n)
+	}
+	// Rejected addresses aren't retried.
+	s.ExpectSpan("webhook deliver").
+		WithAttr(webhookAttemptsKey.Int(1), webhookOutcomeKey.String("failed")).
+		WithStatus(codes.Error)
+}
+
+func TestCheckWebhookAddr(t *testing.T) {
+	for addr, want := range map[string]error{
+		"93.184.215.14":       nil,
+		"2606:2800:21f::1":    nil,
+		"127.0.0.1":           errWebhookAddr,
+		"::1":                 errWebhookAddr,
+		"::ffff:127.0.0.1":    errWebhookAddr,
+		"10.1.2.3":            errWebhookAddr,
+		"172.16.0.1":          errWebhookAddr,
+		"192.168.1.1":         errWebhookAddr,
+		"fd00::1":             errWebhookAddr,
+		"169.254.169.254":     errWebhookAddr,
+		"fe80::1":             errWebhookAddr,
+		"0.0.0.0":             errWebhookAddr,
+		"::":                  errWebhookAddr,
+		"::ffff:192.168.0.10": errWebhookAddr,
+	} {
+		if got := checkWebhookAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("checkWebhookAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
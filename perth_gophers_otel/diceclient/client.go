@@ -0,0 +1,458 @@
+// Package diceclient provides a client for the dice server's HTTP API,
+// instrumented with OpenTelemetry.
+//
+// Requests are traced with otelhttp, propagating trace context to the
+// server, and failed requests are retried with exponential backoff when
+// the failure may be transient: network errors, 429 and 5xx responses.
+// Each attempt is a separate client span.
+//
+// Alternatively, rolls may be requested over NATS request-reply, as
+// described by package natsroll, with WithNATS.
+package diceclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/clock"
+	"oteldemo/fair"
+	"oteldemo/natsroll"
+)
+
+// instrumentationName identifies the client's instrumentation scope.
+const instrumentationName = "oteldemo/diceclient"
+
+// Client is a client for the dice server.
+type Client struct {
+	baseURL     string
+	http        *http.Client
+	nats        *nats.Conn
+	timeout     time.Duration
+	tracer      trace.Tracer
+	propagators propagation.TextMapPropagator
+	maxRetries  int
+	backoff     time.Duration
+	clock       clock.Clock
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+type options struct {
+	transport      http.RoundTripper
+	timeout        time.Duration
+	tracerProvider trace.TracerProvider
+	propagators    propagation.TextMapPropagator
+	maxRetries     int
+	backoff        time.Duration
+	clock          clock.Clock
+	nats           *nats.Conn
+}
+
+// WithTransport sets the transport used to send requests, which is
+// wrapped with otelhttp instrumentation. The default is
+// http.DefaultTransport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *options) { o.transport = rt }
+}
+
+// WithTimeout sets the timeout for each attempt at a request. The
+// default is 10 seconds; the overall time, including retries, is
+// bounded by the context passed to each method.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithTracerProvider sets the TracerProvider used by the client. If
+// unspecified, the global TracerProvider is used.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) { o.tracerProvider = tp }
+}
+
+// WithPropagators sets the propagators used to inject trace context into
+// requests. If unspecified, the global TextMapPropagator is used.
+func WithPropagators(p propagation.TextMapPropagator) Option {
+	return func(o *options) { o.propagators = p }
+}
+
+// WithRetries sets the maximum number of times a failed request is
+// retried, and the backoff before the first retry, which doubles for
+// each subsequent retry. The default is 2 retries, with 100ms backoff.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.maxRetries = n
+		o.backoff = backoff
+	}
+}
+
+// WithClock sets the clock used to wait between retries.
+// If unspecified, clock.Real is used.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithNATS makes the client request rolls over NATS request-reply,
+// on the connection nc, rather than over HTTP. Other requests are
+// still made over HTTP.
+func WithNATS(nc *nats.Conn) Option {
+	return func(o *options) { o.nats = nc }
+}
+
+// New returns a Client for the dice server at baseURL,
+// e.g. "http://localhost:8080".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: expected http or https", baseURL)
+	}
+	o := options{
+		transport:  http.DefaultTransport,
+		timeout:    10 * time.Second,
+		maxRetries: 2,
+		backoff:    100 * time.Millisecond,
+		clock:      clock.Real,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.tracerProvider == nil {
+		o.tracerProvider = otel.GetTracerProvider()
+	}
+	if o.propagators == nil {
+		o.propagators = otel.GetTextMapPropagator()
+	}
+	if o.maxRetries < 0 {
+		return nil, errors.New("retries must be non-negative")
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http: &http.Client{
+			Timeout: o.timeout,
+			Transport: otelhttp.NewTransport(o.transport,
+				otelhttp.WithTracerProvider(o.tracerProvider),
+				otelhttp.WithPropagators(o.propagators),
+			),
+		},
+		nats:        o.nats,
+		timeout:     o.timeout,
+		tracer:      o.tracerProvider.Tracer(instrumentationName),
+		propagators: o.propagators,
+		maxRetries:  o.maxRetries,
+		backoff:     o.backoff,
+		clock:       o.clock,
+	}, nil
+}
+
+// Error is returned for error responses from the server, which
+// are described by RFC 9457 problem details.
+type Error struct {
+	StatusCode int
+	Title      string
+	Detail     string
+
+	// TraceID is the ID of the server's trace for the request,
+	// if it was sampled.
+	TraceID string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s", e.StatusCode, e.Title)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// temporary reports whether the error may not recur if retried.
+func (e *Error) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Roll rolls dice given in RPG dice notation (e.g. 2d20),
+// returning the sum.
+func (c *Client) Roll(ctx context.Context, dice string) (int64, error) {
+	var body []byte
+	var err error
+	if c.nats != nil {
+		body, err = c.retry(ctx, func() ([]byte, error) { return c.requestNATS(ctx, dice) })
+	} else {
+		body, err = c.get(ctx, "/roll/"+url.PathEscape(dice))
+	}
+	if err != nil {
+		return 0, err
+	}
+	sum, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid roll response: %w", err)
+	}
+	return sum, nil
+}
+
+// Simulate rolls dice n times, returning the number of times each sum
+// was rolled. The simulation runs client-side, making n requests as for
+// Roll, in a "simulate" span so they are grouped in a single trace; it
+// does not use GET /simulate/:dice, which sums a single roll of many dice.
+func (c *Client) Simulate(ctx context.Context, dice string, n int) (map[int64]int, error) {
+	ctx, span := c.tracer.Start(ctx, "simulate", trace.WithAttributes(
+		attribute.String("dice", dice),
+		attribute.Int("rolls", n),
+	))
+	defer span.End()
+	counts := make(map[int64]int)
+	for range n {
+		sum, err := c.Roll(ctx, dice)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+		counts[sum]++
+	}
+	return counts, nil
+}
+
+// RollFair makes a provably fair roll of dice given in RPG dice notation
+// (e.g. 2d20), with the given client seed, as described by package fair.
+// The server's commitment is obtained before the dice are rolled, and
+// the proof is verified against it once the seed is revealed. The
+// requests are made in a "fair roll" span, so they are grouped in a
+// single trace.
+func (c *Client) RollFair(ctx context.Context, dice, clientSeed string) (proof fair.Proof, err error) {
+	ctx, span := c.tracer.Start(ctx, "fair roll", trace.WithAttributes(attribute.String("dice", dice)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	var commitment struct {
+		ID         string `json:"id"`
+		Commitment string `json:"commitment"`
+	}
+	if err := c.post(ctx, "/rolls", map[string]string{"dice": dice}, &commitment); err != nil {
+		return proof, err
+	}
+	span.SetAttributes(attribute.String("fair.roll.id", commitment.ID))
+	var result struct{}
+	path := "/rolls/" + url.PathEscape(commitment.ID)
+	if err := c.post(ctx, path, map[string]string{"client_seed": clientSeed}, &result); err != nil {
+		return proof, err
+	}
+	body, err := c.get(ctx, path+"/proof")
+	if err != nil {
+		return proof, err
+	}
+	if err := json.Unmarshal(body, &proof); err != nil {
+		return proof, fmt.Errorf("invalid proof: %w", err)
+	}
+	if proof.ID != commitment.ID || proof.Commitment != commitment.Commitment {
+		return proof, fmt.Errorf("proof is not for the roll committed to: %w", fair.ErrCommitment)
+	}
+	if proof.ClientSeed != clientSeed {
+		return proof, fmt.Errorf("proof is not for client seed %q: %w", clientSeed, fair.ErrRolls)
+	}
+	return proof, proof.Verify()
+}
+
+// Session is a session of rolls recorded by the server.
+type Session struct {
+	ID       string    `json:"id"`
+	Rolls    int64     `json:"rolls"`
+	Started  time.Time `json:"started"`
+	LastRoll time.Time `json:"last_roll"`
+}
+
+// Roll is a roll made in a session.
+type Roll struct {
+	N     int64     `json:"n"`
+	Sides int64     `json:"sides"`
+	Sum   int64     `json:"sum"`
+	Time  time.Time `json:"time"`
+}
+
+// History is a session, and its most recent rolls.
+type History struct {
+	Session Session `json:"session"`
+	Rolls   []Roll  `json:"rolls"`
+}
+
+// History returns a session and up to limit of its most recent rolls,
+// most recent first. If limit is zero, the server's default is used.
+// The server must be recording roll history.
+func (c *Client) History(ctx context.Context, session string, limit int) (History, error) {
+	var history History
+	path := "/sessions/" + url.PathEscape(session) + "/history"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+	body, err := c.get(ctx, path)
+	if err != nil {
+		return history, err
+	}
+	if err := json.Unmarshal(body, &history); err != nil {
+		return history, fmt.Errorf("invalid history response: %w", err)
+	}
+	return history, nil
+}
+
+// get sends a GET request for path, retrying transient failures,
+// and returns the body of a successful response.
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	u := c.baseURL + path
+	return c.retry(ctx, func() ([]byte, error) { return c.do(ctx, http.MethodGet, u, nil) })
+}
+
+// post sends a POST request for path with v encoded as a JSON body, and
+// decodes the body of a successful response into result. POST requests
+// aren't idempotent, so they aren't retried.
+func (c *Client) post(ctx context.Context, path string, v, result any) error {
+	reqBody, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body, err := c.do(ctx, http.MethodPost, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, result)
+}
+
+// retry makes a request with do, retrying transient failures.
+func (c *Client) retry(ctx context.Context, do func() ([]byte, error)) ([]byte, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		body, err := do()
+		if err == nil || attempt == c.maxRetries || !retryable(ctx, err) {
+			return body, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-c.clock.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// do sends a single request, with an optional JSON body.
+func (c *Client) do(ctx context.Context, method, u string, reqBody []byte) ([]byte, error) {
+	var r io.Reader
+	if reqBody != nil {
+		r = bytes.NewReader(reqBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, responseError(resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// natsSpanOptions are the options of the spans of NATS requests.
+var natsSpanOptions = []trace.SpanStartOption{
+	trace.WithSpanKind(trace.SpanKindProducer),
+	trace.WithAttributes(
+		semconv.MessagingSystemKey.String("nats"),
+		semconv.MessagingOperationPublish,
+		semconv.MessagingDestinationName(natsroll.Subject),
+	),
+}
+
+// requestNATS sends a single roll request over NATS, in a span whose
+// context is propagated in the request headers.
+func (c *Client) requestNATS(ctx context.Context, dice string) (body []byte, err error) {
+	ctx, span := c.tracer.Start(ctx, natsroll.Subject+" publish", natsSpanOptions...)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	msg := nats.NewMsg(natsroll.Subject)
+	msg.Data = []byte(dice)
+	c.propagators.Inject(ctx, natsroll.HeaderCarrier(msg.Header))
+	reply, err := c.nats.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	status, err := strconv.Atoi(reply.Header.Get(natsroll.StatusHeader))
+	if err != nil {
+		return nil, fmt.Errorf("invalid roll reply status: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, responseError(status, reply.Data)
+	}
+	return reply.Data, nil
+}
+
+// responseError returns the Error for an error response.
+func responseError(status int, body []byte) *Error {
+	e := &Error{StatusCode: status, Title: http.StatusText(status)}
+	var p struct {
+		Title   string `json:"title"`
+		Detail  string `json:"detail"`
+		TraceID string `json:"trace_id"`
+	}
+	if json.Unmarshal(body, &p) == nil && p.Title != "" {
+		e.Title, e.Detail, e.TraceID = p.Title, p.Detail, p.TraceID
+	}
+	return e
+}
+
+// retryable reports whether a request that failed with err may succeed
+// if retried: error responses that may be temporary, and network errors
+// other than the context being done.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.temporary()
+	}
+	if errors.Is(err, nats.ErrNoResponders) || errors.Is(err, nats.ErrTimeout) {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
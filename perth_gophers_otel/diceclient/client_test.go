@@ -0,0 +1,139 @@
+package diceclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oteldemo/clock"
+)
+
+// newTestClient returns a Client for a server responding to each request
+// with the next of statuses, and the last once they run out, with
+// body for 200 OK. It also returns the number of requests served.
+func newTestClient(t *testing.T, body string, statuses []int, opts ...Option) (*Client, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(requests.Add(1)) - 1
+		status := statuses[min(i, len(statuses)-1)]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &requests
+}
+
+func TestRollRetriesTransientErrors(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+	c, requests := newTestClient(t, "7\n", statuses, WithClock(clk), WithRetries(2, time.Second))
+
+	type result struct {
+		sum int64
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		sum, err := c.Roll(context.Background(), "2d6")
+		done <- result{sum, err}
+	}()
+	// The backoff doubles for each retry.
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second} {
+		for clk.Waiters() == 0 {
+			runtime.Gosched()
+		}
+		clk.Advance(backoff - time.Nanosecond)
+		if clk.Waiters() == 0 {
+			t.Fatalf("retried before backing off for %s", backoff)
+		}
+		clk.Advance(time.Nanosecond)
+	}
+	if r := <-done; r.err != nil || r.sum != 7 {
+		t.Fatalf("got %d, %v; want 7", r.sum, r.err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+}
+
+func TestRollGivesUpAfterRetries(t *testing.T) {
+	// Without backoff, there's no need to advance the clock.
+	c, requests := newTestClient(t, "", []int{http.StatusBadGateway}, WithRetries(2, 0))
+	_, err := c.Roll(context.Background(), "2d6")
+	var e *Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusBadGateway {
+		t.Fatalf("got error %v, want a 502 Error", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+}
+
+func TestRollDoesNotRetryClientErrors(t *testing.T) {
+	c, requests := newTestClient(t, "", []int{http.StatusBadRequest, http.StatusOK}, WithRetries(2, 0))
+	if _, err := c.Roll(context.Background(), "2x6"); err == nil {
+		t.Fatal("got no error, want the 400 response")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+}
+
+func TestPostDoesNotRetry(t *testing.T) {
+	c, requests := newTestClient(t, "", []int{http.StatusServiceUnavailable, http.StatusOK}, WithRetries(2, 0))
+	if _, err := c.RollFair(context.Background(), "2d6", "lucky"); err == nil {
+		t.Fatal("got no error, want the 503 response")
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+}
+
+func TestErrorProblemDetails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{
+			"type": "about:blank",
+			"title": "Invalid dice",
+			"status": 422,
+			"detail": "at least one die with at least one side is required",
+			"trace_id": "0af7651916cd43dd8448eb211c80319c"
+		}`))
+	}))
+	defer srv.Close()
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Roll(context.Background(), "0d6")
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got error %v, want an *Error", err)
+	}
+	want := Error{
+		StatusCode: http.StatusUnprocessableEntity,
+		Title:      "Invalid dice",
+		Detail:     "at least one die with at least one side is required",
+		TraceID:    "0af7651916cd43dd8448eb211c80319c",
+	}
+	if *e != want {
+		t.Errorf("got %+v, want %+v", *e, want)
+	}
+	if got, want := e.Error(), "422 Invalid dice: at least one die with at least one side is required"; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,304 @@
+// Command dicectl is a command line client for the dice server, for
+// demonstrating client-side instrumentation. Each command runs in its
+// own root span, whose trace ID is printed so the trace can be found
+// in the backend:
+//
+//	dicectl roll 2d6
+//	dicectl simulate -n 1000 3d6
+//	dicectl fair -seed lucky 2d6
+//	dicectl history -n 20 my-session
+//	dicectl stats my-session
+//
+// With -nats-url, rolls are requested over NATS rather than HTTP, from
+// a natsworker serving them.
+//
+// Telemetry is exported as OTLP, configured by -otlp-endpoint or the
+// standard OTEL_EXPORTER_OTLP_* environment variables, or to stderr
+// with -console.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/diceclient"
+)
+
+const usage = `usage: dicectl [flags] <command> [args]
+
+commands:
+  roll <dice>                roll dice, e.g. 2d6, and print the sum
+  simulate [-n N] <dice>     roll dice N times, and print the distribution of sums
+  fair [-seed S] <dice>      make and verify a provably fair roll, and print the rolls
+  history [-n N] <session>   print a session's N most recent rolls
+  stats <session>            summarise a session's recent rolls
+
+flags:
+`
+
+// command is a dicectl subcommand.
+type command func(ctx context.Context, c *diceclient.Client, args []string) error
+
+var commands = map[string]command{
+	"roll":     roll,
+	"simulate": simulate,
+	"fair":     fairRoll,
+	"history":  history,
+	"stats":    stats,
+}
+
+func roll(ctx context.Context, c *diceclient.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: dicectl roll <dice>")
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("dice", args[0]))
+	sum, err := c.Roll(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println(sum)
+	return nil
+}
+
+func simulate(ctx context.Context, c *diceclient.Client, args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	n := fs.Int("n", 100, "number of rolls")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *n < 1 {
+		return errors.New("usage: dicectl simulate [-n N] <dice>")
+	}
+	dice := fs.Arg(0)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("dice", dice),
+		attribute.Int("rolls", *n),
+	)
+	counts, err := c.Simulate(ctx, dice, *n)
+	if err != nil {
+		return err
+	}
+
+	sums := make([]int64, 0, len(counts))
+	most := 0
+	for sum, count := range counts {
+		sums = append(sums, sum)
+		most = max(most, count)
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i] < sums[j] })
+	const width = 50
+	for _, sum := range sums {
+		count := counts[sum]
+		fmt.Printf("%4d %5d %s\n", sum, count, strings.Repeat("#", (count*width+most-1)/most))
+	}
+	return nil
+}
+
+func fairRoll(ctx context.Context, c *diceclient.Client, args []string) error {
+	fs := flag.NewFlagSet("fair", flag.ContinueOnError)
+	seed := fs.String("seed", "", "client seed (defaults to a random seed)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: dicectl fair [-seed S] <dice>")
+	}
+	if *seed == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		*seed = hex.EncodeToString(b)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("dice", fs.Arg(0)))
+	proof, err := c.RollFair(ctx, fs.Arg(0), *seed)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rolls:       %v\n", proof.Rolls)
+	fmt.Printf("sum:         %d\n", proof.Sum)
+	fmt.Printf("commitment:  %s\n", proof.Commitment)
+	fmt.Printf("server seed: %s\n", proof.Seed)
+	fmt.Printf("client seed: %s\n", proof.ClientSeed)
+	fmt.Println("verified")
+	return nil
+}
+
+func history(ctx context.Context, c *diceclient.Client, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	n := fs.Int("n", 0, "number of rolls (defaults to the server's default)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *n < 0 {
+		return errors.New("usage: dicectl history [-n N] <session>")
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("session", fs.Arg(0)))
+	h, err := c.History(ctx, fs.Arg(0), *n)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("session %s: %d rolls since %s\n", h.Session.ID, h.Session.Rolls, h.Session.Started.Format(time.RFC3339))
+	for _, r := range h.Rolls {
+		fmt.Printf("%s %4dd%-4d %6d\n", r.Time.Format(time.RFC3339), r.N, r.Sides, r.Sum)
+	}
+	return nil
+}
+
+// statsRolls is the number of recent rolls summarised by stats,
+// the most the server returns.
+const statsRolls = 100
+
+func stats(ctx context.Context, c *diceclient.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: dicectl stats <session>")
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("session", args[0]))
+	h, err := c.History(ctx, args[0], statsRolls)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("session:     %s\n", h.Session.ID)
+	fmt.Printf("rolls:       %d\n", h.Session.Rolls)
+	fmt.Printf("started:     %s\n", h.Session.Started.Format(time.RFC3339))
+	fmt.Printf("last roll:   %s\n", h.Session.LastRoll.Format(time.RFC3339))
+	if len(h.Rolls) == 0 {
+		return nil
+	}
+	// Summarise the sums relative to the expected sum, as
+	// the recent rolls may be of different dice.
+	var total, expected float64
+	lowest, highest := h.Rolls[0].Sum, h.Rolls[0].Sum
+	for _, r := range h.Rolls {
+		total += float64(r.Sum)
+		expected += float64(r.N) * float64(r.Sides+1) / 2
+		lowest, highest = min(lowest, r.Sum), max(highest, r.Sum)
+	}
+	fmt.Printf("recent:      %d rolls, sums %d to %d, %.1f%% of expected\n",
+		len(h.Rolls), lowest, highest, 100*total/expected)
+	return nil
+}
+
+func initTracerProvider(ctx context.Context, otlpEndpoint string, console bool) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName("dicectl")),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		log.Printf("error detecting resource: %v", err)
+	}
+
+	var exporter sdktrace.SpanExporter
+	if console {
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint(), stdouttrace.WithWriter(os.Stderr))
+	} else {
+		var opts []otlptracegrpc.Option
+		if otlpEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(otlpEndpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider, nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("dicectl: ")
+
+	fs := flag.NewFlagSet("dicectl", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	server := fs.String("server", "http://localhost:8080", "base URL of the dice server")
+	natsURL := fs.String("nats-url", "", "URL of a NATS server over which to request rolls, instead of HTTP")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for the command")
+	otlpEndpoint := fs.String("otlp-endpoint", "", "OTLP endpoint URL (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	console := fs.Bool("console", false, "export spans to stderr instead of OTLP")
+	fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name, args := fs.Arg(0), fs.Args()[1:]
+	cmd, ok := commands[name]
+	if !ok {
+		log.Printf("unknown command %q", name)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	tracerProvider, err := initTracerProvider(context.Background(), *otlpEndpoint, *console)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var clientOpts []diceclient.Option
+	if *natsURL != "" {
+		nc, err := nats.Connect(*natsURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer nc.Close()
+		clientOpts = append(clientOpts, diceclient.WithNATS(nc))
+	}
+	c, err := diceclient.New(*server, clientOpts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	ctx, span := otel.Tracer("oteldemo/dicectl").Start(ctx, "dicectl "+name)
+	cmdErr := cmd(ctx, c, args)
+	if cmdErr != nil {
+		span.RecordError(cmdErr)
+		span.SetStatus(codes.Error, cmdErr.Error())
+	}
+	span.End()
+	cancel()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(flushCtx); err != nil {
+		log.Printf("error flushing telemetry: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "trace_id: %s\n", span.SpanContext().TraceID())
+	if cmdErr != nil {
+		log.Fatal(cmdErr)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"oteldemo/config"
+	"oteldemo/dice"
+)
+
+// otlpStub is an in-process OTLP/gRPC receiver, which
+// captures the telemetry exported to it.
+type otlpStub struct {
+	// endpoint is the stub's OTLP endpoint URL.
+	endpoint string
+
+	// down makes the stub fail exports as unavailable while set.
+	down atomic.Bool
+
+	mu      sync.Mutex
+	spans   []*tracepb.ResourceSpans
+	metrics []*metricspb.ResourceMetrics
+}
+
+// startOTLPStub starts an otlpStub, which is stopped when the test completes.
+func startOTLPStub(t *testing.T) *otlpStub {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &otlpStub{endpoint: "http://" + l.Addr().String()}
+	srv := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(srv, traceService{otlpStub: stub})
+	colmetricpb.RegisterMetricsServiceServer(srv, metricsService{otlpStub: stub})
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return stub
+}
+
+// traceService is the stub's OTLP trace service.
+type traceService struct {
+	coltracepb.UnimplementedTraceServiceServer
+	*otlpStub
+}
+
+func (s traceService) Export(
+	ctx context.Context, req *coltracepb.ExportTraceServiceRequest,
+) (*coltracepb.ExportTraceServiceResponse, error) {
+	if s.down.Load() {
+		return nil, status.Error(codes.Unavailable, "stub is down")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spans = append(s.spans, req.ResourceSpans...)
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+// metricsService is the stub's OTLP metrics service.
+type metricsService struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	*otlpStub
+}
+
+func (s metricsService) Export(
+	ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest,
+) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	if s.down.Load() {
+		return nil, status.Error(codes.Unavailable, "stub is down")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = append(s.metrics, req.ResourceMetrics...)
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+// resourceAttribute returns the string value of the resource
+// attribute with the given key, or "" if it is not set.
+func resourceAttribute(res *resourcepb.Resource, key string) string {
+	for _, kv := range res.GetAttributes() {
+		if kv.Key == key {
+			return kv.Value.GetStringValue()
+		}
+	}
+	return ""
+}
+
+// findMetric returns the first exported metric with the given name.
+func (s *otlpStub) findMetric(name string) (*metricspb.Metric, *resourcepb.Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rm := range s.metrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Name == name {
+					return m, rm.Resource
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// traceIDs returns the IDs of the exported traces.
+func (s *otlpStub) traceIDs() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make(map[string]bool)
+	for _, rs := range s.spans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				ids[string(span.TraceId)] = true
+			}
+		}
+	}
+	return ids
+}
+
+func TestExportOTLP(t *testing.T) {
+	t.Setenv("OTEL_GO_X_EXEMPLAR", "true")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "deployment.environment=test")
+	stub := startOTLPStub(t)
+
+	cfg := config.Default()
+	cfg.Telemetry.OTLP.Endpoint = stub.endpoint
+	consoleExporters.Set(false)
+	t.Cleanup(func() { consoleExporters.Set(true) })
+	meterProvider := initMeterProvider(cfg.Telemetry)
+	tracerProvider := initTracerProvider(cfg.Telemetry)
+
+	srv, err := dice.New(dice.WithConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	for range 3 {
+		resp, err := http.Get(ts.URL + "/roll/2d6")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// Shutting down the providers flushes telemetry to the stub.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := meterProvider.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rolls, res := stub.findMetric("dice_rolls")
+	if rolls == nil {
+		t.Fatal("dice_rolls not exported")
+	}
+	for key, want := range map[string]string{
+		"service.name":           "dice-server",
+		"deployment.environment": "test",
+	} {
+		if got := resourceAttribute(res, key); got != want {
+			t.Errorf("got resource attribute %s=%q, want %q", key, got, want)
+		}
+	}
+
+	sum := rolls.GetSum()
+	if sum == nil {
+		t.Fatalf("dice_rolls exported as %T, want a sum", rolls.Data)
+	}
+	if sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		t.Errorf("got dice_rolls temporality %v, want delta", sum.AggregationTemporality)
+	}
+	var total int64
+	var exemplars []*metricspb.Exemplar
+	for _, dp := range sum.DataPoints {
+		total += dp.GetAsInt()
+		exemplars = append(exemplars, dp.Exemplars...)
+	}
+	if total != 6 {
+		t.Errorf("got %d dice_rolls, want 6", total)
+	}
+
+	// Exemplars link measurements to the (sampled) traces they were made in.
+	if len(exemplars) == 0 {
+		t.Fatal("no dice_rolls exemplars exported")
+	}
+	traceIDs := stub.traceIDs()
+	for _, e := range exemplars {
+		if len(e.TraceId) == 0 || bytes.Equal(e.TraceId, make([]byte, 16)) {
+			t.Errorf("exemplar without trace ID: %v", e)
+		} else if !traceIDs[string(e.TraceId)] {
+			t.Errorf("exemplar trace ID %x not exported", e.TraceId)
+		}
+	}
+
+	duration, _ := stub.findMetric("http.server.request.duration")
+	if duration.GetHistogram().GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		t.Errorf("http.server.request.duration not exported as a delta histogram")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// readinessChecks holds readiness checks registered while setting up the
+// telemetry pipeline, to be passed to the server with dice.WithReadinessCheck.
+var readinessChecks = make(map[string]func() error)
+
+// exportStatus records the outcome of the most recent export,
+// and is registered as a readiness check.
+type exportStatus struct {
+	mu  sync.Mutex
+	err error
+}
+
+func newExportStatus(signal string) *exportStatus {
+	s := &exportStatus{}
+	readinessChecks[signal+" exporter"] = s.check
+	return s
+}
+
+func (s *exportStatus) record(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	return err
+}
+
+func (s *exportStatus) check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return fmt.Errorf("last export failed: %w", s.err)
+	}
+	return nil
+}
+
+// statusSpanExporter records export outcomes of a sdktrace.SpanExporter.
+type statusSpanExporter struct {
+	sdktrace.SpanExporter
+	status *exportStatus
+}
+
+func newStatusSpanExporter(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	return &statusSpanExporter{SpanExporter: exporter, status: newExportStatus("traces")}
+}
+
+func (e *statusSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	return e.status.record(e.SpanExporter.ExportSpans(ctx, spans))
+}
+
+// statusMetricExporter records export outcomes of a sdkmetric.Exporter.
+type statusMetricExporter struct {
+	sdkmetric.Exporter
+	status *exportStatus
+}
+
+func newStatusMetricExporter(exporter sdkmetric.Exporter) sdkmetric.Exporter {
+	return &statusMetricExporter{Exporter: exporter, status: newExportStatus("metrics")}
+}
+
+func (e *statusMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	return e.status.record(e.Exporter.Export(ctx, rm))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"oteldemo/config"
+)
+
+// exporterMeterName is the instrumentation scope of
+// the metrics recorded by the OTLP exporters.
+const exporterMeterName = "oteldemo/exporter"
+
+// endpointNames holds the values of the "endpoint" attribute recorded
+// by the otlp_exporter_active gauge, indexed by endpoint.
+var endpointNames = [2]string{"primary", "secondary"}
+
+// newOTLPSpanExporter returns an OTLP span exporter, which will fail over
+// to cfg.SecondaryEndpoint when the primary endpoint is unavailable. The
+// outcome of exports is reported by the readiness endpoint, and the
+// active endpoint and spool size are recorded with mp.
+func newOTLPSpanExporter(ctx context.Context, cfg config.OTLP, mp metric.MeterProvider, opts ...otlptracegrpc.Option) (sdktrace.SpanExporter, error) {
+	exporter, err := newFailoverSpanExporter(ctx, cfg, mp, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newStatusSpanExporter(exporter), nil
+}
+
+func newFailoverSpanExporter(ctx context.Context, cfg config.OTLP, mp metric.MeterProvider, opts ...otlptracegrpc.Option) (sdktrace.SpanExporter, error) {
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+	}
+	spoolOpts, err := spoolDialOptions(cfg, "traces", mp)
+	if err != nil {
+		return nil, err
+	}
+	// Both endpoints share the spool, which the failover
+	// only lets spool when both endpoints are unavailable.
+	if spoolOpts != nil {
+		opts = append(opts, otlptracegrpc.WithDialOption(spoolOpts...))
+	}
+	if cfg.SecondaryEndpoint == "" {
+		return otlptracegrpc.New(ctx, opts...)
+	}
+	// Failing over is our retry strategy, so don't let the
+	// exporters block the pipeline retrying a dead endpoint.
+	opts = append(opts, otlptracegrpc.WithRetry(otlptracegrpc.RetryConfig{Enabled: false}))
+	primary, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := otlptracegrpc.New(ctx, append(opts, otlptracegrpc.WithEndpointURL(cfg.SecondaryEndpoint))...)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newFailover("traces", mp)
+	if err != nil {
+		return nil, err
+	}
+	return &failoverSpanExporter{
+		failover:  f,
+		exporters: [2]sdktrace.SpanExporter{primary, secondary},
+	}, nil
+}
+
+// newOTLPMetricExporter returns an OTLP metric exporter, which will fail
+// over to cfg.SecondaryEndpoint when the primary endpoint is unavailable.
+// The outcome of exports is reported by the readiness endpoint, and the
+// active endpoint and spool size are recorded with mp.
+func newOTLPMetricExporter(ctx context.Context, cfg config.OTLP, mp metric.MeterProvider, opts ...otlpmetricgrpc.Option) (sdkmetric.Exporter, error) {
+	exporter, err := newFailoverMetricExporter(ctx, cfg, mp, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newStatusMetricExporter(exporter), nil
+}
+
+func newFailoverMetricExporter(ctx context.Context, cfg config.OTLP, mp metric.MeterProvider, opts ...otlpmetricgrpc.Option) (sdkmetric.Exporter, error) {
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint))
+	}
+	spoolOpts, err := spoolDialOptions(cfg, "metrics", mp)
+	if err != nil {
+		return nil, err
+	}
+	// Both endpoints share the spool, which the failover
+	// only lets spool when both endpoints are unavailable.
+	if spoolOpts != nil {
+		opts = append(opts, otlpmetricgrpc.WithDialOption(spoolOpts...))
+	}
+	if cfg.SecondaryEndpoint == "" {
+		return otlpmetricgrpc.New(ctx, opts...)
+	}
+	opts = append(opts, otlpmetricgrpc.WithRetry(otlpmetricgrpc.RetryConfig{Enabled: false}))
+	primary, err := otlpmetricgrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := otlpmetricgrpc.New(ctx, append(opts, otlpmetricgrpc.WithEndpointURL(cfg.SecondaryEndpoint))...)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newFailover("metrics", mp)
+	if err != nil {
+		return nil, err
+	}
+	return &failoverMetricExporter{
+		failover:  f,
+		exporters: [2]sdkmetric.Exporter{primary, secondary},
+	}, nil
+}
+
+// failover tracks which of a primary and secondary exporter is active.
+type failover struct {
+	signal string
+	active atomic.Int32
+}
+
+func newFailover(signal string, mp metric.MeterProvider) (*failover, error) {
+	f := &failover{signal: signal}
+	meter := mp.Meter(exporterMeterName)
+	gauge, err := meter.Int64ObservableGauge(
+		"otlp_exporter_active",
+		metric.WithDescription("Set to 1 for the OTLP endpoint currently in use, 0 otherwise"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// The gauge is shared by all signals, so register
+	// a callback rather than passing it to the gauge.
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		active := int(f.active.Load())
+		for i, name := range endpointNames {
+			var v int64
+			if i == active {
+				v = 1
+			}
+			o.ObserveInt64(gauge, v, metric.WithAttributes(
+				attribute.String("signal", f.signal),
+				attribute.String("endpoint", name),
+			))
+		}
+		return nil
+	}, gauge)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// export calls fn with the index of the active exporter. If that fails,
+// fn is called again with the other exporter, which becomes active if
+// it succeeds. Requests are only spooled by the second call, once both
+// endpoints have failed, and the active exporter is then kept, so the
+// failover doesn't switch to an endpoint that is unreachable.
+func (f *failover) export(ctx context.Context, fn func(ctx context.Context, i int) error) error {
+	i := int(f.active.Load())
+	err := fn(withSpoolCall(ctx, &spoolCall{disabled: true}), i)
+	if err == nil {
+		return nil
+	}
+	j := 1 - i
+	call := &spoolCall{}
+	if err2 := fn(withSpoolCall(ctx, call), j); err2 != nil {
+		return errors.Join(err, err2)
+	}
+	if call.spooled {
+		return nil
+	}
+	if f.active.CompareAndSwap(int32(i), int32(j)) {
+		log.Printf(
+			"exporting %s to %s OTLP endpoint failed, switched to %s: %v",
+			f.signal, endpointNames[i], endpointNames[j], err,
+		)
+	}
+	return nil
+}
+
+type failoverSpanExporter struct {
+	*failover
+	exporters [2]sdktrace.SpanExporter
+}
+
+func (e *failoverSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	return e.export(ctx, func(ctx context.Context, i int) error {
+		return e.exporters[i].ExportSpans(ctx, spans)
+	})
+}
+
+func (e *failoverSpanExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(
+		e.exporters[0].Shutdown(ctx),
+		e.exporters[1].Shutdown(ctx),
+	)
+}
+
+type failoverMetricExporter struct {
+	*failover
+	exporters [2]sdkmetric.Exporter
+}
+
+func (e *failoverMetricExporter) Temporality(k sdkmetric.InstrumentKind) metricdata.Temporality {
+	return e.exporters[0].Temporality(k)
+}
+
+func (e *failoverMetricExporter) Aggregation(k sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return e.exporters[0].Aggregation(k)
+}
+
+func (e *failoverMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	return e.export(ctx, func(ctx context.Context, i int) error {
+		return e.exporters[i].Export(ctx, rm)
+	})
+}
+
+func (e *failoverMetricExporter) ForceFlush(ctx context.Context) error {
+	return errors.Join(
+		e.exporters[0].ForceFlush(ctx),
+		e.exporters[1].ForceFlush(ctx),
+	)
+}
+
+func (e *failoverMetricExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(
+		e.exporters[0].Shutdown(ctx),
+		e.exporters[1].Shutdown(ctx),
+	)
+}
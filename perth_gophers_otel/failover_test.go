@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/metric/noop"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"oteldemo/config"
+)
+
+// spanCount returns the number of resource spans exported to the stub.
+func (s *otlpStub) spanCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.spans)
+}
+
+// newTestFailoverExporter returns a failover span exporter for
+// the primary and secondary stubs, spooling to a temporary directory,
+// and a function returning the number of spooled requests.
+func newTestFailoverExporter(t *testing.T, primary, secondary *otlpStub) (*failoverSpanExporter, func() int) {
+	t.Helper()
+	cfg := config.OTLP{
+		Endpoint:          primary.endpoint,
+		SecondaryEndpoint: secondary.endpoint,
+		SpoolDir:          t.TempDir(),
+		SpoolMaxBytes:     1 << 20,
+	}
+	t.Cleanup(func() { delete(readinessChecks, "traces spool") })
+	exporter, err := newFailoverSpanExporter(context.Background(), cfg, noop.NewMeterProvider())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { exporter.Shutdown(context.Background()) })
+	spooled := func() int {
+		entries, err := os.ReadDir(filepath.Join(cfg.SpoolDir, "traces"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+	return exporter.(*failoverSpanExporter), spooled
+}
+
+func exportTestSpan(t *testing.T, exporter sdktrace.SpanExporter) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	spans := tracetest.SpanStubs{{Name: "roll"}}.Snapshots()
+	if err := exporter.ExportSpans(ctx, spans); err != nil {
+		t.Fatalf("exporting spans: %v", err)
+	}
+}
+
+func TestFailoverSwitchesBack(t *testing.T) {
+	primary, secondary := startOTLPStub(t), startOTLPStub(t)
+	exporter, spooled := newTestFailoverExporter(t, primary, secondary)
+
+	primary.down.Store(true)
+	exportTestSpan(t, exporter)
+	if got := exporter.active.Load(); got != 1 {
+		t.Fatalf("got active endpoint %d with the primary down, want the secondary", got)
+	}
+	if got := secondary.spanCount(); got != 1 {
+		t.Errorf("got %d exports to the secondary, want 1", got)
+	}
+
+	primary.down.Store(false)
+	secondary.down.Store(true)
+	exportTestSpan(t, exporter)
+	if got := exporter.active.Load(); got != 0 {
+		t.Fatalf("got active endpoint %d with the secondary down, want the primary", got)
+	}
+	if got := primary.spanCount(); got != 1 {
+		t.Errorf("got %d exports to the primary, want 1", got)
+	}
+	if got := spooled(); got != 0 {
+		t.Errorf("got %d spooled requests, want none", got)
+	}
+}
+
+func TestFailoverSpoolsWhenBothDown(t *testing.T) {
+	primary, secondary := startOTLPStub(t), startOTLPStub(t)
+	exporter, spooled := newTestFailoverExporter(t, primary, secondary)
+
+	primary.down.Store(true)
+	secondary.down.Store(true)
+	exportTestSpan(t, exporter)
+	if got := exporter.active.Load(); got != 0 {
+		t.Errorf("got active endpoint %d with both down, want the primary", got)
+	}
+	if got := spooled(); got != 1 {
+		t.Fatalf("got %d spooled requests, want 1", got)
+	}
+
+	// The primary is retried when it comes back, and
+	// the spooled request is replayed to it.
+	primary.down.Store(false)
+	exportTestSpan(t, exporter)
+	if got := exporter.active.Load(); got != 0 {
+		t.Errorf("got active endpoint %d with the primary back, want the primary", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for primary.spanCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d exports to the primary, want the export and the replayed request", primary.spanCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := secondary.spanCount(); got != 0 {
+		t.Errorf("got %d exports to the secondary, want none", got)
+	}
+	for spooled() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d spooled requests after replay, want none", spooled())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
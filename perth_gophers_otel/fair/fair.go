@@ -0,0 +1,115 @@
+// Package fair implements provably fair dice rolls, with a commit-reveal
+// scheme, so clients can verify that the server didn't choose the
+// outcome of a roll:
+//
+//  1. The server chooses a secret, random seed for the roll, and
+//     publishes a commitment to it: the HMAC-SHA256 of the roll's ID,
+//     keyed by the seed.
+//  2. The client chooses a seed of its own, and the dice are rolled
+//     from HMAC-SHA256s of the client seed keyed by the server seed, so
+//     neither the server nor the client alone determines the outcome.
+//  3. The server reveals its seed, with which anyone can Verify that it
+//     is the seed committed to, and that the dice were rolled from it.
+package fair
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// SeedSize is the size of server seeds, in bytes.
+const SeedSize = 32
+
+// NewSeed returns a random server seed.
+func NewSeed() ([]byte, error) {
+	seed := make([]byte, SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return seed, nil
+}
+
+// Commit returns the hex-encoded commitment to seed for the roll id.
+func Commit(seed []byte, id string) string {
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte("commit:" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Roll rolls n dice with the given number of sides, from seed
+// and clientSeed, returning the value rolled on each die.
+func Roll(seed []byte, clientSeed string, n, sides int64) []int64 {
+	rolls := make([]int64, n)
+	// Reject values beyond the largest multiple of sides, so the
+	// remainders are uniformly distributed.
+	limit := math.MaxUint64 - math.MaxUint64%uint64(sides)
+	mac := hmac.New(sha256.New, seed)
+	for i := range rolls {
+		for attempt := 0; ; attempt++ {
+			mac.Reset()
+			mac.Write([]byte(clientSeed + ":" + strconv.Itoa(i) + ":" + strconv.Itoa(attempt)))
+			v := binary.BigEndian.Uint64(mac.Sum(nil))
+			if v < limit {
+				rolls[i] = int64(v%uint64(sides)) + 1
+				break
+			}
+		}
+	}
+	return rolls
+}
+
+// Proof is the record of a fair roll, with which it can be verified.
+type Proof struct {
+	ID         string  `json:"id"`
+	N          int64   `json:"n"`
+	Sides      int64   `json:"sides"`
+	Commitment string  `json:"commitment"`
+	Seed       string  `json:"seed"`
+	ClientSeed string  `json:"client_seed"`
+	Rolls      []int64 `json:"rolls"`
+	Sum        int64   `json:"sum"`
+}
+
+// Errors returned by Proof.Verify.
+var (
+	ErrCommitment = errors.New("seed does not match the commitment")
+	ErrRolls      = errors.New("rolls do not match the seeds")
+)
+
+// Verify verifies that the roll's seed is the seed committed to,
+// and that the dice were rolled from the seeds.
+func (p Proof) Verify() error {
+	seed, err := hex.DecodeString(p.Seed)
+	if err != nil {
+		return fmt.Errorf("invalid seed: %w", err)
+	}
+	commitment, err := hex.DecodeString(p.Commitment)
+	if err != nil {
+		return fmt.Errorf("invalid commitment: %w", err)
+	}
+	want, _ := hex.DecodeString(Commit(seed, p.ID))
+	if !hmac.Equal(commitment, want) {
+		return ErrCommitment
+	}
+	if p.N < 1 || p.Sides < 1 || int64(len(p.Rolls)) != p.N {
+		return ErrRolls
+	}
+	var sum int64
+	for i, roll := range Roll(seed, p.ClientSeed, p.N, p.Sides) {
+		if p.Rolls[i] != roll {
+			return ErrRolls
+		}
+		sum += roll
+	}
+	if p.Sum != sum {
+		return fmt.Errorf("sum %d does not match the rolls, which sum to %d", p.Sum, sum)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package fair
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func newProof(t *testing.T) Proof {
+	t.Helper()
+	seed, err := NewSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Proof{
+		ID:         "0123456789abcdef",
+		N:          3,
+		Sides:      6,
+		Commitment: Commit(seed, "0123456789abcdef"),
+		ClientSeed: "lucky",
+		Rolls:      Roll(seed, "lucky", 3, 6),
+	}
+	p.Seed = hex.EncodeToString(seed)
+	for _, roll := range p.Rolls {
+		p.Sum += roll
+	}
+	return p
+}
+
+func TestVerify(t *testing.T) {
+	if err := newProof(t).Verify(); err != nil {
+		t.Fatal(err)
+	}
+	for name, test := range map[string]struct {
+		tamper func(*Proof)
+		want   error
+	}{
+		"other roll's commitment": {func(p *Proof) { p.ID = "fedcba9876543210" }, ErrCommitment},
+		"other seed":              {func(p *Proof) { p.Seed = hex.EncodeToString(make([]byte, SeedSize)) }, ErrCommitment},
+		"other client seed":       {func(p *Proof) { p.ClientSeed = "unlucky" }, ErrRolls},
+		"other rolls":             {func(p *Proof) { p.Rolls[0] = p.Rolls[0]%6 + 1 }, ErrRolls},
+		"missing rolls":           {func(p *Proof) { p.Rolls = p.Rolls[:2] }, ErrRolls},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := newProof(t)
+			test.tamper(&p)
+			if err := p.Verify(); !errors.Is(err, test.want) {
+				t.Errorf("got error %v, want %v", err, test.want)
+			}
+		})
+	}
+	p := newProof(t)
+	p.Sum++
+	if err := p.Verify(); err == nil {
+		t.Error("got no error for the wrong sum")
+	}
+}
+
+func TestRoll(t *testing.T) {
+	seed := make([]byte, SeedSize)
+	rolls := Roll(seed, "client", 1000, 6)
+	counts := make(map[int64]int)
+	for _, roll := range rolls {
+		if roll < 1 || roll > 6 {
+			t.Fatalf("rolled %d on a d6", roll)
+		}
+		counts[roll]++
+	}
+	if len(counts) != 6 {
+		t.Errorf("got rolls %v, want every side rolled in 1000 rolls", counts)
+	}
+	again := Roll(seed, "client", 1000, 6)
+	for i := range rolls {
+		if rolls[i] != again[i] {
+			t.Fatalf("roll %d: got %d and then %d from the same seeds", i, rolls[i], again[i])
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/open-feature/go-sdk/openfeature"
+	"github.com/open-feature/go-sdk/openfeature/memprovider"
+	"gopkg.in/yaml.v3"
+)
+
+// flagsFile is the format of the file named by -feature-flags.
+type flagsFile struct {
+	Flags map[string]struct {
+		State          string         `yaml:"state"`
+		DefaultVariant string         `yaml:"default_variant"`
+		Variants       map[string]any `yaml:"variants"`
+	} `yaml:"flags"`
+}
+
+// initFeatureFlags registers a global OpenFeature provider serving the
+// flags defined in the given file. If path is empty, the default no-op
+// provider is used, and all flags evaluate to their defaults.
+func initFeatureFlags(path string) error {
+	if path == "" {
+		return openfeature.SetProvider(openfeature.NoopProvider{})
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file flagsFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	flags := make(map[string]memprovider.InMemoryFlag)
+	for key, flag := range file.Flags {
+		state := memprovider.Enabled
+		switch flag.State {
+		case "", "enabled":
+		case "disabled":
+			state = memprovider.Disabled
+		default:
+			return fmt.Errorf("flag %q: invalid state %q, expected enabled or disabled", key, flag.State)
+		}
+		if _, ok := flag.Variants[flag.DefaultVariant]; !ok {
+			return fmt.Errorf("flag %q: default variant %q not defined", key, flag.DefaultVariant)
+		}
+		flags[key] = memprovider.InMemoryFlag{
+			Key:            key,
+			State:          state,
+			DefaultVariant: flag.DefaultVariant,
+			Variants:       flag.Variants,
+		}
+	}
+	return openfeature.SetProviderAndWait(memprovider.NewInMemoryProvider(flags))
+}
@@ -0,0 +1,192 @@
+// Command fortune serves fortunes, with a luck modifier that the dice
+// server adds to its rolls when configured with -fortune-url, e.g.
+//
+//	go run ./fortune -listen localhost:8082 &
+//	go run . -fortune-url http://localhost:8082
+//
+// Requests are served with otelhttp, so the fortune service's spans join
+// the traces of the rolls calling it, making them span multiple services.
+// Latency and errors can be injected, to show how a slow or failing
+// dependency appears in those traces. The size of the roll, propagated
+// by the dice server as baggage, is recorded as the roll.size attribute
+// of the service's spans.
+//
+// Telemetry is exported as OTLP, configured by -otlp-endpoint or the
+// standard OTEL_EXPORTER_OTLP_* environment variables, or to stdout
+// with -console.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type options struct {
+	listen    string
+	latency   time.Duration
+	errorRate float64
+
+	otlpEndpoint string
+	console      bool
+}
+
+func parseFlags(args []string) (*options, error) {
+	var opts options
+	fs := flag.NewFlagSet("fortune", flag.ContinueOnError)
+	fs.StringVar(&opts.listen, "listen", "localhost:8082", "host:port on which to listen")
+	fs.DurationVar(&opts.latency, "latency", 0, "latency to add to every request")
+	fs.Float64Var(&opts.errorRate, "error-rate", 0, "probability of a request failing at random")
+	fs.StringVar(&opts.otlpEndpoint, "otlp-endpoint", "", "OTLP endpoint URL (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.BoolVar(&opts.console, "console", false, "print spans to stdout, instead of exporting them as OTLP")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.latency < 0 {
+		return nil, errors.New("latency must not be negative")
+	}
+	if r := opts.errorRate; r < 0 || r > 1 {
+		return nil, fmt.Errorf("error rate %v out of range [0, 1]", r)
+	}
+	return &opts, nil
+}
+
+func initTracerProvider(ctx context.Context, opts *options) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName("fortune")),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		log.Printf("error detecting resource: %v", err)
+	}
+
+	var exporter sdktrace.SpanExporter
+	if opts.console {
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	} else {
+		var exporterOpts []otlptracegrpc.Option
+		if opts.otlpEndpoint != "" {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithEndpointURL(opts.otlpEndpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, exporterOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider, nil
+}
+
+// fortunes are told with the luck modifier they bring.
+var fortunes = []struct {
+	fortune string
+	luck    int64
+}{
+	{"Fortune favours the bold.", 1},
+	{"A smooth sea never made a skilled sailor.", 0},
+	{"The dice remember nothing.", 0},
+	{"Beware of snake eyes.", -1},
+	{"Luck is what happens when preparation meets opportunity.", 1},
+	{"Today is not your day.", -1},
+}
+
+// fortuneHandler handles GET /fortune, responding with a random fortune.
+func fortuneHandler(opts *options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		span := trace.SpanFromContext(r.Context())
+		if size := baggage.FromContext(r.Context()).Member("roll.size"); size.Key() != "" {
+			span.SetAttributes(attribute.String("roll.size", size.Value()))
+		}
+		if opts.latency > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(opts.latency):
+			}
+		}
+		if opts.errorRate > 0 && rand.Float64() < opts.errorRate {
+			span.RecordError(errors.New("injected failure"))
+			http.Error(w, "injected failure", http.StatusInternalServerError)
+			return
+		}
+		f := fortunes[rand.Intn(len(fortunes))]
+		span.SetAttributes(attribute.Int64("fortune.luck", f.luck))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"fortune": f.fortune,
+			"luck":    f.luck,
+		})
+	}
+}
+
+func main() {
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tracerProvider, err := initTracerProvider(ctx, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/fortune", otelhttp.NewHandler(fortuneHandler(opts), "GET /fortune"))
+	srv := &http.Server{Addr: opts.listen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	log.Printf("serving fortunes on %s", opts.listen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Print(err)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(flushCtx); err != nil {
+		log.Printf("error flushing telemetry: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+//go:build integration
+
+// Integration tests run the dice server against an OpenTelemetry
+// Collector in a container, and check that the expected telemetry
+// arrives. They require Docker, and are run with
+//
+//	go test -tags integration .
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"oteldemo/config"
+	"oteldemo/dice"
+)
+
+// collectorImage is the Collector image to test against. The contrib
+// distribution is required for the file exporter.
+const collectorImage = "otel/opentelemetry-collector-contrib:0.94.0"
+
+// collectorConfig configures the Collector to write received
+// telemetry to files in /out, as OTLP JSON.
+const collectorConfig = `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 0.0.0.0:4317
+exporters:
+  file/traces:
+    path: /out/traces.json
+  file/metrics:
+    path: /out/metrics.json
+extensions:
+  health_check:
+    endpoint: 0.0.0.0:13133
+service:
+  extensions: [health_check]
+  pipelines:
+    traces:
+      receivers: [otlp]
+      exporters: [file/traces]
+    metrics:
+      receivers: [otlp]
+      exporters: [file/metrics]
+`
+
+// collector is a Collector running in a container.
+type collector struct {
+	// endpoint is the OTLP/gRPC endpoint URL.
+	endpoint string
+
+	// dir holds the files written by the Collector.
+	dir string
+}
+
+// startCollector starts a Collector container, which is removed
+// when the test completes. The test is skipped without Docker.
+func startCollector(t *testing.T) *collector {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not found")
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skipf("docker unavailable: %v", err)
+	}
+
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "out")
+	// The Collector runs as an unprivileged user.
+	if err := os.Mkdir(outDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(outDir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(collectorConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	id := docker(t, "run", "--detach", "--rm",
+		"--publish", "127.0.0.1::4317",
+		"--publish", "127.0.0.1::13133",
+		"--volume", configPath+":/etc/otelcol-contrib/config.yaml:ro",
+		"--volume", outDir+":/out",
+		collectorImage,
+	)
+	t.Cleanup(func() {
+		exec.Command("docker", "stop", id).Run()
+	})
+
+	c := &collector{
+		endpoint: "http://" + dockerPort(t, id, "4317/tcp"),
+		dir:      outDir,
+	}
+	health := "http://" + dockerPort(t, id, "13133/tcp")
+	deadline := time.Now().Add(time.Minute)
+	for {
+		resp, err := http.Get(health)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return c
+			}
+		}
+		if time.Now().After(deadline) {
+			logs, _ := exec.Command("docker", "logs", id).CombinedOutput()
+			t.Fatalf("collector not healthy: %v\n%s", err, logs)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// docker runs a docker command, returning its trimmed output.
+func docker(t *testing.T, args ...string) string {
+	t.Helper()
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("docker %s: %v\n%s", args[0], err, stderr.Bytes())
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// dockerPort returns the host address to which a container port is published.
+func dockerPort(t *testing.T, id, port string) string {
+	t.Helper()
+	// docker port may list several addresses; they are equivalent.
+	addr, _, _ := strings.Cut(docker(t, "port", id, port), "\n")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		t.Fatalf("unexpected published address %q: %v", addr, err)
+	}
+	return addr
+}
+
+// otlpFile holds the parts of OTLP JSON, as written by the
+// Collector's file exporter, that the tests check.
+type otlpFile struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []struct {
+				Name       string          `json:"name"`
+				Attributes []otlpAttribute `json:"attributes"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+	ResourceMetrics []struct {
+		ScopeMetrics []struct {
+			Metrics []struct {
+				Name string `json:"name"`
+			} `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+		IntValue    string `json:"intValue"`
+	} `json:"value"`
+}
+
+// read reads the named file written by the Collector, which
+// holds a line of OTLP JSON for each export request.
+func (c *collector) read(t *testing.T, name string) []otlpFile {
+	t.Helper()
+	f, err := os.Open(filepath.Join(c.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var files []otlpFile
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var file otlpFile
+		if err := json.Unmarshal(scanner.Bytes(), &file); err != nil {
+			t.Fatalf("decoding %s: %v", name, err)
+		}
+		files = append(files, file)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// spans returns the received spans as "name key=value..." strings,
+// with the attributes in the order exported.
+func (c *collector) spans(t *testing.T) []string {
+	var spans []string
+	for _, file := range c.read(t, "traces.json") {
+		for _, rs := range file.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					s := span.Name
+					for _, kv := range span.Attributes {
+						s += fmt.Sprintf(" %s=%s%s", kv.Key, kv.Value.StringValue, kv.Value.IntValue)
+					}
+					spans = append(spans, s)
+				}
+			}
+		}
+	}
+	return spans
+}
+
+// metrics returns the names of the received metrics.
+func (c *collector) metrics(t *testing.T) map[string]bool {
+	metrics := make(map[string]bool)
+	for _, file := range c.read(t, "metrics.json") {
+		for _, rm := range file.ResourceMetrics {
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					metrics[m.Name] = true
+				}
+			}
+		}
+	}
+	return metrics
+}
+
+// waitFor polls cond until it returns true, or fails the test.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func TestCollectorIntegration(t *testing.T) {
+	c := startCollector(t)
+
+	cfg := config.Default()
+	cfg.Telemetry.OTLP.Endpoint = c.endpoint
+	meterProvider := initMeterProvider(cfg.Telemetry)
+	tracerProvider := initTracerProvider(cfg.Telemetry)
+	consoleExporters.Set(false)
+
+	srv, err := dice.New(dice.WithConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	for _, path := range []string{"/roll/2d6", "/roll/3d20", "/roll/nonsense"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// Shutting down the providers flushes telemetry to the Collector.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := meterProvider.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSpans := []string{
+		"/roll/:dice http.route=/roll/:dice http.status_code=200",
+		"/roll/:dice http.route=/roll/:dice http.status_code=400",
+	}
+	waitFor(t, "spans", func() bool {
+		spans := c.spans(t)
+		for _, want := range wantSpans {
+			if !containsSpan(spans, want) {
+				return false
+			}
+		}
+		return true
+	})
+
+	wantMetrics := []string{
+		"dice_rolls",
+		"http.server.request.duration",
+		"http.server.active_requests",
+	}
+	waitFor(t, "metrics", func() bool {
+		metrics := c.metrics(t)
+		for _, want := range wantMetrics {
+			if !metrics[want] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// containsSpan reports whether spans contains a span with the name
+// and attributes in want, which is of the form returned by
+// collector.spans.
+func containsSpan(spans []string, want string) bool {
+	name, attrs, _ := strings.Cut(want, " ")
+	for _, span := range spans {
+		if got, _, _ := strings.Cut(span, " "); got != name {
+			continue
+		}
+		found := true
+		for _, attr := range strings.Fields(attrs) {
+			if !strings.Contains(span+" ", " "+attr+" ") {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errInjected is returned for requests failed by injectTransport.
+var errInjected = errors.New("injected client error")
+
+// injectTransport is an http.RoundTripper that injects errors and
+// latency into a fraction of requests, before they are sent.
+//
+// It is wrapped by the otelhttp transport, so injected faults show
+// up in the client spans, and the gap between client and server
+// spans is visible in traces.
+type injectTransport struct {
+	next http.RoundTripper
+
+	errorFraction   float64
+	latency         time.Duration
+	latencyFraction float64
+}
+
+func (t injectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := trace.SpanFromContext(req.Context())
+	if t.latency > 0 && rand.Float64() < t.latencyFraction {
+		span.AddEvent("injected latency", trace.WithAttributes(
+			attribute.String("latency", t.latency.String()),
+		))
+		select {
+		case <-time.After(t.latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if rand.Float64() < t.errorFraction {
+		return nil, errInjected
+	}
+	return t.next.RoundTrip(req)
+}
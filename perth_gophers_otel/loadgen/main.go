@@ -0,0 +1,328 @@
+// Command loadgen drives load against the dice server, with an
+// OpenTelemetry-instrumented HTTP client, so that traces contain
+// both client and server spans.
+//
+// The request rate follows a ramp-up profile, and the client can inject
+// errors and latency into a fraction of its requests, e.g.
+//
+//	go run ./loadgen -rps 50 -profile linear -ramp-up 1m \
+//		-dice 2d6,1d20,3d0 -latency 200ms -latency-fraction 0.1
+//
+// Alternatively, -scenario runs a YAML scenario of weighted requests,
+// with think times and bursts of users, to reproduce specific patterns
+// in the telemetry; see the scenarios directory for examples.
+//
+// Telemetry is exported as OTLP, configured by -otlp-endpoint or the
+// standard OTEL_EXPORTER_OTLP_* environment variables, or to stdout
+// with -console.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// tick is the interval at which requests are scheduled.
+const tick = 10 * time.Millisecond
+
+type options struct {
+	target      string
+	dice        []string
+	rps         float64
+	profile     string
+	rampUp      time.Duration
+	duration    time.Duration
+	maxInFlight int
+	timeout     time.Duration
+	scenario    string
+
+	errorFraction   float64
+	latency         time.Duration
+	latencyFraction float64
+
+	otlpEndpoint string
+	console      bool
+}
+
+func parseFlags(args []string) (*options, error) {
+	var opts options
+	var dice string
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	fs.StringVar(&opts.target, "target", "http://localhost:8080", "base URL of the dice server")
+	fs.StringVar(&dice, "dice", "2d6", "comma-separated dice notation to roll, chosen at random for each request")
+	fs.Float64Var(&opts.rps, "rps", 10, "target requests per second, once ramped up")
+	fs.StringVar(&opts.profile, "profile", "linear", "ramp-up profile: constant, linear, step, or spike")
+	fs.DurationVar(&opts.rampUp, "ramp-up", 30*time.Second, "time to reach the target rate, or the spike period")
+	fs.DurationVar(&opts.duration, "duration", 0, "how long to run for; zero to run until interrupted")
+	fs.IntVar(&opts.maxInFlight, "max-in-flight", 100, "maximum concurrent requests; requests beyond this are dropped")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "request timeout")
+	fs.StringVar(&opts.scenario, "scenario", "", "path to a YAML scenario to run, in place of -dice, -rps, and -profile")
+	fs.Float64Var(&opts.errorFraction, "error-fraction", 0, "fraction of requests to fail on the client, without sending")
+	fs.DurationVar(&opts.latency, "latency", 0, "latency to inject on the client before sending requests")
+	fs.Float64Var(&opts.latencyFraction, "latency-fraction", 1, "fraction of requests to inject latency into")
+	fs.StringVar(&opts.otlpEndpoint, "otlp-endpoint", "", "OTLP endpoint URL (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.BoolVar(&opts.console, "console", false, "export spans to stdout instead of OTLP")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	opts.dice = strings.Split(dice, ",")
+
+	var errs []error
+	if u, err := url.Parse(opts.target); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		errs = append(errs, fmt.Errorf("invalid target %q: expected http or https URL", opts.target))
+	}
+	if opts.rps <= 0 {
+		errs = append(errs, errors.New("rps must be positive"))
+	}
+	if opts.maxInFlight <= 0 {
+		errs = append(errs, errors.New("max in-flight requests must be positive"))
+	}
+	for name, f := range map[string]float64{
+		"error fraction":   opts.errorFraction,
+		"latency fraction": opts.latencyFraction,
+	} {
+		if f < 0 || f > 1 {
+			errs = append(errs, fmt.Errorf("%s %v out of range [0,1]", name, f))
+		}
+	}
+	return &opts, errors.Join(errs...)
+}
+
+func initTracerProvider(ctx context.Context, opts *options) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName("loadgen")),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		log.Printf("error detecting resource: %v", err)
+	}
+
+	var exporter sdktrace.SpanExporter
+	if opts.console {
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	} else {
+		var exporterOpts []otlptracegrpc.Option
+		if opts.otlpEndpoint != "" {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithEndpointURL(opts.otlpEndpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, exporterOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider, nil
+}
+
+// stats counts the outcomes of requests.
+type stats struct {
+	sent, succeeded, clientErrors, serverErrors, failed, dropped atomic.Int64
+}
+
+func (s *stats) String() string {
+	return fmt.Sprintf("sent=%d ok=%d 4xx=%d 5xx=%d failed=%d dropped=%d",
+		s.sent.Load(), s.succeeded.Load(), s.clientErrors.Load(),
+		s.serverErrors.Load(), s.failed.Load(), s.dropped.Load(),
+	)
+}
+
+// generator sends requests to the dice server.
+type generator struct {
+	opts   *options
+	client *http.Client
+	stats  stats
+	wg     sync.WaitGroup
+	slots  chan struct{}
+}
+
+func newGenerator(opts *options) *generator {
+	transport := injectTransport{
+		next:            http.DefaultTransport,
+		errorFraction:   opts.errorFraction,
+		latency:         opts.latency,
+		latencyFraction: opts.latencyFraction,
+	}
+	return &generator{
+		opts: opts,
+		client: &http.Client{
+			Timeout: opts.timeout,
+			Transport: otelhttp.NewTransport(transport,
+				otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+					return req.Method + " " + route(req.URL.Path)
+				}),
+			),
+		},
+		slots: make(chan struct{}, opts.maxInFlight),
+	}
+}
+
+// run sends requests at the rate given by rate until ctx is done,
+// and then waits for in-flight requests to complete.
+func (g *generator) run(ctx context.Context, rate profile) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	report := time.NewTicker(5 * time.Second)
+	defer report.Stop()
+
+	start := time.Now()
+	last := start
+	var due float64
+	for {
+		select {
+		case <-ctx.Done():
+			g.wg.Wait()
+			return
+		case now := <-report.C:
+			log.Printf("rate=%.1f/s %s", rate(now.Sub(start)), &g.stats)
+		case now := <-ticker.C:
+			// Accumulate fractional requests, so low rates
+			// and rate changes are scheduled accurately.
+			due += rate(now.Sub(start)) * now.Sub(last).Seconds()
+			last = now
+			for ; due >= 1; due-- {
+				g.send(ctx)
+			}
+		}
+	}
+}
+
+// send sends a request in the background, or drops
+// it if there are too many requests in flight.
+func (g *generator) send(ctx context.Context) {
+	select {
+	case g.slots <- struct{}{}:
+	default:
+		g.stats.dropped.Add(1)
+		return
+	}
+	g.stats.sent.Add(1)
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer func() { <-g.slots }()
+		// Let in-flight requests complete when stopping.
+		dice := g.opts.dice[rand.Intn(len(g.opts.dice))]
+		g.get(context.WithoutCancel(ctx), "/roll/"+url.PathEscape(dice))
+	}()
+}
+
+// route returns the server route for a request path, to name client
+// spans without including the (high cardinality) dice notation.
+func route(path string) string {
+	if strings.HasPrefix(path, "/roll/") {
+		return "/roll/:dice"
+	}
+	return path
+}
+
+// get sends a GET request for the path, recording its outcome.
+func (g *generator) get(ctx context.Context, path string) {
+	u := strings.TrimSuffix(g.opts.target, "/") + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		g.stats.failed.Add(1)
+		return
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		if !errors.Is(err, errInjected) {
+			log.Print(err)
+		}
+		g.stats.failed.Add(1)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 500:
+		g.stats.serverErrors.Add(1)
+	case resp.StatusCode >= 400:
+		g.stats.clientErrors.Add(1)
+	default:
+		g.stats.succeeded.Add(1)
+	}
+}
+
+func main() {
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	var sc *scenario
+	var rate profile
+	if opts.scenario != "" {
+		sc, err = loadScenario(opts.scenario)
+		if err == nil && opts.duration == 0 {
+			opts.duration = sc.Duration
+		}
+	} else {
+		rate, err = newProfile(opts.profile, opts.rps, opts.rampUp)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if opts.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.duration)
+		defer cancel()
+	}
+	tracerProvider, err := initTracerProvider(ctx, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := newGenerator(opts)
+	if sc != nil {
+		log.Printf("running scenario %s against %s", opts.scenario, opts.target)
+		g.runScenario(ctx, sc)
+	} else {
+		log.Printf("sending up to %v requests/s to %s (%s profile)", opts.rps, opts.target, opts.profile)
+		g.run(ctx, rate)
+	}
+	log.Printf("done: %s", &g.stats)
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(flushCtx); err != nil {
+		log.Printf("error flushing telemetry: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// profile returns the target request rate, in requests per second,
+// at the given time since the start of a run.
+type profile func(elapsed time.Duration) float64
+
+// rampSteps is the number of steps taken by the "step" profile.
+const rampSteps = 4
+
+// newProfile returns the named ramp-up profile, reaching rps
+// after rampUp:
+//
+//   - "constant" sends at rps from the start, ignoring rampUp;
+//   - "linear" increases the rate linearly from zero;
+//   - "step" increases the rate in equal steps; and
+//   - "spike" sends at a tenth of rps, with a burst at rps
+//     for one second in every rampUp.
+func newProfile(name string, rps float64, rampUp time.Duration) (profile, error) {
+	if rampUp <= 0 && name != "constant" {
+		return nil, fmt.Errorf("%s profile requires a positive ramp-up", name)
+	}
+	switch name {
+	case "constant":
+		return func(time.Duration) float64 { return rps }, nil
+	case "linear":
+		return func(elapsed time.Duration) float64 {
+			if elapsed >= rampUp {
+				return rps
+			}
+			return rps * float64(elapsed) / float64(rampUp)
+		}, nil
+	case "step":
+		return func(elapsed time.Duration) float64 {
+			if elapsed >= rampUp {
+				return rps
+			}
+			step := int(elapsed*rampSteps/rampUp) + 1
+			return rps * float64(step) / rampSteps
+		}, nil
+	case "spike":
+		return func(elapsed time.Duration) float64 {
+			if elapsed%rampUp < time.Second {
+				return rps
+			}
+			return rps / 10
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown profile %q: expected constant, linear, step, or spike", name)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scenario describes traffic to reproduce a particular pattern in the
+// telemetry, such as an error spike or a latency tail. Virtual users
+// each repeatedly send a request, chosen at random by weight, and then
+// wait for a think time before the next.
+type scenario struct {
+	// Duration is how long to run the scenario for. If zero, it runs
+	// until interrupted, or for the -duration flag if set.
+	Duration time.Duration `yaml:"duration"`
+
+	// Users is the number of virtual users throughout the scenario.
+	Users int `yaml:"users"`
+
+	// ThinkTime is the range of time each user waits between requests.
+	ThinkTime struct {
+		Min time.Duration `yaml:"min"`
+		Max time.Duration `yaml:"max"`
+	} `yaml:"think_time"`
+
+	// Requests holds the requests users choose between.
+	Requests []weightedRequest `yaml:"requests"`
+
+	// Bursts add users for a time.
+	Bursts []burst `yaml:"bursts"`
+}
+
+// weightedRequest is a request that a user chooses with
+// probability proportional to its weight.
+type weightedRequest struct {
+	// Path is the request path, e.g. /roll/2d6. Paths with dice
+	// notation that the server rejects, like /roll/0d6, or that
+	// trigger failures, like /roll/4d4 with -tetraphobic, produce
+	// errors in the telemetry.
+	Path   string `yaml:"path"`
+	Weight int    `yaml:"weight"`
+}
+
+// burst adds users starting At the time since the start of the
+// scenario, for Duration, repeating Every period if non-zero.
+type burst struct {
+	At       time.Duration `yaml:"at"`
+	Duration time.Duration `yaml:"duration"`
+	Every    time.Duration `yaml:"every"`
+	Users    int           `yaml:"users"`
+}
+
+func loadScenario(path string) (*scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sc scenario
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := sc.validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	return &sc, nil
+}
+
+func (sc *scenario) validate() error {
+	var errs []error
+	if sc.Users < 0 {
+		errs = append(errs, errors.New("users must be non-negative"))
+	}
+	if sc.ThinkTime.Min < 0 || sc.ThinkTime.Max < sc.ThinkTime.Min {
+		errs = append(errs, errors.New("think time must satisfy 0 <= min <= max"))
+	}
+	if len(sc.Requests) == 0 {
+		errs = append(errs, errors.New("no requests"))
+	}
+	for _, r := range sc.Requests {
+		if r.Weight <= 0 {
+			errs = append(errs, fmt.Errorf("request %s: weight must be positive", r.Path))
+		}
+		if len(r.Path) == 0 || r.Path[0] != '/' {
+			errs = append(errs, fmt.Errorf("request %q: path must start with /", r.Path))
+		}
+	}
+	for i, b := range sc.Bursts {
+		if b.At < 0 || b.Duration <= 0 || b.Users <= 0 {
+			errs = append(errs, fmt.Errorf("burst %d: expected non-negative at, and positive duration and users", i))
+		}
+		if b.Every != 0 && b.Every < b.Duration {
+			errs = append(errs, fmt.Errorf("burst %d: every must be at least the duration", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pick returns the path of a request chosen at random by weight.
+func (sc *scenario) pick() string {
+	var total int
+	for _, r := range sc.Requests {
+		total += r.Weight
+	}
+	n := rand.Intn(total)
+	for _, r := range sc.Requests {
+		if n < r.Weight {
+			return r.Path
+		}
+		n -= r.Weight
+	}
+	panic("unreachable")
+}
+
+// thinkTime returns a random think time.
+func (sc *scenario) thinkTime() time.Duration {
+	d := sc.ThinkTime.Min
+	if spread := sc.ThinkTime.Max - sc.ThinkTime.Min; spread > 0 {
+		d += time.Duration(rand.Int63n(int64(spread)))
+	}
+	return d
+}
+
+// runScenario runs the scenario until ctx is done, and then
+// waits for users to finish their in-flight requests.
+func (g *generator) runScenario(ctx context.Context, sc *scenario) {
+	var users sync.WaitGroup
+	var active atomic.Int64
+	startUsers := func(ctx context.Context, n int) {
+		for range n {
+			users.Add(1)
+			active.Add(1)
+			go func() {
+				defer users.Done()
+				defer active.Add(-1)
+				g.user(ctx, sc)
+			}()
+		}
+	}
+
+	startUsers(ctx, sc.Users)
+	for _, b := range sc.Bursts {
+		users.Add(1)
+		go func() {
+			defer users.Done()
+			next := time.Now().Add(b.At)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Until(next)):
+				}
+				log.Printf("burst of %d users for %s", b.Users, b.Duration)
+				burstCtx, cancel := context.WithTimeout(ctx, b.Duration)
+				startUsers(burstCtx, b.Users)
+				<-burstCtx.Done()
+				cancel()
+				if b.Every == 0 {
+					return
+				}
+				next = next.Add(b.Every)
+			}
+		}()
+	}
+
+	report := time.NewTicker(5 * time.Second)
+	defer report.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			users.Wait()
+			return
+		case <-report.C:
+			log.Printf("users=%d %s", active.Load(), &g.stats)
+		}
+	}
+}
+
+// user sends requests as a virtual user until ctx is done.
+func (g *generator) user(ctx context.Context, sc *scenario) {
+	for ctx.Err() == nil {
+		g.stats.sent.Add(1)
+		// Let in-flight requests complete when stopping.
+		g.get(context.WithoutCancel(ctx), sc.pick())
+		select {
+		case <-ctx.Done():
+		case <-time.After(sc.thinkTime()):
+		}
+	}
+}
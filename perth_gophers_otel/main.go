@@ -2,145 +2,81 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
-	"math/rand"
-	"net/http"
-	"strconv"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/labstack/echo/v4"
-	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
-	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
 	"go.opentelemetry.io/otel/propagation"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	"go.opentelemetry.io/otel/trace"
-)
 
-// newHTTPHandler returns an instrumented net/http.Handler.
-func newEcho() *echo.Echo {
-	r := echo.New()
-	r.Use(otelecho.Middleware("dice-server"))
-	r.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) (result error) {
-			span := trace.SpanFromContext(c.Request().Context())
-			defer func() {
-				if v := recover(); v != nil {
-					err := fmt.Errorf("panic: %v", v)
-					span.RecordError(err, trace.WithStackTrace(true))
-					span.SetStatus(codes.Error, "handler panicked")
-					result = echo.ErrInternalServerError
-				}
-			}()
-			if err := next(c); err != nil {
-				span.RecordError(err, trace.WithStackTrace(true))
-				return err
-			}
-			return nil
-		}
-	})
-
-	rollCounter, err := meter.Int64Counter("dice_rolls")
-	if err != nil {
-		panic(err)
-	}
-	r.GET("/roll/:dice", func(c echo.Context) error {
-		diceString := c.Param("dice")
-		nString, sidesString, ok := strings.Cut(diceString, "d")
-		if !ok {
-			return fmt.Errorf("expected dice notation like 2d20, got %s", diceString)
-		}
-		n, err := strconv.ParseInt(nString, 10, 8)
-		if err != nil {
-			return fmt.Errorf("expected dice notation like 2d20, got %s: %w", diceString, err)
-		}
-		sides, err := strconv.ParseInt(sidesString, 10, 8)
-		if err != nil {
-			return fmt.Errorf("expected dice notation like 2d20, got %s: %w", diceString, err)
-		}
-		if n == 4 || sides == 4 {
-			return fmt.Errorf("tetraphobic")
-		}
-		span := trace.SpanFromContext(c.Request().Context())
-		span.AddEvent("rolling dice", trace.WithAttributes(
-			attribute.Int64("n", n),
-			attribute.Int64("sides", sides),
-		))
-
-		zipf := rand.NewZipf(
-			rand.New(rand.NewSource(time.Now().UnixNano())), 2, 1,
-			uint64(sides)-1,
-		)
-
-		var sum int64
-		for range n {
-			//roll := 1 + rand.Int63n(sides)
-			roll := 1 + int64(zipf.Uint64()) // TODO use uniform distribution
-			rollCounter.Add(c.Request().Context(), 1, metric.WithAttributes(
-				// include the value as a dimension
-				attribute.Int64("value", roll),
-			))
-			sum += roll
-		}
-		return c.String(http.StatusOK, strconv.FormatInt(sum, 10)+"\n")
-	})
-	return r
-}
+	"oteldemo/config"
+	"oteldemo/dashboard"
+	"oteldemo/dice"
+	"oteldemo/toggle"
+)
 
 // BEGIN INIT METER PROVIDER OMIT
 
-// meter is initially a no-op, hot-swapped when a global MeterProvider is
-// registered by initMeterProvider.
-var meter = otel.Meter("my/package/name")
-
-func initMeterProvider() {
+func initMeterProvider(cfg config.Telemetry) *sdkmetric.MeterProvider {
 	// Set up a meter provider, exporting both to stdout and as OTLP.
 	const interval = 10 * time.Second
 	stdoutExporter, _ := stdoutmetric.New()
-	otlpExporter, _ := otlpmetricgrpc.New(
-		context.Background(),
-		otlpmetricgrpc.WithTemporalitySelector(
-			func(k sdkmetric.InstrumentKind) metricdata.Temporality {
-				// Send all metrics as deltas, which are simpler
-				// to deal with in Kibana.
-				return metricdata.DeltaTemporality
-			},
-		),
-	)
-	meterProvider := sdkmetric.NewMeterProvider(
-		sdkmetric.WithReader(
-			sdkmetric.NewPeriodicReader(stdoutExporter, sdkmetric.WithInterval(interval)),
-		),
+	opts := []sdkmetric.Option{
+		sdkmetric.WithResource(newResource(context.Background())),
+		// The console exporters are toggled at runtime, from the
+		// initial value of cfg.Console.
 		sdkmetric.WithReader(
 			sdkmetric.NewPeriodicReader(
-				otlpExporter,
+				toggledMetricExporter{stdoutExporter, []*toggle.Toggle{metricsExport, consoleExporters}},
 				sdkmetric.WithInterval(interval),
 			),
 		),
-	)
+	}
+	if cfg.Phase >= config.PhaseFull {
+		// The exporter's own metrics are recorded with the global
+		// MeterProvider, which forwards to meterProvider once set.
+		otlpExporter, _ := newOTLPMetricExporter(
+			context.Background(), cfg.OTLP, otel.GetMeterProvider(),
+			otlpmetricgrpc.WithTemporalitySelector(
+				func(k sdkmetric.InstrumentKind) metricdata.Temporality {
+					// Send all metrics as deltas, which are simpler
+					// to deal with in Kibana.
+					return metricdata.DeltaTemporality
+				},
+			),
+		)
+		opts = append(opts, sdkmetric.WithReader(
+			sdkmetric.NewPeriodicReader(
+				toggledMetricExporter{otlpExporter, []*toggle.Toggle{metricsExport}},
+				sdkmetric.WithInterval(interval),
+			),
+		))
+	}
+	if dash != nil {
+		opts = append(opts, sdkmetric.WithReader(dash.Reader()))
+	}
+	meterProvider := sdkmetric.NewMeterProvider(opts...)
 	otel.SetMeterProvider(meterProvider)
+	return meterProvider
 }
 
 // END INIT METER PROVIDER OMIT
 
 // BEGIN INIT TRACER PROVIDER OMIT
 
-// tracer is initially a no-op, hot-swapped when a global TracerProvider is
-// registered by initTracerProvider.
-var tracer = otel.Tracer("my/package/name")
-
 // initTracerProvider registers a global TracerProvider.
-func initTracerProvider() {
+func initTracerProvider(cfg config.Telemetry) *sdktrace.TracerProvider {
 	// Set up propagator, for injecting trace context into and extracting
 	// from HTTP headers, Kafka message headers, etc.
 	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
@@ -150,23 +86,165 @@ func initTracerProvider() {
 
 	// Set up a tracer provider, exporting both to stdout and as OTLP.
 	stdoutExporter, _ := stdouttrace.New(stdouttrace.WithPrettyPrint())
-	otlpExporter, _ := otlptracegrpc.New(context.Background())
-	_ = otlpExporter
-	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithSyncer(stdoutExporter),
-		sdktrace.WithBatcher(otlpExporter),
-	)
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(newResource(context.Background())),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	}
+	if cfg.Phase >= config.PhaseFull {
+		otlpExporter, _ := newOTLPSpanExporter(context.Background(), cfg.OTLP, otel.GetMeterProvider())
+		opts = append(opts, sdktrace.WithBatcher(otlpExporter))
+	}
+	opts = append(opts, spanExporters(stdoutExporter)...)
+	tracerProvider := sdktrace.NewTracerProvider(opts...)
 	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider
 }
 
 // END INIT TRACER PROVIDER OMIT
 
+// spanExporters returns options for the TracerProvider exporting spans
+// to stdoutExporter while the console exporters are toggled on, and
+// to the dashboard if enabled.
+func spanExporters(stdoutExporter sdktrace.SpanExporter) []sdktrace.TracerProviderOption {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSyncer(toggledSpanExporter{stdoutExporter, []*toggle.Toggle{consoleExporters}}),
+	}
+	if dash != nil {
+		opts = append(opts, sdktrace.WithSyncer(dash))
+	}
+	return opts
+}
+
+// reloadOnHangup reloads the server's configuration
+// on SIGHUP, until ctx is cancelled.
+func reloadOnHangup(ctx context.Context, srv *dice.Server) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := srv.Reload(); err != nil {
+				log.Printf("error reloading configuration: %v", err)
+			}
+		}
+	}
+}
+
+// dash is the terminal dashboard, if enabled.
+var dash *dashboard.Dashboard
+
 func main() {
-	initMeterProvider()
-	initTracerProvider()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Telemetry.Replay) > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := replay(ctx, cfg.Telemetry); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if cfg.Telemetry.Smoke {
+		if err := smoke(context.Background(), cfg, os.Stdout); err != nil {
+			log.Fatalf("smoke test failed: %v", err)
+		}
+		return
+	}
+	// Without OTLP export, the console is the only place to see telemetry.
+	consoleExporters.Set(cfg.Telemetry.Console || cfg.Telemetry.Phase < config.PhaseFull)
+	if cfg.Telemetry.Dashboard {
+		dash = dashboard.New()
+		consoleExporters.Set(false)
+		log.SetOutput(dash)
+	}
+	// The sampler is adjusted at runtime, from the initial ratio,
+	// when the configuration is reloaded.
+	sampler.SetRatio(cfg.Telemetry.SamplerRatio)
+	var shutdownTelemetry func(context.Context) error
+	switch {
+	case cfg.Telemetry.ConfigFile != "":
+		shutdownTelemetry, err = initFromOTelConfig(cfg.Telemetry.ConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case cfg.Telemetry.Phase == config.PhaseUninstrumented:
+		shutdownTelemetry = func(context.Context) error { return nil }
+	case cfg.Telemetry.Phase == config.PhaseTraces:
+		tracerProvider := initTracerProvider(cfg.Telemetry)
+		shutdownTelemetry = tracerProvider.Shutdown
+	default:
+		meterProvider := initMeterProvider(cfg.Telemetry)
+		tracerProvider := initTracerProvider(cfg.Telemetry)
+		shutdownTelemetry = func(ctx context.Context) error {
+			// Shut down the tracer provider first, so metrics
+			// recorded while ending spans are flushed.
+			return errors.Join(
+				tracerProvider.Shutdown(ctx),
+				meterProvider.Shutdown(ctx),
+			)
+		}
+	}
 
-	r := newEcho()
-	if err := r.Start("localhost:8080"); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Telemetry.OTLP.StartupCheck && cfg.Telemetry.Phase >= config.PhaseFull && cfg.Telemetry.ConfigFile == "" {
+		startOTLPCheck(ctx, cfg.Telemetry.OTLP)
+	}
+	opts := []dice.Option{
+		dice.WithConfig(cfg),
+		dice.WithConfigLoader(func() (*config.Config, error) {
+			return config.Load(os.Args[1:])
+		}),
+		dice.WithTelemetryDescription(func() any {
+			return describePipeline(cfg.Telemetry)
+		}),
+	}
+	if cfg.Telemetry.ConfigFile == "" {
+		opts = append(opts, dice.WithReloadHook(func(cfg *config.Config) {
+			sampler.SetRatio(cfg.Telemetry.SamplerRatio)
+		}))
+	}
+	if err := initFeatureFlags(cfg.Features.FlagsFile); err != nil {
 		log.Fatal(err)
 	}
+	opts = append(opts, dice.WithReloadHook(func(cfg *config.Config) {
+		if err := initFeatureFlags(cfg.Features.FlagsFile); err != nil {
+			log.Printf("error reloading feature flags: %v", err)
+		}
+	}))
+	if cfg.Telemetry.Phase < config.PhaseMetrics && cfg.Telemetry.ConfigFile == "" {
+		// Let the server know metrics are off, so it can skip them.
+		opts = append(opts, dice.WithMeterProvider(metricnoop.NewMeterProvider()))
+	}
+	for name, check := range readinessChecks {
+		opts = append(opts, dice.WithReadinessCheck(name, check))
+	}
+	srv, err := dice.New(opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go reloadOnHangup(ctx, srv)
+	if dash != nil {
+		go dash.Run(ctx, os.Stdout)
+	}
+	serveErr := srv.Serve(ctx)
+	stop()
+	log.SetOutput(os.Stderr)
+
+	log.Print("flushing telemetry")
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTelemetry(flushCtx); err != nil {
+		log.Printf("error flushing telemetry: %v", err)
+	}
+	if serveErr != nil {
+		log.Fatal(serveErr)
+	}
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// newDeltaMeter returns a Meter whose measurements are read with delta
+// temporality, as by the OTLP reader set up by initMeterProvider. The
+// reader is collected every collectEvery measurements, as a periodic
+// reader would, so the delta aggregations are reset.
+func newDeltaMeter(b *testing.B) (metric.Meter, func()) {
+	reader := sdkmetric.NewManualReader(sdkmetric.WithTemporalitySelector(
+		func(sdkmetric.InstrumentKind) metricdata.Temporality { return metricdata.DeltaTemporality },
+	))
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	b.Cleanup(func() { mp.Shutdown(context.Background()) })
+	collect := func() {
+		var rm metricdata.ResourceMetrics
+		if err := reader.Collect(context.Background(), &rm); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return mp.Meter("bench"), collect
+}
+
+// collectEvery is the number of measurements between collections.
+const collectEvery = 10000
+
+// BenchmarkCounterAdd measures adding to a counter, as for dice_rolls:
+//
+//   - no-attrs: without attributes;
+//   - precomputed: with an attribute option created once;
+//   - per-call: with an attribute option created for each call,
+//     as the roll handler does for the value rolled.
+func BenchmarkCounterAdd(b *testing.B) {
+	ctx := context.Background()
+	b.Run("no-attrs", func(b *testing.B) {
+		meter, collect := newDeltaMeter(b)
+		counter, _ := meter.Int64Counter("dice_rolls")
+		b.ReportAllocs()
+		for i := range b.N {
+			counter.Add(ctx, 1)
+			if i%collectEvery == 0 {
+				collect()
+			}
+		}
+	})
+	b.Run("precomputed", func(b *testing.B) {
+		meter, collect := newDeltaMeter(b)
+		counter, _ := meter.Int64Counter("dice_rolls")
+		opt := metric.WithAttributes(attribute.Int64("value", 4))
+		b.ReportAllocs()
+		for i := range b.N {
+			counter.Add(ctx, 1, opt)
+			if i%collectEvery == 0 {
+				collect()
+			}
+		}
+	})
+	b.Run("per-call", func(b *testing.B) {
+		meter, collect := newDeltaMeter(b)
+		counter, _ := meter.Int64Counter("dice_rolls")
+		b.ReportAllocs()
+		for i := range b.N {
+			counter.Add(ctx, 1, metric.WithAttributes(attribute.Int64("value", int64(1+i%6))))
+			if i%collectEvery == 0 {
+				collect()
+			}
+		}
+	})
+}
+
+// BenchmarkHistogramRecord measures recording a request duration, with
+// the buckets and attributes of http.server.request.duration.
+func BenchmarkHistogramRecord(b *testing.B) {
+	ctx := context.Background()
+	meter, collect := newDeltaMeter(b)
+	histogram, _ := meter.Float64Histogram("http.server.request.duration",
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(
+			0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10,
+		),
+	)
+	b.ReportAllocs()
+	for i := range b.N {
+		histogram.Record(ctx, float64(i%1000)/1000, metric.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(http.MethodGet),
+			semconv.HTTPRoute("/roll/:dice"),
+			semconv.HTTPResponseStatusCode(http.StatusOK),
+		))
+		if i%collectEvery == 0 {
+			collect()
+		}
+	}
+}
+
+// Benchmark results are assigned to sinks,
+// so the compiler does not optimize them away.
+var (
+	kvSink  []attribute.KeyValue
+	setSink attribute.Set
+	optSink metric.MeasurementOption
+)
+
+// BenchmarkAttributes measures constructing the attributes
+// of a request measurement, in the ways the API allows.
+func BenchmarkAttributes(b *testing.B) {
+	attrs := func() []attribute.KeyValue {
+		return []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(http.MethodGet),
+			semconv.HTTPRoute("/roll/:dice"),
+			semconv.HTTPResponseStatusCode(http.StatusOK),
+		}
+	}
+	b.Run("key-values", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			kvSink = attrs()
+		}
+	})
+	b.Run("set", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			setSink = attribute.NewSet(attrs()...)
+		}
+	})
+	b.Run("with-attributes", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			optSink = metric.WithAttributes(attrs()...)
+		}
+	})
+	b.Run("with-attribute-set", func(b *testing.B) {
+		set := attribute.NewSet(attrs()...)
+		b.ReportAllocs()
+		for range b.N {
+			optSink = metric.WithAttributeSet(set)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"oteldemo/middleware"
+)
+
+// BenchmarkErrorMiddleware measures the error handling middleware stack,
+// for requests that succeed and that fail, with Recover and RecordErrors
+// installed separately, and with them consolidated by Errors.
+func BenchmarkErrorMiddleware(b *testing.B) {
+	stacks := []struct {
+		name string
+		mw   []echo.MiddlewareFunc
+	}{
+		{"separate", []echo.MiddlewareFunc{middleware.RecordErrors, middleware.Recover()}},
+		{"consolidated", []echo.MiddlewareFunc{middleware.Errors(middleware.RecoverConfig{})}},
+	}
+	for _, stack := range stacks {
+		for _, target := range []string{"/ok", "/error"} {
+			b.Run(stack.name+target, func(b *testing.B) {
+				tp := sdktrace.NewTracerProvider()
+				b.Cleanup(func() { tp.Shutdown(context.Background()) })
+				r := echo.New()
+				r.Use(otelecho.Middleware("bench", otelecho.WithTracerProvider(tp)))
+				r.Use(stack.mw...)
+				r.GET("/ok", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+				r.GET("/error", func(echo.Context) error { return errors.New("oops") })
+				req := httptest.NewRequest(http.MethodGet, target, nil)
+				b.ReportAllocs()
+				for range b.N {
+					r.ServeHTTP(httptest.NewRecorder(), req)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	echomiddleware "github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/attrset"
+	"oteldemo/clock"
+)
+
+// instrumentationName identifies the package's instrumentation scope.
+const instrumentationName = "oteldemo/middleware"
+
+// MetricsConfig configures the Metrics middleware.
+type MetricsConfig struct {
+	// MeterProvider is used to create the middleware's instruments.
+	// If nil, the global MeterProvider is used.
+	MeterProvider metric.MeterProvider
+
+	// Skipper, if non-nil, skips recording metrics for some requests,
+	// such as health checks.
+	Skipper echomiddleware.Skipper
+
+	// Clock is used to measure request durations.
+	// If nil, clock.Real is used.
+	Clock clock.Clock
+
+	// SemconvStability selects whether the request duration is also
+	// recorded following the old conventions, for SemconvDuplicate.
+	// The metrics otherwise follow the stable conventions, whatever the
+	// stability, as no dashboards were built on the old.
+	SemconvStability SemconvStability
+}
+
+// Metrics returns middleware recording HTTP server metrics following
+// the OpenTelemetry semantic conventions:
+//
+//   - http.server.request.duration, a histogram of request durations
+//     by method, route and response status code; and
+//   - http.server.active_requests, the number of requests being served,
+//     by method.
+//
+// Methods other than those defined by RFC 9110 and RFC 5789 are recorded
+// as "_OTHER", so clients can't create unbounded metric streams, with
+// the method recorded by http.request.method_original on the span.
+//
+// Errors returned by handlers are handled by the middleware, with
+// echo.Context.Error, so that their final response status is recorded.
+// It should therefore be installed after otelecho, so the error handler
+// runs with the request span active.
+//
+// The metrics follow the stable conventions whatever cfg.SemconvStability,
+// which only selects the conventions of span attributes, but for
+// SemconvDuplicate request durations are also recorded by
+// http.server.duration, in milliseconds, by the old method, route and
+// status code attributes.
+func Metrics(cfg MetricsConfig) (echo.MiddlewareFunc, error) {
+	mp := cfg.MeterProvider
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	meter := mp.Meter(instrumentationName)
+	duration, err := meter.Float64Histogram(
+		"http.server.request.duration",
+		metric.WithDescription("Duration of HTTP server requests"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(
+			0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10,
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+	active, err := meter.Int64UpDownCounter(
+		"http.server.active_requests",
+		metric.WithDescription("Number of active HTTP server requests"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var oldDuration metric.Float64Histogram
+	if cfg.SemconvStability == SemconvDuplicate {
+		oldDuration, err = meter.Float64Histogram(
+			"http.server.duration",
+			metric.WithDescription("Duration of HTTP server requests"),
+			metric.WithUnit("ms"),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	methodAttrs := attrset.New(maxMethods, func(method string) []attribute.KeyValue {
+		return []attribute.KeyValue{semconv.HTTPRequestMethodKey.String(method)}
+	})
+	durationAttrs := attrset.New(maxRequestAttrs, requestAttrs.attributes)
+	oldDurationAttrs := attrset.New(maxRequestAttrs, requestAttrs.oldAttributes)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cfg.Skipper != nil && cfg.Skipper(c) {
+				return next(c)
+			}
+			ctx := c.Request().Context()
+			method, known := requestMethod(c.Request().Method)
+			if !known {
+				trace.SpanFromContext(ctx).SetAttributes(semconv.HTTPRequestMethodOriginal(c.Request().Method))
+			}
+			active.Add(ctx, 1, methodAttrs.Option(method))
+			defer active.Add(ctx, -1, methodAttrs.Option(method))
+
+			start := clk.Now()
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+			a := requestAttrs{
+				method: method,
+				route:  c.Path(),
+				status: c.Response().Status,
+			}
+			elapsed := clock.Since(clk, start)
+			duration.Record(ctx, elapsed.Seconds(), durationAttrs.Option(a))
+			if oldDuration != nil {
+				oldDuration.Record(ctx, float64(elapsed)/float64(time.Millisecond), oldDurationAttrs.Option(a))
+			}
+			return err
+		}
+	}, nil
+}
+
+// The number of attribute sets cached for the request metrics:
+// one for each known method, and one for the rest.
+const (
+	maxMethods      = 10
+	maxRequestAttrs = 1024
+)
+
+// knownMethods holds the methods recorded by http.request.method.
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPatch:   true,
+}
+
+// requestMethod returns the value of http.request.method for a request
+// method, and whether it's known. Unknown methods are "_OTHER".
+func requestMethod(method string) (string, bool) {
+	if knownMethods[method] {
+		return method, true
+	}
+	return semconv.HTTPRequestMethodOther.Value.AsString(), false
+}
+
+// requestAttrs are the attributes of a request duration measurement.
+type requestAttrs struct {
+	method string
+	route  string
+	status int
+}
+
+func (a requestAttrs) attributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(a.method),
+		semconv.HTTPRoute(a.route),
+		semconv.HTTPResponseStatusCode(a.status),
+	}
+	if a.status >= 500 {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(strconv.Itoa(a.status)))
+	}
+	return attrs
+}
+
+// oldAttributes returns the attributes of a request duration
+// measurement following the old conventions.
+func (a requestAttrs) oldAttributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("http.method", a.method),
+		semconv.HTTPRoute(a.route),
+		attribute.Int("http.status_code", a.status),
+	}
+}
@@ -0,0 +1,171 @@
+// Package middleware provides echo middleware for instrumenting HTTP
+// servers with OpenTelemetry, complementing the spans created by otelecho:
+//
+//   - Recover recovers from panics in handlers, recording them to the span
+//     with their stack trace.
+//   - RecordErrors records errors returned by handlers to the span.
+//   - Errors does both, with a single deferred call per request.
+//   - Metrics records request durations and the number of active requests.
+//
+// They should be installed after otelecho, in this order:
+//
+//	r.Use(otelecho.Middleware("my-service"))
+//	r.Use(metrics) // from middleware.Metrics
+//	r.Use(middleware.Errors(middleware.RecoverConfig{}))
+//
+// or, with separate middleware in between:
+//
+//	r.Use(otelecho.Middleware("my-service"))
+//	r.Use(metrics) // from middleware.Metrics
+//	r.Use(middleware.RecordErrors)
+//	r.Use(middleware.Recover())
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxGoroutineDumpBytes is the maximum size of goroutine
+// dumps attached to spans, to keep spans exportable.
+const maxGoroutineDumpBytes = 16 << 10
+
+// PanicError is returned by the Recover middleware for handler panics,
+// so they can be distinguished from errors returned by handlers.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// RecoverConfig configures the Recover middleware.
+type RecoverConfig struct {
+	// GoroutineDumps, if non-nil, is called for each panic to decide
+	// whether to attach a (truncated) dump of all goroutines to the span,
+	// as the "goroutine_dump" attribute. Dumps are expensive, so this is
+	// intended for debugging.
+	GoroutineDumps func() bool
+}
+
+// Recover returns middleware that recovers from panics in handlers,
+// recording them to the span, and returning a *PanicError.
+func Recover() echo.MiddlewareFunc {
+	return RecoverWithConfig(RecoverConfig{})
+}
+
+// RecoverWithConfig returns Recover middleware with the given configuration.
+func RecoverWithConfig(cfg RecoverConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (result error) {
+			span := trace.SpanFromContext(c.Request().Context())
+			defer func() {
+				if v := recover(); v != nil {
+					result = recordPanic(span, v, cfg)
+				}
+			}()
+			return next(c)
+		}
+	}
+}
+
+// Errors returns middleware that recovers from panics in handlers, as
+// Recover does, and records errors returned by handlers, as RecordErrors
+// does, with a single deferred call per request rather than one for
+// each, and checking each error once.
+//
+// As with net/http, panics with http.ErrAbortHandler are not recovered,
+// so they abort the response.
+func Errors(cfg RecoverConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (result error) {
+			span := trace.SpanFromContext(c.Request().Context())
+			defer func() {
+				if v := recover(); v != nil {
+					result = recordPanic(span, v, cfg)
+				} else if result != nil && !IsRecorded(result) {
+					span.RecordError(result, trace.WithStackTrace(true))
+					result = recordedError{result}
+				}
+			}()
+			return next(c)
+		}
+	}
+}
+
+// recordPanic records a recovered panic to the span, from the deferred
+// call that recovered it, so the stack trace includes the panic. It
+// returns the recorded *PanicError. Panics with http.ErrAbortHandler
+// are re-panicked.
+func recordPanic(span trace.Span, v any, cfg RecoverConfig) error {
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	err := &PanicError{v}
+	span.RecordError(err, trace.WithStackTrace(true))
+	span.SetStatus(codes.Error, "handler panicked")
+	if cfg.GoroutineDumps != nil && cfg.GoroutineDumps() && span.IsRecording() {
+		span.SetAttributes(attribute.String("goroutine_dump", goroutineDump()))
+	}
+	return recordedError{err}
+}
+
+// goroutineDump returns a dump of all goroutines,
+// truncated to maxGoroutineDumpBytes.
+func goroutineDump() string {
+	buf := make([]byte, maxGoroutineDumpBytes)
+	n := runtime.Stack(buf, true)
+	dump := string(buf[:n])
+	if n == len(buf) {
+		dump += "\n... truncated"
+	}
+	return dump
+}
+
+// recordedError wraps an error that has already been
+// recorded to the span, so it is not recorded again.
+type recordedError struct {
+	error
+}
+
+func (e recordedError) Unwrap() error {
+	return e.error
+}
+
+// Recorded returns err wrapped to indicate that it has already been
+// recorded to the span, so RecordErrors does not record it again.
+func Recorded(err error) error {
+	if err == nil || IsRecorded(err) {
+		return err
+	}
+	return recordedError{err}
+}
+
+// IsRecorded reports whether err has been recorded to the span.
+func IsRecorded(err error) bool {
+	return errors.As(err, new(recordedError))
+}
+
+// RecordErrors is middleware that records errors returned by handlers to
+// the span, with a stack trace, unless they have already been recorded.
+// The span status is left to otelecho, which sets it from the response
+// status code; client errors are not span errors.
+func RecordErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err != nil && !IsRecorded(err) {
+			trace.SpanFromContext(c.Request().Context()).RecordError(err, trace.WithStackTrace(true))
+			err = Recorded(err)
+		}
+		return err
+	}
+}
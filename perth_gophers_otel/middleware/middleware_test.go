@@ -0,0 +1,307 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+
+	"oteldemo/middleware"
+)
+
+// newEcho returns an echo.Echo instrumented with otelecho and the
+// middleware under test, recording spans to the returned recorder.
+func newEcho(t *testing.T, mw ...echo.MiddlewareFunc) (*echo.Echo, *tracetest.SpanRecorder) {
+	t.Helper()
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	r := echo.New()
+	r.Use(otelecho.Middleware("test", otelecho.WithTracerProvider(tp)))
+	r.Use(mw...)
+	return r, sr
+}
+
+func serve(r *echo.Echo, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// exceptionEvents returns the number of exception events on the only
+// span recorded by sr.
+func exceptionEvents(t *testing.T, sr *tracetest.SpanRecorder) int {
+	t.Helper()
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	var n int
+	for _, event := range spans[0].Events() {
+		if event.Name == semconv.ExceptionEventName {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRecover(t *testing.T) {
+	var handled error
+	r, sr := newEcho(t, middleware.RecordErrors, middleware.Recover())
+	r.HTTPErrorHandler = func(err error, c echo.Context) {
+		handled = err
+		r.DefaultHTTPErrorHandler(err, c)
+	}
+	r.GET("/", func(echo.Context) error { panic("oops") })
+
+	if rec := serve(r, "/"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	var panicErr *middleware.PanicError
+	if !errors.As(handled, &panicErr) {
+		t.Fatalf("got error %v, want *PanicError", handled)
+	}
+	if panicErr.Value != "oops" {
+		t.Errorf("got panic value %v, want oops", panicErr.Value)
+	}
+	if !middleware.IsRecorded(handled) {
+		t.Error("panic error not marked as recorded")
+	}
+	// The panic is recorded once, by Recover, not again by RecordErrors.
+	if n := exceptionEvents(t, sr); n != 1 {
+		t.Errorf("got %d exception events, want 1", n)
+	}
+}
+
+func TestRecoverGoroutineDumps(t *testing.T) {
+	r, sr := newEcho(t, middleware.RecoverWithConfig(middleware.RecoverConfig{
+		GoroutineDumps: func() bool { return true },
+	}))
+	r.GET("/", func(echo.Context) error { panic("oops") })
+	serve(r, "/")
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	for _, kv := range spans[0].Attributes() {
+		if kv.Key == "goroutine_dump" {
+			if len(kv.Value.AsString()) == 0 {
+				t.Error("empty goroutine dump")
+			}
+			return
+		}
+	}
+	t.Error("goroutine_dump attribute not set")
+}
+
+func TestRecordErrors(t *testing.T) {
+	r, sr := newEcho(t, middleware.RecordErrors)
+	r.GET("/", func(echo.Context) error { return errors.New("oops") })
+	serve(r, "/")
+	if n := exceptionEvents(t, sr); n != 1 {
+		t.Errorf("got %d exception events, want 1", n)
+	}
+}
+
+func TestRecordErrorsSkipsRecorded(t *testing.T) {
+	r, sr := newEcho(t, middleware.RecordErrors)
+	r.GET("/", func(echo.Context) error {
+		return middleware.Recorded(errors.New("oops"))
+	})
+	serve(r, "/")
+	if n := exceptionEvents(t, sr); n != 0 {
+		t.Errorf("got %d exception events, want 0", n)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		handler    echo.HandlerFunc
+		panicked   bool
+		exceptions int
+	}{
+		{"panic", func(echo.Context) error { panic("oops") }, true, 1},
+		{"error", func(echo.Context) error { return errors.New("oops") }, false, 1},
+		{"recorded", func(echo.Context) error { return middleware.Recorded(errors.New("oops")) }, false, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var handled error
+			r, sr := newEcho(t, middleware.Errors(middleware.RecoverConfig{}))
+			r.HTTPErrorHandler = func(err error, c echo.Context) {
+				handled = err
+				r.DefaultHTTPErrorHandler(err, c)
+			}
+			r.GET("/", test.handler)
+			serve(r, "/")
+
+			if !middleware.IsRecorded(handled) {
+				t.Errorf("error %v not marked as recorded", handled)
+			}
+			if panicked := errors.As(handled, new(*middleware.PanicError)); panicked != test.panicked {
+				t.Errorf("got *PanicError %v, want %v", panicked, test.panicked)
+			}
+			if n := exceptionEvents(t, sr); n != test.exceptions {
+				t.Errorf("got %d exception events, want %d", n, test.exceptions)
+			}
+		})
+	}
+}
+
+func TestErrorsAbortHandler(t *testing.T) {
+	r, _ := newEcho(t, middleware.Errors(middleware.RecoverConfig{}))
+	r.GET("/", func(echo.Context) error { panic(http.ErrAbortHandler) })
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("got panic %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	serve(r, "/")
+	t.Error("http.ErrAbortHandler was recovered")
+}
+
+func TestRecorded(t *testing.T) {
+	if err := middleware.Recorded(nil); err != nil {
+		t.Errorf("Recorded(nil) = %v, want nil", err)
+	}
+	base := errors.New("oops")
+	err := middleware.Recorded(base)
+	if !errors.Is(err, base) {
+		t.Errorf("Recorded(err) does not wrap err")
+	}
+	if err.Error() != base.Error() {
+		t.Errorf("got message %q, want %q", err.Error(), base.Error())
+	}
+	if middleware.IsRecorded(base) {
+		t.Error("IsRecorded(base) = true, want false")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	metrics, err := middleware.Metrics(middleware.MetricsConfig{
+		MeterProvider: mp,
+		Skipper:       func(c echo.Context) bool { return c.Path() == "/healthz" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := newEcho(t, metrics)
+	r.GET("/ok/:id", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	r.GET("/fail", func(echo.Context) error { return errors.New("oops") })
+	r.GET("/healthz", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	serve(r, "/ok/1")
+	serve(r, "/ok/2")
+	serve(r, "/fail")
+	serve(r, "/healthz")
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+
+	duration, ok := got["http.server.request.duration"].(metricdata.Histogram[float64])
+	if !ok {
+		t.Fatalf("http.server.request.duration not recorded as a float64 histogram")
+	}
+	counts := make(map[string]uint64)
+	for _, dp := range duration.DataPoints {
+		route, _ := dp.Attributes.Value(semconv.HTTPRouteKey)
+		status, _ := dp.Attributes.Value(semconv.HTTPResponseStatusCodeKey)
+		errorType, hasErrorType := dp.Attributes.Value(semconv.ErrorTypeKey)
+		switch route.AsString() {
+		case "/ok/:id":
+			if status.AsInt64() != http.StatusOK || hasErrorType {
+				t.Errorf("unexpected attributes for /ok/:id: %v", dp.Attributes.ToSlice())
+			}
+		case "/fail":
+			if status.AsInt64() != http.StatusInternalServerError || errorType.AsString() != "500" {
+				t.Errorf("unexpected attributes for /fail: %v", dp.Attributes.ToSlice())
+			}
+		}
+		counts[route.AsString()] += dp.Count
+	}
+	want := map[string]uint64{"/ok/:id": 2, "/fail": 1}
+	if len(counts) != len(want) || counts["/ok/:id"] != 2 || counts["/fail"] != 1 {
+		t.Errorf("got request counts %v, want %v", counts, want)
+	}
+
+	active, ok := got["http.server.active_requests"].(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("http.server.active_requests not recorded as an int64 sum")
+	}
+	for _, dp := range active.DataPoints {
+		if dp.Value != 0 {
+			method, _ := dp.Attributes.Value(semconv.HTTPRequestMethodKey)
+			t.Errorf("got %d active %s requests, want 0", dp.Value, method.AsString())
+		}
+	}
+	if len(active.DataPoints) != 1 {
+		t.Errorf("got %d active request data points, want 1 (GET)", len(active.DataPoints))
+	}
+}
+
+func TestMetricsUnknownMethod(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	metrics, err := middleware.Metrics(middleware.MetricsConfig{MeterProvider: mp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, sr := newEcho(t, metrics)
+	r.Add("PURGE", "/cache", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PURGE", "/cache", nil))
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	var methods []string
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "http.server.request.duration" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints {
+				method, _ := dp.Attributes.Value(semconv.HTTPRequestMethodKey)
+				methods = append(methods, method.AsString())
+			}
+		}
+	}
+	if len(methods) != 1 || methods[0] != "_OTHER" {
+		t.Errorf("got request duration methods %q, want [_OTHER]", methods)
+	}
+
+	// The method is recorded on the span.
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	var original string
+	for _, kv := range spans[0].Attributes() {
+		if kv.Key == semconv.HTTPRequestMethodOriginalKey {
+			original = kv.Value.AsString()
+		}
+	}
+	if original != "PURGE" {
+		t.Errorf("got http.request.method_original %q, want PURGE", original)
+	}
+}
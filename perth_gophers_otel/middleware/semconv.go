@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SemconvStabilityOptIn is the environment variable selecting the HTTP
+// semantic conventions emitted while migrating to the stable
+// conventions; see ParseSemconvStability.
+const SemconvStabilityOptIn = "OTEL_SEMCONV_STABILITY_OPT_IN"
+
+// SemconvStability selects the HTTP semantic conventions of span
+// attributes. The metrics recorded by Metrics follow the stable
+// conventions whatever the stability; see MetricsConfig.
+type SemconvStability int
+
+const (
+	// SemconvOld emits the conventions otelecho follows, from before
+	// HTTP was stabilized. This is the default.
+	SemconvOld SemconvStability = iota
+
+	// SemconvStable emits the stable HTTP conventions.
+	SemconvStable
+
+	// SemconvDuplicate emits both the old and stable conventions, so
+	// dashboards and alerts can be migrated before the old are dropped.
+	SemconvDuplicate
+)
+
+// ParseSemconvStability parses the value of OTEL_SEMCONV_STABILITY_OPT_IN:
+// a comma-separated list, in which "http" selects SemconvStable, and
+// "http/dup" SemconvDuplicate, taking precedence. Other values opt in to
+// other conventions, and are ignored.
+func ParseSemconvStability(optIn string) SemconvStability {
+	stability := SemconvOld
+	for _, v := range strings.Split(optIn, ",") {
+		switch strings.TrimSpace(v) {
+		case "http/dup":
+			return SemconvDuplicate
+		case "http":
+			stability = SemconvStable
+		}
+	}
+	return stability
+}
+
+// String returns the opt-in value selecting s, or "" for SemconvOld.
+func (s SemconvStability) String() string {
+	switch s {
+	case SemconvStable:
+		return "http"
+	case SemconvDuplicate:
+		return "http/dup"
+	}
+	return ""
+}
+
+// stableKeys maps the attributes of HTTP server spans recorded by
+// otelecho, following v1.20 of the conventions, to their stable names.
+var stableKeys = map[attribute.Key]attribute.Key{
+	"http.method":          "http.request.method",
+	"http.scheme":          "url.scheme",
+	"http.target":          "url.path", // and url.query; see translate
+	"http.status_code":     "http.response.status_code",
+	"http.client_ip":       "client.address",
+	"net.host.name":        "server.address",
+	"net.host.port":        "server.port",
+	"net.sock.peer.addr":   "network.peer.address",
+	"net.sock.peer.port":   "network.peer.port",
+	"net.protocol.name":    "network.protocol.name",
+	"net.protocol.version": "network.protocol.version",
+}
+
+// translate returns attrs with the old HTTP attributes renamed or
+// duplicated, following s.
+func (s SemconvStability) translate(attrs []attribute.KeyValue) []attribute.KeyValue {
+	n := len(attrs)
+	if s == SemconvDuplicate {
+		n *= 2
+	}
+	translated := make([]attribute.KeyValue, 0, n)
+	for _, kv := range attrs {
+		key, ok := stableKeys[kv.Key]
+		if !ok || s == SemconvDuplicate {
+			translated = append(translated, kv)
+		}
+		if !ok {
+			continue
+		}
+		value := kv.Value
+		switch key {
+		case semconv.URLPathKey:
+			// http.target holds the query too, which
+			// the stable conventions record separately.
+			path, query, hasQuery := strings.Cut(value.AsString(), "?")
+			value = attribute.StringValue(path)
+			if hasQuery {
+				translated = append(translated, semconv.URLQuery(query))
+			}
+		case semconv.HTTPRequestMethodKey:
+			// The stable conventions bound the methods recorded.
+			if method, known := requestMethod(value.AsString()); !known {
+				value = attribute.StringValue(method)
+				translated = append(translated, semconv.HTTPRequestMethodOriginal(kv.Value.AsString()))
+			}
+		}
+		translated = append(translated, attribute.KeyValue{Key: key, Value: value})
+	}
+	return translated
+}
+
+// SemconvTracerProvider returns a TracerProvider wrapping tp, whose spans
+// have the HTTP attributes set by otelecho, and by middleware correcting
+// them, renamed or duplicated following stability. The span put in the
+// context by Start is wrapped, so attributes set by later middleware on
+// the span from the context are translated too. For SemconvOld, tp is
+// returned.
+func SemconvTracerProvider(tp trace.TracerProvider, stability SemconvStability) trace.TracerProvider {
+	if stability == SemconvOld {
+		return tp
+	}
+	return semconvTracerProvider{TracerProvider: tp, stability: stability}
+}
+
+type semconvTracerProvider struct {
+	trace.TracerProvider
+	stability SemconvStability
+}
+
+func (tp semconvTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return semconvTracer{Tracer: tp.TracerProvider.Tracer(name, opts...), stability: tp.stability}
+}
+
+type semconvTracer struct {
+	trace.Tracer
+	stability SemconvStability
+}
+
+func (t semconvTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	// Options can't be removed, so rebuild them from their config.
+	cfg := trace.NewSpanStartConfig(opts...)
+	opts = []trace.SpanStartOption{
+		trace.WithAttributes(t.stability.translate(cfg.Attributes())...),
+		trace.WithLinks(cfg.Links()...),
+		trace.WithSpanKind(cfg.SpanKind()),
+	}
+	if !cfg.Timestamp().IsZero() {
+		opts = append(opts, trace.WithTimestamp(cfg.Timestamp()))
+	}
+	if cfg.NewRoot() {
+		opts = append(opts, trace.WithNewRoot())
+	}
+	ctx, span := t.Tracer.Start(ctx, name, opts...)
+	span = semconvSpan{Span: span, stability: t.stability}
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type semconvSpan struct {
+	trace.Span
+	stability SemconvStability
+}
+
+func (s semconvSpan) SetAttributes(attrs ...attribute.KeyValue) {
+	s.Span.SetAttributes(s.stability.translate(attrs)...)
+}
@@ -0,0 +1,149 @@
+package middleware_test
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/middleware"
+)
+
+func TestParseSemconvStability(t *testing.T) {
+	for optIn, want := range map[string]middleware.SemconvStability{
+		"":                 middleware.SemconvOld,
+		"database":         middleware.SemconvOld,
+		"http":             middleware.SemconvStable,
+		"database, http":   middleware.SemconvStable,
+		"http/dup":         middleware.SemconvDuplicate,
+		"http,http/dup":    middleware.SemconvDuplicate,
+		"http/dup , other": middleware.SemconvDuplicate,
+	} {
+		if got := middleware.ParseSemconvStability(optIn); got != want {
+			t.Errorf("ParseSemconvStability(%q) = %v, want %v", optIn, got, want)
+		}
+	}
+}
+
+func TestSemconvTracerProvider(t *testing.T) {
+	for _, test := range []struct {
+		stability middleware.SemconvStability
+		old, new  bool
+	}{
+		{middleware.SemconvOld, true, false},
+		{middleware.SemconvStable, false, true},
+		{middleware.SemconvDuplicate, true, true},
+	} {
+		t.Run(test.stability.String(), func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+			t.Cleanup(func() { tp.Shutdown(context.Background()) })
+			r := echo.New()
+			r.Use(otelecho.Middleware("test",
+				otelecho.WithTracerProvider(middleware.SemconvTracerProvider(tp, test.stability)),
+			))
+			r.GET("/ok", func(c echo.Context) error {
+				// Attributes set later on the span are translated too.
+				span := trace.SpanFromContext(c.Request().Context())
+				span.SetAttributes(attribute.String("http.client_ip", "192.0.2.1"))
+				return c.NoContent(http.StatusOK)
+			})
+			serve(r, "/ok")
+
+			spans := sr.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			attrs := attribute.NewSet(spans[0].Attributes()...)
+			for _, key := range []struct{ old, new attribute.Key }{
+				{"http.method", "http.request.method"},
+				{"http.status_code", "http.response.status_code"},
+				{"http.client_ip", "client.address"},
+			} {
+				if attrs.HasValue(key.old) != test.old {
+					t.Errorf("got %s: %t, want %t", key.old, attrs.HasValue(key.old), test.old)
+				}
+				if attrs.HasValue(key.new) != test.new {
+					t.Errorf("got %s: %t, want %t", key.new, attrs.HasValue(key.new), test.new)
+				}
+			}
+			if !attrs.HasValue("http.route") {
+				t.Error("http.route not recorded")
+			}
+		})
+	}
+}
+
+func TestMetricsSemconvDuplicate(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	metrics, err := middleware.Metrics(middleware.MetricsConfig{
+		MeterProvider:    mp,
+		SemconvStability: middleware.SemconvDuplicate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := newEcho(t, metrics)
+	r.GET("/ok", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	serve(r, "/ok")
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+	if _, ok := got["http.server.request.duration"]; !ok {
+		t.Error("http.server.request.duration not recorded")
+	}
+	old, ok := got["http.server.duration"].(metricdata.Histogram[float64])
+	if !ok || len(old.DataPoints) != 1 {
+		t.Fatalf("http.server.duration not recorded as a float64 histogram")
+	}
+	if status, _ := old.DataPoints[0].Attributes.Value("http.status_code"); status.AsInt64() != http.StatusOK {
+		t.Errorf("got attributes %v, want http.status_code=200", old.DataPoints[0].Attributes.ToSlice())
+	}
+}
+
+func TestSemconvTracerProviderTarget(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	tracer := middleware.SemconvTracerProvider(tp, middleware.SemconvStable).Tracer("test")
+	_, span := tracer.Start(context.Background(), "test",
+		trace.WithAttributes(attribute.String("http.target", "/roll/2d6?session=alice")),
+	)
+	span.End()
+	_, span = tracer.Start(context.Background(), "test",
+		trace.WithAttributes(attribute.String("http.target", "/roll/2d6")),
+	)
+	span.End()
+
+	spans := sr.Ended()
+	for i, want := range []map[attribute.Key]string{
+		{"url.path": "/roll/2d6", "url.query": "session=alice"},
+		{"url.path": "/roll/2d6"},
+	} {
+		got := make(map[attribute.Key]string)
+		for _, kv := range spans[i].Attributes() {
+			got[kv.Key] = kv.Value.Emit()
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("got attributes %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Package modifier implements the Modifier gRPC service, which the dice
+// server calls to modify the sums of its rolls, so traces include a
+// gRPC hop as well as HTTP ones.
+//
+// The service is declared by hand rather than generated from a .proto
+// file, with the well-known wrapper types as its messages, so the demo
+// needs no protoc build step. It is equivalent to:
+//
+//	service Modifier {
+//	  rpc Modify(google.protobuf.Int64Value) returns (google.protobuf.Int64Value);
+//	}
+package modifier
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// modifyMethod is the full name of the Modify method.
+const modifyMethod = "/modifier.Modifier/Modify"
+
+// Modifier is the Modifier service.
+type Modifier interface {
+	// Modify returns the modified sum of a roll.
+	Modify(ctx context.Context, sum int64) (int64, error)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "modifier.Modifier",
+	HandlerType: (*Modifier)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Modify", Handler: modifyHandler},
+	},
+	Metadata: "modifier.proto",
+}
+
+func modifyHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.Int64Value)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	call := func(ctx context.Context, req any) (any, error) {
+		sum, err := srv.(Modifier).Modify(ctx, req.(*wrapperspb.Int64Value).GetValue())
+		if err != nil {
+			return nil, err
+		}
+		return wrapperspb.Int64(sum), nil
+	}
+	if interceptor == nil {
+		return call(ctx, in)
+	}
+	return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: modifyMethod}, call)
+}
+
+// Register registers m with s, to serve the Modifier service.
+func Register(s *grpc.Server, m Modifier) {
+	s.RegisterService(&serviceDesc, m)
+}
+
+// Client is a client of the Modifier service.
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewClient returns a Client calling the service over conn.
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+// Modify calls the service to modify the sum of a roll.
+func (c *Client) Modify(ctx context.Context, sum int64, opts ...grpc.CallOption) (int64, error) {
+	out := new(wrapperspb.Int64Value)
+	if err := c.conn.Invoke(ctx, modifyMethod, wrapperspb.Int64(sum), out, opts...); err != nil {
+		return 0, err
+	}
+	return out.GetValue(), nil
+}
@@ -0,0 +1,180 @@
+// Command modifierd serves the Modifier gRPC service, which the dice
+// server calls to modify the sum of each roll when configured with
+// -modifier-addr, e.g.
+//
+//	go run ./modifierd -listen localhost:8083 -bonus 1 &
+//	go run . -modifier-addr localhost:8083
+//
+// The service is instrumented with otelgrpc, so its spans join the
+// traces of the rolls calling it, showing an HTTP to gRPC hop. The size
+// of the roll, propagated by the dice server as baggage, is recorded as
+// the roll.size attribute of the spans. Errors and latency can be
+// injected, to show how gRPC statuses are mapped to the dice server's
+// HTTP responses.
+//
+// Telemetry is exported as OTLP, configured by -otlp-endpoint or the
+// standard OTEL_EXPORTER_OTLP_* environment variables, or to stdout
+// with -console.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"oteldemo/modifier"
+)
+
+type options struct {
+	listen    string
+	bonus     int64
+	latency   time.Duration
+	errorRate float64
+
+	otlpEndpoint string
+	console      bool
+}
+
+func parseFlags(args []string) (*options, error) {
+	var opts options
+	fs := flag.NewFlagSet("modifierd", flag.ContinueOnError)
+	fs.StringVar(&opts.listen, "listen", "localhost:8083", "host:port on which to listen")
+	fs.Int64Var(&opts.bonus, "bonus", 0, "amount to add to the sum of each roll")
+	fs.DurationVar(&opts.latency, "latency", 0, "latency to add to every call")
+	fs.Float64Var(&opts.errorRate, "error-rate", 0, "probability of a call failing at random, with status Unavailable")
+	fs.StringVar(&opts.otlpEndpoint, "otlp-endpoint", "", "OTLP endpoint URL (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.BoolVar(&opts.console, "console", false, "print spans to stdout, instead of exporting them as OTLP")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.latency < 0 {
+		return nil, errors.New("latency must not be negative")
+	}
+	if r := opts.errorRate; r < 0 || r > 1 {
+		return nil, fmt.Errorf("error rate %v out of range [0, 1]", r)
+	}
+	return &opts, nil
+}
+
+func initTracerProvider(ctx context.Context, opts *options) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName("modifier")),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		log.Printf("error detecting resource: %v", err)
+	}
+
+	var exporter sdktrace.SpanExporter
+	if opts.console {
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	} else {
+		var exporterOpts []otlptracegrpc.Option
+		if opts.otlpEndpoint != "" {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithEndpointURL(opts.otlpEndpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, exporterOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider, nil
+}
+
+// bonusModifier adds a bonus to the sums of rolls.
+type bonusModifier struct {
+	opts *options
+}
+
+func (m bonusModifier) Modify(ctx context.Context, sum int64) (int64, error) {
+	if size := baggage.FromContext(ctx).Member("roll.size"); size.Key() != "" {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("roll.size", size.Value()))
+	}
+	if sum < 1 {
+		return 0, status.Errorf(codes.InvalidArgument, "sum %d is not a valid roll", sum)
+	}
+	if m.opts.latency > 0 {
+		select {
+		case <-ctx.Done():
+			return 0, status.FromContextError(ctx.Err()).Err()
+		case <-time.After(m.opts.latency):
+		}
+	}
+	if m.opts.errorRate > 0 && rand.Float64() < m.opts.errorRate {
+		return 0, status.Error(codes.Unavailable, "injected failure")
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("modifier.bonus", m.opts.bonus))
+	return sum + m.opts.bonus, nil
+}
+
+func main() {
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tracerProvider, err := initTracerProvider(ctx, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	modifier.Register(srv, bonusModifier{opts: opts})
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	log.Printf("serving the Modifier service on %s", l.Addr())
+	if err := srv.Serve(l); err != nil {
+		log.Print(err)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(flushCtx); err != nil {
+		log.Printf("error flushing telemetry: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+// Package natsroll defines how roll requests are made over NATS
+// request-reply, and the propagation of trace context in NATS message
+// headers, shared by the dice server and its clients.
+//
+// A request's data is dice notation, e.g. 2d6, and is sent to Subject.
+// The reply's data is the body of the equivalent HTTP response, with its
+// status code in the StatusHeader header.
+package natsroll
+
+import (
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Subject is the subject to which roll requests are sent.
+const Subject = "dice.roll"
+
+// StatusHeader is the header of replies holding the HTTP status code
+// of the equivalent response.
+const StatusHeader = "Status"
+
+// HeaderCarrier is a propagation.TextMapCarrier for NATS message
+// headers. Unlike propagation.HeaderCarrier, keys are not canonicalized,
+// so they are sent as given by the propagators, e.g. "traceparent".
+type HeaderCarrier nats.Header
+
+var _ propagation.TextMapCarrier = HeaderCarrier{}
+
+// Get returns the value of the header with the given key.
+func (c HeaderCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+// Set sets the header with the given key, replacing any existing value.
+func (c HeaderCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+// Keys returns the keys of the headers.
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,141 @@
+// Command natsworker serves rolls over NATS request-reply, as described
+// by package natsroll, rather than over HTTP, e.g.
+//
+//	nats-server &
+//	go run ./natsworker &
+//	go run ./dicectl -nats-url nats://localhost:4222 roll 2d6
+//
+// Workers subscribe in a queue group, so running several of them
+// balances the requests between them. Each request is served in a
+// consumer span continuing the trace of the client that sent it, from
+// the trace context in the message headers, and is then handled the
+// same way as by the HTTP server, so traces show the same roll spans.
+//
+// Telemetry is exported as OTLP, configured by -otlp-endpoint or the
+// standard OTEL_EXPORTER_OTLP_* environment variables, or to stdout
+// with -console.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+
+	"oteldemo/config"
+	"oteldemo/dice"
+	"oteldemo/natsroll"
+)
+
+type options struct {
+	natsURL string
+
+	otlpEndpoint string
+	console      bool
+}
+
+func parseFlags(args []string) (*options, error) {
+	var opts options
+	fs := flag.NewFlagSet("natsworker", flag.ContinueOnError)
+	fs.StringVar(&opts.natsURL, "nats-url", nats.DefaultURL, "URL of the NATS server")
+	fs.StringVar(&opts.otlpEndpoint, "otlp-endpoint", "", "OTLP endpoint URL (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.BoolVar(&opts.console, "console", false, "print spans to stdout, instead of exporting them as OTLP")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+func initTracerProvider(ctx context.Context, opts *options) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName("natsworker")),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		log.Printf("error detecting resource: %v", err)
+	}
+
+	var exporter sdktrace.SpanExporter
+	if opts.console {
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	} else {
+		var exporterOpts []otlptracegrpc.Option
+		if opts.otlpEndpoint != "" {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithEndpointURL(opts.otlpEndpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, exporterOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider, nil
+}
+
+func main() {
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tracerProvider, err := initTracerProvider(ctx, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	nc, err := nats.Connect(opts.natsURL,
+		nats.Name("natsworker"),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv, err := dice.New(dice.WithConfig(config.Default()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving %s requests from %s", natsroll.Subject, nc.ConnectedUrl())
+	if err := srv.ServeNATS(ctx, nc); err != nil {
+		log.Print(err)
+	}
+	// Wait for the requests already received to be served.
+	if err := nc.Drain(); err != nil {
+		log.Print(err)
+	}
+	<-closed
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(flushCtx); err != nil {
+		log.Printf("error flushing telemetry: %v", err)
+	}
+}
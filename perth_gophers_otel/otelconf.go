@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"gopkg.in/yaml.v3"
+)
+
+// otelConfig is the subset of the OpenTelemetry declarative configuration
+// file format (file_format "0.1") understood by the dice server. See
+// https://github.com/open-telemetry/opentelemetry-configuration.
+type otelConfig struct {
+	FileFormat     string                `yaml:"file_format"`
+	Disabled       bool                  `yaml:"disabled"`
+	Resource       *otelResourceConfig   `yaml:"resource"`
+	Propagator     *otelPropagatorConfig `yaml:"propagator"`
+	TracerProvider *otelTracerConfig     `yaml:"tracer_provider"`
+	MeterProvider  *otelMeterConfig      `yaml:"meter_provider"`
+}
+
+type otelResourceConfig struct {
+	Attributes map[string]any `yaml:"attributes"`
+}
+
+type otelPropagatorConfig struct {
+	Composite []string `yaml:"composite"`
+}
+
+type otelTracerConfig struct {
+	Processors []otelSpanProcessorConfig `yaml:"processors"`
+	Sampler    *otelSamplerConfig        `yaml:"sampler"`
+}
+
+type otelSpanProcessorConfig struct {
+	Batch *struct {
+		ScheduleDelay      *int                   `yaml:"schedule_delay"`
+		ExportTimeout      *int                   `yaml:"export_timeout"`
+		MaxQueueSize       *int                   `yaml:"max_queue_size"`
+		MaxExportBatchSize *int                   `yaml:"max_export_batch_size"`
+		Exporter           otelSpanExporterConfig `yaml:"exporter"`
+	} `yaml:"batch"`
+	Simple *struct {
+		Exporter otelSpanExporterConfig `yaml:"exporter"`
+	} `yaml:"simple"`
+}
+
+type otelSpanExporterConfig struct {
+	OTLP    *otelOTLPConfig `yaml:"otlp"`
+	Console *struct{}       `yaml:"console"`
+}
+
+type otelOTLPConfig struct {
+	Protocol              string            `yaml:"protocol"`
+	Endpoint              string            `yaml:"endpoint"`
+	Headers               map[string]string `yaml:"headers"`
+	Compression           string            `yaml:"compression"`
+	Timeout               *int              `yaml:"timeout"`
+	Insecure              bool              `yaml:"insecure"`
+	TemporalityPreference string            `yaml:"temporality_preference"`
+}
+
+type otelSamplerConfig struct {
+	AlwaysOn          *struct{} `yaml:"always_on"`
+	AlwaysOff         *struct{} `yaml:"always_off"`
+	TraceIDRatioBased *struct {
+		Ratio float64 `yaml:"ratio"`
+	} `yaml:"trace_id_ratio_based"`
+	ParentBased *struct {
+		Root *otelSamplerConfig `yaml:"root"`
+	} `yaml:"parent_based"`
+}
+
+type otelMeterConfig struct {
+	Readers []otelMetricReaderConfig `yaml:"readers"`
+	Views   []otelViewConfig         `yaml:"views"`
+}
+
+type otelMetricReaderConfig struct {
+	Periodic *struct {
+		Interval *int `yaml:"interval"`
+		Timeout  *int `yaml:"timeout"`
+		Exporter struct {
+			OTLP    *otelOTLPConfig `yaml:"otlp"`
+			Console *struct{}       `yaml:"console"`
+		} `yaml:"exporter"`
+	} `yaml:"periodic"`
+}
+
+type otelViewConfig struct {
+	Selector struct {
+		InstrumentName string `yaml:"instrument_name"`
+		InstrumentType string `yaml:"instrument_type"`
+		Unit           string `yaml:"unit"`
+		MeterName      string `yaml:"meter_name"`
+	} `yaml:"selector"`
+	Stream struct {
+		Name          string   `yaml:"name"`
+		Description   string   `yaml:"description"`
+		AttributeKeys []string `yaml:"attribute_keys"`
+		Aggregation   *struct {
+			Default                 *struct{} `yaml:"default"`
+			Drop                    *struct{} `yaml:"drop"`
+			Sum                     *struct{} `yaml:"sum"`
+			LastValue               *struct{} `yaml:"last_value"`
+			ExplicitBucketHistogram *struct {
+				Boundaries   []float64 `yaml:"boundaries"`
+				RecordMinMax *bool     `yaml:"record_min_max"`
+			} `yaml:"explicit_bucket_histogram"`
+			Base2ExponentialBucketHistogram *struct {
+				MaxScale     *int32 `yaml:"max_scale"`
+				MaxSize      *int32 `yaml:"max_size"`
+				RecordMinMax *bool  `yaml:"record_min_max"`
+			} `yaml:"base2_exponential_bucket_histogram"`
+		} `yaml:"aggregation"`
+	} `yaml:"stream"`
+}
+
+// loadOTelConfig reads and parses the configuration file at path,
+// substituting ${VAR} references with environment variables.
+func loadOTelConfig(path string) (*otelConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg otelConfig
+	if err := yaml.Unmarshal([]byte(expandEnv(string(data))), &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if cfg.FileFormat != "0.1" {
+		return nil, fmt.Errorf("%s: unsupported file_format %q, expected \"0.1\"", path, cfg.FileFormat)
+	}
+	return &cfg, nil
+}
+
+// envReference matches the ${VAR} environment variable
+// references substituted in configuration files.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv substitutes ${VAR} references in s with the values of the
+// environment variables, or "" for those unset. Unlike os.ExpandEnv, a
+// bare $VAR is left as is, as the configuration file format specifies.
+func expandEnv(s string) string {
+	return envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envReference.FindStringSubmatch(ref)[1])
+	})
+}
+
+// initFromOTelConfig registers global propagators, TracerProvider, and
+// MeterProvider as described by the configuration file at path. The
+// returned function shuts down the providers, flushing any telemetry.
+func initFromOTelConfig(path string) (shutdown func(context.Context) error, err error) {
+	cfg, err := loadOTelConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var shutdownFuncs []func(context.Context) error
+	shutdown = func(ctx context.Context) error {
+		var errs []error
+		for _, f := range shutdownFuncs {
+			errs = append(errs, f(ctx))
+		}
+		return errors.Join(errs...)
+	}
+	otelConfigPipeline = cfg.describe(path)
+	if cfg.Disabled {
+		return shutdown, nil
+	}
+	ctx := context.Background()
+
+	res, err := cfg.resource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Propagator != nil {
+		propagator, err := cfg.Propagator.propagator()
+		if err != nil {
+			return nil, err
+		}
+		otel.SetTextMapPropagator(propagator)
+	}
+	if cfg.TracerProvider != nil {
+		tracerProvider, err := cfg.TracerProvider.tracerProvider(ctx, res)
+		if err != nil {
+			return nil, err
+		}
+		otel.SetTracerProvider(tracerProvider)
+		shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
+	}
+	if cfg.MeterProvider != nil {
+		meterProvider, err := cfg.MeterProvider.meterProvider(ctx, res)
+		if err != nil {
+			return nil, err
+		}
+		otel.SetMeterProvider(meterProvider)
+		shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
+	}
+	return shutdown, nil
+}
+
+func (cfg *otelConfig) resource(ctx context.Context) (*resource.Resource, error) {
+	if cfg.Resource == nil {
+		return newResource(ctx), nil
+	}
+	var attrs []attribute.KeyValue
+	for k, v := range cfg.Resource.Attributes {
+		switch v := v.(type) {
+		case string:
+			attrs = append(attrs, attribute.String(k, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(k, v))
+		case int:
+			attrs = append(attrs, attribute.Int(k, v))
+		case float64:
+			attrs = append(attrs, attribute.Float64(k, v))
+		default:
+			return nil, fmt.Errorf("unsupported type %T for resource attribute %q", v, k)
+		}
+	}
+	return newResource(ctx, attrs...), nil
+}
+
+func (cfg *otelPropagatorConfig) propagator() (propagation.TextMapPropagator, error) {
+	var propagators []propagation.TextMapPropagator
+	for _, name := range cfg.Composite {
+		switch name {
+		case "tracecontext":
+			propagators = append(propagators, propagation.TraceContext{})
+		case "baggage":
+			propagators = append(propagators, propagation.Baggage{})
+		default:
+			return nil, fmt.Errorf("unsupported propagator %q", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...), nil
+}
+
+func (cfg *otelTracerConfig) tracerProvider(ctx context.Context, res *resource.Resource) (*sdktrace.TracerProvider, error) {
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if cfg.Sampler != nil {
+		sampler, err := cfg.Sampler.sampler()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithSampler(sampler))
+	}
+	for _, p := range cfg.Processors {
+		switch {
+		case p.Batch != nil:
+			exporter, err := p.Batch.Exporter.spanExporter(ctx)
+			if err != nil {
+				return nil, err
+			}
+			var batchOpts []sdktrace.BatchSpanProcessorOption
+			if p.Batch.ScheduleDelay != nil {
+				batchOpts = append(batchOpts, sdktrace.WithBatchTimeout(millis(*p.Batch.ScheduleDelay)))
+			}
+			if p.Batch.ExportTimeout != nil {
+				batchOpts = append(batchOpts, sdktrace.WithExportTimeout(millis(*p.Batch.ExportTimeout)))
+			}
+			if p.Batch.MaxQueueSize != nil {
+				batchOpts = append(batchOpts, sdktrace.WithMaxQueueSize(*p.Batch.MaxQueueSize))
+			}
+			if p.Batch.MaxExportBatchSize != nil {
+				batchOpts = append(batchOpts, sdktrace.WithMaxExportBatchSize(*p.Batch.MaxExportBatchSize))
+			}
+			opts = append(opts, sdktrace.WithBatcher(exporter, batchOpts...))
+		case p.Simple != nil:
+			exporter, err := p.Simple.Exporter.spanExporter(ctx)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, sdktrace.WithSyncer(exporter))
+		default:
+			return nil, errors.New("span processor must be one of batch or simple")
+		}
+	}
+	return sdktrace.NewTracerProvider(opts...), nil
+}
+
+func (cfg *otelSamplerConfig) sampler() (sdktrace.Sampler, error) {
+	switch {
+	case cfg.AlwaysOn != nil:
+		return sdktrace.AlwaysSample(), nil
+	case cfg.AlwaysOff != nil:
+		return sdktrace.NeverSample(), nil
+	case cfg.TraceIDRatioBased != nil:
+		return sdktrace.TraceIDRatioBased(cfg.TraceIDRatioBased.Ratio), nil
+	case cfg.ParentBased != nil:
+		root := sdktrace.AlwaysSample()
+		if cfg.ParentBased.Root != nil {
+			var err error
+			if root, err = cfg.ParentBased.Root.sampler(); err != nil {
+				return nil, err
+			}
+		}
+		return sdktrace.ParentBased(root), nil
+	}
+	return nil, errors.New("sampler must be one of always_on, always_off, trace_id_ratio_based, or parent_based")
+}
+
+func (cfg *otelSpanExporterConfig) spanExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	switch {
+	case cfg.Console != nil:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case cfg.OTLP != nil:
+		if cfg.OTLP.Protocol != "grpc" {
+			return nil, fmt.Errorf("unsupported OTLP protocol %q, expected grpc", cfg.OTLP.Protocol)
+		}
+		var opts []otlptracegrpc.Option
+		if cfg.OTLP.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.OTLP.Endpoint))
+		}
+		if cfg.OTLP.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if headers := cfg.OTLP.headers(); len(headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(headers))
+		}
+		if cfg.OTLP.Compression == "gzip" {
+			opts = append(opts, otlptracegrpc.WithCompressor("gzip"))
+		}
+		if cfg.OTLP.Timeout != nil {
+			opts = append(opts, otlptracegrpc.WithTimeout(millis(*cfg.OTLP.Timeout)))
+		}
+		exporter, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return newStatusSpanExporter(exporter), nil
+	}
+	return nil, errors.New("span exporter must be one of otlp or console")
+}
+
+func (cfg *otelMeterConfig) meterProvider(ctx context.Context, res *resource.Resource) (*sdkmetric.MeterProvider, error) {
+	opts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	for _, r := range cfg.Readers {
+		if r.Periodic == nil {
+			return nil, errors.New("only periodic metric readers are supported")
+		}
+		var exporter sdkmetric.Exporter
+		var err error
+		switch {
+		case r.Periodic.Exporter.Console != nil:
+			exporter, err = stdoutmetric.New()
+		case r.Periodic.Exporter.OTLP != nil:
+			exporter, err = r.Periodic.Exporter.OTLP.metricExporter(ctx)
+		default:
+			err = errors.New("metric exporter must be one of otlp or console")
+		}
+		if err != nil {
+			return nil, err
+		}
+		var readerOpts []sdkmetric.PeriodicReaderOption
+		if r.Periodic.Interval != nil {
+			readerOpts = append(readerOpts, sdkmetric.WithInterval(millis(*r.Periodic.Interval)))
+		}
+		if r.Periodic.Timeout != nil {
+			readerOpts = append(readerOpts, sdkmetric.WithTimeout(millis(*r.Periodic.Timeout)))
+		}
+		opts = append(opts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, readerOpts...)))
+	}
+	for _, v := range cfg.Views {
+		view, err := v.view()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdkmetric.WithView(view))
+	}
+	return sdkmetric.NewMeterProvider(opts...), nil
+}
+
+func (cfg *otelOTLPConfig) metricExporter(ctx context.Context) (sdkmetric.Exporter, error) {
+	if cfg.Protocol != "grpc" {
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, expected grpc", cfg.Protocol)
+	}
+	var opts []otlpmetricgrpc.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+	if headers := cfg.headers(); len(headers) > 0 {
+		opts = append(opts, otlpmetricgrpc.WithHeaders(headers))
+	}
+	if cfg.Compression == "gzip" {
+		opts = append(opts, otlpmetricgrpc.WithCompressor("gzip"))
+	}
+	if cfg.Timeout != nil {
+		opts = append(opts, otlpmetricgrpc.WithTimeout(millis(*cfg.Timeout)))
+	}
+	switch cfg.TemporalityPreference {
+	case "", "cumulative":
+	case "delta":
+		opts = append(opts, otlpmetricgrpc.WithTemporalitySelector(
+			func(sdkmetric.InstrumentKind) metricdata.Temporality {
+				return metricdata.DeltaTemporality
+			},
+		))
+	default:
+		return nil, fmt.Errorf("unsupported temporality_preference %q", cfg.TemporalityPreference)
+	}
+	exporter, err := otlpmetricgrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newStatusMetricExporter(exporter), nil
+}
+
+// headers returns the configured headers, omitting any that are empty,
+// e.g. due to substituting an unset environment variable.
+func (cfg *otelOTLPConfig) headers() map[string]string {
+	headers := make(map[string]string, len(cfg.Headers))
+	for k, v := range cfg.Headers {
+		if v != "" {
+			headers[k] = v
+		}
+	}
+	return headers
+}
+
+var instrumentKinds = map[string]sdkmetric.InstrumentKind{
+	"counter":                    sdkmetric.InstrumentKindCounter,
+	"histogram":                  sdkmetric.InstrumentKindHistogram,
+	"observable_counter":         sdkmetric.InstrumentKindObservableCounter,
+	"observable_gauge":           sdkmetric.InstrumentKindObservableGauge,
+	"observable_up_down_counter": sdkmetric.InstrumentKindObservableUpDownCounter,
+	"up_down_counter":            sdkmetric.InstrumentKindUpDownCounter,
+}
+
+func (cfg *otelViewConfig) view() (sdkmetric.View, error) {
+	selector := sdkmetric.Instrument{
+		Name: cfg.Selector.InstrumentName,
+		Unit: cfg.Selector.Unit,
+	}
+	selector.Scope.Name = cfg.Selector.MeterName
+	if cfg.Selector.InstrumentType != "" {
+		kind, ok := instrumentKinds[cfg.Selector.InstrumentType]
+		if !ok {
+			return nil, fmt.Errorf("unsupported instrument_type %q", cfg.Selector.InstrumentType)
+		}
+		selector.Kind = kind
+	}
+
+	stream := sdkmetric.Stream{
+		Name:        cfg.Stream.Name,
+		Description: cfg.Stream.Description,
+	}
+	if cfg.Stream.AttributeKeys != nil {
+		keys := make([]attribute.Key, len(cfg.Stream.AttributeKeys))
+		for i, k := range cfg.Stream.AttributeKeys {
+			keys[i] = attribute.Key(k)
+		}
+		stream.AttributeFilter = attribute.NewAllowKeysFilter(keys...)
+	}
+	if agg := cfg.Stream.Aggregation; agg != nil {
+		switch {
+		case agg.Default != nil:
+			stream.Aggregation = sdkmetric.AggregationDefault{}
+		case agg.Drop != nil:
+			stream.Aggregation = sdkmetric.AggregationDrop{}
+		case agg.Sum != nil:
+			stream.Aggregation = sdkmetric.AggregationSum{}
+		case agg.LastValue != nil:
+			stream.Aggregation = sdkmetric.AggregationLastValue{}
+		case agg.ExplicitBucketHistogram != nil:
+			h := agg.ExplicitBucketHistogram
+			stream.Aggregation = sdkmetric.AggregationExplicitBucketHistogram{
+				Boundaries: h.Boundaries,
+				NoMinMax:   h.RecordMinMax != nil && !*h.RecordMinMax,
+			}
+		case agg.Base2ExponentialBucketHistogram != nil:
+			h := agg.Base2ExponentialBucketHistogram
+			a := sdkmetric.AggregationBase2ExponentialHistogram{
+				MaxSize:  160,
+				MaxScale: 20,
+				NoMinMax: h.RecordMinMax != nil && !*h.RecordMinMax,
+			}
+			if h.MaxSize != nil {
+				a.MaxSize = *h.MaxSize
+			}
+			if h.MaxScale != nil {
+				a.MaxScale = *h.MaxScale
+			}
+			stream.Aggregation = a
+		}
+	}
+	return sdkmetric.NewView(selector, stream), nil
+}
+
+// millis converts a configuration duration in milliseconds to a time.Duration.
+func millis(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
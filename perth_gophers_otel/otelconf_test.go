@@ -0,0 +1,33 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("OTLP_AUTHORIZATION", "Bearer s3cret")
+	t.Setenv("UNSET_FOR_TEST", "")
+	for in, want := range map[string]string{
+		"authorization: ${OTLP_AUTHORIZATION}": "authorization: Bearer s3cret",
+		"authorization: ${UNSET_FOR_TEST}":     "authorization: ",
+		"password: pa$$word":                   "password: pa$$word",
+		"literal: $OTLP_AUTHORIZATION":         "literal: $OTLP_AUTHORIZATION",
+		"unterminated: ${OTLP_AUTHORIZATION":   "unterminated: ${OTLP_AUTHORIZATION",
+	} {
+		if got := expandEnv(in); got != want {
+			t.Errorf("expandEnv(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestOTelOTLPConfigHeaders(t *testing.T) {
+	cfg := otelOTLPConfig{Headers: map[string]string{
+		"authorization": "",
+		"x-tenant":      "dice",
+	}}
+	want := map[string]string{"x-tenant": "dice"}
+	if got := cfg.headers(); !maps.Equal(got, want) {
+		t.Errorf("got headers %v, want %v", got, want)
+	}
+}
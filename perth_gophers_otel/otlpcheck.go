@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"oteldemo/config"
+)
+
+const (
+	// otlpCheckTimeout bounds each probe of an OTLP endpoint.
+	otlpCheckTimeout = 5 * time.Second
+
+	// otlpCheckInterval is the interval at which endpoints are probed
+	// again, after none were reachable at startup.
+	otlpCheckInterval = 10 * time.Second
+)
+
+// startOTLPCheck probes the configured OTLP endpoints with an empty
+// trace export, which a Collector accepts without effect, logging a
+// diagnosis for each endpoint.
+//
+// If no endpoint is reachable, the "otlp endpoints" readiness check fails,
+// and the endpoints are probed again in the background until ctx is done
+// or one is reachable.
+func startOTLPCheck(ctx context.Context, cfg config.OTLP) {
+	endpoints := []string{resolveOTLPEndpoint(cfg.Endpoint)}
+	if cfg.SecondaryEndpoint != "" {
+		endpoints = append(endpoints, cfg.SecondaryEndpoint)
+	}
+	var mu sync.Mutex
+	var lastErr error
+	readinessChecks["otlp endpoints"] = func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return lastErr
+	}
+	probe := func() error {
+		var errs []error
+		for i, endpoint := range endpoints {
+			if err := probeOTLPEndpoint(ctx, endpoint); err != nil {
+				log.Printf("OTLP %s endpoint %s: %v", endpointNames[i], endpoint, err)
+				errs = append(errs, fmt.Errorf("%s endpoint: %w", endpointNames[i], err))
+			} else {
+				log.Printf("OTLP %s endpoint %s: ok", endpointNames[i], endpoint)
+			}
+		}
+		// Either endpoint will do, as the exporters fail over.
+		var err error
+		if len(errs) == len(endpoints) {
+			err = errors.Join(errs...)
+		}
+		mu.Lock()
+		lastErr = err
+		mu.Unlock()
+		return err
+	}
+	if probe() == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(otlpCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if probe() == nil {
+				return
+			}
+		}
+	}()
+}
+
+// probeOTLPEndpoint sends an empty trace export to the endpoint,
+// returning an error diagnosing why it failed, if it did.
+func probeOTLPEndpoint(ctx context.Context, endpoint string) error {
+	conn, err := dialOTLP(endpoint)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(ctx, otlpCheckTimeout)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, otlpHeaders())
+	_, err = coltracepb.NewTraceServiceClient(conn).Export(ctx, &coltracepb.ExportTraceServiceRequest{})
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.Unavailable:
+		return fmt.Errorf("unreachable; is the Collector running, and listening for OTLP/gRPC? (%w)", err)
+	case codes.DeadlineExceeded:
+		return fmt.Errorf("timed out after %s (%w)", otlpCheckTimeout, err)
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return fmt.Errorf("credentials rejected; check OTEL_EXPORTER_OTLP_HEADERS (%w)", err)
+	case codes.Unimplemented:
+		return fmt.Errorf("does not accept OTLP/gRPC traces; is it an OTLP/HTTP endpoint? (%w)", err)
+	}
+	return err
+}
+
+// otlpHeaders returns the headers configured for the OTLP exporters
+// by OTEL_EXPORTER_OTLP_HEADERS, such as authorization headers.
+func otlpHeaders() metadata.MD {
+	md := metadata.MD{}
+	for _, header := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		key, value, ok := strings.Cut(header, "=")
+		if !ok {
+			continue
+		}
+		if v, err := url.PathUnescape(strings.TrimSpace(value)); err == nil {
+			value = v
+		}
+		md.Append(strings.TrimSpace(key), value)
+	}
+	return md
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sort"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"oteldemo/config"
+)
+
+// pipelineDescription describes the telemetry pipeline, as reported by
+// GET /debug/config on the admin listener. It must not hold secrets.
+type pipelineDescription struct {
+	// Source is "built-in", or the path of the declarative
+	// configuration file from which the pipeline was set up.
+	Source string `yaml:"source"`
+
+	// Phase is the instrumentation phase of the built-in pipeline.
+	Phase int `yaml:"phase,omitempty"`
+
+	// Sampler describes the root sampler, with its current ratio.
+	Sampler string `yaml:"sampler,omitempty"`
+
+	// PropagationFields are the headers injected and
+	// extracted by the global propagators.
+	PropagationFields []string `yaml:"propagation_fields"`
+
+	Exporters []exporterDescription `yaml:"exporters"`
+}
+
+// exporterDescription describes an exporter in the pipeline.
+type exporterDescription struct {
+	Signal string `yaml:"signal"`
+
+	// Type is "otlp", "console", or "dashboard".
+	Type string `yaml:"type"`
+
+	// Endpoint and SecondaryEndpoint are OTLP endpoint URLs,
+	// with any credentials redacted.
+	Endpoint          string `yaml:"endpoint,omitempty"`
+	SecondaryEndpoint string `yaml:"secondary_endpoint,omitempty"`
+
+	// Headers holds the headers sent with OTLP exports,
+	// with their values redacted.
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Enabled reports whether the exporter's toggles are all on.
+	Enabled bool `yaml:"enabled"`
+}
+
+// otelConfigPipeline describes the pipeline set up by initFromOTelConfig.
+var otelConfigPipeline *pipelineDescription
+
+// describePipeline returns a description of the telemetry pipeline set
+// up for cfg, with the current sampler ratio and toggles.
+func describePipeline(cfg config.Telemetry) pipelineDescription {
+	fields := otel.GetTextMapPropagator().Fields()
+	sort.Strings(fields)
+	if cfg.ConfigFile != "" && otelConfigPipeline != nil {
+		p := *otelConfigPipeline
+		p.PropagationFields = fields
+		return p
+	}
+	p := pipelineDescription{Source: "built-in", Phase: cfg.Phase, PropagationFields: fields}
+	if cfg.Phase < config.PhaseTraces {
+		return p
+	}
+	p.Sampler = sdktrace.ParentBased(sampler).Description()
+	otlp := exporterDescription{
+		Type:              "otlp",
+		Endpoint:          config.RedactURL(resolveOTLPEndpoint(cfg.OTLP.Endpoint)),
+		SecondaryEndpoint: config.RedactURL(cfg.OTLP.SecondaryEndpoint),
+		Headers:           redactHeaders(otlpHeaders()),
+	}
+	signals := []string{"traces"}
+	if cfg.Phase >= config.PhaseMetrics {
+		signals = append(signals, "metrics")
+	}
+	for _, signal := range signals {
+		metrics := signal == "metrics"
+		p.Exporters = append(p.Exporters, exporterDescription{
+			Signal:  signal,
+			Type:    "console",
+			Enabled: consoleExporters.Enabled() && (!metrics || metricsExport.Enabled()),
+		})
+		if cfg.Phase >= config.PhaseFull {
+			otlp := otlp
+			otlp.Signal = signal
+			otlp.Enabled = !metrics || metricsExport.Enabled()
+			p.Exporters = append(p.Exporters, otlp)
+		}
+		if dash != nil {
+			p.Exporters = append(p.Exporters, exporterDescription{Signal: signal, Type: "dashboard", Enabled: true})
+		}
+	}
+	return p
+}
+
+// redactHeaders returns the names of the given headers,
+// each mapped to config.RedactedValue.
+func redactHeaders[V any](headers map[string]V) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(headers))
+	for name := range headers {
+		redacted[name] = config.RedactedValue
+	}
+	return redacted
+}
+
+// describe returns a description of the pipeline set up from cfg,
+// loaded from the configuration file at path.
+func (cfg *otelConfig) describe(path string) *pipelineDescription {
+	p := &pipelineDescription{Source: path}
+	if cfg.Disabled {
+		return p
+	}
+	if cfg.TracerProvider != nil {
+		// The SDK's default sampler.
+		sampler := sdktrace.ParentBased(sdktrace.AlwaysSample())
+		if cfg.TracerProvider.Sampler != nil {
+			if s, err := cfg.TracerProvider.Sampler.sampler(); err == nil {
+				sampler = s
+			}
+		}
+		p.Sampler = sampler.Description()
+		for _, proc := range cfg.TracerProvider.Processors {
+			exporter := otelSpanExporterConfig{}
+			switch {
+			case proc.Batch != nil:
+				exporter = proc.Batch.Exporter
+			case proc.Simple != nil:
+				exporter = proc.Simple.Exporter
+			}
+			p.Exporters = append(p.Exporters, describeOTelExporter("traces", exporter.OTLP, exporter.Console != nil))
+		}
+	}
+	if cfg.MeterProvider != nil {
+		for _, r := range cfg.MeterProvider.Readers {
+			if r.Periodic != nil {
+				exporter := r.Periodic.Exporter
+				p.Exporters = append(p.Exporters, describeOTelExporter("metrics", exporter.OTLP, exporter.Console != nil))
+			}
+		}
+	}
+	return p
+}
+
+// describeOTelExporter describes an exporter in a
+// declarative configuration file.
+func describeOTelExporter(signal string, otlp *otelOTLPConfig, console bool) exporterDescription {
+	e := exporterDescription{Signal: signal, Enabled: true}
+	switch {
+	case console:
+		e.Type = "console"
+	case otlp != nil:
+		e.Type = "otlp"
+		e.Endpoint = config.RedactURL(otlp.Endpoint)
+		e.Headers = redactHeaders(otlp.headers())
+	}
+	return e
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"oteldemo/config"
+)
+
+// replay re-exports the telemetry in OTLP-JSON files, which hold a JSON
+// object per line as written by the Collector's file exporter, to the
+// OTLP endpoint.
+//
+// Timestamps are shifted so the latest is the present, preserving the
+// timing of the original telemetry. Trace and span IDs are replaced,
+// consistently across files, so the same files can be replayed more
+// than once without clashing in the backend.
+func replay(ctx context.Context, cfg config.Telemetry) error {
+	var objects []map[string]any
+	for _, path := range cfg.Replay {
+		fileObjects, err := readOTLPJSON(path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, fileObjects...)
+	}
+
+	var latest uint64
+	for _, obj := range objects {
+		walkOTLPJSON(obj, func(key string, value any) any {
+			if ts, ok := timestamp(key, value); ok && ts > latest {
+				latest = ts
+			}
+			return value
+		})
+	}
+	offset := uint64(time.Now().UnixNano()) - latest
+	ids := make(map[string]string)
+
+	conn, err := dialOTLP(cfg.OTLP.Endpoint)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	traces := coltracepb.NewTraceServiceClient(conn)
+	metrics := colmetricpb.NewMetricsServiceClient(conn)
+
+	var exported int
+	for _, obj := range objects {
+		walkOTLPJSON(obj, func(key string, value any) any {
+			if ts, ok := timestamp(key, value); ok && ts != 0 {
+				return strconv.FormatUint(ts+offset, 10)
+			}
+			if isID(key) {
+				return replaceID(ids, value)
+			}
+			return value
+		})
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		switch {
+		case obj["resourceSpans"] != nil:
+			var req coltracepb.ExportTraceServiceRequest
+			if err = unmarshalOTLPJSON(data, &req); err == nil {
+				_, err = traces.Export(ctx, &req)
+			}
+		case obj["resourceMetrics"] != nil:
+			var req colmetricpb.ExportMetricsServiceRequest
+			if err = unmarshalOTLPJSON(data, &req); err == nil {
+				_, err = metrics.Export(ctx, &req)
+			}
+		default:
+			// e.g. logs, which the demo does not export.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("replaying telemetry: %w", err)
+		}
+		exported++
+	}
+	log.Printf("replayed %d export requests from %s", exported, strings.Join(cfg.Replay, ", "))
+	return nil
+}
+
+// readOTLPJSON reads a file holding an OTLP-JSON object per line.
+func readOTLPJSON(path string) ([]map[string]any, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var objects []map[string]any
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		// Decode numbers as json.Number, so nanosecond
+		// timestamps do not lose precision as floats.
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		objects = append(objects, obj)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return objects, nil
+}
+
+// unmarshalOTLPJSON unmarshals OTLP-JSON, with IDs already
+// converted to the base64 that protojson expects.
+func unmarshalOTLPJSON(data []byte, m proto.Message) error {
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, m)
+}
+
+// walkOTLPJSON calls fn for each field of the JSON value v, recursively,
+// replacing each field's value with the result.
+func walkOTLPJSON(v any, fn func(key string, value any) any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			walkOTLPJSON(value, fn)
+			v[key] = fn(key, value)
+		}
+	case []any:
+		for _, value := range v {
+			walkOTLPJSON(value, fn)
+		}
+	}
+}
+
+// timestamp returns the value of a timestamp field, such as
+// "startTimeUnixNano", which may be a string or number.
+func timestamp(key string, value any) (uint64, bool) {
+	if key != "timeUnixNano" && !strings.HasSuffix(key, "TimeUnixNano") {
+		return 0, false
+	}
+	var s string
+	switch value := value.(type) {
+	case string:
+		s = value
+	case json.Number:
+		s = value.String()
+	default:
+		return 0, false
+	}
+	ts, err := strconv.ParseUint(s, 10, 64)
+	return ts, err == nil
+}
+
+// isID reports whether key is that of a trace or span ID field.
+func isID(key string) bool {
+	return key == "traceId" || key == "spanId" || key == "parentSpanId"
+}
+
+// replaceID returns a random replacement for the hex-encoded ID,
+// in base64 as protojson expects, reusing replacements in ids.
+func replaceID(ids map[string]string, value any) any {
+	id, ok := value.(string)
+	if !ok || id == "" {
+		return value
+	}
+	if replacement, ok := ids[id]; ok {
+		return replacement
+	}
+	b, err := hex.DecodeString(id)
+	if err != nil {
+		// Leave it to protojson to report.
+		return value
+	}
+	rand.Read(b)
+	replacement := base64.StdEncoding.EncodeToString(b)
+	ids[id] = replacement
+	return replacement
+}
+
+// resolveOTLPEndpoint returns the OTLP endpoint URL, or if it is empty,
+// the endpoint given by OTEL_EXPORTER_OTLP_ENDPOINT, or the default.
+func resolveOTLPEndpoint(endpoint string) string {
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = "http://localhost:4317"
+	}
+	return endpoint
+}
+
+// dialOTLP returns a connection to the OTLP/gRPC endpoint URL,
+// resolved by resolveOTLPEndpoint.
+func dialOTLP(endpoint string) (*grpc.ClientConn, error) {
+	u, err := url.Parse(resolveOTLPEndpoint(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %w", err)
+	}
+	creds := credentials.NewTLS(nil)
+	if u.Scheme == "http" {
+		creds = insecure.NewCredentials()
+	}
+	return grpc.Dial(u.Host, grpc.WithTransportCredentials(creds))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// newResource returns a Resource describing the dice server, including
+// the given attributes.
+//
+// The service name defaults to "dice-server", but OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES take precedence, so each run can be relabeled
+// without rebuilding, e.g.
+//
+//	OTEL_SERVICE_NAME=dice-server-blue \
+//	OTEL_RESOURCE_ATTRIBUTES=deployment.environment=demo \
+//	go run .
+func newResource(ctx context.Context, attrs ...attribute.KeyValue) *resource.Resource {
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName("dice-server")),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(attrs...),
+		// Detectors are applied in order, so this must be last
+		// for the environment variables to take precedence.
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		// res holds whatever could be detected.
+		log.Printf("error detecting resource: %v", err)
+	}
+	return res
+}
@@ -0,0 +1,223 @@
+// Command rollconsumer consumes the rolls the dice server publishes to
+// Kafka when configured with -kafka-brokers, e.g.
+//
+//	go run . -kafka-brokers localhost:9092 &
+//	go run ./rollconsumer -brokers localhost:9092
+//
+// Each message is processed in a consumer span continuing the trace of
+// the roll that published it, from the trace context in the message
+// headers, so traces show the asynchronous hop through the broker.
+// Processing keeps a tally of rolls, which is logged periodically.
+//
+// Telemetry is exported as OTLP, configured by -otlp-endpoint or the
+// standard OTEL_EXPORTER_OTLP_* environment variables, or to stdout
+// with -console.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/rollevents"
+)
+
+type options struct {
+	brokers []string
+	topic   string
+	group   string
+
+	otlpEndpoint string
+	console      bool
+}
+
+func parseFlags(args []string) (*options, error) {
+	var opts options
+	var brokers string
+	fs := flag.NewFlagSet("rollconsumer", flag.ContinueOnError)
+	fs.StringVar(&brokers, "brokers", "localhost:9092", "comma-separated Kafka broker addresses")
+	fs.StringVar(&opts.topic, "topic", rollevents.DefaultTopic, "Kafka topic to consume rolls from")
+	fs.StringVar(&opts.group, "group", "rollconsumer", "Kafka consumer group")
+	fs.StringVar(&opts.otlpEndpoint, "otlp-endpoint", "", "OTLP endpoint URL (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.BoolVar(&opts.console, "console", false, "print spans to stdout, instead of exporting them as OTLP")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	for _, b := range strings.Split(brokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			opts.brokers = append(opts.brokers, b)
+		}
+	}
+	if len(opts.brokers) == 0 {
+		return nil, errors.New("no Kafka brokers specified")
+	}
+	return &opts, nil
+}
+
+func initTracerProvider(ctx context.Context, opts *options) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName("rollconsumer")),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		log.Printf("error detecting resource: %v", err)
+	}
+
+	var exporter sdktrace.SpanExporter
+	if opts.console {
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	} else {
+		var exporterOpts []otlptracegrpc.Option
+		if opts.otlpEndpoint != "" {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithEndpointURL(opts.otlpEndpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, exporterOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(exporter),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider, nil
+}
+
+// tally counts the rolls consumed.
+type tally struct {
+	rolls, dice, sum int64
+}
+
+// consumer processes roll events.
+type consumer struct {
+	opts   *options
+	reader *kafka.Reader
+	tracer trace.Tracer
+	tally  tally
+}
+
+// run consumes messages until ctx is cancelled.
+func (c *consumer) run(ctx context.Context) error {
+	report := time.NewTicker(10 * time.Second)
+	defer report.Stop()
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		c.process(ctx, msg)
+		if err := c.reader.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			log.Printf("error committing offset %d: %v", msg.Offset, err)
+		}
+		select {
+		case <-report.C:
+			log.Printf("consumed %d rolls of %d dice, summing to %d", c.tally.rolls, c.tally.dice, c.tally.sum)
+		default:
+		}
+	}
+}
+
+// process processes a message in a consumer span, continuing
+// the trace from the context in the message's headers.
+func (c *consumer) process(ctx context.Context, msg kafka.Message) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, rollevents.NewHeaderCarrier(&msg))
+	_, span := c.tracer.Start(ctx, msg.Topic+" deliver",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationDeliver,
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingKafkaConsumerGroup(c.opts.group),
+			semconv.MessagingKafkaDestinationPartition(msg.Partition),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+		),
+	)
+	defer span.End()
+
+	var ev rollevents.Event
+	if err := json.Unmarshal(msg.Value, &ev); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid roll event")
+		return
+	}
+	// Time in the broker is the gap between the publish and deliver spans,
+	// but record it explicitly as it is easier to query.
+	span.SetAttributes(
+		attribute.Int64("n", ev.N),
+		attribute.Int64("sides", ev.Sides),
+		attribute.Int64("sum", ev.Sum),
+		attribute.Float64("rollevents.age", time.Since(ev.Time).Seconds()),
+	)
+	c.tally.rolls++
+	c.tally.dice += ev.N
+	c.tally.sum += ev.Sum
+}
+
+func main() {
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tracerProvider, err := initTracerProvider(ctx, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c := &consumer{
+		opts: opts,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: opts.brokers,
+			Topic:   opts.topic,
+			GroupID: opts.group,
+		}),
+		tracer: otel.Tracer("oteldemo/rollconsumer"),
+	}
+	log.Printf("consuming rolls from %s on %s", opts.topic, strings.Join(opts.brokers, ","))
+	if err := c.run(ctx); err != nil {
+		log.Print(err)
+	}
+	if err := c.reader.Close(); err != nil {
+		log.Printf("error closing reader: %v", err)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(flushCtx); err != nil {
+		log.Printf("error flushing telemetry: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+// Package rollevents defines the roll events the dice server publishes
+// to Kafka, and the propagation of trace context in their message
+// headers, so consumers can continue the traces of the rolls.
+package rollevents
+
+import (
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// DefaultTopic is the default Kafka topic to which rolls are published.
+const DefaultTopic = "rolls"
+
+// Event is a roll, published as a JSON message.
+type Event struct {
+	N     int64     `json:"n"`
+	Sides int64     `json:"sides"`
+	Sum   int64     `json:"sum"`
+	Time  time.Time `json:"time"`
+}
+
+// HeaderCarrier is a propagation.TextMapCarrier
+// for the headers of a Kafka message.
+type HeaderCarrier struct {
+	msg *kafka.Message
+}
+
+var _ propagation.TextMapCarrier = HeaderCarrier{}
+
+// NewHeaderCarrier returns a HeaderCarrier for the headers of msg.
+func NewHeaderCarrier(msg *kafka.Message) HeaderCarrier {
+	return HeaderCarrier{msg: msg}
+}
+
+// Get returns the value of the header with the given key.
+func (c HeaderCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set sets the header with the given key, replacing any existing value.
+func (c HeaderCarrier) Set(key, value string) {
+	for i, h := range c.msg.Headers {
+		if h.Key == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+// Keys returns the keys of the message's headers.
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, len(c.msg.Headers))
+	for i, h := range c.msg.Headers {
+		keys[i] = h.Key
+	}
+	return keys
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sync/atomic"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// sampler is the root sampler of the built-in pipeline, sampling all
+// traces until its ratio is set from the configuration, and updated
+// when the configuration is reloaded.
+var sampler = newRatioSampler(1)
+
+// ratioSampler is a sdktrace.Sampler that samples a given ratio
+// of traces by trace ID, which may be changed at runtime.
+type ratioSampler struct {
+	sampler atomic.Pointer[sdktrace.Sampler]
+}
+
+func newRatioSampler(ratio float64) *ratioSampler {
+	s := &ratioSampler{}
+	s.SetRatio(ratio)
+	return s
+}
+
+// SetRatio sets the ratio of traces to sample.
+func (s *ratioSampler) SetRatio(ratio float64) {
+	sampler := sdktrace.TraceIDRatioBased(ratio)
+	s.sampler.Store(&sampler)
+}
+
+func (s *ratioSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return (*s.sampler.Load()).ShouldSample(p)
+}
+
+func (s *ratioSampler) Description() string {
+	return (*s.sampler.Load()).Description()
+}
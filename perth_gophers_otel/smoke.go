@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"oteldemo/config"
+	"oteldemo/dice"
+)
+
+// smokeDice is the dice notation rolled by the smoke test,
+// which should record smokeDiceCount dice_rolls.
+const (
+	smokeDice      = "2d6"
+	smokeDiceCount = 2
+)
+
+// smoke runs a preflight check of the server: it starts the server on an
+// ephemeral port, rolls dice with an instrumented client, and verifies
+// that the expected telemetry was produced, writing a summary to w.
+//
+// Telemetry is recorded in memory rather than exported, so the check
+// does not depend on a Collector; TLS is disabled, so the client need
+// not trust the server's certificate.
+func smoke(ctx context.Context, cfg *config.Config, w io.Writer) error {
+	smokeCfg := *cfg
+	smokeCfg.ListenAddr = "localhost:0"
+	if smokeCfg.AdminAddr != "" {
+		smokeCfg.AdminAddr = "localhost:0"
+	}
+	smokeCfg.TLS = config.TLS{}
+
+	spans := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	defer tp.Shutdown(context.Background())
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer mp.Shutdown(context.Background())
+	propagators := propagation.TraceContext{}
+
+	if err := initFeatureFlags(cfg.Features.FlagsFile); err != nil {
+		return err
+	}
+	srv, err := dice.New(
+		dice.WithConfig(&smokeCfg),
+		dice.WithTracerProvider(tp),
+		dice.WithMeterProvider(mp),
+		dice.WithPropagators(propagators),
+	)
+	if err != nil {
+		return err
+	}
+	if err := srv.Listen(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ctx) }()
+	defer func() {
+		cancel()
+		<-serveErr
+	}()
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: otelhttp.NewTransport(http.DefaultTransport,
+			otelhttp.WithTracerProvider(tp),
+			otelhttp.WithPropagators(propagators),
+		),
+	}
+	start := time.Now()
+	sum, err := smokeRoll(ctx, client, "http://"+srv.Addr().String()+"/roll/"+smokeDice)
+	if err != nil {
+		return fmt.Errorf("rolling %s: %w", smokeDice, err)
+	}
+	fmt.Fprintf(w, "smoke: rolled %s = %d in %s\n", smokeDice, sum, time.Since(start).Round(time.Microsecond))
+
+	// The server span may end after the client receives the
+	// response, so wait briefly for both spans to be recorded.
+	var traceSpans tracetest.SpanStubs
+	for deadline := time.Now().Add(time.Second); ; {
+		traceSpans = spans.GetSpans()
+		if len(traceSpans) >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var errs []error
+	var names []string
+	for _, span := range traceSpans {
+		names = append(names, span.Name)
+		if span.SpanContext.TraceID() != traceSpans[0].SpanContext.TraceID() {
+			errs = append(errs, errors.New("client and server spans are in different traces"))
+		}
+	}
+	if len(traceSpans) < 2 {
+		errs = append(errs, fmt.Errorf("got %d spans, want client and server spans", len(traceSpans)))
+	} else {
+		fmt.Fprintf(w, "smoke: trace %s: %s\n", traceSpans[0].SpanContext.TraceID(), strings.Join(names, ", "))
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		return fmt.Errorf("collecting metrics: %w", err)
+	}
+	var metricNames []string
+	var rolls int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metricNames = append(metricNames, m.Name)
+			if s, ok := m.Data.(metricdata.Sum[int64]); ok && m.Name == "dice_rolls" {
+				for _, dp := range s.DataPoints {
+					rolls += dp.Value
+				}
+			}
+		}
+	}
+	if rolls != smokeDiceCount {
+		errs = append(errs, fmt.Errorf("recorded %d dice_rolls, want %d", rolls, smokeDiceCount))
+	}
+	fmt.Fprintf(w, "smoke: %d metrics: %s\n", len(metricNames), strings.Join(metricNames, ", "))
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "smoke: ok")
+	return nil
+}
+
+// smokeRoll rolls dice at url, returning the sum.
+func smokeRoll(ctx context.Context, client *http.Client, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+}
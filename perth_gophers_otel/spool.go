@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"oteldemo/config"
+)
+
+// spoolDialOptions returns gRPC dial options for spooling failed OTLP
+// export requests for the given signal ("traces" or "metrics") to disk,
+// or nil if cfg.SpoolDir is not set. The spool's size is recorded with mp.
+func spoolDialOptions(cfg config.OTLP, signal string, mp metric.MeterProvider) ([]grpc.DialOption, error) {
+	if cfg.SpoolDir == "" {
+		return nil, nil
+	}
+	var newMessages func() (req, reply proto.Message)
+	switch signal {
+	case "traces":
+		newMessages = func() (proto.Message, proto.Message) {
+			return &coltracepb.ExportTraceServiceRequest{}, &coltracepb.ExportTraceServiceResponse{}
+		}
+	case "metrics":
+		newMessages = func() (proto.Message, proto.Message) {
+			return &colmetricpb.ExportMetricsServiceRequest{}, &colmetricpb.ExportMetricsServiceResponse{}
+		}
+	default:
+		return nil, fmt.Errorf("cannot spool unknown signal %q", signal)
+	}
+	s, err := openSpool(filepath.Join(cfg.SpoolDir, signal), cfg.SpoolMaxBytes, newMessages)
+	if err != nil {
+		return nil, err
+	}
+	meter := mp.Meter(exporterMeterName)
+	gauge, err := meter.Int64ObservableGauge(
+		"otlp_spool_size",
+		metric.WithUnit("By"),
+		metric.WithDescription("Size of OTLP requests spooled to disk, awaiting replay"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		o.ObserveInt64(gauge, s.size, metric.WithAttributes(attribute.String("signal", signal)))
+		return nil
+	}, gauge)
+	if err != nil {
+		return nil, err
+	}
+	readinessChecks[signal+" spool"] = func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.size > 0 {
+			return fmt.Errorf("%d bytes of requests awaiting replay", s.size)
+		}
+		return nil
+	}
+	return []grpc.DialOption{grpc.WithChainUnaryInterceptor(s.intercept)}, nil
+}
+
+// spool holds OTLP export requests on disk while the collector is
+// unreachable, and replays them in order once it is reachable again.
+type spool struct {
+	dir         string
+	maxBytes    int64
+	newMessages func() (req, reply proto.Message)
+
+	mu        sync.Mutex
+	files     []spoolFile // oldest first
+	size      int64
+	seq       int64
+	replaying bool
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+// openSpool opens the spool in dir, creating it if necessary. Requests
+// spooled by a previous process are picked up and replayed.
+func openSpool(dir string, maxBytes int64, newMessages func() (req, reply proto.Message)) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		newMessages: newMessages,
+		seq:         time.Now().UnixNano(),
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pb") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.files = append(s.files, spoolFile{name: entry.Name(), size: info.Size()})
+		s.size += info.Size()
+	}
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].name < s.files[j].name })
+	return s, nil
+}
+
+// spoolCall controls the spooling of an export request, when carried in
+// its context by withSpoolCall, so a failover exporter can spool only
+// once both of its endpoints have failed.
+type spoolCall struct {
+	// disabled is set if the request must not be spooled.
+	disabled bool
+
+	// spooled is set by the spool if the request was spooled.
+	spooled bool
+}
+
+type spoolCallKey struct{}
+
+// withSpoolCall returns a copy of ctx carrying call.
+func withSpoolCall(ctx context.Context, call *spoolCall) context.Context {
+	return context.WithValue(ctx, spoolCallKey{}, call)
+}
+
+// intercept is a grpc.UnaryClientInterceptor which spools requests that
+// fail because the collector is unreachable, reporting success to the
+// exporter, unless disabled by the request's spoolCall. Successful
+// requests trigger replay of spooled requests.
+func (s *spool) intercept(
+	ctx context.Context, method string, req, reply any,
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil {
+		s.startReplay(method, cc, invoker)
+		return nil
+	}
+	call, _ := ctx.Value(spoolCallKey{}).(*spoolCall)
+	msg, ok := req.(proto.Message)
+	if !ok || !isTransient(err) || (call != nil && call.disabled) {
+		return err
+	}
+	if spoolErr := s.write(msg); spoolErr != nil {
+		return errors.Join(err, spoolErr)
+	}
+	if call != nil {
+		call.spooled = true
+	}
+	return nil
+}
+
+// isTransient reports whether err indicates that the collector is
+// temporarily unreachable or overloaded, and the request may be retried.
+func isTransient(err error) bool {
+	switch status.Code(err) {
+	case grpccodes.Unavailable, grpccodes.DeadlineExceeded, grpccodes.ResourceExhausted, grpccodes.Aborted:
+		return true
+	}
+	return false
+}
+
+// write adds req to the spool, dropping the oldest requests if
+// necessary to stay within the size limit.
+func (s *spool) write(req proto.Message) error {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > s.maxBytes {
+		return fmt.Errorf("request of %d bytes exceeds spool limit", len(data))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.size+int64(len(data)) > s.maxBytes {
+		oldest := s.files[0]
+		log.Printf("spool %s full, dropping %s", s.dir, oldest.name)
+		s.removeLocked(oldest.name)
+	}
+	s.seq++
+	name := fmt.Sprintf("%020d.pb", s.seq)
+	if err := os.WriteFile(filepath.Join(s.dir, name), data, 0o644); err != nil {
+		return err
+	}
+	s.files = append(s.files, spoolFile{name: name, size: int64(len(data))})
+	s.size += int64(len(data))
+	return nil
+}
+
+func (s *spool) removeLocked(name string) {
+	for i, f := range s.files {
+		if f.name == name {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			s.size -= f.size
+			break
+		}
+	}
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("error removing spooled request: %v", err)
+	}
+}
+
+// startReplay starts replaying spooled requests in the background,
+// unless there are none or a replay is already in progress.
+func (s *spool) startReplay(method string, cc *grpc.ClientConn, invoker grpc.UnaryInvoker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replaying || len(s.files) == 0 {
+		return
+	}
+	s.replaying = true
+	go s.replay(method, cc, invoker)
+}
+
+func (s *spool) replay(method string, cc *grpc.ClientConn, invoker grpc.UnaryInvoker) {
+	var replayed int
+	defer func() {
+		s.mu.Lock()
+		s.replaying = false
+		s.mu.Unlock()
+		if replayed > 0 {
+			log.Printf("replayed %d spooled requests from %s", replayed, s.dir)
+		}
+	}()
+	for {
+		s.mu.Lock()
+		if len(s.files) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		name := s.files[0].name
+		s.mu.Unlock()
+
+		err := s.replayFile(method, cc, invoker, name)
+		if isTransient(err) {
+			// Collector has gone away again;
+			// try again after the next successful export.
+			return
+		}
+		if err != nil {
+			log.Printf("error replaying spooled request %s, dropping: %v", name, err)
+		} else {
+			replayed++
+		}
+		s.mu.Lock()
+		s.removeLocked(name)
+		s.mu.Unlock()
+	}
+}
+
+func (s *spool) replayFile(method string, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, name string) error {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	req, reply := s.newMessages()
+	if err := proto.Unmarshal(data, req); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return invoker(ctx, method, req, reply, cc)
+}
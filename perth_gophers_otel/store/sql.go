@@ -0,0 +1,377 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// schema creates the tables, if they don't already exist.
+const schema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id        TEXT PRIMARY KEY,
+	rolls     INTEGER NOT NULL,
+	started   INTEGER NOT NULL,
+	last_roll INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS rolls (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	session TEXT NOT NULL REFERENCES sessions (id),
+	n       INTEGER NOT NULL,
+	sides   INTEGER NOT NULL,
+	sum     INTEGER NOT NULL,
+	time    INTEGER NOT NULL,
+	-- The span in which the roll was recorded, if any.
+	trace_id TEXT NOT NULL DEFAULT '',
+	span_id  TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS rolls_by_session ON rolls (session, id);
+CREATE INDEX IF NOT EXISTS sessions_by_rolls ON sessions (rolls DESC, id);
+CREATE TABLE IF NOT EXISTS fair_rolls (
+	id          TEXT PRIMARY KEY,
+	n           INTEGER NOT NULL,
+	sides       INTEGER NOT NULL,
+	seed        BLOB NOT NULL,
+	commitment  TEXT NOT NULL,
+	committed   INTEGER NOT NULL,
+	-- Set once the dice are rolled; rolls is a JSON array.
+	client_seed TEXT,
+	rolls       TEXT,
+	rolled      INTEGER
+);
+-- Webhooks may be registered before their session starts.
+CREATE TABLE IF NOT EXISTS webhooks (
+	session    TEXT PRIMARY KEY,
+	url        TEXT NOT NULL,
+	secret     BLOB NOT NULL,
+	registered INTEGER NOT NULL
+);
+`
+
+// Option configures a SQLStore.
+type Option func(*options)
+
+type options struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+// WithTracerProvider sets the TracerProvider used to trace queries.
+// If unspecified, the global TracerProvider is used.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) { o.tracerProvider = tp }
+}
+
+// WithMeterProvider sets the MeterProvider used to report query and
+// connection pool metrics. If unspecified, the global MeterProvider
+// is used.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(o *options) { o.meterProvider = mp }
+}
+
+// SQLStore is a Store in a SQLite database.
+type SQLStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// OpenSQLite opens the SQLite database at path, creating it if
+// necessary. Queries are traced with otelsql.
+func OpenSQLite(path string, opts ...Option) (*SQLStore, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.tracerProvider == nil {
+		o.tracerProvider = otel.GetTracerProvider()
+	}
+	if o.meterProvider == nil {
+		o.meterProvider = otel.GetMeterProvider()
+	}
+	sqlOpts := []otelsql.Option{
+		otelsql.WithTracerProvider(o.tracerProvider),
+		otelsql.WithMeterProvider(o.meterProvider),
+		otelsql.WithAttributes(semconv.DBSystemSqlite),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			// Trace the queries, rather than the plumbing around them.
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			OmitConnectorConnect: true,
+		}),
+	}
+	db, err := otelsql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_foreign_keys=on", sqlOpts...)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time; serialize
+	// access rather than retrying when it is locked.
+	db.SetMaxOpenConns(1)
+	if err := otelsql.RegisterDBStatsMetrics(db, sqlOpts...); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+// RecordRoll records a roll in a session, starting the session if
+// necessary. The roll is recorded with the span context of ctx.
+func (s *SQLStore) RecordRoll(ctx context.Context, session string, roll Roll) error {
+	var traceID, spanID string
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		traceID, spanID = sc.TraceID().String(), sc.SpanID().String()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	t := roll.Time.UnixNano()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO sessions (id, rolls, started, last_roll) VALUES (?, 1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET rolls = rolls + 1, last_roll = excluded.last_roll`,
+		session, t, t,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO rolls (session, n, sides, sum, time, trace_id, span_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session, roll.N, roll.Sides, roll.Sum, t, traceID, spanID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Session returns a session, or ErrNotFound.
+func (s *SQLStore) Session(ctx context.Context, id string) (Session, error) {
+	sess := Session{ID: id}
+	var started, lastRoll int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT rolls, started, last_roll FROM sessions WHERE id = ?`, id,
+	).Scan(&sess.Rolls, &started, &lastRoll)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrNotFound
+	} else if err != nil {
+		return Session{}, err
+	}
+	sess.Started = time.Unix(0, started).UTC()
+	sess.LastRoll = time.Unix(0, lastRoll).UTC()
+	return sess, nil
+}
+
+// History returns up to limit of the most
+// recent rolls in a session, newest first.
+func (s *SQLStore) History(ctx context.Context, session string, limit int) ([]Roll, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+rollColumns+` FROM rolls WHERE session = ? ORDER BY id DESC LIMIT ?`,
+		session, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRolls(rows)
+}
+
+// OldRolls returns up to limit of the rolls older than the keep
+// most recent rolls of their session, oldest first.
+func (s *SQLStore) OldRolls(ctx context.Context, keep, limit int) ([]Roll, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+rollColumns+` FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY session ORDER BY id DESC) AS age FROM rolls
+		) WHERE age > ? ORDER BY id LIMIT ?`,
+		keep, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRolls(rows)
+}
+
+// DeleteRolls deletes rolls by ID. Deleted rolls are still
+// counted in their session's number of rolls.
+func (s *SQLStore) DeleteRolls(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.Repeat(", ?", len(ids))[2:]
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rolls WHERE id IN (`+placeholders+`)`, args...)
+	return err
+}
+
+// Leaderboard returns up to limit of the sessions
+// with the most rolls, in descending order.
+func (s *SQLStore) Leaderboard(ctx context.Context, limit int) ([]Leader, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.rolls, s.started, s.last_roll, COALESCE(r.trace_id, ''), COALESCE(r.span_id, '')
+		FROM sessions s
+		LEFT JOIN rolls r ON r.id = (SELECT MAX(id) FROM rolls WHERE session = s.id)
+		ORDER BY s.rolls DESC, s.id LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	leaders := []Leader{}
+	for rows.Next() {
+		var l Leader
+		var started, lastRoll int64
+		var traceID, spanID string
+		if err := rows.Scan(&l.ID, &l.Rolls, &started, &lastRoll, &traceID, &spanID); err != nil {
+			return nil, err
+		}
+		l.Started = time.Unix(0, started).UTC()
+		l.LastRoll = time.Unix(0, lastRoll).UTC()
+		l.SpanContext = spanContext(traceID, spanID)
+		leaders = append(leaders, l)
+	}
+	return leaders, rows.Err()
+}
+
+// CommitFairRoll records a fair roll whose dice are yet to be rolled.
+func (s *SQLStore) CommitFairRoll(ctx context.Context, roll FairRoll) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO fair_rolls (id, n, sides, seed, commitment, committed) VALUES (?, ?, ?, ?, ?, ?)`,
+		roll.ID, roll.N, roll.Sides, roll.Seed, roll.Commitment, roll.Committed.UnixNano(),
+	)
+	return err
+}
+
+// CompleteFairRoll records the client seed and rolls of a fair
+// roll, or returns ErrFairRollNotFound or ErrFairRollRolled.
+func (s *SQLStore) CompleteFairRoll(ctx context.Context, id, clientSeed string, rolls []int64, rolled time.Time) error {
+	data, err := json.Marshal(rolls)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE fair_rolls SET client_seed = ?, rolls = ?, rolled = ? WHERE id = ? AND rolled IS NULL`,
+		clientSeed, string(data), rolled.UnixNano(), id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		// Either the roll doesn't exist, or it was already rolled.
+		if _, err := s.FairRoll(ctx, id); err != nil {
+			return err
+		}
+		return ErrFairRollRolled
+	}
+	return nil
+}
+
+// FairRoll returns a fair roll, or ErrFairRollNotFound.
+func (s *SQLStore) FairRoll(ctx context.Context, id string) (FairRoll, error) {
+	roll := FairRoll{ID: id}
+	var committed int64
+	var clientSeed, rolls sql.NullString
+	var rolled sql.NullInt64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT n, sides, seed, commitment, committed, client_seed, rolls, rolled FROM fair_rolls WHERE id = ?`, id,
+	).Scan(&roll.N, &roll.Sides, &roll.Seed, &roll.Commitment, &committed, &clientSeed, &rolls, &rolled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return FairRoll{}, ErrFairRollNotFound
+	} else if err != nil {
+		return FairRoll{}, err
+	}
+	roll.Committed = time.Unix(0, committed).UTC()
+	if rolled.Valid {
+		roll.ClientSeed = clientSeed.String
+		if err := json.Unmarshal([]byte(rolls.String), &roll.Rolls); err != nil {
+			return FairRoll{}, err
+		}
+		roll.Rolled = time.Unix(0, rolled.Int64).UTC()
+	}
+	return roll, nil
+}
+
+// SetWebhook registers a webhook for its session, replacing any
+// registered before. The session need not have started.
+func (s *SQLStore) SetWebhook(ctx context.Context, hook Webhook) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhooks (session, url, secret, registered) VALUES (?, ?, ?, ?)
+		ON CONFLICT (session) DO UPDATE SET
+			url = excluded.url, secret = excluded.secret, registered = excluded.registered`,
+		hook.Session, hook.URL, hook.Secret, hook.Registered.UnixNano(),
+	)
+	return err
+}
+
+// Webhook returns the webhook of a session, or ErrWebhookNotFound.
+func (s *SQLStore) Webhook(ctx context.Context, session string) (Webhook, error) {
+	hook := Webhook{Session: session}
+	var registered int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT url, secret, registered FROM webhooks WHERE session = ?`, session,
+	).Scan(&hook.URL, &hook.Secret, &registered)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Webhook{}, ErrWebhookNotFound
+	} else if err != nil {
+		return Webhook{}, err
+	}
+	hook.Registered = time.Unix(0, registered).UTC()
+	return hook, nil
+}
+
+// rollColumns are the columns of the rolls table scanned by scanRolls.
+const rollColumns = `id, n, sides, sum, time, trace_id, span_id`
+
+// scanRolls scans rows of rollColumns, closing them.
+func scanRolls(rows *sql.Rows) ([]Roll, error) {
+	defer rows.Close()
+	rolls := []Roll{}
+	for rows.Next() {
+		var roll Roll
+		var t int64
+		var traceID, spanID string
+		if err := rows.Scan(&roll.ID, &roll.N, &roll.Sides, &roll.Sum, &t, &traceID, &spanID); err != nil {
+			return nil, err
+		}
+		roll.Time = time.Unix(0, t).UTC()
+		roll.SpanContext = spanContext(traceID, spanID)
+		rolls = append(rolls, roll)
+	}
+	return rolls, rows.Err()
+}
+
+// spanContext returns the context of the span with the given hex
+// encoded IDs, or an invalid span context if they are invalid.
+func spanContext(traceID, spanID string) trace.SpanContext {
+	tid, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	sid, err := trace.SpanIDFromHex(spanID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, Remote: true})
+}
+
+// Close closes the database.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"oteldemo/teletest"
+)
+
+func openTestStore(t *testing.T) (*SQLStore, *teletest.Recorder) {
+	t.Helper()
+	rec := teletest.New(t)
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "dice.db"),
+		WithTracerProvider(rec.TracerProvider),
+		WithMeterProvider(rec.MeterProvider),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, rec
+}
+
+func TestSQLStore(t *testing.T) {
+	s, rec := openTestStore(t)
+	ctx := context.Background()
+	start := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		roll := Roll{N: 2, Sides: 6, Sum: int64(2 + i), Time: start.Add(time.Duration(i) * time.Second)}
+		if err := s.RecordRoll(ctx, "alice", roll); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.RecordRoll(ctx, "bob", Roll{N: 1, Sides: 20, Sum: 20, Time: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := s.Session(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Session{ID: "alice", Rolls: 3, Started: start, LastRoll: start.Add(2 * time.Second)}
+	if sess != want {
+		t.Errorf("got session %+v, want %+v", sess, want)
+	}
+	rolls, err := s.History(ctx, "alice", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rolls) != 2 || rolls[0].Sum != 4 || rolls[1].Sum != 3 {
+		t.Errorf("got history %+v, want the last two rolls, newest first", rolls)
+	}
+	if _, err := s.Session(ctx, "carol"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+
+	rec.ExpectSpan("sql.conn.exec").WithAttr(semconv.DBSystemSqlite)
+	rec.ExpectSpan("sql.conn.query").WithAttr(semconv.DBSystemSqlite)
+}
+
+func TestSQLStoreCompaction(t *testing.T) {
+	s, rec := openTestStore(t)
+	start := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	var spans []trace.SpanContext
+	for i := range 3 {
+		ctx, span := rec.TracerProvider.Tracer("test").Start(context.Background(), "roll")
+		spans = append(spans, span.SpanContext())
+		roll := Roll{N: 1, Sides: 6, Sum: int64(1 + i), Time: start.Add(time.Duration(i) * time.Second)}
+		if err := s.RecordRoll(ctx, "alice", roll); err != nil {
+			t.Fatal(err)
+		}
+		span.End()
+	}
+	if err := s.RecordRoll(context.Background(), "bob", Roll{N: 1, Sides: 20, Sum: 20, Time: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	old, err := s.OldRolls(ctx, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old) != 2 || old[0].Sum != 1 || old[1].Sum != 2 {
+		t.Fatalf("got old rolls %+v, want alice's first two rolls, oldest first", old)
+	}
+	for i, roll := range old {
+		if roll.SpanContext.SpanID() != spans[i].SpanID() {
+			t.Errorf("roll %d: got span %s, want %s", i, roll.SpanContext.SpanID(), spans[i].SpanID())
+		}
+	}
+	if err := s.DeleteRolls(ctx, []int64{old[0].ID, old[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if rolls, err := s.History(ctx, "alice", 10); err != nil || len(rolls) != 1 {
+		t.Errorf("got history %+v (%v), want one roll", rolls, err)
+	}
+
+	leaders, err := s.Leaderboard(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaders) != 2 || leaders[0].ID != "alice" || leaders[0].Rolls != 3 || leaders[1].ID != "bob" {
+		t.Fatalf("got leaderboard %+v, want alice, with all 3 rolls counted, then bob", leaders)
+	}
+	if leaders[0].SpanContext.SpanID() != spans[2].SpanID() {
+		t.Errorf("got alice's span %s, want the last roll's %s", leaders[0].SpanContext.SpanID(), spans[2].SpanID())
+	}
+	if leaders[1].SpanContext.IsValid() {
+		t.Errorf("got bob's span %s, want none", leaders[1].SpanContext.SpanID())
+	}
+}
+
+func TestSQLStoreFairRolls(t *testing.T) {
+	s, _ := openTestStore(t)
+	ctx := context.Background()
+	committed := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	if err := s.CommitFairRoll(ctx, FairRoll{
+		ID: "abc", N: 2, Sides: 6, Seed: []byte{1, 2, 3}, Commitment: "c0ffee", Committed: committed,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	roll, err := s.FairRoll(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roll.N != 2 || roll.Sides != 6 || string(roll.Seed) != "\x01\x02\x03" || roll.Commitment != "c0ffee" ||
+		!roll.Committed.Equal(committed) || !roll.Rolled.IsZero() {
+		t.Errorf("got %+v, want the committed roll, not yet rolled", roll)
+	}
+
+	rolled := committed.Add(time.Second)
+	if err := s.CompleteFairRoll(ctx, "abc", "lucky", []int64{3, 5}, rolled); err != nil {
+		t.Fatal(err)
+	}
+	if roll, err = s.FairRoll(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if roll.ClientSeed != "lucky" || len(roll.Rolls) != 2 || roll.Rolls[1] != 5 || !roll.Rolled.Equal(rolled) {
+		t.Errorf("got %+v, want it rolled", roll)
+	}
+
+	if err := s.CompleteFairRoll(ctx, "abc", "luckier", []int64{6, 6}, rolled); !errors.Is(err, ErrFairRollRolled) {
+		t.Errorf("rolling again: got error %v, want ErrFairRollRolled", err)
+	}
+	if err := s.CompleteFairRoll(ctx, "xyz", "lucky", []int64{1}, rolled); !errors.Is(err, ErrFairRollNotFound) {
+		t.Errorf("rolling a missing roll: got error %v, want ErrFairRollNotFound", err)
+	}
+	if _, err := s.FairRoll(ctx, "xyz"); !errors.Is(err, ErrFairRollNotFound) {
+		t.Errorf("got error %v, want ErrFairRollNotFound", err)
+	}
+}
+
+func TestSQLStoreWebhooks(t *testing.T) {
+	s, _ := openTestStore(t)
+	ctx := context.Background()
+	if _, err := s.Webhook(ctx, "alice"); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("got error %v, want ErrWebhookNotFound", err)
+	}
+	registered := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	for _, url := range []string{"http://example.com/old", "http://example.com/new"} {
+		if err := s.SetWebhook(ctx, Webhook{
+			Session: "alice", URL: url, Secret: []byte(url), Registered: registered,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hook, err := s.Webhook(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.URL != "http://example.com/new" || string(hook.Secret) != hook.URL || !hook.Registered.Equal(registered) {
+		t.Errorf("got %+v, want the webhook registered last", hook)
+	}
+}
@@ -0,0 +1,134 @@
+// Package store persists the history of rolls, grouped into sessions.
+//
+// The SQL implementation is instrumented with otelsql, so queries appear
+// as spans, with their statements, beneath the requests making them.
+// Rolls are recorded with the context of the span recording them, so
+// that background jobs processing them later can link to it.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrNotFound is returned for sessions that don't exist.
+var ErrNotFound = errors.New("session not found")
+
+// Errors returned for fair rolls.
+var (
+	// ErrFairRollNotFound is returned for fair rolls that don't exist.
+	ErrFairRollNotFound = errors.New("fair roll not found")
+
+	// ErrFairRollRolled is returned when completing
+	// a fair roll whose dice have already been rolled.
+	ErrFairRollRolled = errors.New("fair roll already rolled")
+)
+
+// ErrWebhookNotFound is returned for sessions without a webhook.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Roll is a roll of dice in a session.
+type Roll struct {
+	N     int64     `json:"n"`
+	Sides int64     `json:"sides"`
+	Sum   int64     `json:"sum"`
+	Time  time.Time `json:"time"`
+
+	// ID identifies the roll in the store.
+	ID int64 `json:"-"`
+
+	// SpanContext is the context of the span in which
+	// the roll was recorded, if it was recorded in one.
+	SpanContext trace.SpanContext `json:"-"`
+}
+
+// Session is a sequence of rolls by a client, identified by the client.
+type Session struct {
+	ID       string    `json:"id"`
+	Rolls    int64     `json:"rolls"`
+	Started  time.Time `json:"started"`
+	LastRoll time.Time `json:"last_roll"`
+}
+
+// Leader is a session on the leaderboard.
+type Leader struct {
+	Session
+
+	// SpanContext is the context of the span in
+	// which the session's last roll was recorded.
+	SpanContext trace.SpanContext `json:"-"`
+}
+
+// FairRoll is a provably fair roll. It is committed to before the
+// dice are rolled, and completed with the client seed and the rolls.
+type FairRoll struct {
+	ID         string
+	N          int64
+	Sides      int64
+	Seed       []byte
+	Commitment string
+	Committed  time.Time
+
+	// ClientSeed, Rolls and Rolled are set once the dice are rolled.
+	ClientSeed string
+	Rolls      []int64
+	Rolled     time.Time
+}
+
+// Webhook is a URL registered for a session, to which its rolls are
+// delivered, signed with the secret.
+type Webhook struct {
+	Session    string
+	URL        string
+	Secret     []byte
+	Registered time.Time
+}
+
+// Store persists rolls and sessions.
+type Store interface {
+	// RecordRoll records a roll in a session,
+	// starting the session if necessary.
+	RecordRoll(ctx context.Context, session string, roll Roll) error
+
+	// Session returns a session, or ErrNotFound.
+	Session(ctx context.Context, id string) (Session, error)
+
+	// History returns up to limit of the most
+	// recent rolls in a session, newest first.
+	History(ctx context.Context, session string, limit int) ([]Roll, error)
+
+	// OldRolls returns up to limit of the rolls older than the keep
+	// most recent rolls of their session, oldest first.
+	OldRolls(ctx context.Context, keep, limit int) ([]Roll, error)
+
+	// DeleteRolls deletes rolls by ID. Deleted rolls are still
+	// counted in their session's number of rolls.
+	DeleteRolls(ctx context.Context, ids []int64) error
+
+	// Leaderboard returns up to limit of the sessions
+	// with the most rolls, in descending order.
+	Leaderboard(ctx context.Context, limit int) ([]Leader, error)
+
+	// CommitFairRoll records a fair roll whose dice are yet to be rolled.
+	CommitFairRoll(ctx context.Context, roll FairRoll) error
+
+	// CompleteFairRoll records the client seed and rolls of a fair
+	// roll, or returns ErrFairRollNotFound or ErrFairRollRolled.
+	CompleteFairRoll(ctx context.Context, id, clientSeed string, rolls []int64, rolled time.Time) error
+
+	// FairRoll returns a fair roll, or ErrFairRollNotFound.
+	FairRoll(ctx context.Context, id string) (FairRoll, error)
+
+	// SetWebhook registers a webhook for its session, replacing any
+	// registered before. The session need not have started.
+	SetWebhook(ctx context.Context, hook Webhook) error
+
+	// Webhook returns the webhook of a session, or ErrWebhookNotFound.
+	Webhook(ctx context.Context, session string) (Webhook, error)
+
+	// Close closes the store.
+	Close() error
+}
@@ -0,0 +1,390 @@
+// Package teletest provides fluent assertions over telemetry recorded
+// in tests. A Recorder provides SDK tracer and meter providers that
+// record in memory, for the code under test to use:
+//
+//	rec := teletest.New(t)
+//	srv, err := dice.New(
+//		dice.WithTracerProvider(rec.TracerProvider),
+//		dice.WithMeterProvider(rec.MeterProvider),
+//	)
+//	...
+//	rec.ExpectSpan("/roll/:dice").
+//		WithAttr(attribute.Int("http.status_code", 200)).
+//		WithEvents("die rolled", 3)
+//	rec.ExpectMetric("dice_rolls").Sum(3)
+//
+// Each assertion reports a test error if it fails, including the
+// telemetry that was recorded, and later assertions in the chain are
+// skipped.
+package teletest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recorder records telemetry in memory.
+type Recorder struct {
+	TracerProvider *sdktrace.TracerProvider
+	MeterProvider  *sdkmetric.MeterProvider
+
+	t      testing.TB
+	spans  *tracetest.SpanRecorder
+	reader *sdkmetric.ManualReader
+}
+
+// New returns a Recorder, whose providers are shut
+// down when the test completes.
+func New(t testing.TB) *Recorder {
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	r := &Recorder{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+		t:              t,
+		spans:          spans,
+		reader:         reader,
+	}
+	t.Cleanup(func() {
+		r.TracerProvider.Shutdown(context.Background())
+		r.MeterProvider.Shutdown(context.Background())
+	})
+	return r
+}
+
+// Spans returns the spans that have ended.
+func (r *Recorder) Spans() []sdktrace.ReadOnlySpan {
+	return r.spans.Ended()
+}
+
+// Metrics collects and returns the metrics recorded.
+func (r *Recorder) Metrics() []metricdata.Metrics {
+	r.t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := r.reader.Collect(context.Background(), &rm); err != nil {
+		r.t.Fatalf("collecting metrics: %v", err)
+	}
+	var metrics []metricdata.Metrics
+	for _, sm := range rm.ScopeMetrics {
+		metrics = append(metrics, sm.Metrics...)
+	}
+	return metrics
+}
+
+// ExpectSpan asserts that a span with the given name has ended, and
+// returns a SpanAssertion for further assertions about such spans.
+func (r *Recorder) ExpectSpan(name string) *SpanAssertion {
+	r.t.Helper()
+	a := &SpanAssertion{t: r.t, desc: fmt.Sprintf("span %q", name), all: r.Spans()}
+	for _, span := range a.all {
+		if span.Name() == name {
+			a.spans = append(a.spans, span)
+		}
+	}
+	a.check()
+	return a
+}
+
+// ExpectNoSpans asserts that no spans have ended.
+func (r *Recorder) ExpectNoSpans() {
+	r.t.Helper()
+	if spans := r.Spans(); len(spans) > 0 {
+		r.t.Errorf("expected no spans, got:\n%s", formatSpans(spans))
+	}
+}
+
+// SpanAssertion makes assertions about the spans matched so far. Each
+// method narrows the matched spans to those satisfying a condition,
+// reporting an error if none do.
+type SpanAssertion struct {
+	t      testing.TB
+	desc   string
+	all    []sdktrace.ReadOnlySpan
+	spans  []sdktrace.ReadOnlySpan
+	failed bool
+}
+
+// WithAttr asserts that a matched span has all of the attributes.
+func (a *SpanAssertion) WithAttr(attrs ...attribute.KeyValue) *SpanAssertion {
+	a.t.Helper()
+	return a.filter(fmt.Sprintf("attributes %s", formatAttrs(attrs)), func(span sdktrace.ReadOnlySpan) bool {
+		return hasAttrs(attribute.NewSet(span.Attributes()...), attrs)
+	})
+}
+
+// WithStatus asserts that a matched span has the status code.
+func (a *SpanAssertion) WithStatus(code codes.Code) *SpanAssertion {
+	a.t.Helper()
+	return a.filter(fmt.Sprintf("status %s", code), func(span sdktrace.ReadOnlySpan) bool {
+		return span.Status().Code == code
+	})
+}
+
+// WithKind asserts that a matched span has the span kind.
+func (a *SpanAssertion) WithKind(kind trace.SpanKind) *SpanAssertion {
+	a.t.Helper()
+	return a.filter(fmt.Sprintf("kind %s", kind), func(span sdktrace.ReadOnlySpan) bool {
+		return span.SpanKind() == kind
+	})
+}
+
+// WithEvent asserts that a matched span has an event with
+// the given name, and all of the attributes.
+func (a *SpanAssertion) WithEvent(name string, attrs ...attribute.KeyValue) *SpanAssertion {
+	a.t.Helper()
+	desc := fmt.Sprintf("event %q", name)
+	if len(attrs) > 0 {
+		desc += " with attributes " + formatAttrs(attrs)
+	}
+	return a.filter(desc, func(span sdktrace.ReadOnlySpan) bool {
+		for _, event := range span.Events() {
+			if event.Name == name && hasAttrs(attribute.NewSet(event.Attributes...), attrs) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// WithEvents asserts that a matched span has exactly n events with the name.
+func (a *SpanAssertion) WithEvents(name string, n int) *SpanAssertion {
+	a.t.Helper()
+	return a.filter(fmt.Sprintf("%d %q events", n, name), func(span sdktrace.ReadOnlySpan) bool {
+		var count int
+		for _, event := range span.Events() {
+			if event.Name == name {
+				count++
+			}
+		}
+		return count == n
+	})
+}
+
+// Span returns the first matched span, or nil if an assertion failed.
+func (a *SpanAssertion) Span() sdktrace.ReadOnlySpan {
+	if a.failed {
+		return nil
+	}
+	return a.spans[0]
+}
+
+func (a *SpanAssertion) filter(desc string, match func(sdktrace.ReadOnlySpan) bool) *SpanAssertion {
+	a.t.Helper()
+	if a.failed {
+		return a
+	}
+	a.desc += ", with " + desc
+	var matched []sdktrace.ReadOnlySpan
+	for _, span := range a.spans {
+		if match(span) {
+			matched = append(matched, span)
+		}
+	}
+	a.spans = matched
+	a.check()
+	return a
+}
+
+func (a *SpanAssertion) check() {
+	a.t.Helper()
+	if len(a.spans) == 0 {
+		a.failed = true
+		a.t.Errorf("expected %s, got spans:\n%s", a.desc, formatSpans(a.all))
+	}
+}
+
+// ExpectMetric asserts that a metric with the given name has been
+// recorded, and returns a MetricAssertion for further assertions.
+func (r *Recorder) ExpectMetric(name string) *MetricAssertion {
+	r.t.Helper()
+	a := &MetricAssertion{t: r.t, desc: fmt.Sprintf("metric %q", name)}
+	var names []string
+	for _, m := range r.Metrics() {
+		names = append(names, m.Name)
+		if m.Name == name {
+			a.points = dataPoints(m.Data)
+			a.histogram = isHistogram(m.Data)
+			return a
+		}
+	}
+	a.failed = true
+	r.t.Errorf("expected %s, got metrics: %s", a.desc, strings.Join(names, ", "))
+	return a
+}
+
+// ExpectNoMetric asserts that no metric with the given name has been recorded.
+func (r *Recorder) ExpectNoMetric(name string) {
+	r.t.Helper()
+	for _, m := range r.Metrics() {
+		if m.Name == name {
+			r.t.Errorf("expected no metric %q, got %d data points", name, len(dataPoints(m.Data)))
+		}
+	}
+}
+
+// MetricAssertion makes assertions about a metric's data points.
+type MetricAssertion struct {
+	t         testing.TB
+	desc      string
+	points    []dataPoint
+	histogram bool
+	failed    bool
+}
+
+// dataPoint is a data point of any type of metric, with the value
+// of a sum or gauge, or the sum and count of a histogram.
+type dataPoint struct {
+	attrs attribute.Set
+	value float64
+	count uint64
+}
+
+// WithAttr narrows the data points to those with all of the
+// attributes, asserting that there is at least one.
+func (a *MetricAssertion) WithAttr(attrs ...attribute.KeyValue) *MetricAssertion {
+	a.t.Helper()
+	if a.failed {
+		return a
+	}
+	var matched []dataPoint
+	for _, dp := range a.points {
+		if hasAttrs(dp.attrs, attrs) {
+			matched = append(matched, dp)
+		}
+	}
+	if len(matched) == 0 {
+		a.failed = true
+		var got []string
+		for _, dp := range a.points {
+			got = append(got, formatAttrs(dp.attrs.ToSlice()))
+		}
+		a.t.Errorf("expected %s data point with attributes %s, got:\n\t%s",
+			a.desc, formatAttrs(attrs), strings.Join(got, "\n\t"))
+		return a
+	}
+	a.desc += " with attributes " + formatAttrs(attrs)
+	a.points = matched
+	return a
+}
+
+// Sum asserts that the values of the data points, or the sums of
+// histogram data points, total want.
+func (a *MetricAssertion) Sum(want float64) *MetricAssertion {
+	a.t.Helper()
+	if a.failed {
+		return a
+	}
+	var got float64
+	for _, dp := range a.points {
+		got += dp.value
+	}
+	if got != want {
+		a.failed = true
+		a.t.Errorf("expected %s to sum to %v, got %v", a.desc, want, got)
+	}
+	return a
+}
+
+// Count asserts that the counts of histogram data points total want.
+func (a *MetricAssertion) Count(want uint64) *MetricAssertion {
+	a.t.Helper()
+	if a.failed {
+		return a
+	}
+	if !a.histogram {
+		a.failed = true
+		a.t.Errorf("expected %s to be a histogram", a.desc)
+		return a
+	}
+	var got uint64
+	for _, dp := range a.points {
+		got += dp.count
+	}
+	if got != want {
+		a.failed = true
+		a.t.Errorf("expected %s count of %d, got %d", a.desc, want, got)
+	}
+	return a
+}
+
+func dataPoints(data metricdata.Aggregation) []dataPoint {
+	var points []dataPoint
+	switch data := data.(type) {
+	case metricdata.Sum[int64]:
+		for _, dp := range data.DataPoints {
+			points = append(points, dataPoint{attrs: dp.Attributes, value: float64(dp.Value)})
+		}
+	case metricdata.Sum[float64]:
+		for _, dp := range data.DataPoints {
+			points = append(points, dataPoint{attrs: dp.Attributes, value: dp.Value})
+		}
+	case metricdata.Gauge[int64]:
+		for _, dp := range data.DataPoints {
+			points = append(points, dataPoint{attrs: dp.Attributes, value: float64(dp.Value)})
+		}
+	case metricdata.Gauge[float64]:
+		for _, dp := range data.DataPoints {
+			points = append(points, dataPoint{attrs: dp.Attributes, value: dp.Value})
+		}
+	case metricdata.Histogram[int64]:
+		for _, dp := range data.DataPoints {
+			points = append(points, dataPoint{attrs: dp.Attributes, value: float64(dp.Sum), count: dp.Count})
+		}
+	case metricdata.Histogram[float64]:
+		for _, dp := range data.DataPoints {
+			points = append(points, dataPoint{attrs: dp.Attributes, value: dp.Sum, count: dp.Count})
+		}
+	}
+	return points
+}
+
+func isHistogram(data metricdata.Aggregation) bool {
+	switch data.(type) {
+	case metricdata.Histogram[int64], metricdata.Histogram[float64]:
+		return true
+	}
+	return false
+}
+
+// hasAttrs reports whether set contains all of attrs.
+func hasAttrs(set attribute.Set, attrs []attribute.KeyValue) bool {
+	for _, kv := range attrs {
+		if v, ok := set.Value(kv.Key); !ok || v != kv.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func formatAttrs(attrs []attribute.KeyValue) string {
+	parts := make([]string, len(attrs))
+	for i, kv := range attrs {
+		parts[i] = string(kv.Key) + "=" + kv.Value.Emit()
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func formatSpans(spans []sdktrace.ReadOnlySpan) string {
+	if len(spans) == 0 {
+		return "\t(none)"
+	}
+	var b strings.Builder
+	for _, span := range spans {
+		fmt.Fprintf(&b, "\t%q %s status=%s", span.Name(), formatAttrs(span.Attributes()), span.Status().Code)
+		for _, event := range span.Events() {
+			fmt.Fprintf(&b, "\n\t\tevent %q %s", event.Name, formatAttrs(event.Attributes))
+		}
+		b.WriteByte('\n')
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
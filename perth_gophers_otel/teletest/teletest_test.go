@@ -0,0 +1,104 @@
+package teletest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeT records the errors reported by assertions, so
+// tests can check that failing assertions fail.
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+// record records a span and metric, as the code under test would.
+func record(rec *Recorder) {
+	ctx := context.Background()
+	_, span := rec.TracerProvider.Tracer("test").Start(ctx, "roll dice",
+		trace.WithAttributes(attribute.Int("n", 2)),
+	)
+	span.AddEvent("die rolled", trace.WithAttributes(attribute.Int("value", 3)))
+	span.AddEvent("die rolled", trace.WithAttributes(attribute.Int("value", 5)))
+	span.SetStatus(codes.Error, "tetraphobic")
+	span.End()
+
+	meter := rec.MeterProvider.Meter("test")
+	counter, _ := meter.Int64Counter("dice_rolls")
+	counter.Add(ctx, 1, metric.WithAttributes(attribute.Int("value", 3)))
+	counter.Add(ctx, 1, metric.WithAttributes(attribute.Int("value", 5)))
+	histogram, _ := meter.Float64Histogram("roll.duration")
+	histogram.Record(ctx, 0.5)
+	histogram.Record(ctx, 1.5)
+}
+
+func TestPassingAssertions(t *testing.T) {
+	rec := New(t)
+	record(rec)
+	rec.ExpectSpan("roll dice").
+		WithAttr(attribute.Int("n", 2)).
+		WithStatus(codes.Error).
+		WithKind(trace.SpanKindInternal).
+		WithEvent("die rolled", attribute.Int("value", 5)).
+		WithEvents("die rolled", 2)
+	rec.ExpectMetric("dice_rolls").Sum(2)
+	rec.ExpectMetric("dice_rolls").WithAttr(attribute.Int("value", 3)).Sum(1)
+	rec.ExpectMetric("roll.duration").Sum(2).Count(2)
+	rec.ExpectNoMetric("http.server.request.duration")
+}
+
+func TestFailingAssertions(t *testing.T) {
+	for name, assert := range map[string]func(*Recorder){
+		"no span":         func(r *Recorder) { r.ExpectSpan("roll die") },
+		"attribute":       func(r *Recorder) { r.ExpectSpan("roll dice").WithAttr(attribute.Int("n", 3)) },
+		"status":          func(r *Recorder) { r.ExpectSpan("roll dice").WithStatus(codes.Ok) },
+		"kind":            func(r *Recorder) { r.ExpectSpan("roll dice").WithKind(trace.SpanKindServer) },
+		"event":           func(r *Recorder) { r.ExpectSpan("roll dice").WithEvent("die rolled", attribute.Int("value", 4)) },
+		"event count":     func(r *Recorder) { r.ExpectSpan("roll dice").WithEvents("die rolled", 1) },
+		"spans":           func(r *Recorder) { r.ExpectNoSpans() },
+		"no metric":       func(r *Recorder) { r.ExpectMetric("dice_roll") },
+		"metric":          func(r *Recorder) { r.ExpectNoMetric("dice_rolls") },
+		"sum":             func(r *Recorder) { r.ExpectMetric("dice_rolls").Sum(3) },
+		"data point":      func(r *Recorder) { r.ExpectMetric("dice_rolls").WithAttr(attribute.Int("value", 4)) },
+		"count":           func(r *Recorder) { r.ExpectMetric("roll.duration").Count(3) },
+		"not a histogram": func(r *Recorder) { r.ExpectMetric("dice_rolls").Count(2) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ft := &fakeT{TB: t}
+			rec := New(ft)
+			record(rec)
+			assert(rec)
+			if len(ft.errors) != 1 {
+				t.Errorf("got %d errors, want 1: %q", len(ft.errors), ft.errors)
+			}
+		})
+	}
+}
+
+func TestFailedChainReportsOnce(t *testing.T) {
+	ft := &fakeT{TB: t}
+	rec := New(ft)
+	record(rec)
+	span := rec.ExpectSpan("roll die").
+		WithAttr(attribute.Int("n", 3)).
+		WithStatus(codes.Ok).
+		Span()
+	if span != nil {
+		t.Errorf("got span %q from failed assertion, want nil", span.Name())
+	}
+	if len(ft.errors) != 1 {
+		t.Errorf("got %d errors, want 1: %q", len(ft.errors), ft.errors)
+	}
+}
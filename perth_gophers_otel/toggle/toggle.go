@@ -0,0 +1,78 @@
+// Package toggle provides named switches for turning pieces of
+// instrumentation on and off at runtime, e.g. from an admin API,
+// so telemetry can be shown appearing and disappearing live.
+package toggle
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Toggle is a named switch, safe for concurrent use.
+type Toggle struct {
+	name        string
+	description string
+	enabled     atomic.Bool
+}
+
+var (
+	mu      sync.Mutex
+	toggles = make(map[string]*Toggle)
+)
+
+// New registers and returns a new Toggle with the given name,
+// description and initial state. New panics if a toggle with
+// the same name is already registered.
+func New(name, description string, enabled bool) *Toggle {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := toggles[name]; ok {
+		panic(fmt.Sprintf("toggle %q already registered", name))
+	}
+	t := &Toggle{name: name, description: description}
+	t.enabled.Store(enabled)
+	toggles[name] = t
+	return t
+}
+
+// Name returns the toggle's name.
+func (t *Toggle) Name() string {
+	return t.name
+}
+
+// Description returns the toggle's description.
+func (t *Toggle) Description() string {
+	return t.description
+}
+
+// Enabled reports whether the toggle is on.
+func (t *Toggle) Enabled() bool {
+	return t.enabled.Load()
+}
+
+// Set turns the toggle on or off.
+func (t *Toggle) Set(enabled bool) {
+	t.enabled.Store(enabled)
+}
+
+// Lookup returns the registered toggle with the given name, if any.
+func Lookup(name string) (*Toggle, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	t, ok := toggles[name]
+	return t, ok
+}
+
+// All returns all registered toggles, sorted by name.
+func All() []*Toggle {
+	mu.Lock()
+	defer mu.Unlock()
+	all := make([]*Toggle, 0, len(toggles))
+	for _, t := range toggles {
+		all = append(all, t)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+	return all
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"oteldemo/toggle"
+)
+
+// Toggles for the built-in telemetry pipeline. They have no effect on a
+// pipeline loaded from a declarative configuration file.
+var (
+	metricsExport    = toggle.New("metrics", "export metrics", true)
+	consoleExporters = toggle.New("console-exporters", "print telemetry to stdout", true)
+)
+
+// toggledSpanExporter is a sdktrace.SpanExporter that
+// drops spans unless all of its toggles are on.
+type toggledSpanExporter struct {
+	sdktrace.SpanExporter
+	toggles []*toggle.Toggle
+}
+
+func (e toggledSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if !allEnabled(e.toggles) {
+		return nil
+	}
+	return e.SpanExporter.ExportSpans(ctx, spans)
+}
+
+// toggledMetricExporter is a sdkmetric.Exporter that
+// drops metrics unless all of its toggles are on.
+type toggledMetricExporter struct {
+	sdkmetric.Exporter
+	toggles []*toggle.Toggle
+}
+
+func (e toggledMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	if !allEnabled(e.toggles) {
+		return nil
+	}
+	return e.Exporter.Export(ctx, rm)
+}
+
+func allEnabled(toggles []*toggle.Toggle) bool {
+	for _, t := range toggles {
+		if !t.Enabled() {
+			return false
+		}
+	}
+	return true
+}